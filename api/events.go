@@ -0,0 +1,197 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 实时事件类型
+const (
+	LiveEventThroughput   = "throughput"    // 每秒的吞吐量
+	LiveEventSessionOpen  = "session_open"  // SSH连接建立
+	LiveEventSessionClose = "session_close" // SSH连接断开
+	LiveEventChannelOpen  = "channel_open"  // 转发通道打开
+	LiveEventChannelClose = "channel_close" // 转发通道关闭
+)
+
+// liveEventBufferSize 每个订阅者的事件缓冲区大小，缓冲区满时丢弃新事件，避免慢订阅者阻塞SSH服务器
+const liveEventBufferSize = 64
+
+// LiveEvent 推送给实时仪表盘的事件
+type LiveEvent struct {
+	Type string      `json:"type"` // 事件类型
+	Time time.Time   `json:"time"` // 事件时间
+	Data interface{} `json:"data"` // 事件数据，类型取决于事件类型
+}
+
+// ThroughputSample 最近一秒的吞吐量
+type ThroughputSample struct {
+	Up       int64                      `json:"up"`       // 上行速率（字节/秒）
+	Down     int64                      `json:"down"`     // 下行速率（字节/秒）
+	Sessions int                        `json:"sessions"` // 活动SSH连接数
+	Channels int                        `json:"channels"` // 打开的转发通道数
+	Users    map[string]*UserThroughput `json:"users"`    // 按用户名统计的速率，只包含有流量的用户
+}
+
+// UserThroughput 单个用户最近一秒的吞吐量
+type UserThroughput struct {
+	Up   int64 `json:"up"`   // 上行速率（字节/秒）
+	Down int64 `json:"down"` // 下行速率（字节/秒）
+}
+
+// SessionEvent SSH连接建立或断开事件的数据
+type SessionEvent struct {
+	ConnectionID int    `json:"connection_id"`    // SSH连接ID
+	Username     string `json:"username"`         // 用户名
+	IP           string `json:"ip"`               // 客户端地址
+	Reason       string `json:"reason,omitempty"` // 断开原因，仅断开事件有
+}
+
+// ChannelEvent 转发通道打开或关闭事件的数据
+type ChannelEvent struct {
+	ID           int    `json:"id"`            // 目标连接ID
+	ConnectionID int    `json:"connection_id"` // SSH连接ID
+	Username     string `json:"username"`      // 用户名
+	Target       string `json:"target"`        // 目标地址
+	ChannelType  string `json:"channel_type"`  // 通道类型
+	BytesUp      int64  `json:"bytes_up"`      // 上行流量（字节），仅关闭事件有
+	BytesDown    int64  `json:"bytes_down"`    // 下行流量（字节），仅关闭事件有
+}
+
+// trafficCounter 自服务器启动以来的累计流量
+type trafficCounter struct {
+	up   atomic.Int64
+	down atomic.Int64
+}
+
+// 所有用户和按用户名统计的累计流量
+var totalTraffic trafficCounter
+var userTrafficCounters = make(map[string]*trafficCounter)
+var userTrafficCountersMutex sync.Mutex
+
+// 实时事件的订阅者
+var liveEventSubscribers = make(map[chan *LiveEvent]struct{})
+var liveEventSubscribersMutex sync.Mutex
+
+// countTraffic 累计用户的流量
+// 参数:
+//   username - 用户名，为空时只计入总流量
+//   up - 上行字节数
+//   down - 下行字节数
+func countTraffic(username string, up, down int64) {
+	totalTraffic.up.Add(up)
+	totalTraffic.down.Add(down)
+	if username == "" {
+		return
+	}
+
+	userTrafficCountersMutex.Lock()
+	counter, exists := userTrafficCounters[username]
+	if !exists {
+		counter = &trafficCounter{}
+		userTrafficCounters[username] = counter
+	}
+	userTrafficCountersMutex.Unlock()
+
+	counter.up.Add(up)
+	counter.down.Add(down)
+}
+
+// snapshotUserTraffic 获取按用户名统计的累计流量
+func snapshotUserTraffic() map[string]*UserThroughput {
+	userTrafficCountersMutex.Lock()
+	defer userTrafficCountersMutex.Unlock()
+
+	snapshot := make(map[string]*UserThroughput, len(userTrafficCounters))
+	for username, counter := range userTrafficCounters {
+		snapshot[username] = &UserThroughput{Up: counter.up.Load(), Down: counter.down.Load()}
+	}
+	return snapshot
+}
+
+// SubscribeLiveEvents 订阅实时事件
+// 返回:
+//   <-chan *LiveEvent - 事件通道
+//   func() - 取消订阅的函数，调用后事件通道不再接收事件
+func SubscribeLiveEvents() (<-chan *LiveEvent, func()) {
+	events := make(chan *LiveEvent, liveEventBufferSize)
+
+	liveEventSubscribersMutex.Lock()
+	liveEventSubscribers[events] = struct{}{}
+	liveEventSubscribersMutex.Unlock()
+
+	return events, func() {
+		liveEventSubscribersMutex.Lock()
+		delete(liveEventSubscribers, events)
+		liveEventSubscribersMutex.Unlock()
+	}
+}
+
+// hasLiveEventSubscribers 判断是否有订阅者
+func hasLiveEventSubscribers() bool {
+	liveEventSubscribersMutex.Lock()
+	defer liveEventSubscribersMutex.Unlock()
+	return len(liveEventSubscribers) > 0
+}
+
+// publishLiveEvent 向所有订阅者发送事件，订阅者的缓冲区已满时丢弃该事件
+func publishLiveEvent(eventType string, data interface{}) {
+	event := &LiveEvent{Type: eventType, Time: time.Now(), Data: data}
+
+	liveEventSubscribersMutex.Lock()
+	defer liveEventSubscribersMutex.Unlock()
+
+	for events := range liveEventSubscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// 每秒计算吞吐量并推送给订阅者
+func publishThroughputPeriodically() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastUp, lastDown := totalTraffic.up.Load(), totalTraffic.down.Load()
+	lastUsers := snapshotUserTraffic()
+	lastTime := time.Now()
+
+	for now := range ticker.C {
+		up, down := totalTraffic.up.Load(), totalTraffic.down.Load()
+		users := snapshotUserTraffic()
+		elapsed := now.Sub(lastTime).Seconds()
+
+		if hasLiveEventSubscribers() {
+			sample := &ThroughputSample{
+				Up:    int64(float64(up-lastUp) / elapsed),
+				Down:  int64(float64(down-lastDown) / elapsed),
+				Users: make(map[string]*UserThroughput),
+			}
+			for username, current := range users {
+				var previous UserThroughput
+				if last, exists := lastUsers[username]; exists {
+					previous = *last
+				}
+				if current.Up == previous.Up && current.Down == previous.Down {
+					continue
+				}
+				sample.Users[username] = &UserThroughput{
+					Up:   int64(float64(current.Up-previous.Up) / elapsed),
+					Down: int64(float64(current.Down-previous.Down) / elapsed),
+				}
+			}
+
+			connectionsMutex.RLock()
+			sample.Sessions = len(activeConnections)
+			sample.Channels = len(activeTargetConnections)
+			connectionsMutex.RUnlock()
+
+			publishLiveEvent(LiveEventThroughput, sample)
+		}
+
+		lastUp, lastDown, lastUsers, lastTime = up, down, users, now
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// nextLiveEvent 读取下一个不是吞吐量的事件，超时后测试失败
+func nextLiveEvent(t *testing.T, events <-chan *LiveEvent) *LiveEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type != LiveEventThroughput {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a live event")
+			return nil
+		}
+	}
+}
+
+// waitEarlierSessionsClosed 等待之前的测试的连接全部断开，避免订阅后收到它们的事件
+func waitEarlierSessionsClosed(t *testing.T) {
+	t.Helper()
+	waitFor(t, "earlier sessions to close", func() bool {
+		connectionsMutex.RLock()
+		defer connectionsMutex.RUnlock()
+		return len(activeConnections) == 0 && len(activeTargetConnections) == 0
+	})
+}
+
+func TestLiveEventSubscribers(t *testing.T) {
+	waitEarlierSessionsClosed(t)
+	first, unsubscribeFirst := SubscribeLiveEvents()
+	second, unsubscribeSecond := SubscribeLiveEvents()
+	defer unsubscribeSecond()
+
+	publishLiveEvent(LiveEventSessionOpen, &SessionEvent{Username: "alice"})
+	for _, events := range []<-chan *LiveEvent{first, second} {
+		event := nextLiveEvent(t, events)
+		if event.Type != LiveEventSessionOpen || event.Data.(*SessionEvent).Username != "alice" || event.Time.IsZero() {
+			t.Errorf("got event %+v", event)
+		}
+	}
+
+	// 取消订阅后不再接收事件
+	unsubscribeFirst()
+	publishLiveEvent(LiveEventSessionClose, &SessionEvent{Username: "alice"})
+	if event := nextLiveEvent(t, second); event.Type != LiveEventSessionClose {
+		t.Errorf("got event type %q", event.Type)
+	}
+	select {
+	case event := <-first:
+		t.Errorf("unsubscribed channel received %+v", event)
+	default:
+	}
+}
+
+func TestLiveEventSlowSubscriber(t *testing.T) {
+	waitEarlierSessionsClosed(t)
+	events, unsubscribe := SubscribeLiveEvents()
+	defer unsubscribe()
+
+	// 订阅者不读取事件时，缓冲区满后丢弃新事件而不阻塞发布者
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < liveEventBufferSize+10; i++ {
+			publishLiveEvent(LiveEventChannelOpen, &ChannelEvent{ID: i})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("publishing blocked on a slow subscriber")
+	}
+	if len(events) != liveEventBufferSize {
+		t.Errorf("got %d buffered events, want %d", len(events), liveEventBufferSize)
+	}
+	if event := <-events; event.Data.(*ChannelEvent).ID != 0 {
+		t.Errorf("got event %d first, want the oldest event 0", event.Data.(*ChannelEvent).ID)
+	}
+}
+
+func TestCountTraffic(t *testing.T) {
+	up, down := totalTraffic.up.Load(), totalTraffic.down.Load()
+	before := snapshotUserTraffic()["counted"]
+
+	countTraffic("counted", 10, 20)
+	countTraffic("", 1, 2)
+
+	if got := totalTraffic.up.Load() - up; got != 11 {
+		t.Errorf("got total up %d, want 11", got)
+	}
+	if got := totalTraffic.down.Load() - down; got != 22 {
+		t.Errorf("got total down %d, want 22", got)
+	}
+	after := snapshotUserTraffic()["counted"]
+	if before != nil {
+		after.Up, after.Down = after.Up-before.Up, after.Down-before.Down
+	}
+	if after.Up != 10 || after.Down != 20 {
+		t.Errorf("got user traffic %+v, want 10/20", after)
+	}
+}
+
+func TestLiveEventsFromSSH(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "alice", nil)
+	echoAddr := startEchoServer(t)
+	waitEarlierSessionsClosed(t)
+	events, unsubscribe := SubscribeLiveEvents()
+	defer unsubscribe()
+
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+	open := nextLiveEvent(t, events)
+	if session, ok := open.Data.(*SessionEvent); open.Type != LiveEventSessionOpen || !ok || session.Username != "alice" || session.ConnectionID == 0 {
+		t.Fatalf("got %+v, want a session_open event for alice", open)
+	}
+
+	conn, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open channel: %v", err)
+	}
+	echoThrough(t, conn, []byte("ping"))
+	if event := nextLiveEvent(t, events); event.Type != LiveEventChannelOpen || event.Data.(*ChannelEvent).Target != echoAddr {
+		t.Fatalf("got %+v, want a channel_open event", event)
+	}
+
+	// 关闭事件包含通道的流量
+	conn.Close()
+	event := nextLiveEvent(t, events)
+	if event.Type != LiveEventChannelClose {
+		t.Fatalf("got %+v, want a channel_close event", event)
+	}
+	if channel := event.Data.(*ChannelEvent); channel.Username != "alice" || channel.BytesUp != 4 || channel.BytesDown != 4 {
+		t.Errorf("got channel_close %+v", channel)
+	}
+
+	client.Close()
+	event = nextLiveEvent(t, events)
+	if session, ok := event.Data.(*SessionEvent); event.Type != LiveEventSessionClose || !ok || session.Reason != models.DisconnectReasonClient {
+		t.Errorf("got %+v, want a session_close event with reason client", event)
+	}
+}
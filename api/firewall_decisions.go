@@ -0,0 +1,37 @@
+package api
+
+import (
+	"log"
+	"time"
+	"ssh-manage/utils"
+)
+
+// firewallDecisionRetention 防火墙决策记录的保留时长，0表示永久保留
+var firewallDecisionRetention time.Duration
+
+// firewallDecisionPruneInterval 清理过期防火墙决策记录的间隔
+const firewallDecisionPruneInterval = time.Hour
+
+// 上次清理过期防火墙决策记录的时间，只在updateTrafficStats中访问
+var lastFirewallDecisionPrune time.Time
+
+// flushFirewallDecisions 写入内存中的防火墙决策，并每隔firewallDecisionPruneInterval清理一次过期记录
+func flushFirewallDecisions(now time.Time) {
+	if err := utils.FlushFirewallDecisions(); err != nil {
+		log.Printf("Failed to record firewall decisions: %v", err)
+	}
+
+	if now.Sub(lastFirewallDecisionPrune) < firewallDecisionPruneInterval {
+		return
+	}
+	lastFirewallDecisionPrune = now
+
+	deleted, err := utils.PruneFirewallDecisions(firewallDecisionRetention)
+	if err != nil {
+		log.Printf("Failed to prune firewall decisions: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Pruned %d expired firewall decisions", deleted)
+	}
+}
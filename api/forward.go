@@ -0,0 +1,308 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"ssh-manage/models"
+	"ssh-manage/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RemoteForward 用于跟踪远程端口转发（ssh -R）监听器的结构体
+type RemoteForward struct {
+	UserID   int          // 所属用户ID
+	BindAddr string       // 客户端请求的绑定地址
+	BindPort uint32       // 实际绑定的端口
+	Listener net.Listener // 服务器端监听器
+}
+
+// 存储每个SSH会话的远程端口转发监听器，键为会话ID，内层键为"地址:端口"
+// 会话建立时登记（openRemoteForwards），断开时移除（closeRemoteForwards），不在映射中的会话不能再绑定监听器
+var activeRemoteForwards = make(map[string]map[string]*RemoteForward)
+var remoteForwardsMutex sync.Mutex
+
+// tcpipForwardRequest tcpip-forward和cancel-tcpip-forward请求的负载（RFC 4254 7.1节）
+type tcpipForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+// tcpipForwardReply tcpip-forward请求端口为0时的应答负载
+type tcpipForwardReply struct {
+	BindPort uint32
+}
+
+// forwardedTCPIPData forwarded-tcpip通道的额外数据（RFC 4254 7.2节）
+type forwardedTCPIPData struct {
+	BindAddr   string
+	BindPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// handleTCPIPForward 处理TCP/IP转发请求，在服务器上绑定监听器并将接入的连接转发回客户端
+func handleTCPIPForward(req *ssh.Request, sessionID string, sshConn *ssh.ServerConn) {
+	var payload tcpipForwardRequest
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		log.Printf("Failed to parse tcpip-forward request: %v", err)
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+		return
+	}
+
+	log.Printf("TCP/IP forward request for %s:%d", payload.BindAddr, payload.BindPort)
+
+	// 加载用户的远程转发策略（每次请求时读取，修改策略后立即生效）
+	user := sessionUser(sshConn)
+	if user == nil {
+		rejectForward(req, "user not found")
+		return
+	}
+
+	if !utils.IsRemoteForwardAddrAllowed(user, payload.BindAddr) {
+		rejectForward(req, fmt.Sprintf("bind address %q not allowed for user %s", payload.BindAddr, user.Username))
+		return
+	}
+
+	if payload.BindPort == 0 {
+		if !user.RemoteForwardAutoPort {
+			rejectForward(req, fmt.Sprintf("automatic port allocation disabled for user %s", user.Username))
+			return
+		}
+	} else if !utils.IsRemoteForwardPortAllowed(user, payload.BindPort) {
+		rejectForward(req, fmt.Sprintf("port %d not allowed for user %s", payload.BindPort, user.Username))
+		return
+	}
+
+	// 检查监听器数量上限并绑定监听器，整个过程持有锁以避免并发请求突破上限
+	remoteForwardsMutex.Lock()
+	forwards, open := activeRemoteForwards[sessionID]
+	if !open {
+		// 会话已断开并关闭了所有监听器，此时登记的监听器将永远不会被关闭
+		remoteForwardsMutex.Unlock()
+		rejectForward(req, "session is closed")
+		return
+	}
+	if user.MaxRemoteForwards > 0 && countUserRemoteForwards(user.ID) >= user.MaxRemoteForwards {
+		remoteForwardsMutex.Unlock()
+		rejectForward(req, fmt.Sprintf("user %s reached the limit of %d remote forwards", user.Username, user.MaxRemoteForwards))
+		return
+	}
+
+	listener, err := listenRemoteForward(user, payload.BindAddr, payload.BindPort)
+	if err != nil {
+		remoteForwardsMutex.Unlock()
+		rejectForward(req, fmt.Sprintf("failed to listen for tcpip-forward: %v", err))
+		return
+	}
+
+	boundPort := uint32(listener.Addr().(*net.TCPAddr).Port)
+	forward := &RemoteForward{
+		UserID:   user.ID,
+		BindAddr: payload.BindAddr,
+		BindPort: boundPort,
+		Listener: listener,
+	}
+
+	// 登记监听器，便于取消请求或断开连接时关闭
+	forwards[remoteForwardKey(payload.BindAddr, boundPort)] = forward
+	remoteForwardsMutex.Unlock()
+
+	if req.WantReply {
+		// 只有请求端口为0时才需要在应答中返回实际分配的端口
+		var reply []byte
+		if payload.BindPort == 0 {
+			reply = ssh.Marshal(tcpipForwardReply{BindPort: boundPort})
+		}
+		req.Reply(true, reply)
+	}
+
+	log.Printf("Accepted tcpip-forward request for %s:%d, listening on %s", payload.BindAddr, payload.BindPort, listener.Addr())
+
+	go acceptRemoteForward(forward, sessionID, sshConn)
+}
+
+// handleCancelTCPIPForward 处理取消TCP/IP转发请求，关闭对应的监听器
+func handleCancelTCPIPForward(req *ssh.Request, sessionID string) {
+	var payload tcpipForwardRequest
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		log.Printf("Failed to parse cancel-tcpip-forward request: %v", err)
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+		return
+	}
+
+	key := remoteForwardKey(payload.BindAddr, payload.BindPort)
+
+	remoteForwardsMutex.Lock()
+	forward, exists := activeRemoteForwards[sessionID][key]
+	if exists {
+		delete(activeRemoteForwards[sessionID], key)
+	}
+	remoteForwardsMutex.Unlock()
+
+	if !exists {
+		log.Printf("No tcpip-forward listener found for %s", key)
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+		return
+	}
+
+	forward.Listener.Close()
+
+	if req.WantReply {
+		req.Reply(true, nil)
+	}
+	log.Printf("Accepted cancel-tcpip-forward request for %s", key)
+}
+
+// rejectForward 拒绝tcpip-forward请求并记录原因
+func rejectForward(req *ssh.Request, reason string) {
+	log.Printf("Rejected tcpip-forward request: %s", reason)
+	if req.WantReply {
+		req.Reply(false, nil)
+	}
+}
+
+// countUserRemoteForwards 统计用户在所有会话中的远程转发监听器数量，调用方需持有remoteForwardsMutex
+func countUserRemoteForwards(userID int) int {
+	count := 0
+	for _, forwards := range activeRemoteForwards {
+		for _, forward := range forwards {
+			if forward.UserID == userID {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// listenRemoteForward 按用户策略绑定远程转发监听器
+// 请求端口为0时，依次尝试用户允许范围内的端口，直到绑定成功
+func listenRemoteForward(user *models.User, bindAddr string, bindPort uint32) (net.Listener, error) {
+	if bindPort != 0 {
+		return net.Listen("tcp", remoteForwardKey(bindAddr, bindPort))
+	}
+
+	ranges, err := utils.ParsePortRanges(user.RemoteForwardPorts)
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("automatic port allocation requires allowed remote forward ports")
+	}
+	for _, r := range ranges {
+		for port := r.Start; port <= r.End; port++ {
+			listener, err := net.Listen("tcp", remoteForwardKey(bindAddr, port))
+			if err == nil {
+				return listener, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no free port available in %q", user.RemoteForwardPorts)
+}
+
+// openRemoteForwards 登记SSH会话，之后该会话才能绑定远程端口转发监听器
+// 需要在开始处理全局请求之前调用
+func openRemoteForwards(sessionID string) {
+	remoteForwardsMutex.Lock()
+	activeRemoteForwards[sessionID] = make(map[string]*RemoteForward)
+	remoteForwardsMutex.Unlock()
+}
+
+// closeRemoteForwards 关闭指定会话的所有远程端口转发监听器，之后该会话的tcpip-forward请求都会被拒绝
+func closeRemoteForwards(sessionID string) {
+	remoteForwardsMutex.Lock()
+	forwards := activeRemoteForwards[sessionID]
+	delete(activeRemoteForwards, sessionID)
+	remoteForwardsMutex.Unlock()
+
+	for key, forward := range forwards {
+		forward.Listener.Close()
+		log.Printf("Closed tcpip-forward listener %s for disconnected session", key)
+	}
+}
+
+// acceptRemoteForward 接受监听器上的连接，并为每个连接打开forwarded-tcpip通道
+func acceptRemoteForward(forward *RemoteForward, sessionID string, sshConn *ssh.ServerConn) {
+	for {
+		conn, err := forward.Listener.Accept()
+		if err != nil {
+			// 监听器被关闭（取消转发或连接断开）
+			return
+		}
+
+		go handleForwardedConnection(conn, forward, sessionID, sshConn)
+	}
+}
+
+// handleForwardedConnection 将一个接入的连接通过forwarded-tcpip通道转发给SSH客户端
+func handleForwardedConnection(conn net.Conn, forward *RemoteForward, sessionID string, sshConn *ssh.ServerConn) {
+	defer conn.Close()
+
+	originAddr, originPortStr, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	originPort, _ := strconv.ParseUint(originPortStr, 10, 32)
+
+	extraData := ssh.Marshal(forwardedTCPIPData{
+		BindAddr:   forward.BindAddr,
+		BindPort:   forward.BindPort,
+		OriginAddr: originAddr,
+		OriginPort: uint32(originPort),
+	})
+
+	// 远程转发的流量同样计入用户的流量配额、带宽限速和并发通道数
+	var quota *quotaTracker
+	var limiter *rateLimiter
+	release := func() {}
+	if user := sessionUser(sshConn); user != nil {
+		quota = userQuotaTracker(user)
+		limiter = userRateLimiter(user)
+		if !quota.allowNewChannel() {
+			log.Printf("Rejected forwarded-tcpip connection from %s for user %s: traffic quota exceeded", conn.RemoteAddr(), user.Username)
+			return
+		}
+
+		var err error
+		if release, err = reserveChannel(user); err != nil {
+			log.Printf("Rejected forwarded-tcpip connection from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+	defer release()
+
+	channel, requests, err := sshConn.OpenChannel("forwarded-tcpip", extraData)
+	if err != nil {
+		log.Printf("Failed to open forwarded-tcpip channel for %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer channel.Close()
+
+	go ssh.DiscardRequests(requests)
+
+	// 目标地址记录为服务器上的监听地址，与direct-tcpip一样统计流量
+	target := remoteForwardKey(forward.BindAddr, forward.BindPort)
+	targetConn, ok := startTargetConnection(sessionID, target, models.ChannelTypeForwardedTCPIP)
+	release()
+	if !ok {
+		return
+	}
+	defer finishTargetConnection(targetConn.ID)
+
+	log.Printf("Established forwarded-tcpip connection from %s via %s", conn.RemoteAddr(), target)
+
+	pipeWithTraffic(channel, conn, targetConn.ID, quota, limiter)
+
+	log.Printf("Closed forwarded-tcpip connection from %s via %s", conn.RemoteAddr(), target)
+}
+
+// remoteForwardKey 生成远程端口转发的映射键
+func remoteForwardKey(bindAddr string, bindPort uint32) string {
+	return net.JoinHostPort(bindAddr, strconv.FormatUint(uint64(bindPort), 10))
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"ssh-manage/models"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// userRemoteForwardCount 获取用户当前登记的远程转发监听器数量
+func userRemoteForwardCount(userID int) int {
+	remoteForwardsMutex.Lock()
+	defer remoteForwardsMutex.Unlock()
+	return countUserRemoteForwards(userID)
+}
+
+func TestRemoteForwardReleasedOnCancelAndDisconnect(t *testing.T) {
+	openTestDB(t)
+	port := freeTCPPort(t)
+	user := createTestUser(t, "forwarder", func(user *models.User) {
+		user.RemoteForwardPorts = strconv.Itoa(int(port))
+		user.MaxRemoteForwards = 1
+	})
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+	bindAddr := remoteForwardKey("127.0.0.1", port)
+
+	// 相当于ssh -R：服务器监听端口，接入的连接通过forwarded-tcpip通道转发给客户端
+	listener, err := client.Listen("tcp", bindAddr)
+	if err != nil {
+		t.Fatalf("tcpip-forward: %v", err)
+	}
+	conn, err := net.Dial("tcp", bindAddr)
+	if err != nil {
+		t.Fatalf("dial forwarded port: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	forwarded, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept forwarded connection: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(forwarded, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v through the forward, want ping", buf, err)
+	}
+	forwarded.Close()
+	conn.Close()
+
+	// 取消转发后端口被释放，且不再计入监听器数量上限
+	if err := listener.Close(); err != nil {
+		t.Fatalf("cancel-tcpip-forward: %v", err)
+	}
+	waitFor(t, "port release after cancel", func() bool { return portIsFree(port) })
+	if count := userRemoteForwardCount(user.ID); count != 0 {
+		t.Fatalf("got %d registered forwards after cancel, want 0", count)
+	}
+
+	// 上限为1，取消后可以再次绑定
+	if _, err := client.Listen("tcp", bindAddr); err != nil {
+		t.Fatalf("tcpip-forward after cancel: %v", err)
+	}
+	if portIsFree(port) {
+		t.Fatalf("port %d is free while forwarded", port)
+	}
+
+	// 断开连接后关闭该会话的所有监听器
+	client.Close()
+	waitFor(t, "port release after disconnect", func() bool { return portIsFree(port) })
+	waitFor(t, "forwards unregistered after disconnect", func() bool { return userRemoteForwardCount(user.ID) == 0 })
+}
+
+func TestTCPIPForwardRejectedAfterSessionClosed(t *testing.T) {
+	openTestDB(t)
+	port := freeTCPPort(t)
+	user := createTestUser(t, "forwarder", func(user *models.User) {
+		user.RemoteForwardPorts = strconv.Itoa(int(port))
+	})
+
+	// 模拟断开连接与tcpip-forward请求并发：请求在closeRemoteForwards之后才被处理
+	sessionID := "closed-session"
+	openRemoteForwards(sessionID)
+	closeRemoteForwards(sessionID)
+
+	sshConn := &ssh.ServerConn{Permissions: userPermissions(user, "password")}
+	req := &ssh.Request{Type: "tcpip-forward", Payload: ssh.Marshal(tcpipForwardRequest{BindAddr: "127.0.0.1", BindPort: port})}
+	handleTCPIPForward(req, sessionID, sshConn)
+
+	if !portIsFree(port) {
+		t.Errorf("port %d is held by a listener registered after the session closed", port)
+	}
+	remoteForwardsMutex.Lock()
+	_, exists := activeRemoteForwards[sessionID]
+	remoteForwardsMutex.Unlock()
+	if exists {
+		t.Errorf("closed session %q was registered again", sessionID)
+	}
+	if count := userRemoteForwardCount(user.ID); count != 0 {
+		t.Errorf("got %d registered forwards, want 0", count)
+	}
+}
+
+func TestTCPIPForwardPolicy(t *testing.T) {
+	openTestDB(t)
+	port := freeTCPPort(t)
+	user := createTestUser(t, "forwarder", func(user *models.User) {
+		user.RemoteForwardPorts = strconv.Itoa(int(port))
+		user.MaxRemoteForwards = 1
+		user.RemoteForwardAutoPort = true
+	})
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+
+	// 未配置绑定地址时只允许回环地址
+	if _, err := client.Listen("tcp", remoteForwardKey("0.0.0.0", port)); err == nil {
+		t.Fatalf("tcpip-forward on 0.0.0.0 was accepted")
+	}
+	// 端口不在允许范围内
+	if _, err := client.Listen("tcp", remoteForwardKey("127.0.0.1", port+1)); err == nil {
+		t.Fatalf("tcpip-forward on port %d outside the allowed range was accepted", port+1)
+	}
+
+	// 端口0在允许范围内自动分配
+	listener, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("tcpip-forward with port 0: %v", err)
+	}
+	if got := uint32(listener.Addr().(*net.TCPAddr).Port); got != port {
+		t.Errorf("got allocated port %d, want %d", got, port)
+	}
+
+	// 达到监听器数量上限
+	if _, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Fatalf("tcpip-forward beyond the limit of 1 was accepted")
+	}
+}
+
+func TestListenRemoteForwardAutoPortWithoutRanges(t *testing.T) {
+	user := &models.User{Username: "forwarder", RemoteForwardAutoPort: true}
+	if listener, err := listenRemoteForward(user, "127.0.0.1", 0); err == nil {
+		listener.Close()
+		t.Fatalf("listened on %s without allowed port ranges", listener.Addr())
+	}
+}
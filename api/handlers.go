@@ -1,61 +1,575 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"ssh-manage/models"
 	"ssh-manage/services"
+	"ssh-manage/utils"
 	"time"
 )
 
+// apiError JSON接口的错误响应
+type apiError struct {
+	Error string `json:"error"` // 错误信息
+}
+
+// Handler JSON接口，挂载在/api/下
+// 请求可以携带API令牌（Authorization: Bearer ...），也可以使用Web管理界面的管理员账号进行基础认证
+// 每个请求按requiredRole检查请求者的角色
 func Handler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	
+	role, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if required := requiredRole(r); !utils.WebAdminRoleAllows(role, required) {
+		writeError(w, http.StatusForbidden, "this request requires the "+required+" role")
+		return
+	}
+
 	switch r.URL.Path {
 	case "/api/users":
 		handleUsers(w, r)
+	case "/api/users/activate":
+		handleUserActive(w, r, true)
+	case "/api/users/deactivate":
+		handleUserActive(w, r, false)
+	case "/api/users/reset_password":
+		handleResetPassword(w, r)
 	case "/api/connections":
 		handleConnections(w, r)
+	case "/api/target_connections":
+		handleTargetConnections(w, r)
 	case "/api/stats":
 		handleStats(w, r)
+	case "/api/user_keys":
+		handleUserKeys(w, r)
+	case "/api/firewall/rules":
+		handleFirewallRules(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authenticateRequest 验证请求的API令牌或管理员账号的基础认证，失败时输出401响应
+// 返回:
+//   string - 请求者的角色：read令牌为viewer，admin令牌为admin，基础认证为管理员账号的角色
+//   bool - 是否认证通过
+func authenticateRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		token, err := services.AuthenticateAPIToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), ip)
+		if err != nil {
+			if err != utils.ErrInvalidAPIToken {
+				log.Printf("Failed to authenticate API token: %v", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="SSH Manage"`)
+			writeError(w, http.StatusUnauthorized, "invalid or expired token")
+			return "", false
+		}
+		if token.Scope == models.APITokenScopeAdmin {
+			return models.WebAdminRoleAdmin, true
+		}
+		return models.WebAdminRoleViewer, true
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok {
+		if admin, err := services.AuthenticateWebAdmin(user, pass); err == nil {
+			return admin.Role, true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="SSH Manage"`)
+	writeError(w, http.StatusUnauthorized, "unauthorized")
+	return "", false
+}
+
+// requiredRole 返回请求需要的最低角色
+// GET请求只需要viewer；激活/停用用户需要operator；其他修改操作需要admin
+func requiredRole(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return models.WebAdminRoleViewer
+	}
+
+	switch r.URL.Path {
+	case "/api/users/activate", "/api/users/deactivate":
+		return models.WebAdminRoleOperator
+	}
+	return models.WebAdminRoleAdmin
+}
+
+// writeJSON 以指定状态码输出JSON响应，所有接口都通过该函数输出响应体
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode API response: %v", err)
+	}
+}
+
+// writeError 输出JSON格式的错误响应：{"error": "..."}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// writeServiceError 按错误类型选择状态码输出错误响应：记录不存在为404，冲突为409，其余为fallback
+func writeServiceError(w http.ResponseWriter, err error, fallback int) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, services.ErrUserExists), errors.Is(err, utils.ErrUserHasConnections):
+		writeError(w, http.StatusConflict, err.Error())
 	default:
-		http.NotFound(w, r)
+		writeError(w, fallback, err.Error())
 	}
 }
 
+// decodeJSON 解析请求体中的JSON，不允许未知字段，以便发现拼写错误
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// queryInt 读取整数查询参数，参数为空时返回0
+func queryInt(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("invalid " + name)
+	}
+	return n, nil
+}
+
+// requireID 读取必需的id查询参数
+func requireID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := queryInt(r, "id")
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+// publicUser 返回不包含密码哈希的用户信息副本
+func publicUser(user *models.User) *models.User {
+	copied := *user
+	copied.Password = ""
+	return &copied
+}
+
 func handleUsers(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
+		// 指定id时只返回该用户
+		if r.URL.Query().Get("id") != "" {
+			id, ok := requireID(w, r)
+			if !ok {
+				return
+			}
+
+			user, err := utils.GetUserByID(id)
+			if err != nil {
+				writeServiceError(w, err, http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, publicUser(user))
+			return
+		}
+
 		users := services.GetAllUsers()
-		json.NewEncoder(w).Encode(users)
+		result := make([]*models.User, 0, len(users))
+		for _, user := range users {
+			result = append(result, publicUser(user))
+		}
+		writeJSON(w, http.StatusOK, result)
 	case http.MethodPost:
-		var user models.User
-		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		// 未指定active时默认激活
+		user := models.User{Active: true}
+		if err := decodeJSON(r, &user); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 		
+		user.ID = 0
 		user.Created = time.Now()
-		user.Active = true
 		
-		if err := services.AddUser(&user); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		created, err := services.CreateUser(&user)
+		if err != nil {
+			writeServiceError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, publicUser(created))
+	case http.MethodPut, http.MethodPatch:
+		// 请求体中出现的字段覆盖原值，未出现的字段保持不变；密码通过reset_password修改
+		id, ok := requireID(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := utils.GetUserByID(id)
+		if err != nil {
+			writeServiceError(w, err, http.StatusInternalServerError)
+			return
+		}
+		original := *user
+
+		// 清空密码哈希，以便发现请求体中的password字段
+		user.Password = ""
+		if err := decodeJSON(r, user); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if user.Password != "" {
+			writeError(w, http.StatusBadRequest, "password cannot be changed here, use POST /api/users/reset_password")
+			return
+		}
+		user.ID = original.ID
+		user.Password = original.Password
+		user.Created = original.Created
+
+		if err := services.ValidateUser(user); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if user.Username != original.Username {
+			if _, err := utils.GetUserByUsername(user.Username); err == nil {
+				writeServiceError(w, services.ErrUserExists, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := services.UpdateUser(user); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if original.Active && !user.Active {
+			CloseUserSessions(user.ID)
+		}
+
+		writeJSON(w, http.StatusOK, publicUser(user))
+	case http.MethodDelete:
+		// 有连接记录的用户需要指定purge=true才会连同连接记录一起删除
+		id, ok := requireID(w, r)
+		if !ok {
 			return
 		}
 		
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(user)
+		if err := services.DeleteUser(id, r.URL.Query().Get("purge") == "true"); err != nil {
+			writeServiceError(w, err, http.StatusInternalServerError)
+			return
+		}
+		CloseUserSessions(id)
+
+		w.WriteHeader(http.StatusNoContent)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
+// handleUserActive 激活或停用用户，停用时断开该用户的所有SSH连接
+func handleUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, ok := requireID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := services.SetUserActive(id, active)
+	if err != nil {
+		writeServiceError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !active {
+		CloseUserSessions(id)
+	}
+
+	writeJSON(w, http.StatusOK, publicUser(user))
+}
+
+// handleResetPassword 重置用户密码，请求体中没有指定密码时生成随机密码并在响应中返回
+func handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, ok := requireID(w, r)
+	if !ok {
+		return
+	}
+
+	// 请求体可以为空
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	password, err := services.ResetUserPassword(id, req.Password)
+	if err != nil {
+		writeServiceError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		UserID   int    `json:"user_id"`
+		Password string `json:"password,omitempty"` // 生成的随机密码
+	}{UserID: id}
+	if req.Password == "" {
+		resp.Password = password
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
 func handleConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
 	connections := services.GetAllConnections()
-	json.NewEncoder(w).Encode(connections)
+	writeJSON(w, http.StatusOK, connections)
+}
+
+// handleTargetConnections 分页查询目标连接记录
+// 参数: user_id、username、connection_id、target（包含）、channel_type、from、to（RFC3339）、active（true/false）、limit、offset
+func handleTargetConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	query := &utils.TargetConnectionQuery{
+		Username:    params.Get("username"),
+		Target:      params.Get("target"),
+		ChannelType: params.Get("channel_type"),
+	}
+	var err error
+	for name, dest := range map[string]*int{
+		"user_id":       &query.UserID,
+		"connection_id": &query.ConnectionID,
+		"limit":         &query.Limit,
+		"offset":        &query.Offset,
+	} {
+		if *dest, err = queryInt(r, name); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	for name, dest := range map[string]*time.Time{
+		"from": &query.From,
+		"to":   &query.To,
+	} {
+		if value := params.Get(name); value != "" {
+			if *dest, err = time.Parse(time.RFC3339, value); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+name)
+				return
+			}
+		}
+	}
+	if value := params.Get("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid active")
+			return
+		}
+		query.Active = &active
+	}
+
+	page, err := utils.QueryTargetConnections(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
 func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
 	stats := services.GetStatistics()
-	json.NewEncoder(w).Encode(stats)
-}
\ No newline at end of file
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func handleUserKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// 可通过user_id参数筛选指定用户的公钥
+		userIDStr := r.URL.Query().Get("user_id")
+		if userIDStr == "" {
+			writeJSON(w, http.StatusOK, services.GetAllUserKeys())
+			return
+		}
+
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid user_id")
+			return
+		}
+		writeJSON(w, http.StatusOK, services.GetUserKeys(userID))
+	case http.MethodPost:
+		var req struct {
+			UserID    int    `json:"user_id"`
+			Label     string `json:"label"`
+			PublicKey string `json:"public_key"`
+		}
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		key, err := services.AddUserKey(req.UserID, req.Label, req.PublicKey)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, key)
+	case http.MethodDelete:
+		id, ok := requireID(w, r)
+		if !ok {
+			return
+		}
+
+		if err := services.DeleteUserKey(id); err != nil {
+			writeServiceError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleFirewallRules 防火墙规则的增删改查
+func handleFirewallRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// 指定id时只返回该规则
+		if r.URL.Query().Get("id") != "" {
+			id, ok := requireID(w, r)
+			if !ok {
+				return
+			}
+
+			rule, err := utils.GetFirewallRule(id)
+			if err != nil {
+				writeServiceError(w, err, http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, rule)
+			return
+		}
+
+		rules, err := utils.GetFirewallRules()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if rules == nil {
+			rules = []*models.FirewallRule{}
+		}
+		writeJSON(w, http.StatusOK, rules)
+	case http.MethodPost:
+		var rule models.FirewallRule
+		if err := decodeJSON(r, &rule); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := utils.AddFirewallRule(&rule); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		created, err := utils.GetFirewallRule(rule.ID)
+		if err != nil {
+			writeServiceError(w, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	case http.MethodPut, http.MethodPatch:
+		// 请求体中出现的字段覆盖原值，未出现的字段保持不变
+		id, ok := requireID(w, r)
+		if !ok {
+			return
+		}
+
+		rule, err := utils.GetFirewallRule(id)
+		if err != nil {
+			writeServiceError(w, err, http.StatusInternalServerError)
+			return
+		}
+		original := *rule
+
+		// action和type只需给出其一，都没有给出时保持原值
+		rule.Action, rule.Type = "", ""
+		if err := decodeJSON(r, rule); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if rule.Action == "" && rule.Type == "" {
+			rule.Action, rule.Type = original.Action, original.Type
+		}
+		rule.ID = original.ID
+		rule.Active = original.Active
+		rule.HitCount = original.HitCount
+		rule.LastHit = original.LastHit
+
+		if err := utils.UpdateFirewallRule(rule); err != nil {
+			writeServiceError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		updated, err := utils.GetFirewallRule(id)
+		if err != nil {
+			writeServiceError(w, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, updated)
+	case http.MethodDelete:
+		id, ok := requireID(w, r)
+		if !ok {
+			return
+		}
+
+		if err := utils.DeleteFirewallRule(id); err != nil {
+			writeServiceError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/services"
+	"ssh-manage/utils"
+)
+
+// createTestAPIToken 创建指定权限范围的API令牌，返回令牌明文
+func createTestAPIToken(t *testing.T, scope string) string {
+	t.Helper()
+	plaintext, _, err := services.CreateAPIToken("test", scope, nil)
+	if err != nil {
+		t.Fatalf("create API token: %v", err)
+	}
+	return plaintext
+}
+
+// apiRequest 使用API令牌调用Handler
+// 参数:
+//   token - 令牌明文，为空时不携带Authorization头
+//   method - 请求方法
+//   target - 请求路径和查询参数
+//   body - 请求体，可以为空
+func apiRequest(t *testing.T, token, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	Handler(w, r)
+	return w
+}
+
+// decodeAPIError 解析错误响应，同时检查响应类型为JSON
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", contentType)
+	}
+	var resp apiError
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	return resp.Error
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	openTestDB(t)
+	token := createTestAPIToken(t, models.APITokenScopeAdmin)
+
+	for _, target := range []string{"/api/connections", "/api/stats", "/api/users/deactivate?id=1"} {
+		method := http.MethodPost
+		if strings.HasPrefix(target, "/api/users/") {
+			method = http.MethodGet
+		}
+		w := apiRequest(t, token, method, target, "")
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: got status %d, want %d", method, target, w.Code, http.StatusMethodNotAllowed)
+			continue
+		}
+		if msg := decodeAPIError(t, w); msg != "method not allowed" {
+			t.Errorf("%s %s: got error %q", method, target, msg)
+		}
+	}
+}
+
+func TestAPIJSONResponses(t *testing.T) {
+	openTestDB(t)
+	token := createTestAPIToken(t, models.APITokenScopeRead)
+	user := createTestUser(t, "alice", nil)
+
+	for _, target := range []string{"/api/connections", "/api/stats", "/api/user_keys?user_id=" + strconv.Itoa(user.ID)} {
+		w := apiRequest(t, token, http.MethodGet, target, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: got status %d: %s", target, w.Code, w.Body)
+			continue
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("GET %s: got Content-Type %q", target, contentType)
+		}
+		if !json.Valid(w.Body.Bytes()) {
+			t.Errorf("GET %s: invalid JSON %q", target, w.Body)
+		}
+	}
+}
+
+func TestAPIUpdateUserRejectsPassword(t *testing.T) {
+	openTestDB(t)
+	token := createTestAPIToken(t, models.APITokenScopeAdmin)
+	user := createTestUser(t, "alice", nil)
+
+	w := apiRequest(t, token, http.MethodPut, "/api/users?id="+strconv.Itoa(user.ID), `{"password": "changed"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	decodeAPIError(t, w)
+
+	// 未出现的字段保持不变
+	w = apiRequest(t, token, http.MethodPut, "/api/users?id="+strconv.Itoa(user.ID), `{"name": "Alice"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var updated models.User
+	if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	if updated.Name != "Alice" || updated.Username != "alice" || !updated.Active || updated.Password != "" {
+		t.Errorf("got %+v after update", updated)
+	}
+}
+
+func TestAPIDeactivateClosesSessions(t *testing.T) {
+	openTestDB(t)
+	token := createTestAPIToken(t, models.APITokenScopeAdmin)
+	user := createTestUser(t, "alice", nil)
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+	trackedSession(t, client)
+
+	w := apiRequest(t, token, http.MethodPost, "/api/users/deactivate?id="+strconv.Itoa(user.ID), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Wait() }()
+	waitFor(t, "SSH connection to close", func() bool {
+		select {
+		case err := <-closed:
+			closed <- err
+			return true
+		default:
+			return false
+		}
+	})
+	waitFor(t, "session to be unregistered", func() bool {
+		connectionsMutex.RLock()
+		defer connectionsMutex.RUnlock()
+		return len(activeConnections) == 0
+	})
+}
+
+func TestAPITargetConnections(t *testing.T) {
+	openTestDB(t)
+	token := createTestAPIToken(t, models.APITokenScopeRead)
+	user := createTestUser(t, "alice", nil)
+	connectedAt := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+	connID, err := utils.RecordConnection(&models.Connection{UserID: user.ID, Username: user.Username, IP: "127.0.0.1", ConnectedAt: connectedAt, SessionID: "test"})
+	if err != nil {
+		t.Fatalf("record connection: %v", err)
+	}
+	for _, target := range []string{"db.example:5432", "web.example:443"} {
+		if _, err := utils.RecordTargetConnection(&models.TargetConnection{ConnectionID: connID, Target: target, ConnectedAt: connectedAt}); err != nil {
+			t.Fatalf("record target connection: %v", err)
+		}
+	}
+
+	w := apiRequest(t, token, http.MethodGet, "/api/target_connections?target=db.&active=true&limit=10&user_id="+strconv.Itoa(user.ID)+
+		"&from="+url.QueryEscape(connectedAt.Add(-time.Hour).Format(time.RFC3339)), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var page utils.TargetConnectionPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	if page.Total != 1 || page.Limit != 10 || len(page.Items) != 1 || page.Items[0].Target != "db.example:5432" || page.Items[0].Username != "alice" {
+		t.Errorf("got page %+v", page)
+	}
+
+	for _, query := range []string{"limit=x", "offset=-1", "from=yesterday", "active=maybe", "limit=1001"} {
+		w := apiRequest(t, token, http.MethodGet, "/api/target_connections?"+query, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, w.Code, http.StatusBadRequest)
+			continue
+		}
+		decodeAPIError(t, w)
+	}
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	openTestDB(t)
+	read := createTestAPIToken(t, models.APITokenScopeRead)
+	admin := createTestAPIToken(t, models.APITokenScopeAdmin)
+	expiresAt := time.Now().Add(time.Hour)
+	expired, token, err := services.CreateAPIToken("expired", models.APITokenScopeAdmin, &expiresAt)
+	if err != nil {
+		t.Fatalf("create API token: %v", err)
+	}
+	if _, err := utils.GetDB().Exec("UPDATE api_tokens SET expires_at = ? WHERE id = ?", "2000-01-01 00:00:00", token.ID); err != nil {
+		t.Fatalf("expire token: %v", err)
+	}
+
+	// 缺少、错误或过期的令牌返回401
+	for _, token := range []string{"", "sshm_wrong", expired} {
+		w := apiRequest(t, token, http.MethodGet, "/api/stats", "")
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: got status %d, want %d with WWW-Authenticate", token, w.Code, http.StatusUnauthorized)
+			continue
+		}
+		decodeAPIError(t, w)
+	}
+
+	// read令牌只能调用GET接口
+	body := `{"name": "Bob", "username": "bob", "password": "bob"}`
+	if w := apiRequest(t, read, http.MethodGet, "/api/users", ""); w.Code != http.StatusOK {
+		t.Errorf("read token GET: got status %d", w.Code)
+	}
+	w := apiRequest(t, read, http.MethodPost, "/api/users", body)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("read token POST: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	decodeAPIError(t, w)
+	if _, err := utils.GetUserByUsername("bob"); err == nil {
+		t.Errorf("read token created a user")
+	}
+	if w := apiRequest(t, admin, http.MethodPost, "/api/users", body); w.Code != http.StatusCreated {
+		t.Errorf("admin token POST: got status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method, target, want string
+	}{
+		{http.MethodGet, "/api/users", models.WebAdminRoleViewer},
+		{http.MethodHead, "/api/stats", models.WebAdminRoleViewer},
+		{http.MethodPost, "/api/users/activate?id=1", models.WebAdminRoleOperator},
+		{http.MethodPost, "/api/users/deactivate?id=1", models.WebAdminRoleOperator},
+		{http.MethodPost, "/api/users", models.WebAdminRoleAdmin},
+		{http.MethodDelete, "/api/firewall/rules?id=1", models.WebAdminRoleAdmin},
+		{http.MethodPost, "/api/users/reset_password?id=1", models.WebAdminRoleAdmin},
+	}
+	for _, tt := range tests {
+		if got := requiredRole(httptest.NewRequest(tt.method, tt.target, nil)); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestAPIWebAdminRoles(t *testing.T) {
+	openTestDB(t)
+	for _, role := range []string{models.WebAdminRoleViewer, models.WebAdminRoleOperator} {
+		if err := services.AddWebAdmin(role, role+"-password", role); err != nil {
+			t.Fatalf("add %s: %v", role, err)
+		}
+	}
+	user := createTestUser(t, "alice", nil)
+
+	// basicRequest 使用管理员账号的基础认证调用Handler
+	basicRequest := func(username, password, method, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		Handler(w, r)
+		return w
+	}
+	deactivate := "/api/users/deactivate?id=" + strconv.Itoa(user.ID)
+	tests := []struct {
+		username, method, target string
+		want                     int
+	}{
+		{models.WebAdminRoleViewer, http.MethodGet, "/api/users", http.StatusOK},
+		{models.WebAdminRoleViewer, http.MethodPost, deactivate, http.StatusForbidden},
+		{models.WebAdminRoleOperator, http.MethodPost, deactivate, http.StatusOK},
+		{models.WebAdminRoleOperator, http.MethodDelete, "/api/users?id=" + strconv.Itoa(user.ID), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := basicRequest(tt.username, tt.username+"-password", tt.method, tt.target)
+		if w.Code != tt.want {
+			t.Errorf("%s %s %s: got status %d, want %d: %s", tt.username, tt.method, tt.target, w.Code, tt.want, w.Body)
+		}
+	}
+
+	if w := basicRequest(models.WebAdminRoleViewer, "wrong", http.MethodGet, "/api/users"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if updated, err := utils.GetUserByID(user.ID); err != nil || updated.Active {
+		t.Errorf("user still active after operator deactivated it: %+v, %v", updated, err)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"ssh-manage/models"
+)
+
+// 已通过上限检查、尚未登记到活动连接映射的会话和通道数，按用户ID统计
+// 检查和登记之间需要写数据库、解析目标地址，预留名额可以避免并发请求突破上限
+var pendingSessions = make(map[int]int)
+var pendingChannels = make(map[int]int)
+
+// countUserSessionsLocked 统计用户的活动SSH会话数，调用方需持有connectionsMutex
+func countUserSessionsLocked(userID int) int {
+	count := 0
+	for _, trackedConn := range activeConnections {
+		if trackedConn.Connection.UserID == userID {
+			count++
+		}
+	}
+	return count
+}
+
+// countUserChannelsLocked 统计用户打开的转发通道数（direct-tcpip和forwarded-tcpip），调用方需持有connectionsMutex
+func countUserChannelsLocked(userID int) int {
+	// 目标连接只记录了SSH连接ID，先找出该用户的SSH连接
+	connIDs := make(map[int]bool)
+	for _, trackedConn := range activeConnections {
+		if trackedConn.Connection.UserID == userID {
+			connIDs[trackedConn.Connection.ID] = true
+		}
+	}
+
+	count := 0
+	for _, trackedTargetConn := range activeTargetConnections {
+		if connIDs[trackedTargetConn.TargetConnection.ConnectionID] {
+			count++
+		}
+	}
+	return count
+}
+
+// checkSessionLimit 检查用户的并发SSH会话数是否已达到上限（不预留名额，用于认证阶段向客户端说明原因）
+// 参数: user - 用户信息
+// 返回: error - 已达到上限时返回拒绝原因
+func checkSessionLimit(user *models.User) error {
+	if user.MaxSessions <= 0 {
+		return nil
+	}
+
+	connectionsMutex.RLock()
+	count := countUserSessionsLocked(user.ID) + pendingSessions[user.ID]
+	connectionsMutex.RUnlock()
+
+	if count >= user.MaxSessions {
+		return fmt.Errorf("user %s reached the limit of %d concurrent sessions", user.Username, user.MaxSessions)
+	}
+	return nil
+}
+
+// reserveSession 检查并预留一个SSH会话名额
+// 参数: user - 用户信息
+// 返回:
+//   func() - 释放预留名额的函数，会话登记到activeConnections后或登记失败时调用，可重复调用
+//   error - 已达到上限时返回拒绝原因
+func reserveSession(user *models.User) (func(), error) {
+	return reserveSlot(pendingSessions, user.ID, user.MaxSessions, countUserSessionsLocked,
+		fmt.Sprintf("user %s reached the limit of %d concurrent sessions", user.Username, user.MaxSessions))
+}
+
+// reserveChannel 检查并预留一个转发通道名额
+// 参数: user - 用户信息
+// 返回:
+//   func() - 释放预留名额的函数，通道登记到activeTargetConnections后或打开失败时调用，可重复调用
+//   error - 已达到上限时返回拒绝原因
+func reserveChannel(user *models.User) (func(), error) {
+	return reserveSlot(pendingChannels, user.ID, user.MaxChannels, countUserChannelsLocked,
+		fmt.Sprintf("user %s reached the limit of %d open channels", user.Username, user.MaxChannels))
+}
+
+// reserveSlot 在持有connectionsMutex的情况下检查活动数量与预留数量之和是否达到上限，未达到时预留一个名额
+func reserveSlot(pending map[int]int, userID, limit int, countLocked func(int) int, reason string) (func(), error) {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	if limit > 0 && countLocked(userID)+pending[userID] >= limit {
+		return func() {}, errors.New(reason)
+	}
+
+	pending[userID]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			connectionsMutex.Lock()
+			pending[userID]--
+			if pending[userID] <= 0 {
+				delete(pending, userID)
+			}
+			connectionsMutex.Unlock()
+		})
+	}, nil
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"testing"
+	"ssh-manage/models"
+)
+
+// pendingCount 获取用户预留的名额数
+func pendingCount(pending map[int]int, userID int) int {
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+	return pending[userID]
+}
+
+func TestReserveSession(t *testing.T) {
+	user := &models.User{ID: 1, Username: "alice", MaxSessions: 2}
+
+	// 已登记的会话和预留的名额都计入上限
+	connectionsMutex.Lock()
+	activeConnections["test-session"] = &TrackedConnection{Connection: &models.Connection{ID: 1, UserID: user.ID}}
+	connectionsMutex.Unlock()
+	t.Cleanup(func() {
+		connectionsMutex.Lock()
+		delete(activeConnections, "test-session")
+		connectionsMutex.Unlock()
+	})
+
+	release, err := reserveSession(user)
+	if err != nil {
+		t.Fatalf("reserve below the limit: %v", err)
+	}
+	if _, err := reserveSession(user); err == nil {
+		t.Fatalf("reserved a session beyond the limit of 2")
+	}
+	if err := checkSessionLimit(user); err == nil {
+		t.Errorf("checkSessionLimit ignored the pending session")
+	}
+
+	// 释放函数可重复调用，只释放一次
+	release()
+	release()
+	if n := pendingCount(pendingSessions, user.ID); n != 0 {
+		t.Fatalf("got %d pending sessions after release, want 0", n)
+	}
+	if err := checkSessionLimit(user); err != nil {
+		t.Errorf("limit reached after release: %v", err)
+	}
+
+	// 其他用户和不限制的用户不受影响
+	other, err := reserveSession(&models.User{ID: 2, MaxSessions: 1})
+	if err != nil {
+		t.Fatalf("reserve for another user: %v", err)
+	}
+	other()
+	for i := 0; i < 3; i++ {
+		release, err := reserveSession(&models.User{ID: user.ID})
+		if err != nil {
+			t.Fatalf("reserve without a limit: %v", err)
+		}
+		defer release()
+	}
+}
+
+func TestReserveChannel(t *testing.T) {
+	user := &models.User{ID: 1, Username: "alice", MaxChannels: 1}
+
+	first, err := reserveChannel(user)
+	if err != nil {
+		t.Fatalf("reserve first channel: %v", err)
+	}
+	if _, err := reserveChannel(user); err == nil {
+		t.Fatalf("reserved a channel beyond the limit of 1")
+	}
+	first()
+	second, err := reserveChannel(user)
+	if err != nil {
+		t.Fatalf("reserve after release: %v", err)
+	}
+	second()
+}
+
+func TestSessionLimit(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "limited", func(user *models.User) {
+		user.MaxSessions = 1
+	})
+	addr := startTestSSHServer(t)
+
+	first := dialTestSSH(t, addr, user.Username)
+	trackedSession(t, first)
+	if client, err := dialWithPassword(addr, user.Username, user.Username); err == nil {
+		client.Close()
+		t.Fatalf("second session accepted with a limit of 1")
+	}
+
+	// 断开后可以重新登录
+	first.Close()
+	waitFor(t, "session to be unregistered", func() bool {
+		connectionsMutex.RLock()
+		defer connectionsMutex.RUnlock()
+		return countUserSessionsLocked(user.ID) == 0
+	})
+	dialTestSSH(t, addr, user.Username)
+}
+
+func TestChannelLimit(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "limited", func(user *models.User) {
+		user.MaxChannels = 1
+	})
+	echoAddr := startEchoServer(t)
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+
+	first, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open first channel: %v", err)
+	}
+	if conn, err := client.Dial("tcp", echoAddr); err == nil {
+		conn.Close()
+		t.Fatalf("second channel accepted with a limit of 1")
+	}
+
+	// 关闭通道后可以打开新的通道
+	first.Close()
+	waitFor(t, "channel to be unregistered", func() bool {
+		connectionsMutex.RLock()
+		defer connectionsMutex.RUnlock()
+		return countUserChannelsLocked(user.ID) == 0
+	})
+	conn, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open channel after closing the first: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"ssh-manage/utils"
+)
+
+// 认证结果
+const (
+	authResultSuccess  = "success"  // 认证成功并建立连接
+	authResultFailure  = "failure"  // 用户名、密码或公钥错误
+	authResultRejected = "rejected" // 认证通过但因会话数上限被拒绝
+)
+
+// 由SSH服务器记录的指标
+var (
+	// 按认证方式和结果统计的认证次数
+	authAttemptsTotal = utils.NewCounterVec("ssh_manage_auth_attempts_total",
+		"Number of SSH authentication attempts, by method and result.", "method", "result")
+	// 连接已通过防火墙检查的目标失败的次数
+	dialErrorsTotal = utils.NewCounterVec("ssh_manage_dial_errors_total",
+		"Number of failed connections to forwarding targets.")
+)
+
+// 从活动连接映射和流量计数器中读取的指标
+func init() {
+	utils.NewMetricFunc("ssh_manage_active_sessions", "Number of active SSH sessions.", utils.MetricTypeGauge,
+		func() []utils.MetricSample {
+			connectionsMutex.RLock()
+			defer connectionsMutex.RUnlock()
+			return []utils.MetricSample{{Value: float64(len(activeConnections))}}
+		})
+
+	utils.NewMetricFunc("ssh_manage_active_channels", "Number of open forwarding channels, by channel type.", utils.MetricTypeGauge,
+		func() []utils.MetricSample {
+			counts := make(map[string]int)
+			connectionsMutex.RLock()
+			for _, trackedTargetConn := range activeTargetConnections {
+				counts[trackedTargetConn.TargetConnection.ChannelType]++
+			}
+			connectionsMutex.RUnlock()
+
+			samples := make([]utils.MetricSample, 0, len(counts))
+			for channelType, count := range counts {
+				samples = append(samples, utils.MetricSample{Labels: []string{channelType}, Value: float64(count)})
+			}
+			return samples
+		}, "type")
+
+	utils.NewMetricFunc("ssh_manage_bytes_total", "Bytes forwarded since the server started, by user and direction.", utils.MetricTypeCounter,
+		func() []utils.MetricSample {
+			users := snapshotUserTraffic()
+			samples := make([]utils.MetricSample, 0, 2*len(users))
+			for username, traffic := range users {
+				samples = append(samples,
+					utils.MetricSample{Labels: []string{username, "up"}, Value: float64(traffic.Up)},
+					utils.MetricSample{Labels: []string{username, "down"}, Value: float64(traffic.Down)})
+			}
+			return samples
+		}, "user", "direction")
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"log"
+	"sync"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/utils"
+)
+
+// quotaTracker 跟踪单个用户在当前日/月的流量，用于执行流量配额
+// 同一用户的所有SSH连接和通道共享一个跟踪器
+type quotaTracker struct {
+	mu            sync.Mutex
+	userID        int
+	username      string
+	dailyQuota    int64     // 每日配额，0表示不限制
+	monthlyQuota  int64     // 每月配额，0表示不限制
+	action        string    // 配额用尽后的处理方式
+	loaded        bool      // 是否已从数据库加载已用流量
+	dayStart      time.Time // 当前统计日的开始时间
+	monthStart    time.Time // 当前统计月的开始时间
+	dailyUsed     int64     // 当日已用流量
+	monthlyUsed   int64     // 当月已用流量
+	throttleUntil time.Time // 限速时该用户下一次可以传输数据的时间
+}
+
+// 按用户ID存储流量配额跟踪器
+var quotaTrackers = make(map[int]*quotaTracker)
+var quotaTrackersMutex sync.Mutex
+
+// quotaThrottleRate 配额用尽且处理方式为throttle时，每个用户的速率上限（字节/秒）
+var quotaThrottleRate int64 = 64 * 1024
+
+// userQuotaTracker 获取用户的流量配额跟踪器，并同步最新的配额设置
+// 首次使用时从数据库加载本日和本月已用的流量
+func userQuotaTracker(user *models.User) *quotaTracker {
+	quotaTrackersMutex.Lock()
+	q, exists := quotaTrackers[user.ID]
+	if !exists {
+		q = &quotaTracker{userID: user.ID}
+		quotaTrackers[user.ID] = q
+	}
+	quotaTrackersMutex.Unlock()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.username = user.Username
+	q.dailyQuota = user.DailyQuota
+	q.monthlyQuota = user.MonthlyQuota
+	q.action = user.QuotaAction
+
+	now := time.Now()
+	if !q.loaded {
+		q.dayStart, q.monthStart = utils.DayStart(now), utils.MonthStart(now)
+		var err error
+		if q.dailyUsed, err = utils.GetUserTrafficSince(user.ID, q.dayStart); err != nil {
+			log.Printf("Failed to load daily traffic for user %s: %v", user.Username, err)
+		}
+		if q.monthlyUsed, err = utils.GetUserTrafficSince(user.ID, q.monthStart); err != nil {
+			log.Printf("Failed to load monthly traffic for user %s: %v", user.Username, err)
+		}
+		q.loaded = true
+	}
+	q.rollover(now)
+
+	return q
+}
+
+// rollover 进入新的一天或新的一个月时重置对应的已用流量，调用方需持有q.mu
+func (q *quotaTracker) rollover(now time.Time) {
+	if dayStart := utils.DayStart(now); !dayStart.Equal(q.dayStart) {
+		q.dayStart = dayStart
+		q.dailyUsed = 0
+	}
+	if monthStart := utils.MonthStart(now); !monthStart.Equal(q.monthStart) {
+		q.monthStart = monthStart
+		q.monthlyUsed = 0
+	}
+}
+
+// exhaustedLocked 判断配额是否已用尽，调用方需持有q.mu
+func (q *quotaTracker) exhaustedLocked() bool {
+	return (q.dailyQuota > 0 && q.dailyUsed >= q.dailyQuota) ||
+		(q.monthlyQuota > 0 && q.monthlyUsed >= q.monthlyQuota)
+}
+
+// allowNewChannel 检查配额是否允许打开新的通道
+// 配额用尽时，处理方式为throttle的用户仍可打开通道（只限速），其余处理方式拒绝
+func (q *quotaTracker) allowNewChannel() bool {
+	if q == nil {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover(time.Now())
+	return !q.exhaustedLocked() || q.action == models.QuotaActionThrottle
+}
+
+// consume 记录用户传输的流量，并根据配额返回需要采取的动作
+// 参数: n - 传输的字节数
+// 返回:
+//   time.Duration - 限速时需要等待的时间
+//   bool - 是否应断开通道
+func (q *quotaTracker) consume(n int64) (time.Duration, bool) {
+	if q == nil {
+		return 0, false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.rollover(now)
+	q.dailyUsed += n
+	q.monthlyUsed += n
+
+	if !q.exhaustedLocked() {
+		return 0, false
+	}
+
+	switch q.action {
+	case models.QuotaActionDrop:
+		return 0, true
+	case models.QuotaActionThrottle:
+		// 该用户所有通道共享同一个速率上限
+		if q.throttleUntil.Before(now) {
+			q.throttleUntil = now
+		}
+		q.throttleUntil = q.throttleUntil.Add(time.Duration(n) * time.Second / time.Duration(quotaThrottleRate))
+		return q.throttleUntil.Sub(now), false
+	default:
+		return 0, false
+	}
+}
+
+// dropUserChannels 关闭配额用尽的用户所有打开的转发通道，SSH连接保持不变
+// 空闲的通道和长轮询连接在配额用尽后不会再调用consume，需要主动关闭才能实现drop
+// 参数: q - 配额处理方式为drop且已用尽的跟踪器
+// 返回: int - 关闭的通道数
+func dropUserChannels(q *quotaTracker) int {
+	var closers []func()
+	connectionsMutex.RLock()
+	for _, trackedTargetConn := range activeTargetConnections {
+		if trackedTargetConn.Session == nil || trackedTargetConn.Session.Connection.UserID != q.userID {
+			continue
+		}
+		trackedTargetConn.mu.Lock()
+		if trackedTargetConn.closeFn != nil {
+			closers = append(closers, trackedTargetConn.closeFn)
+		}
+		trackedTargetConn.mu.Unlock()
+	}
+	connectionsMutex.RUnlock()
+
+	// 在释放锁之后关闭，关闭通道会触发finishTargetConnection获取写锁
+	for _, closeFn := range closers {
+		closeFn()
+	}
+	if len(closers) > 0 {
+		q.mu.Lock()
+		username := q.username
+		q.mu.Unlock()
+		log.Printf("Dropped %d channels of user %s: traffic quota exceeded", len(closers), username)
+	}
+	return len(closers)
+}
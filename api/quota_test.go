@@ -0,0 +1,240 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/utils"
+)
+
+// newTestQuotaTracker 构造已加载、统计周期为当前日和当前月的配额跟踪器
+func newTestQuotaTracker(daily, monthly int64, action string) *quotaTracker {
+	now := time.Now()
+	return &quotaTracker{
+		userID:       1,
+		username:     "quota",
+		dailyQuota:   daily,
+		monthlyQuota: monthly,
+		action:       action,
+		loaded:       true,
+		dayStart:     utils.DayStart(now),
+		monthStart:   utils.MonthStart(now),
+	}
+}
+
+func TestQuotaTrackerReject(t *testing.T) {
+	q := newTestQuotaTracker(1000, 0, models.QuotaActionReject)
+
+	if delay, drop := q.consume(600); delay != 0 || drop {
+		t.Fatalf("consume under quota = (%v, %v), want (0, false)", delay, drop)
+	}
+	if !q.allowNewChannel() {
+		t.Fatalf("new channel rejected under quota")
+	}
+
+	// 用尽后已有通道继续传输，只拒绝新的通道
+	if delay, drop := q.consume(600); delay != 0 || drop {
+		t.Fatalf("consume over quota = (%v, %v), want (0, false)", delay, drop)
+	}
+	if q.allowNewChannel() {
+		t.Fatalf("new channel allowed after quota exhausted")
+	}
+}
+
+func TestQuotaTrackerDrop(t *testing.T) {
+	q := newTestQuotaTracker(0, 1000, models.QuotaActionDrop)
+
+	if _, drop := q.consume(999); drop {
+		t.Fatalf("channel dropped under quota")
+	}
+	if _, drop := q.consume(1); !drop {
+		t.Fatalf("channel not dropped when monthly quota reached")
+	}
+	if q.allowNewChannel() {
+		t.Fatalf("new channel allowed after quota exhausted")
+	}
+}
+
+func TestQuotaTrackerThrottle(t *testing.T) {
+	previousRate := quotaThrottleRate
+	quotaThrottleRate = 1000
+	t.Cleanup(func() { quotaThrottleRate = previousRate })
+
+	q := newTestQuotaTracker(1000, 0, models.QuotaActionThrottle)
+
+	if delay, drop := q.consume(1000); drop || delay < 900*time.Millisecond || delay > time.Second {
+		t.Fatalf("consume reaching quota = (%v, %v), want about 1s delay", delay, drop)
+	}
+	// 同一用户的通道共享速率上限，等待时间累加
+	if delay, drop := q.consume(500); drop || delay < 1400*time.Millisecond || delay > 1500*time.Millisecond {
+		t.Fatalf("second consume = (%v, %v), want about 1.5s delay", delay, drop)
+	}
+	if !q.allowNewChannel() {
+		t.Fatalf("throttled user cannot open new channels")
+	}
+}
+
+func TestQuotaTrackerNil(t *testing.T) {
+	var q *quotaTracker
+	if !q.allowNewChannel() {
+		t.Errorf("nil tracker rejected a new channel")
+	}
+	if delay, drop := q.consume(1 << 30); delay != 0 || drop {
+		t.Errorf("nil tracker consume = (%v, %v), want (0, false)", delay, drop)
+	}
+}
+
+func TestQuotaTrackerRollover(t *testing.T) {
+	now := time.Now()
+
+	// 进入新的一天只重置当日流量
+	q := newTestQuotaTracker(1000, 5000, models.QuotaActionReject)
+	q.dayStart = q.dayStart.AddDate(0, 0, -1)
+	q.dailyUsed, q.monthlyUsed = 1000, 3000
+	if !q.allowNewChannel() {
+		t.Fatalf("daily quota not reset on a new day")
+	}
+	if q.dailyUsed != 0 || q.monthlyUsed != 3000 || !q.dayStart.Equal(utils.DayStart(now)) {
+		t.Errorf("after daily rollover got daily %d, monthly %d, day start %v", q.dailyUsed, q.monthlyUsed, q.dayStart)
+	}
+
+	// 进入新的一个月重置当月流量
+	q = newTestQuotaTracker(0, 1000, models.QuotaActionReject)
+	q.monthStart = q.monthStart.AddDate(0, -1, 0)
+	q.monthlyUsed = 1000
+	if !q.allowNewChannel() {
+		t.Fatalf("monthly quota not reset on a new month")
+	}
+	if q.monthlyUsed != 0 || !q.monthStart.Equal(utils.MonthStart(now)) {
+		t.Errorf("after monthly rollover got monthly %d, month start %v", q.monthlyUsed, q.monthStart)
+	}
+
+	// 同一周期内不重置
+	q = newTestQuotaTracker(1000, 0, models.QuotaActionReject)
+	q.dailyUsed = 1000
+	if q.allowNewChannel() {
+		t.Errorf("quota reset within the same day")
+	}
+}
+
+func TestUserQuotaTrackerLoadsUsage(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "quota", func(user *models.User) {
+		user.DailyQuota = 1000
+	})
+
+	now := time.Now()
+	connID, err := utils.RecordConnection(&models.Connection{UserID: user.ID, Username: user.Username, IP: "127.0.0.1", ConnectedAt: now, SessionID: "quota-session"})
+	if err != nil {
+		t.Fatalf("record connection: %v", err)
+	}
+	// 本日和上个月各有一条目标连接，上个月的流量不计入
+	for _, record := range []struct {
+		at       time.Time
+		up, down int64
+	}{
+		{now, 300, 400},
+		{utils.MonthStart(now).AddDate(0, 0, -1), 5000, 5000},
+	} {
+		if _, err := utils.RecordTargetConnection(&models.TargetConnection{ConnectionID: connID, Target: "example.com:443", ConnectedAt: record.at, BytesUp: record.up, BytesDown: record.down}); err != nil {
+			t.Fatalf("record target connection: %v", err)
+		}
+	}
+
+	q := userQuotaTracker(user)
+	if q.dailyUsed != 700 || q.monthlyUsed != 700 {
+		t.Errorf("got daily %d, monthly %d, want 700 and 700", q.dailyUsed, q.monthlyUsed)
+	}
+
+	// 修改配额后再次获取跟踪器时同步新的设置，已用流量不重新加载
+	user.DailyQuota = 500
+	if q = userQuotaTracker(user); q.dailyQuota != 500 || q.allowNewChannel() {
+		t.Errorf("got daily quota %d, allow %v after lowering the quota", q.dailyQuota, q.allowNewChannel())
+	}
+}
+
+// startEchoServer 启动回显TCP服务器，返回监听地址
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoThrough 通过连接发送数据并读取回显
+func echoThrough(t *testing.T, conn net.Conn, data []byte) {
+	t.Helper()
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("echo through channel: %v", err)
+	}
+}
+
+func TestQuotaDropClosesIdleChannels(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "quota", func(user *models.User) {
+		user.DailyQuota = 1000
+		user.QuotaAction = models.QuotaActionDrop
+	})
+	echoAddr := startEchoServer(t)
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+
+	// 空闲的通道：传输少量数据后不再传输
+	idle, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open idle channel: %v", err)
+	}
+	echoThrough(t, idle, []byte("hi"))
+
+	// 另一个通道用尽配额
+	busy, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open busy channel: %v", err)
+	}
+	busy.Write(bytes.Repeat([]byte("x"), 2000))
+
+	// 空闲的通道也被断开
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, idle)
+		closed <- err
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("idle channel still open after the quota was exhausted")
+	}
+
+	// 用尽后拒绝新的通道，SSH连接保持
+	if conn, err := client.Dial("tcp", echoAddr); err == nil {
+		conn.Close()
+		t.Fatalf("new channel accepted after the quota was exhausted")
+	}
+	waitFor(t, "channels to close", func() bool {
+		connectionsMutex.RLock()
+		defer connectionsMutex.RUnlock()
+		return len(activeTargetConnections) == 0 && len(activeConnections) == 1
+	})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"sync"
+	"time"
+	"ssh-manage/models"
+)
+
+// tokenBucket 令牌桶限速器，多个goroutine可共享同一个令牌桶
+// 令牌不足时允许预支，预支的部分通过返回的等待时间偿还
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   int64     // 每秒补充的令牌数（字节/秒），0表示不限制
+	tokens float64   // 当前令牌数，预支后可能为负数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// setRate 修改令牌桶的速率，速率未变化时保留当前令牌数
+func (b *tokenBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if rate == b.rate {
+		return
+	}
+	if b.rate <= 0 {
+		// 从不限制变为限制时，令牌桶从满的状态开始
+		b.tokens = float64(rate)
+		b.last = time.Now()
+	}
+	b.rate = rate
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+}
+
+// reserve 从令牌桶中取出n个令牌
+// 参数: n - 需要的令牌数（字节数）
+// 返回: time.Duration - 调用方需要等待的时间，令牌充足时为0
+func (b *tokenBucket) reserve(n int64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	// 按经过的时间补充令牌，最多积攒1秒的令牌作为突发量
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+// rateLimiter 上行和下行分别限速的令牌桶
+type rateLimiter struct {
+	up   tokenBucket // 上行（从SSH客户端到目标）
+	down tokenBucket // 下行（从目标到SSH客户端）
+}
+
+// globalRateLimiter 所有用户共享的全局限速器
+var globalRateLimiter = &rateLimiter{}
+
+// 按用户ID存储限速器，同一用户的所有通道共享
+var userRateLimiters = make(map[int]*rateLimiter)
+var userRateLimitersMutex sync.Mutex
+
+// setRates 设置上行和下行的速率，0表示不限制
+func (l *rateLimiter) setRates(up, down int64) {
+	l.up.setRate(up)
+	l.down.setRate(down)
+}
+
+// userRateLimiter 获取用户的限速器，并同步最新的限速设置
+func userRateLimiter(user *models.User) *rateLimiter {
+	userRateLimitersMutex.Lock()
+	l, exists := userRateLimiters[user.ID]
+	if !exists {
+		l = &rateLimiter{}
+		userRateLimiters[user.ID] = l
+	}
+	userRateLimitersMutex.Unlock()
+
+	l.setRates(user.RateLimitUp, user.RateLimitDown)
+	return l
+}
+
+// reserveUp 为上行传输的n个字节取出用户和全局的令牌，返回需要等待的时间
+// 用户限速器为nil时只应用全局限速
+func (l *rateLimiter) reserveUp(n int64) time.Duration {
+	delay := globalRateLimiter.up.reserve(n)
+	if l != nil {
+		delay = max(delay, l.up.reserve(n))
+	}
+	return delay
+}
+
+// reserveDown 为下行传输的n个字节取出用户和全局的令牌，返回需要等待的时间
+// 用户限速器为nil时只应用全局限速
+func (l *rateLimiter) reserveDown(n int64) time.Duration {
+	delay := globalRateLimiter.down.reserve(n)
+	if l != nil {
+		delay = max(delay, l.down.reserve(n))
+	}
+	return delay
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// within 判断时长是否在want附近（误差不超过tolerance）
+func within(got, want, tolerance time.Duration) bool {
+	return got >= want-tolerance && got <= want+tolerance
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	var b tokenBucket
+	if delay := b.reserve(1 << 30); delay != 0 {
+		t.Errorf("unlimited bucket returned delay %v", delay)
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	var b tokenBucket
+	b.setRate(1000)
+
+	// 令牌桶从满的状态开始，可以突发1秒的流量
+	if delay := b.reserve(1000); delay != 0 {
+		t.Fatalf("first reserve waited %v", delay)
+	}
+	// 之后预支令牌，等待时间累加
+	if delay := b.reserve(500); !within(delay, 500*time.Millisecond, 10*time.Millisecond) {
+		t.Fatalf("got delay %v, want about 500ms", delay)
+	}
+	if delay := b.reserve(500); !within(delay, time.Second, 10*time.Millisecond) {
+		t.Fatalf("got delay %v, want about 1s", delay)
+	}
+}
+
+func TestTokenBucketBurstLimit(t *testing.T) {
+	var b tokenBucket
+	b.setRate(1000)
+	b.reserve(1000)
+
+	// 空闲很久后最多积攒1秒的令牌
+	b.mu.Lock()
+	b.last = b.last.Add(-time.Minute)
+	b.mu.Unlock()
+	if delay := b.reserve(1000); delay != 0 {
+		t.Fatalf("reserve after idle waited %v", delay)
+	}
+	if delay := b.reserve(100); !within(delay, 100*time.Millisecond, 10*time.Millisecond) {
+		t.Fatalf("got delay %v, want about 100ms", delay)
+	}
+}
+
+func TestTokenBucketSetRate(t *testing.T) {
+	var b tokenBucket
+	b.setRate(1000)
+	b.reserve(1000)
+
+	// 速率未变化时保留当前令牌数
+	b.setRate(1000)
+	if delay := b.reserve(100); delay == 0 {
+		t.Fatalf("setting the same rate refilled the bucket")
+	}
+
+	// 降低速率时令牌数不超过新的速率
+	var lowered tokenBucket
+	lowered.setRate(1000)
+	lowered.setRate(100)
+	if delay := lowered.reserve(200); !within(delay, time.Second, 10*time.Millisecond) {
+		t.Fatalf("got delay %v after lowering the rate, want about 1s", delay)
+	}
+
+	// 取消限制后不再等待，重新限制时令牌桶从满的状态开始
+	b.setRate(0)
+	if delay := b.reserve(1 << 20); delay != 0 {
+		t.Fatalf("unlimited bucket returned delay %v", delay)
+	}
+	b.setRate(2000)
+	if delay := b.reserve(2000); delay != 0 {
+		t.Fatalf("re-limited bucket waited %v", delay)
+	}
+}
+
+func TestUserRateLimiterShared(t *testing.T) {
+	t.Cleanup(func() {
+		userRateLimitersMutex.Lock()
+		userRateLimiters = make(map[int]*rateLimiter)
+		userRateLimitersMutex.Unlock()
+	})
+	user := &models.User{ID: 1, RateLimitUp: 1000}
+
+	// 同一用户的所有通道共享限速器，并同步最新的限速设置
+	l := userRateLimiter(user)
+	user.RateLimitUp, user.RateLimitDown = 2000, 500
+	if again := userRateLimiter(user); again != l {
+		t.Fatalf("got a different limiter for the same user")
+	}
+	if l.up.rate != 2000 || l.down.rate != 500 {
+		t.Errorf("got rates %d/%d, want 2000/500", l.up.rate, l.down.rate)
+	}
+	if other := userRateLimiter(&models.User{ID: 2}); other == l {
+		t.Errorf("two users share a limiter")
+	}
+}
+
+func TestRateLimiterGlobal(t *testing.T) {
+	globalRateLimiter.setRates(1000, 0)
+	t.Cleanup(func() { globalRateLimiter.setRates(0, 0) })
+
+	// 用户限速器为nil时只应用全局限速
+	var none *rateLimiter
+	none.reserveUp(1000)
+	if delay := none.reserveUp(500); !within(delay, 500*time.Millisecond, 10*time.Millisecond) {
+		t.Fatalf("got global delay %v, want about 500ms", delay)
+	}
+	if delay := none.reserveDown(1 << 20); delay != 0 {
+		t.Fatalf("unlimited direction waited %v", delay)
+	}
+
+	// 同时受用户和全局限速时取较长的等待时间
+	l := &rateLimiter{}
+	l.setRates(100, 0)
+	if delay := l.reserveUp(200); !within(delay, time.Second, 10*time.Millisecond) {
+		t.Fatalf("got delay %v, want the user's 1s", delay)
+	}
+}
+
+func TestRateLimitThroughChannel(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "limited", func(user *models.User) {
+		user.RateLimitUp = 20000
+	})
+	echoAddr := startEchoServer(t)
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+
+	conn, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open channel: %v", err)
+	}
+	defer conn.Close()
+
+	// 突发20000字节之后，剩余30000字节按20000字节/秒传输
+	start := time.Now()
+	echoThrough(t, conn, bytes.Repeat([]byte("x"), 50000))
+	if elapsed := time.Since(start); elapsed < 1200*time.Millisecond {
+		t.Errorf("transferred 50000 bytes in %v, want at least 1.2s at 20000 bytes/s", elapsed)
+	}
+}
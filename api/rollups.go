@@ -0,0 +1,76 @@
+package api
+
+import (
+	"log"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/utils"
+)
+
+// 流量汇总数据的保留时长，0表示永久保留
+var rollupMinuteRetention time.Duration
+var rollupHourRetention time.Duration
+
+// rollupPruneInterval 清理过期流量汇总数据的间隔
+const rollupPruneInterval = time.Hour
+
+// 上次清理过期流量汇总数据的时间，只在updateTrafficStats中访问
+var lastRollupPrune time.Time
+
+// takeTrafficRollup 获取目标连接自上次写入流量汇总以来的流量增量，并记为已写入
+// 增量计入at所在的时间桶，因此流量在时间桶之间的分布精确到流量统计的更新周期（30秒）
+// 参数:
+//   trackedTargetConn - 目标连接
+//   at - 流量计入的时间
+// 返回: *models.TrafficRollup - 流量增量，没有新流量且已计入连接数时返回nil
+func takeTrafficRollup(trackedTargetConn *TrackedTargetConnection, at time.Time) *models.TrafficRollup {
+	trackedTargetConn.mu.Lock()
+	defer trackedTargetConn.mu.Unlock()
+
+	targetConn := trackedTargetConn.TargetConnection
+	rollup := &models.TrafficRollup{
+		BucketStart: at,
+		Target:      targetConn.Target,
+		BytesUp:     targetConn.BytesUp - trackedTargetConn.rolledUpUp,
+		BytesDown:   targetConn.BytesDown - trackedTargetConn.rolledUpDown,
+	}
+	if !trackedTargetConn.rolledUp {
+		rollup.Channels = 1
+	}
+	if rollup.BytesUp == 0 && rollup.BytesDown == 0 && rollup.Channels == 0 {
+		return nil
+	}
+
+	if session := trackedTargetConn.Session; session != nil {
+		rollup.UserID = session.Connection.UserID
+		rollup.Username = session.Connection.Username
+	}
+	trackedTargetConn.rolledUp = true
+	trackedTargetConn.rolledUpUp = targetConn.BytesUp
+	trackedTargetConn.rolledUpDown = targetConn.BytesDown
+	return rollup
+}
+
+// recordTrafficRollups 写入流量增量，失败时只记录日志
+func recordTrafficRollups(rollups []*models.TrafficRollup) {
+	if err := utils.RecordTrafficRollups(rollups); err != nil {
+		log.Printf("Failed to record traffic rollups: %v", err)
+	}
+}
+
+// pruneTrafficRollupsIfDue 每隔rollupPruneInterval清理一次过期的流量汇总数据
+func pruneTrafficRollupsIfDue(now time.Time) {
+	if now.Sub(lastRollupPrune) < rollupPruneInterval {
+		return
+	}
+	lastRollupPrune = now
+
+	deleted, err := utils.PruneTrafficRollups(rollupMinuteRetention, rollupHourRetention)
+	if err != nil {
+		log.Printf("Failed to prune traffic rollups: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Pruned %d expired traffic rollup rows", deleted)
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+func TestTakeTrafficRollup(t *testing.T) {
+	session := &TrackedConnection{Connection: &models.Connection{UserID: 1, Username: "alice"}}
+	targetConn := &models.TargetConnection{Target: "a:22", BytesUp: 100, BytesDown: 200}
+	tracked := &TrackedTargetConnection{TargetConnection: targetConn, Session: session}
+	at := time.Now()
+
+	// 第一次计入新建连接数和已有流量
+	rollup := takeTrafficRollup(tracked, at)
+	if rollup == nil || rollup.Channels != 1 || rollup.BytesUp != 100 || rollup.BytesDown != 200 || rollup.Username != "alice" || !rollup.BucketStart.Equal(at) {
+		t.Fatalf("got first rollup %+v", rollup)
+	}
+
+	// 之后只计入增量，没有新流量时返回nil
+	if rollup := takeTrafficRollup(tracked, at); rollup != nil {
+		t.Errorf("got rollup %+v without new traffic", rollup)
+	}
+	targetConn.BytesUp += 5
+	rollup = takeTrafficRollup(tracked, at)
+	if rollup == nil || rollup.Channels != 0 || rollup.BytesUp != 5 || rollup.BytesDown != 0 || rollup.UserID != 1 {
+		t.Errorf("got incremental rollup %+v", rollup)
+	}
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	"ssh-manage/models"
+)
+
+// throughputSampleInterval 计算通道实时速率的采样间隔
+const throughputSampleInterval = 5 * time.Second
+
+// LiveSession 活动SSH连接的实时快照
+type LiveSession struct {
+	ConnectionID   int            `json:"connection_id"`   // SSH连接ID
+	UserID         int            `json:"user_id"`         // 用户ID
+	Username       string         `json:"username"`        // 用户名
+	IP             string         `json:"ip"`              // 客户端地址
+	ClientVersion  string         `json:"client_version"`  // 客户端版本
+	AuthMethod     string         `json:"auth_method"`     // 认证方式
+	ConnectedAt    time.Time      `json:"connected_at"`    // 连接时间
+	LastActivity   time.Time      `json:"last_activity"`   // 最后活动时间
+	RemoteForwards []string       `json:"remote_forwards"` // 远程转发监听地址
+	Channels       []*LiveChannel `json:"channels"`        // 打开的转发通道
+	BytesUp        int64          `json:"bytes_up"`        // 所有打开通道的上行流量合计（字节）
+	BytesDown      int64          `json:"bytes_down"`      // 所有打开通道的下行流量合计（字节）
+	RateUp         int64          `json:"rate_up"`         // 当前上行速率（字节/秒）
+	RateDown       int64          `json:"rate_down"`       // 当前下行速率（字节/秒）
+}
+
+// LiveChannel 打开的转发通道的实时快照
+type LiveChannel struct {
+	ID          int       `json:"id"`           // 目标连接ID
+	Target      string    `json:"target"`       // 目标地址
+	ChannelType string    `json:"channel_type"` // 通道类型
+	ConnectedAt time.Time `json:"connected_at"` // 连接时间
+	BytesUp     int64     `json:"bytes_up"`     // 上行流量（字节）
+	BytesDown   int64     `json:"bytes_down"`   // 下行流量（字节）
+	RateUp      int64     `json:"rate_up"`      // 当前上行速率（字节/秒）
+	RateDown    int64     `json:"rate_down"`    // 当前下行速率（字节/秒）
+}
+
+// 定期采样各通道的流量，计算实时速率
+func sampleThroughputPeriodically() {
+	ticker := time.NewTicker(throughputSampleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sampleThroughput()
+	}
+}
+
+// sampleThroughput 根据上次采样以来的流量计算每个通道的速率
+func sampleThroughput() {
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+
+	now := time.Now()
+	for _, trackedTargetConn := range activeTargetConnections {
+		trackedTargetConn.mu.Lock()
+		targetConn := trackedTargetConn.TargetConnection
+		if !trackedTargetConn.sampledAt.IsZero() {
+			elapsed := now.Sub(trackedTargetConn.sampledAt).Seconds()
+			trackedTargetConn.RateUp = int64(float64(targetConn.BytesUp-trackedTargetConn.sampledUp) / elapsed)
+			trackedTargetConn.RateDown = int64(float64(targetConn.BytesDown-trackedTargetConn.sampledDown) / elapsed)
+		}
+		trackedTargetConn.sampledAt = now
+		trackedTargetConn.sampledUp = targetConn.BytesUp
+		trackedTargetConn.sampledDown = targetConn.BytesDown
+		trackedTargetConn.mu.Unlock()
+	}
+}
+
+// GetLiveSessions 获取所有活动SSH连接及其打开的转发通道的快照，按连接时间排序
+// 返回: []*LiveSession - 活动连接列表
+func GetLiveSessions() []*LiveSession {
+	connectionsMutex.RLock()
+	sessionsByConnID := make(map[int]*LiveSession)
+	sessionIDs := make(map[int]string)
+	sessions := make([]*LiveSession, 0, len(activeConnections))
+	for sessionID, trackedConn := range activeConnections {
+		conn := trackedConn.Connection
+		session := &LiveSession{
+			ConnectionID: conn.ID,
+			UserID:       conn.UserID,
+			Username:     conn.Username,
+			IP:           conn.IP,
+			ConnectedAt:  conn.ConnectedAt,
+			Channels:     []*LiveChannel{},
+		}
+		if trackedConn.ServerConn != nil {
+			session.ClientVersion = string(trackedConn.ServerConn.ClientVersion())
+			session.AuthMethod = trackedConn.ServerConn.Permissions.Extensions["auth_method"]
+		}
+		trackedConn.mu.Lock()
+		session.LastActivity = trackedConn.LastActivity
+		trackedConn.mu.Unlock()
+
+		sessionsByConnID[conn.ID] = session
+		sessionIDs[conn.ID] = sessionID
+		sessions = append(sessions, session)
+	}
+
+	for _, trackedTargetConn := range activeTargetConnections {
+		trackedTargetConn.mu.Lock()
+		targetConn := trackedTargetConn.TargetConnection
+		channel := &LiveChannel{
+			ID:          targetConn.ID,
+			Target:      targetConn.Target,
+			ChannelType: targetConn.ChannelType,
+			ConnectedAt: targetConn.ConnectedAt,
+			BytesUp:     targetConn.BytesUp,
+			BytesDown:   targetConn.BytesDown,
+			RateUp:      trackedTargetConn.RateUp,
+			RateDown:    trackedTargetConn.RateDown,
+		}
+		trackedTargetConn.mu.Unlock()
+
+		session, exists := sessionsByConnID[targetConn.ConnectionID]
+		if !exists {
+			continue
+		}
+		session.Channels = append(session.Channels, channel)
+		session.BytesUp += channel.BytesUp
+		session.BytesDown += channel.BytesDown
+		session.RateUp += channel.RateUp
+		session.RateDown += channel.RateDown
+	}
+	connectionsMutex.RUnlock()
+
+	// 远程转发监听器
+	remoteForwardsMutex.Lock()
+	for connID, sessionID := range sessionIDs {
+		for key := range activeRemoteForwards[sessionID] {
+			sessionsByConnID[connID].RemoteForwards = append(sessionsByConnID[connID].RemoteForwards, key)
+		}
+	}
+	remoteForwardsMutex.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+	for _, session := range sessions {
+		sort.Strings(session.RemoteForwards)
+		sort.Slice(session.Channels, func(i, j int) bool {
+			return session.Channels[i].ID < session.Channels[j].ID
+		})
+	}
+	return sessions
+}
+
+// CloseLiveSession 强制断开指定的SSH连接，断开原因记录为管理员断开
+// 参数: connectionID - SSH连接ID
+// 返回: error - 连接不存在时返回错误
+func CloseLiveSession(connectionID int) error {
+	var target *TrackedConnection
+	connectionsMutex.RLock()
+	for _, trackedConn := range activeConnections {
+		if trackedConn.Connection.ID == connectionID {
+			target = trackedConn
+			break
+		}
+	}
+	connectionsMutex.RUnlock()
+
+	if target == nil || target.ServerConn == nil {
+		return fmt.Errorf("connection %d is not active", connectionID)
+	}
+	closeSession(target, models.DisconnectReasonAdmin)
+	return nil
+}
+
+// CloseUserSessions 强制断开指定用户的所有SSH连接，用于停用或删除用户后立即生效
+// 参数: userID - 用户ID
+// 返回: int - 断开的连接数
+func CloseUserSessions(userID int) int {
+	var targets []*TrackedConnection
+	connectionsMutex.RLock()
+	for _, trackedConn := range activeConnections {
+		if trackedConn.Connection.UserID == userID && trackedConn.ServerConn != nil {
+			targets = append(targets, trackedConn)
+		}
+	}
+	connectionsMutex.RUnlock()
+
+	for _, trackedConn := range targets {
+		closeSession(trackedConn, models.DisconnectReasonAdmin)
+	}
+	return len(targets)
+}
+
+// CloseLiveChannel 强制关闭指定的转发通道，所属的SSH连接保持不变
+// 参数: channelID - 目标连接ID
+// 返回: error - 通道不存在时返回错误
+func CloseLiveChannel(channelID int) error {
+	connectionsMutex.RLock()
+	trackedTargetConn, exists := activeTargetConnections[channelID]
+	connectionsMutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("channel %d is not active", channelID)
+	}
+
+	trackedTargetConn.mu.Lock()
+	closeFn := trackedTargetConn.closeFn
+	trackedTargetConn.mu.Unlock()
+	if closeFn == nil {
+		return fmt.Errorf("channel %d cannot be closed yet", channelID)
+	}
+
+	log.Printf("Closing target connection %d to %s by administrator", channelID, trackedTargetConn.TargetConnection.Target)
+	closeFn()
+	return nil
+}
+
+// setTargetConnectionCloser 登记关闭目标连接两端的函数，供管理员强制关闭通道
+func setTargetConnectionCloser(targetConnID int, closeFn func()) {
+	connectionsMutex.RLock()
+	trackedTargetConn, exists := activeTargetConnections[targetConnID]
+	connectionsMutex.RUnlock()
+
+	if exists {
+		trackedTargetConn.mu.Lock()
+		trackedTargetConn.closeFn = closeFn
+		trackedTargetConn.mu.Unlock()
+	}
+}
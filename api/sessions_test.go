@@ -0,0 +1,130 @@
+package api
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// liveSessionOf 获取指定用户的实时会话快照
+func liveSessionOf(userID int) *LiveSession {
+	for _, session := range GetLiveSessions() {
+		if session.UserID == userID {
+			return session
+		}
+	}
+	return nil
+}
+
+func TestGetLiveSessions(t *testing.T) {
+	openTestDB(t)
+	port := freeTCPPort(t)
+	user := createTestUser(t, "alice", func(user *models.User) {
+		user.RemoteForwardPorts = strconv.Itoa(int(port))
+	})
+	echoAddr := startEchoServer(t)
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+	trackedSession(t, client)
+
+	conn, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open channel: %v", err)
+	}
+	defer conn.Close()
+	if _, err := client.Listen("tcp", remoteForwardKey("127.0.0.1", port)); err != nil {
+		t.Fatalf("tcpip-forward: %v", err)
+	}
+
+	sampleThroughput()
+	time.Sleep(100 * time.Millisecond)
+	echoThrough(t, conn, []byte("ping"))
+	waitFor(t, "traffic to be counted", func() bool {
+		session := liveSessionOf(user.ID)
+		return session != nil && session.BytesUp == 4 && session.BytesDown == 4
+	})
+	sampleThroughput()
+
+	session := liveSessionOf(user.ID)
+	if session.Username != "alice" || session.AuthMethod != "password" || session.ClientVersion == "" || session.LastActivity.IsZero() {
+		t.Errorf("got session %+v", session)
+	}
+	if len(session.RemoteForwards) != 1 || session.RemoteForwards[0] != remoteForwardKey("127.0.0.1", port) {
+		t.Errorf("got remote forwards %v", session.RemoteForwards)
+	}
+	if len(session.Channels) != 1 {
+		t.Fatalf("got %d channels, want 1", len(session.Channels))
+	}
+	channel := session.Channels[0]
+	if channel.Target != echoAddr || channel.ChannelType != models.ChannelTypeDirectTCPIP || channel.BytesUp != 4 || channel.BytesDown != 4 {
+		t.Errorf("got channel %+v", channel)
+	}
+	// 两次采样之间传输了4字节，速率大于0
+	if channel.RateUp <= 0 || session.RateUp != channel.RateUp {
+		t.Errorf("got channel rate %d, session rate %d", channel.RateUp, session.RateUp)
+	}
+}
+
+func TestCloseLiveChannel(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "alice", nil)
+	echoAddr := startEchoServer(t)
+	client := dialTestSSH(t, startTestSSHServer(t), user.Username)
+
+	conn, err := client.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open channel: %v", err)
+	}
+	defer conn.Close()
+	echoThrough(t, conn, []byte("ping"))
+
+	session := liveSessionOf(user.ID)
+	if session == nil || len(session.Channels) != 1 {
+		t.Fatalf("got session %+v, want one open channel", session)
+	}
+	if err := CloseLiveChannel(session.Channels[0].ID); err != nil {
+		t.Fatalf("close channel: %v", err)
+	}
+
+	// 通道被关闭，SSH连接保持
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("channel still open after CloseLiveChannel")
+	}
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("SSH connection closed with the channel: %v", err)
+	}
+	if err := CloseLiveChannel(99); err == nil {
+		t.Errorf("closed a channel that is not active")
+	}
+}
+
+func TestCloseLiveSession(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice", nil)
+	createTestUser(t, "bob", nil)
+	addr := startTestSSHServer(t)
+	client := dialTestSSH(t, addr, alice.Username)
+	other := dialTestSSH(t, addr, "bob")
+	trackedSession(t, other)
+
+	if err := CloseLiveSession(trackedSession(t, client).Connection.ID); err != nil {
+		t.Fatalf("close session: %v", err)
+	}
+	waitDisconnectReason(t, client, models.DisconnectReasonAdmin)
+
+	// 其他用户的连接不受影响
+	if _, _, err := other.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("other session was closed: %v", err)
+	}
+	if err := CloseLiveSession(99); err == nil {
+		t.Errorf("closed a session that is not active")
+	}
+}
@@ -1,12 +1,12 @@
 package api
 
 import (
-	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/binary"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -26,15 +26,28 @@ import (
 
 // 用于跟踪连接的结构体
 type TrackedConnection struct {
-	Connection *models.Connection
-	UpdatedAt  time.Time
-	mu         sync.Mutex
+	Connection       *models.Connection
+	ServerConn       *ssh.ServerConn // SSH连接，用于服务器主动断开
+	UpdatedAt        time.Time
+	LastActivity     time.Time // 最后一次有通道流量或打开通道的时间，用于判断空闲超时
+	DisconnectReason string    // 服务器主动断开时的原因，为空表示由客户端断开
+	mu               sync.Mutex
 }
 
 // 用于跟踪目标连接的结构体
 type TrackedTargetConnection struct {
 	TargetConnection *models.TargetConnection
+	Session          *TrackedConnection // 所属的SSH连接，可能为nil
 	UpdatedAt        time.Time
+	RateUp           int64 // 最近一个采样周期的上行速率（字节/秒）
+	RateDown         int64 // 最近一个采样周期的下行速率（字节/秒）
+	sampledAt        time.Time
+	sampledUp        int64
+	sampledDown      int64
+	closeFn          func() // 关闭通道两端的函数，开始转发数据后设置
+	rolledUp         bool   // 是否已计入流量汇总的新建连接数
+	rolledUpUp       int64  // 已写入流量汇总的上行流量
+	rolledUpDown     int64  // 已写入流量汇总的下行流量
 	mu               sync.Mutex
 }
 
@@ -43,59 +56,69 @@ var activeConnections = make(map[string]*TrackedConnection)
 var activeTargetConnections = make(map[int]*TrackedTargetConnection)
 var connectionsMutex sync.RWMutex
 
-func StartSSHServer(cfg *config.Config) error {
-	// 创建SSH服务器配置
-	sshConfig := &ssh.ServerConfig{
+// newSSHServerConfig 创建SSH服务器配置（认证回调），不包含主机密钥
+func newSSHServerConfig() *ssh.ServerConfig {
+	return &ssh.ServerConfig{
 		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 			// 验证用户凭据
 			user, err := services.AuthenticateUser(c.User(), string(password))
 			if err != nil {
 				log.Printf("Authentication failed for user %s: %v", c.User(), err)
+				authAttemptsTotal.Inc("password", authResultFailure)
 				return nil, err
 			}
 			
 			// 检查用户是否存在（认证是否成功）
 			if user == nil {
 				log.Printf("Authentication failed for user %s: invalid credentials", c.User())
+				authAttemptsTotal.Inc("password", authResultFailure)
 				return nil, fmt.Errorf("invalid credentials")
 			}
 			
-			log.Printf("Authentication successful for user %s from %s", c.User(), c.RemoteAddr())
-			
-			// 记录连接信息
-			conn := &models.Connection{
-				UserID:      user.ID,
-				Username:    user.Username,
-				IP:          c.RemoteAddr().String(),
-				ConnectedAt: time.Now(),
-				SessionID:   string(c.SessionID()),
+			// 检查并发会话数上限，通过认证横幅告知客户端拒绝原因
+			if err := checkSessionLimit(user); err != nil {
+				log.Printf("Rejected connection for user %s from %s: %v", c.User(), c.RemoteAddr(), err)
+				authAttemptsTotal.Inc("password", authResultRejected)
+				return nil, sessionLimitError(err)
 			}
 			
-			// 记录连接到数据库并获取数据库ID
-			connID, err := utils.RecordConnection(conn)
+			log.Printf("Authentication successful for user %s from %s", c.User(), c.RemoteAddr())
+
+			return userPermissions(user, "password"), nil
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			// 验证公钥是否登记在该用户名下
+			// 注意：此回调可能仅用于查询公钥是否可接受，签名校验在回调之后进行，
+			// 因此连接记录统一在握手完成后（handleConnection）写入
+			user, err := services.AuthenticatePublicKey(c.User(), key)
 			if err != nil {
-				log.Printf("Failed to record connection: %v", err)
-				return nil, err // 认证失败，拒绝连接
+				log.Printf("Public key authentication failed for user %s: %v", c.User(), err)
+				authAttemptsTotal.Inc("publickey", authResultFailure)
+				return nil, err
 			}
 			
-			// 更新连接对象的ID
-			conn.ID = connID
+			if user == nil {
+				log.Printf("Public key authentication failed for user %s: unknown key %s", c.User(), ssh.FingerprintSHA256(key))
+				authAttemptsTotal.Inc("publickey", authResultFailure)
+				return nil, fmt.Errorf("unknown public key")
+			}
 			
-			// 将连接添加到活动连接映射中
-			connectionsMutex.Lock()
-			activeConnections[conn.SessionID] = &TrackedConnection{
-				Connection: conn,
-				UpdatedAt:  time.Now(),
+			if err := checkSessionLimit(user); err != nil {
+				log.Printf("Rejected connection for user %s from %s: %v", c.User(), c.RemoteAddr(), err)
+				authAttemptsTotal.Inc("publickey", authResultRejected)
+				return nil, sessionLimitError(err)
 			}
-			connectionsMutex.Unlock()
 			
-			return &ssh.Permissions{
-				Extensions: map[string]string{
-					"user": c.User(),
-				},
-			}, nil
+			log.Printf("Public key %s accepted for user %s from %s", ssh.FingerprintSHA256(key), c.User(), c.RemoteAddr())
+
+			return userPermissions(user, "publickey"), nil
 		},
 	}
+}
+
+func StartSSHServer(cfg *config.Config) error {
+	// 创建SSH服务器配置
+	sshConfig := newSSHServerConfig()
 
 	// 生成或加载固定的主机密钥
 	privateKey, err := loadOrGenerateHostKey()
@@ -110,6 +133,28 @@ func StartSSHServer(cfg *config.Config) error {
 
 	sshConfig.AddHostKey(private)
 
+	// 配额用尽后的限速
+	quotaThrottleRate = cfg.QuotaThrottleRate
+
+	// 全局带宽限速
+	globalRateLimiter.setRates(cfg.GlobalRateLimitUp, cfg.GlobalRateLimitDown)
+
+	// 默认的会话超时，并启动定期检查超时会话的goroutine
+	defaultIdleTimeout = time.Duration(cfg.IdleTimeout) * time.Minute
+	defaultMaxSessionDuration = time.Duration(cfg.MaxSessionDuration) * time.Minute
+
+	// 流量汇总数据的保留时长
+	rollupMinuteRetention = time.Duration(cfg.RollupMinuteRetentionDays) * 24 * time.Hour
+	rollupHourRetention = time.Duration(cfg.RollupHourRetentionDays) * 24 * time.Hour
+
+	// 防火墙决策记录的保留时长
+	firewallDecisionRetention = time.Duration(cfg.FirewallDecisionRetentionDays) * 24 * time.Hour
+	go enforceSessionTimeoutsPeriodically()
+
+	// 启动定期计算通道实时速率和推送每秒吞吐量的goroutine
+	go sampleThroughputPeriodically()
+	go publishThroughputPeriodically()
+
 	// 启动定期更新数据库中流量统计的goroutine
 	go updateTrafficStatsPeriodically()
 
@@ -147,11 +192,15 @@ func updateTrafficStatsPeriodically() {
 
 // 更新流量统计数据到数据库
 func updateTrafficStats() {
-	connectionsMutex.RLock()
-	defer connectionsMutex.RUnlock()
-
 	// 更新目标连接的流量统计
+	now := time.Now()
+	var rollups []*models.TrafficRollup
+	connectionsMutex.RLock()
 	for _, trackedTargetConn := range activeTargetConnections {
+		if rollup := takeTrafficRollup(trackedTargetConn, now); rollup != nil {
+			rollups = append(rollups, rollup)
+		}
+
 		trackedTargetConn.mu.Lock()
 		targetConn := trackedTargetConn.TargetConnection
 		trackedTargetConn.mu.Unlock()
@@ -162,6 +211,15 @@ func updateTrafficStats() {
 			log.Printf("Failed to update traffic stats for target connection %d: %v", targetConn.ID, err)
 		}
 	}
+	connectionsMutex.RUnlock()
+
+	// 将本周期的流量增量写入按时间汇总的流量表
+	// 在释放锁之后写入，避免等待中的写锁阻塞所有通道的流量计数
+	recordTrafficRollups(rollups)
+	pruneTrafficRollupsIfDue(now)
+
+	// 写入本周期的防火墙决策和规则命中次数
+	flushFirewallDecisions(now)
 }
 
 func handleConnection(conn net.Conn, config *ssh.ServerConfig) {
@@ -172,15 +230,56 @@ func handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 		log.Printf("Failed to handshake: %v", err)
 		return
 	}
+
+	// 认证时已检查过会话数上限，这里预留名额以避免并发登录突破上限
+	user := sessionUser(sshConn)
+	if user == nil {
+		log.Printf("User not found for connection from %s", sshConn.RemoteAddr())
+		sshConn.Close()
+		return
+	}
+	authMethod := sshConn.Permissions.Extensions["auth_method"]
+	release, err := reserveSession(user)
+	if err != nil {
+		log.Printf("Closing connection from %s: %v", sshConn.RemoteAddr(), err)
+		authAttemptsTotal.Inc(authMethod, authResultRejected)
+		sshConn.Close()
+		return
+	}
+	authAttemptsTotal.Inc(authMethod, authResultSuccess)
+
+	// 握手（包括认证）完成后记录连接信息
+	err = recordAuthenticatedConnection(sshConn)
+	release()
+	if err != nil {
+		log.Printf("Failed to record connection: %v", err)
+		sshConn.Close()
+		return
+	}
 	defer func() {
 		// 连接关闭时更新断开时间
 		sessionID := string(sshConn.SessionID())
+		// 关闭该会话的所有远程端口转发监听器
+		closeRemoteForwards(sessionID)
 		connectionsMutex.Lock()
 		if trackedConn, exists := activeConnections[sessionID]; exists {
 			disconnectedAt := time.Now()
+			trackedConn.mu.Lock()
+			reason := trackedConn.DisconnectReason
+			trackedConn.mu.Unlock()
+			if reason == "" {
+				reason = models.DisconnectReasonClient
+			}
 			trackedConn.Connection.DisconnectedAt = &disconnectedAt
-			// 更新断开连接时间
-			utils.UpdateConnectionDisconnectTime(sessionID, disconnectedAt)
+			trackedConn.Connection.DisconnectReason = reason
+			// 更新断开连接时间和断开原因
+			utils.UpdateConnectionDisconnectTime(sessionID, disconnectedAt, reason)
+			publishLiveEvent(LiveEventSessionClose, &SessionEvent{
+				ConnectionID: trackedConn.Connection.ID,
+				Username:     trackedConn.Connection.Username,
+				IP:           trackedConn.Connection.IP,
+				Reason:       reason,
+			})
 			// 从活动连接中移除
 			delete(activeConnections, sessionID)
 		}
@@ -191,7 +290,8 @@ func handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 	log.Printf("New SSH connection from %s (%s)", sshConn.RemoteAddr(), sshConn.ClientVersion())
 	
 	// 全局请求处理
-	go handleGlobalRequests(reqs)
+	openRemoteForwards(string(sshConn.SessionID()))
+	go handleGlobalRequests(reqs, string(sshConn.SessionID()), sshConn)
 	
 	// 通道处理
 	for newChannel := range chans {
@@ -199,14 +299,87 @@ func handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 	}
 }
 
+// sessionLimitError 构造会话数超限的认证错误，错误信息作为认证横幅发送给客户端
+func sessionLimitError(err error) error {
+	return &ssh.BannerError{
+		Err:     err,
+		Message: fmt.Sprintf("Connection rejected: %v\r\n", err),
+	}
+}
+
+// userPermissions 构造认证成功后附加到连接上的权限信息
+func userPermissions(user *models.User, method string) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			"user":        user.Username,
+			"user_id":     strconv.Itoa(user.ID),
+			"auth_method": method,
+		},
+	}
+}
+
+// sessionUser 获取SSH连接对应的用户信息
+func sessionUser(sshConn *ssh.ServerConn) *models.User {
+	userID, err := strconv.Atoi(sshConn.Permissions.Extensions["user_id"])
+	if err != nil {
+		return nil
+	}
+	return services.GetUserByID(userID)
+}
+
+// recordAuthenticatedConnection 记录已认证的SSH连接到数据库并加入活动连接映射
+func recordAuthenticatedConnection(sshConn *ssh.ServerConn) error {
+	userID, err := strconv.Atoi(sshConn.Permissions.Extensions["user_id"])
+	if err != nil {
+		return fmt.Errorf("invalid user id in permissions: %v", err)
+	}
+
+	// 记录连接信息
+	conn := &models.Connection{
+		UserID:      userID,
+		Username:    sshConn.Permissions.Extensions["user"],
+		IP:          sshConn.RemoteAddr().String(),
+		ConnectedAt: time.Now(),
+		SessionID:   string(sshConn.SessionID()),
+	}
+
+	// 记录连接到数据库并获取数据库ID
+	connID, err := utils.RecordConnection(conn)
+	if err != nil {
+		return err
+	}
+
+	// 更新连接对象的ID
+	conn.ID = connID
+
+	// 将连接添加到活动连接映射中
+	connectionsMutex.Lock()
+	activeConnections[conn.SessionID] = &TrackedConnection{
+		Connection:   conn,
+		ServerConn:   sshConn,
+		UpdatedAt:    time.Now(),
+		LastActivity: time.Now(),
+	}
+	connectionsMutex.Unlock()
+
+	log.Printf("User %s authenticated via %s from %s", conn.Username, sshConn.Permissions.Extensions["auth_method"], conn.IP)
+	publishLiveEvent(LiveEventSessionOpen, &SessionEvent{
+		ConnectionID: conn.ID,
+		Username:     conn.Username,
+		IP:           conn.IP,
+	})
+
+	return nil
+}
+
 // handleGlobalRequests 处理全局请求
-func handleGlobalRequests(reqs <-chan *ssh.Request) {
+func handleGlobalRequests(reqs <-chan *ssh.Request, sessionID string, sshConn *ssh.ServerConn) {
 	for req := range reqs {
 		switch req.Type {
 		case "tcpip-forward":
-			handleTCPIPForward(req)
+			handleTCPIPForward(req, sessionID, sshConn)
 		case "cancel-tcpip-forward":
-			handleCancelTCPIPForward(req)
+			handleCancelTCPIPForward(req, sessionID)
 		default:
 			if req.WantReply {
 				req.Reply(false, nil)
@@ -264,6 +437,7 @@ func handleSessionChannel(newChannel ssh.NewChannel, sessionID string) {
 		if err != nil {
 			break
 		}
+		touchSession(sessionID)
 		
 		// 回显数据
 		wn, err := channel.Write(buf[:n])
@@ -289,12 +463,47 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, sessionID string, sshCo
 	
 	targetAddr := net.JoinHostPort(addr, port)
 	
-	// 检查目标地址是否被防火墙允许
-	if !utils.IsAddressAllowed(targetAddr) {
-		log.Printf("Connection to %s rejected by firewall rules", targetAddr)
+	// 获取会话用户，用于评估用户和用户组范围的防火墙规则
+	user := sessionUser(sshConn)
+	if user == nil {
+		log.Printf("User not found for session %x", sessionID)
+		newChannel.Reject(ssh.Prohibited, "user not found")
+		return
+	}
+
+	// 检查流量配额
+	quota := userQuotaTracker(user)
+	if !quota.allowNewChannel() {
+		log.Printf("Connection from user %s to %s rejected: traffic quota exceeded", user.Username, targetAddr)
+		newChannel.Reject(ssh.Prohibited, "traffic quota exceeded")
+		return
+	}
+
+	// 检查并发通道数上限，通道登记到活动目标连接后释放预留的名额
+	release, err := reserveChannel(user)
+	if err != nil {
+		log.Printf("Connection from user %s to %s rejected: %v", user.Username, targetAddr, err)
+		newChannel.Reject(ssh.ResourceShortage, err.Error())
+		return
+	}
+	defer release()
+
+	// 解析目标地址并检查每个解析出的IP是否被防火墙允许
+	dialAddrs, decision, err := utils.ResolveAllowedTarget(user, addr, port)
+	if decision != nil {
+		// 记录防火墙决策，便于审计拒绝记录和统计规则命中
+		utils.RecordFirewallDecision(decision)
+	}
+	if errors.Is(err, utils.ErrTargetDenied) {
+		log.Printf("Connection from user %s to %s rejected by firewall rules: %v", user.Username, targetAddr, err)
 		newChannel.Reject(ssh.Prohibited, "connection to target address is prohibited by firewall rules")
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to resolve target %s: %v", targetAddr, err)
+		newChannel.Reject(ssh.ConnectionFailed, "failed to resolve target address")
+		return
+	}
 	
 	// 接受通道
 	channel, requests, err := newChannel.Accept()
@@ -307,6 +516,59 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, sessionID string, sshCo
 	// 直接拒绝所有通道请求
 	go ssh.DiscardRequests(requests)
 	
+	// 创建并记录目标连接
+	targetConn, ok := startTargetConnection(sessionID, targetAddr, models.ChannelTypeDirectTCPIP)
+	release()
+	if !ok {
+		return
+	}
+
+	// 连接到经过防火墙检查的地址（不再重新解析主机名）
+	targetConnNet, err := dialTarget(dialAddrs)
+	if err != nil {
+		log.Printf("Failed to connect to target %s: %v", targetAddr, err)
+		dialErrorsTotal.Inc()
+		// 更新目标连接的断开时间
+		finishTargetConnection(targetConn.ID)
+		return
+	}
+	defer func() {
+		targetConnNet.Close()
+
+		// 更新目标连接的断开时间
+		finishTargetConnection(targetConn.ID)
+	}()
+
+	log.Printf("Established direct-tcpip connection to %s (%s)", targetAddr, targetConnNet.RemoteAddr())
+
+	// 双向复制数据并统计流量
+	pipeWithTraffic(channel, targetConnNet, targetConn.ID, quota, userRateLimiter(user))
+
+	log.Printf("Closed direct-tcpip connection to %s", targetAddr)
+}
+
+// dialTarget 依次连接已通过防火墙检查的地址，返回第一个成功的连接
+func dialTarget(dialAddrs []string) (net.Conn, error) {
+	var lastErr error
+	for _, dialAddr := range dialAddrs {
+		conn, err := net.Dial("tcp", dialAddr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// startTargetConnection 创建目标连接记录并加入活动目标连接映射
+// 参数:
+//   sessionID - SSH会话ID
+//   target - 目标地址
+//   channelType - 通道类型（direct-tcpip或forwarded-tcpip）
+// 返回:
+//   *models.TargetConnection - 目标连接记录
+//   bool - SSH连接是否存在（不存在时不应继续转发）
+func startTargetConnection(sessionID, target, channelType string) (*models.TargetConnection, bool) {
 	// 获取SSH连接ID
 	var sshConnectionID int
 	connectionsMutex.Lock()
@@ -319,19 +581,23 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, sessionID string, sshCo
 		conn, dbErr := utils.GetConnectionBySessionID(sessionID)
 		if dbErr != nil {
 			log.Printf("SSH connection not found in database for session %s: %v", sessionID, dbErr)
-			return
+			return nil, false
 		}
 		sshConnectionID = conn.ID
 	} else {
 		// 获取SSH连接的数据库ID
 		sshConnectionID = sshConnInfo.Connection.ID
 		connectionsMutex.Unlock()
+
+		// 打开通道视为会话活动
+		sshConnInfo.touch()
 	}
 	
 	// 创建目标连接记录
 	targetConn := &models.TargetConnection{
 		ConnectionID: sshConnectionID, // 使用已确认存在的SSH连接ID
-		Target:       targetAddr,
+		Target:       target,
+		ChannelType:  channelType,
 		ConnectedAt:  time.Now(),
 		BytesUp:      0,
 		BytesDown:    0,
@@ -342,71 +608,100 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, sessionID string, sshCo
 	if err != nil {
 		log.Printf("Failed to record target connection: %v", err)
 		// 即使记录失败，也继续处理连接
-		connectionsMutex.Lock()
-		activeTargetConnections[targetConn.ID] = &TrackedTargetConnection{
-			TargetConnection: targetConn,
-			UpdatedAt:        time.Now(),
-		}
-		connectionsMutex.Unlock()
 	} else {
 		// 使用数据库返回的ID
 		targetConn.ID = targetConnID
-		
-		// 将目标连接添加到活动连接映射中
-		connectionsMutex.Lock()
-		activeTargetConnections[targetConnID] = &TrackedTargetConnection{
-			TargetConnection: targetConn,
-			UpdatedAt:        time.Now(),
-		}
-		connectionsMutex.Unlock()
 	}
 	
-	// 连接到目标地址
-	targetConnNet, err := net.Dial("tcp", targetAddr)
-	if err != nil {
-		log.Printf("Failed to connect to target %s: %v", targetAddr, err)
-		// 更新目标连接的断开时间
-		connectionsMutex.Lock()
-		if trackedTargetConn, exists := activeTargetConnections[targetConn.ID]; exists {
-			disconnectedAt := time.Now()
-			trackedTargetConn.TargetConnection.DisconnectedAt = &disconnectedAt
-			// 更新断开连接时间
-			utils.UpdateTargetConnectionDisconnectTime(trackedTargetConn.TargetConnection.ID, disconnectedAt)
-			// 从活动连接中移除
-			delete(activeTargetConnections, targetConn.ID)
-		}
-		connectionsMutex.Unlock()
-		return
+	// 将目标连接添加到活动连接映射中
+	connectionsMutex.Lock()
+	activeTargetConnections[targetConn.ID] = &TrackedTargetConnection{
+		TargetConnection: targetConn,
+		Session:          sshConnInfo,
+		UpdatedAt:        time.Now(),
 	}
-	defer func() {
-		// 确保只关闭一次
-		var once sync.Once
-		once.Do(func() {
-			if targetConnNet != nil {
-				targetConnNet.Close()
-			}
-		})
-		
-		// 更新目标连接的断开时间
-		connectionsMutex.Lock()
-		if trackedTargetConn, exists := activeTargetConnections[targetConn.ID]; exists {
-			disconnectedAt := time.Now()
-			trackedTargetConn.TargetConnection.DisconnectedAt = &disconnectedAt
-			// 更新断开连接时间
-			utils.UpdateTargetConnectionDisconnectTime(trackedTargetConn.TargetConnection.ID, disconnectedAt)
-			// 从活动连接中移除
-			delete(activeTargetConnections, targetConn.ID)
-		}
-		connectionsMutex.Unlock()
-	}()
+	connectionsMutex.Unlock()
 	
-	log.Printf("Established direct-tcpip connection to %s", targetAddr)
+	publishLiveEvent(LiveEventChannelOpen, newChannelEvent(targetConn, sshConnInfo))
 	
-	// 双向复制数据并统计流量
+	return targetConn, true
+}
+
+// finishTargetConnection 更新目标连接的断开时间并从活动目标连接映射中移除
+func finishTargetConnection(targetConnID int) {
+	var event *ChannelEvent
+	var rollup *models.TrafficRollup
+	connectionsMutex.Lock()
+	if trackedTargetConn, exists := activeTargetConnections[targetConnID]; exists {
+		disconnectedAt := time.Now()
+		trackedTargetConn.TargetConnection.DisconnectedAt = &disconnectedAt
+		// 写入最终流量，避免丢失上次定期更新之后的数据
+		trackedTargetConn.mu.Lock()
+		bytesUp, bytesDown := trackedTargetConn.TargetConnection.BytesUp, trackedTargetConn.TargetConnection.BytesDown
+		trackedTargetConn.mu.Unlock()
+		utils.UpdateTargetConnectionTraffic(trackedTargetConn.TargetConnection.ID, bytesUp, bytesDown)
+		rollup = takeTrafficRollup(trackedTargetConn, disconnectedAt)
+		event = newChannelEvent(trackedTargetConn.TargetConnection, trackedTargetConn.Session)
+		event.BytesUp, event.BytesDown = bytesUp, bytesDown
+		// 更新断开连接时间
+		utils.UpdateTargetConnectionDisconnectTime(trackedTargetConn.TargetConnection.ID, disconnectedAt)
+		// 从活动连接中移除
+		delete(activeTargetConnections, targetConnID)
+	}
+	connectionsMutex.Unlock()
+
+	// 流量汇总在释放锁之后写入
+	if rollup != nil {
+		recordTrafficRollups([]*models.TrafficRollup{rollup})
+	}
+	if event != nil {
+		publishLiveEvent(LiveEventChannelClose, event)
+	}
+}
+
+// newChannelEvent 构造转发通道事件，session为nil时不填写用户信息
+func newChannelEvent(targetConn *models.TargetConnection, session *TrackedConnection) *ChannelEvent {
+	event := &ChannelEvent{
+		ID:           targetConn.ID,
+		ConnectionID: targetConn.ConnectionID,
+		Target:       targetConn.Target,
+		ChannelType:  targetConn.ChannelType,
+	}
+	if session != nil {
+		event.Username = session.Connection.Username
+	}
+	return event
+}
+
+// pipeWithTraffic 在SSH通道与网络连接之间双向复制数据并统计流量
+// 上行流量为从SSH客户端发往网络连接的数据，下行流量为从网络连接发往SSH客户端的数据；
+// quota不为nil时，每次传输后检查用户的流量配额，按配额的处理方式限速或断开通道；
+// 每次传输前按用户（limiter不为nil时）和全局的令牌桶限速，上行和下行分别计算
+func pipeWithTraffic(channel ssh.Channel, netConn net.Conn, targetConnID int, quota *quotaTracker, limiter *rateLimiter) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 	
-	// 从SSH通道复制到目标连接
+	// 关闭两端，使另一个方向的复制也随之结束；管理员可通过实时会话页面调用
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			channel.Close()
+			netConn.Close()
+		})
+	}
+	setTargetConnectionCloser(targetConnID, closeBoth)
+
+	// 配额用尽且处理方式为drop时关闭该用户所有打开的通道（包括空闲的通道）
+	var dropOnce sync.Once
+	drop := func() {
+		dropOnce.Do(func() {
+			log.Printf("Dropping target connection %d: traffic quota exceeded", targetConnID)
+			closeBoth()
+			dropUserChannels(quota)
+		})
+	}
+
+	// 从SSH通道复制到网络连接
 	go func() {
 		defer wg.Done()
 		buf := make([]byte, 32*1024)
@@ -414,9 +709,19 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, sessionID string, sshCo
 			n, err := channel.Read(buf)
 			if n > 0 {
 				// 更新上行流量统计（从客户端到目标）
-				updateTargetTraffic(targetConn.ID, int64(n), 0)
+				updateTargetTraffic(targetConnID, int64(n), 0)
+
+				// 检查流量配额
+				delay, shouldDrop := quota.consume(int64(n))
+				if shouldDrop {
+					drop()
+					break
+				}
+
+				// 按上行限速等待
+				time.Sleep(max(delay, limiter.reserveUp(int64(n))))
 				
-				wn, writeErr := targetConnNet.Write(buf[:n])
+				wn, writeErr := netConn.Write(buf[:n])
 				if writeErr != nil {
 					break
 				}
@@ -431,17 +736,31 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, sessionID string, sshCo
 				break
 			}
 		}
+		// 通知对端不会再有数据写入
+		if tcpConn, ok := netConn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
 	}()
 	
-	// 从目标连接复制到SSH通道
+	// 从网络连接复制到SSH通道
 	go func() {
 		defer wg.Done()
 		buf := make([]byte, 32*1024)
 		for {
-			n, err := targetConnNet.Read(buf)
+			n, err := netConn.Read(buf)
 			if n > 0 {
 				// 更新下行流量统计（从目标到客户端）
-				updateTargetTraffic(targetConn.ID, 0, int64(n))
+				updateTargetTraffic(targetConnID, 0, int64(n))
+
+				// 检查流量配额
+				delay, shouldDrop := quota.consume(int64(n))
+				if shouldDrop {
+					drop()
+					break
+				}
+
+				// 按下行限速等待
+				time.Sleep(max(delay, limiter.reserveDown(int64(n))))
 				
 				wn, writeErr := channel.Write(buf[:n])
 				if writeErr != nil {
@@ -458,10 +777,10 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, sessionID string, sshCo
 				break
 			}
 		}
+		channel.CloseWrite()
 	}()
 	
 	wg.Wait()
-	log.Printf("Closed direct-tcpip connection to %s", targetAddr)
 }
 
 // updateTargetTraffic 更新指定目标连接的流量统计
@@ -476,69 +795,17 @@ func updateTargetTraffic(targetConnID int, bytesUp, bytesDown int64) {
 		trackedTargetConn.TargetConnection.BytesDown += bytesDown
 		trackedTargetConn.UpdatedAt = time.Now()
 		trackedTargetConn.mu.Unlock()
-	}
-}
 
-// handleTCPIPForward 处理TCP/IP转发请求
-func handleTCPIPForward(req *ssh.Request) {
-	// 解析请求数据
-	payload := req.Payload
-	
-	// 读取地址和端口
-	r := bytes.NewReader(payload)
-	
-	// 读取地址长度
-	addrLenBytes := make([]byte, 4)
-	_, err := r.Read(addrLenBytes)
-	if err != nil {
-		if req.WantReply {
-			req.Reply(false, nil)
-		}
-		return
-	}
-	
-	addrLen := binary.BigEndian.Uint32(addrLenBytes)
-	
-	// 读取地址
-	addrBytes := make([]byte, addrLen)
-	_, err = r.Read(addrBytes)
-	if err != nil {
-		if req.WantReply {
-			req.Reply(false, nil)
-		}
-		return
-	}
-	
-	// 读取端口
-	portBytes := make([]byte, 4)
-	_, err = r.Read(portBytes)
-	if err != nil {
-		if req.WantReply {
-			req.Reply(false, nil)
-		}
-		return
-	}
-	
-	port := binary.BigEndian.Uint32(portBytes)
-	
-	log.Printf("TCP/IP forward request for %s:%d", string(addrBytes), port)
-	
-	if req.WantReply {
-		// 返回绑定的端口（这里简化处理，返回请求的端口）
-		replyPort := make([]byte, 4)
-		binary.BigEndian.PutUint32(replyPort, port)
-		req.Reply(true, replyPort)
-	}
-	
-	log.Printf("Accepted tcpip-forward request for %s:%d", string(addrBytes), port)
-}
+		// 通道流量视为会话活动
+		trackedTargetConn.Session.touch()
 
-// handleCancelTCPIPForward 处理取消TCP/IP转发请求
-func handleCancelTCPIPForward(req *ssh.Request) {
-	if req.WantReply {
-		req.Reply(true, nil)
+		// 累计流量，用于实时仪表盘
+		username := ""
+		if trackedTargetConn.Session != nil {
+			username = trackedTargetConn.Session.Connection.Username
+		}
+		countTraffic(username, bytesUp, bytesDown)
 	}
-	log.Printf("Accepted cancel-tcpip-forward request")
 }
 
 // parseDirectTCPIPData 解析direct-tcpip通道的额外数据
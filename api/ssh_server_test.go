@@ -0,0 +1,245 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/services"
+	"ssh-manage/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// openTestDB 使用内存数据库并初始化防火墙规则表，测试结束后关闭
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := utils.OpenDB(":memory:"); err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	utils.InitFirewall()
+	t.Cleanup(func() {
+		utils.CloseDB()
+
+		// 内存数据库的用户ID每次从1开始，清除按用户ID保存的状态，避免影响后续测试
+		quotaTrackersMutex.Lock()
+		quotaTrackers = make(map[int]*quotaTracker)
+		quotaTrackersMutex.Unlock()
+		userRateLimitersMutex.Lock()
+		userRateLimiters = make(map[int]*rateLimiter)
+		userRateLimitersMutex.Unlock()
+	})
+}
+
+// createTestUser 创建已激活的测试用户，密码与用户名相同
+// 参数:
+//   username - 用户名
+//   configure - 保存前修改用户设置，可以为nil
+func createTestUser(t *testing.T, username string, configure func(user *models.User)) *models.User {
+	t.Helper()
+	user := &models.User{Name: username, Username: username, Password: username, Active: true, Created: time.Now()}
+	if configure != nil {
+		configure(user)
+	}
+	created, err := services.CreateUser(user)
+	if err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	return created
+}
+
+// startTestSSHServer 在本机随机端口启动SSH服务器，测试结束后停止监听
+// 返回: string - 监听地址
+func startTestSSHServer(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("create host key signer: %v", err)
+	}
+	sshConfig := newSSHServerConfig()
+	sshConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(conn, sshConfig)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// dialTestSSH 使用密码认证连接测试SSH服务器，密码与用户名相同
+func dialTestSSH(t *testing.T, addr, username string) *ssh.Client {
+	t.Helper()
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.Password(username)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("dial %s as %s: %v", addr, username, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// waitFor 轮询直到condition返回true，超时后测试失败
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// freeTCPPort 获取本机当前空闲的TCP端口
+func freeTCPPort(t *testing.T) uint32 {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	return uint32(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// portIsFree 检查本机端口当前是否可以绑定
+func portIsFree(port uint32) bool {
+	listener, err := net.Listen("tcp", remoteForwardKey("127.0.0.1", port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// newTestSigner 生成ed25519客户端密钥
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("create client key signer: %v", err)
+	}
+	return signer
+}
+
+// dialWithKey 使用公钥认证连接测试SSH服务器
+func dialWithKey(addr, username string, signer ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestPublicKeyAuthentication(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice", nil)
+	createTestUser(t, "bob", nil)
+	addr := startTestSSHServer(t)
+
+	registered := newTestSigner(t)
+	if _, err := services.AddUserKey(alice.ID, "", string(ssh.MarshalAuthorizedKey(registered.PublicKey()))); err != nil {
+		t.Fatalf("add key: %v", err)
+	}
+
+	client, err := dialWithKey(addr, "alice", registered)
+	if err != nil {
+		t.Fatalf("login with a registered key: %v", err)
+	}
+	client.Close()
+
+	// 未登记的公钥、登记在其他用户名下的公钥都被拒绝
+	if client, err := dialWithKey(addr, "alice", newTestSigner(t)); err == nil {
+		client.Close()
+		t.Errorf("login with an unknown key succeeded")
+	}
+	if client, err := dialWithKey(addr, "bob", registered); err == nil {
+		client.Close()
+		t.Errorf("login as bob with alice's key succeeded")
+	}
+
+	// 停用的用户不能使用公钥登录
+	if _, err := services.SetUserActive(alice.ID, false); err != nil {
+		t.Fatalf("deactivate user: %v", err)
+	}
+	if client, err := dialWithKey(addr, "alice", registered); err == nil {
+		client.Close()
+		t.Errorf("login of an inactive user succeeded")
+	}
+}
+
+// dialWithPassword 使用密码认证连接测试SSH服务器
+func dialWithPassword(addr, username, password string) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestPasswordAuthentication(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "alice", nil)
+	addr := startTestSSHServer(t)
+
+	// 创建用户时密码以bcrypt哈希保存
+	stored, err := utils.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if !utils.IsPasswordHash(stored.Password) {
+		t.Fatalf("password stored as %q, want a bcrypt hash", stored.Password)
+	}
+
+	// 使用哈希值本身作为密码不能登录
+	for _, password := range []string{"wrong", stored.Password} {
+		if client, err := dialWithPassword(addr, "alice", password); err == nil {
+			client.Close()
+			t.Errorf("login with password %q succeeded", password)
+		}
+	}
+	if client, err := dialWithPassword(addr, "nobody", "nobody"); err == nil {
+		client.Close()
+		t.Errorf("login of an unknown user succeeded")
+	}
+
+	// 重置密码后只能使用新密码登录
+	if _, err := services.ResetUserPassword(user.ID, "changed"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if client, err := dialWithPassword(addr, "alice", "alice"); err == nil {
+		client.Close()
+		t.Errorf("login with the old password succeeded")
+	}
+	client, err := dialWithPassword(addr, "alice", "changed")
+	if err != nil {
+		t.Fatalf("login with the new password: %v", err)
+	}
+	client.Close()
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"log"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/services"
+)
+
+// 默认的会话超时，用户未单独设置时使用，0表示不限制
+var defaultIdleTimeout time.Duration
+var defaultMaxSessionDuration time.Duration
+
+// sessionTimeoutCheckInterval 检查超时会话的间隔
+const sessionTimeoutCheckInterval = 15 * time.Second
+
+// touch 记录会话活动，用于判断空闲超时
+func (c *TrackedConnection) touch() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.LastActivity = time.Now()
+	c.mu.Unlock()
+}
+
+// touchSession 记录指定会话的活动
+func touchSession(sessionID string) {
+	connectionsMutex.RLock()
+	trackedConn := activeConnections[sessionID]
+	connectionsMutex.RUnlock()
+
+	trackedConn.touch()
+}
+
+// sessionTimeouts 获取用户的空闲超时和最长会话时长，用户未设置时使用全局设置
+// 参数: user - 用户信息，为nil时使用全局设置
+// 返回:
+//   time.Duration - 空闲超时，0表示不限制
+//   time.Duration - 最长会话时长，0表示不限制
+func sessionTimeouts(user *models.User) (time.Duration, time.Duration) {
+	idle, maxDuration := defaultIdleTimeout, defaultMaxSessionDuration
+	if user != nil && user.IdleTimeout > 0 {
+		idle = time.Duration(user.IdleTimeout) * time.Minute
+	}
+	if user != nil && user.MaxSessionDuration > 0 {
+		maxDuration = time.Duration(user.MaxSessionDuration) * time.Minute
+	}
+	return idle, maxDuration
+}
+
+// 定期断开空闲超时或超过最长时长的会话
+func enforceSessionTimeoutsPeriodically() {
+	ticker := time.NewTicker(sessionTimeoutCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		enforceSessionTimeouts()
+	}
+}
+
+// enforceSessionTimeouts 检查所有活动会话，断开空闲超时或超过最长时长的会话
+// 每次检查时重新读取用户设置，修改后对已有会话同样生效
+func enforceSessionTimeouts() {
+	connectionsMutex.RLock()
+	sessions := make([]*TrackedConnection, 0, len(activeConnections))
+	for _, trackedConn := range activeConnections {
+		sessions = append(sessions, trackedConn)
+	}
+	connectionsMutex.RUnlock()
+
+	now := time.Now()
+	users := make(map[int]*models.User)
+	for _, trackedConn := range sessions {
+		userID := trackedConn.Connection.UserID
+		user, ok := users[userID]
+		if !ok {
+			user = services.GetUserByID(userID)
+			users[userID] = user
+		}
+		idle, maxDuration := sessionTimeouts(user)
+
+		trackedConn.mu.Lock()
+		lastActivity := trackedConn.LastActivity
+		trackedConn.mu.Unlock()
+
+		switch {
+		case maxDuration > 0 && now.Sub(trackedConn.Connection.ConnectedAt) >= maxDuration:
+			closeSession(trackedConn, models.DisconnectReasonMaxDuration)
+		case idle > 0 && now.Sub(lastActivity) >= idle:
+			closeSession(trackedConn, models.DisconnectReasonIdleTimeout)
+		}
+	}
+}
+
+// closeSession 由服务器主动断开SSH连接，并记录断开原因
+// 断开时间和原因由handleConnection在连接关闭后写入数据库
+// 参数:
+//   trackedConn - 要断开的连接
+//   reason - 断开原因
+func closeSession(trackedConn *TrackedConnection, reason string) {
+	trackedConn.mu.Lock()
+	if trackedConn.DisconnectReason != "" {
+		// 已经在断开中
+		trackedConn.mu.Unlock()
+		return
+	}
+	trackedConn.DisconnectReason = reason
+	trackedConn.mu.Unlock()
+
+	log.Printf("Closing SSH connection of user %s from %s: %s", trackedConn.Connection.Username, trackedConn.Connection.IP, reason)
+	trackedConn.ServerConn.Close()
+}
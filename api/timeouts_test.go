@@ -0,0 +1,121 @@
+package api
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// trackedSession 等待客户端连接登记为活动会话并返回该会话
+// 客户端认证完成后服务器才写入连接记录，登记可能稍晚于ssh.Dial返回
+func trackedSession(t *testing.T, client *ssh.Client) *TrackedConnection {
+	t.Helper()
+	var trackedConn *TrackedConnection
+	waitFor(t, "session of "+client.User()+" to be registered", func() bool {
+		connectionsMutex.RLock()
+		defer connectionsMutex.RUnlock()
+		trackedConn = activeConnections[string(client.SessionID())]
+		return trackedConn != nil
+	})
+	return trackedConn
+}
+
+// waitDisconnectReason 等待连接关闭，并检查数据库中记录的断开原因
+func waitDisconnectReason(t *testing.T, client *ssh.Client, want string) {
+	t.Helper()
+	sessionID := string(client.SessionID())
+	waitFor(t, client.User()+" to be disconnected", func() bool {
+		connectionsMutex.RLock()
+		defer connectionsMutex.RUnlock()
+		_, exists := activeConnections[sessionID]
+		return !exists
+	})
+	conn, err := utils.GetConnectionBySessionID(sessionID)
+	if err != nil {
+		t.Fatalf("get connection: %v", err)
+	}
+	if conn.DisconnectReason != want || conn.DisconnectedAt == nil {
+		t.Errorf("%s: got disconnect reason %q at %v, want %q", client.User(), conn.DisconnectReason, conn.DisconnectedAt, want)
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	previousIdle, previousMax := defaultIdleTimeout, defaultMaxSessionDuration
+	defaultIdleTimeout, defaultMaxSessionDuration = 10*time.Minute, time.Hour
+	t.Cleanup(func() { defaultIdleTimeout, defaultMaxSessionDuration = previousIdle, previousMax })
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		idle     time.Duration
+		duration time.Duration
+	}{
+		{"no user", nil, 10 * time.Minute, time.Hour},
+		{"user without settings", &models.User{}, 10 * time.Minute, time.Hour},
+		{"user idle timeout", &models.User{IdleTimeout: 5}, 5 * time.Minute, time.Hour},
+		{"user max duration", &models.User{MaxSessionDuration: 120}, 10 * time.Minute, 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if idle, duration := sessionTimeouts(tt.user); idle != tt.idle || duration != tt.duration {
+			t.Errorf("%s: got (%v, %v), want (%v, %v)", tt.name, idle, duration, tt.idle, tt.duration)
+		}
+	}
+}
+
+func TestEnforceSessionTimeouts(t *testing.T) {
+	openTestDB(t)
+	idleUser := createTestUser(t, "idle", func(user *models.User) {
+		user.IdleTimeout = 1
+	})
+	longUser := createTestUser(t, "long", func(user *models.User) {
+		user.MaxSessionDuration = 1
+	})
+	createTestUser(t, "active", func(user *models.User) {
+		user.IdleTimeout = 1
+		user.MaxSessionDuration = 1
+	})
+	echoAddr := startEchoServer(t)
+	addr := startTestSSHServer(t)
+
+	idle := dialTestSSH(t, addr, idleUser.Username)
+	long := dialTestSSH(t, addr, longUser.Username)
+	active := dialTestSSH(t, addr, "active")
+
+	// 模拟idle用户2分钟没有活动，long用户在2分钟前登录
+	idleSession := trackedSession(t, idle)
+	idleSession.mu.Lock()
+	idleSession.LastActivity = time.Now().Add(-2 * time.Minute)
+	idleSession.mu.Unlock()
+	longSession := trackedSession(t, long)
+	connectionsMutex.Lock()
+	longSession.Connection.ConnectedAt = time.Now().Add(-2 * time.Minute)
+	connectionsMutex.Unlock()
+
+	// active用户同样很久没有活动，但打开通道会记录活动
+	activeSession := trackedSession(t, active)
+	activeSession.mu.Lock()
+	activeSession.LastActivity = time.Now().Add(-2 * time.Minute)
+	activeSession.mu.Unlock()
+	conn, err := active.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("open channel: %v", err)
+	}
+	echoThrough(t, conn, []byte("ping"))
+	defer conn.Close()
+
+	enforceSessionTimeouts()
+
+	waitDisconnectReason(t, idle, models.DisconnectReasonIdleTimeout)
+	waitDisconnectReason(t, long, models.DisconnectReasonMaxDuration)
+	if _, _, err := active.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("active session was disconnected: %v", err)
+	}
+
+	// 客户端主动断开时记录为client
+	active.Close()
+	waitDisconnectReason(t, active, models.DisconnectReasonClient)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"path/filepath"
 	"os"
+	"strconv"
 )
 
 // Config 应用配置结构体
@@ -11,8 +12,28 @@ type Config struct {
 	WebPort      string // Web服务端口
 	DBPath       string // 数据库文件路径
 	LogPath      string // 日志文件路径
-	WebUsername  string // Web管理界面用户名
-	WebPassword  string // Web管理界面密码
+	WebUsername  string // 首次启动时创建的Web管理员用户名
+	WebPassword  string // 首次启动时创建的Web管理员密码
+	DNSServer    string // 解析转发目标使用的DNS服务器（host:port），为空时使用系统解析器
+
+	FirewallMode                  string // 防火墙评估模式："compat"（白名单/黑名单兼容模式）或"ordered"（按优先级首条命中）
+	FirewallDefaultPolicy         string // 顺序模式下未命中任何规则时的默认策略："allow"或"deny"
+	FirewallDecisionRetentionDays int64  // 防火墙决策记录保留天数，0表示永久保留
+
+	QuotaThrottleRate int64 // 流量配额用尽且处理方式为throttle时每个用户的速率上限（字节/秒）
+
+	GlobalRateLimitUp   int64 // 所有用户合计的上行速率上限（字节/秒），0表示不限制
+	GlobalRateLimitDown int64 // 所有用户合计的下行速率上限（字节/秒），0表示不限制
+
+	IdleTimeout        int64 // 默认的空闲超时（分钟），没有通道流量超过该时长后断开SSH连接，0表示不限制
+	MaxSessionDuration int64 // 默认的SSH连接最长持续时长（分钟），0表示不限制
+
+	RollupMinuteRetentionDays int64 // 按分钟汇总的流量数据保留天数
+	RollupHourRetentionDays   int64 // 按小时汇总的流量数据保留天数，按天汇总的数据永久保留
+
+	ReportDir      string // 使用报表的输出目录
+	ReportSchedule string // 自动生成使用报表的周期："daily"、"weekly"、"monthly"，为空表示不自动生成
+	ReportFormats  string // 使用报表的文件格式，逗号分隔："csv"、"json"
 }
 
 // Load 加载应用配置
@@ -29,8 +50,28 @@ func Load() *Config {
 		WebPort:      "53380",                              // 默认Web端口
 		DBPath:       filepath.Join(wd, "data", "ssh_manage.db"), // 数据库路径
 		LogPath:      filepath.Join(wd, "logs", "ssh_manage.log"), // 日志路径
-		WebUsername:  getEnvOrDefault("WEB_USERNAME", "admin"),   // Web管理界面用户名，默认为admin
-		WebPassword:  getEnvOrDefault("WEB_PASSWORD", "admin123"), // Web管理界面密码，默认为admin123
+		WebUsername:  getEnvOrDefault("WEB_USERNAME", "admin"),   // 首次启动时创建的Web管理员用户名，默认为admin
+		WebPassword:  getEnvOrDefault("WEB_PASSWORD", "admin123"), // 首次启动时创建的Web管理员密码，默认为admin123
+		DNSServer:    getEnvOrDefault("DNS_SERVER", ""),           // 转发目标DNS服务器，默认使用系统解析器
+
+		FirewallMode:                  getEnvOrDefault("FIREWALL_MODE", "compat"),                            // 防火墙评估模式，默认为兼容模式
+		FirewallDefaultPolicy:         getEnvOrDefault("FIREWALL_DEFAULT_POLICY", "allow"),                   // 顺序模式默认策略，默认为允许
+		FirewallDecisionRetentionDays: getEnvNonNegativeInt64OrDefault("FIREWALL_DECISION_RETENTION_DAYS", 30), // 防火墙决策记录默认保留30天
+
+		QuotaThrottleRate: getEnvInt64OrDefault("QUOTA_THROTTLE_RATE", 64*1024), // 配额用尽后的限速，默认为64KB/s
+
+		GlobalRateLimitUp:   getEnvInt64OrDefault("GLOBAL_RATE_LIMIT_UP", 0),   // 全局上行限速，默认不限制
+		GlobalRateLimitDown: getEnvInt64OrDefault("GLOBAL_RATE_LIMIT_DOWN", 0), // 全局下行限速，默认不限制
+
+		IdleTimeout:        getEnvInt64OrDefault("IDLE_TIMEOUT_MINUTES", 0), // 默认空闲超时，默认不限制
+		MaxSessionDuration: getEnvInt64OrDefault("MAX_SESSION_MINUTES", 0),  // 默认最长会话时长，默认不限制
+
+		RollupMinuteRetentionDays: getEnvInt64OrDefault("ROLLUP_MINUTE_RETENTION_DAYS", 7), // 分钟汇总默认保留7天
+		RollupHourRetentionDays:   getEnvInt64OrDefault("ROLLUP_HOUR_RETENTION_DAYS", 90),  // 小时汇总默认保留90天
+
+		ReportDir:      getEnvOrDefault("REPORT_DIR", filepath.Join(wd, "data", "reports")), // 报表输出目录，默认为data/reports
+		ReportSchedule: getEnvOrDefault("REPORT_SCHEDULE", ""),                           // 默认不自动生成报表
+		ReportFormats:  getEnvOrDefault("REPORT_FORMATS", "csv,json"),                    // 默认同时生成CSV和JSON
 	}
 }
 
@@ -41,4 +82,22 @@ func getEnvOrDefault(key, defaultValue string) string {
 		return defaultValue
 	}
 	return value
+}
+
+// getEnvInt64OrDefault 获取整数类型的环境变量，如果不存在或不是正整数则返回默认值
+func getEnvInt64OrDefault(key string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvNonNegativeInt64OrDefault 获取整数类型的环境变量，允许0，如果不存在或为负数则返回默认值
+func getEnvNonNegativeInt64OrDefault(key string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
 }
\ No newline at end of file
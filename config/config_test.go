@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestFirewallDecisionRetentionDays(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", 30},
+		{"0", 0},
+		{"7", 7},
+		{"-1", 30},
+		{"forever", 30},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("FIREWALL_DECISION_RETENTION_DAYS", tt.value)
+		if got := Load().FirewallDecisionRetentionDays; got != tt.want {
+			t.Errorf("FIREWALL_DECISION_RETENTION_DAYS=%q: got %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"ssh-manage/api"
 	"ssh-manage/config"
+	"ssh-manage/services"
 	"ssh-manage/utils"
 	"ssh-manage/web"
 )
@@ -19,9 +20,16 @@ func main() {
 	// 初始化防火墙模块
 	utils.InitFirewall()
 	
+	// 配置解析转发目标使用的DNS解析器
+	utils.SetResolver(utils.NewResolver(config.Load().DNSServer))
+
+	// 按配置的周期自动生成使用报表
+	services.StartUsageReportScheduler(config.Load())
+
 	// 启动Web服务
 	go func() {
 		http.HandleFunc("/", web.Handler)
+		http.HandleFunc("/api/", api.Handler)
 		cfg := config.Load()
 		log.Printf("Web server listening on port %s", cfg.WebPort)
 		err := http.ListenAndServe(":"+cfg.WebPort, nil)
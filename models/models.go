@@ -7,37 +7,195 @@ type User struct {
 	ID       int       `json:"id"`       // 用户ID
 	Name     string    `json:"name"`     // 昵称
 	Username string    `json:"username"` // 用户名
-	Password string    `json:"password"` // 密码
-	Created  time.Time // 创建时间
-	Active   bool      // 是否激活
+	Password string    `json:"password,omitempty"` // 密码，JSON接口返回用户信息时清空
+	Created  time.Time `json:"created"`            // 创建时间
+	Active   bool      `json:"active"`             // 是否激活
+	Group    string    `json:"group"` // 所属用户组，用于匹配用户组范围的防火墙规则
+
+	// 远程端口转发（ssh -R）策略
+	RemoteForwardAddrs    string `json:"remote_forward_addrs"`     // 允许绑定的地址，逗号分隔，"*"表示任意地址，为空时仅允许回环地址
+	RemoteForwardPorts    string `json:"remote_forward_ports"`     // 允许绑定的端口范围，如"8000-8100,9000"，为空时禁止远程转发
+	MaxRemoteForwards     int    `json:"max_remote_forwards"`      // 同时存在的远程转发监听器数量上限，0表示不限制
+	RemoteForwardAutoPort bool   `json:"remote_forward_auto_port"` // 请求端口为0时是否在允许范围内自动分配端口
+
+	// 流量配额（上行和下行流量合计）
+	DailyQuota   int64  `json:"daily_quota"`   // 每日流量配额（字节），0表示不限制
+	MonthlyQuota int64  `json:"monthly_quota"` // 每月流量配额（字节），0表示不限制
+	QuotaAction  string `json:"quota_action"`  // 配额用尽后的处理方式："reject"、"throttle"或"drop"
+
+	// 带宽限速（该用户所有通道共享）
+	RateLimitUp   int64 `json:"rate_limit_up"`   // 上行速率上限（字节/秒），0表示不限制
+	RateLimitDown int64 `json:"rate_limit_down"` // 下行速率上限（字节/秒），0表示不限制
+
+	// 并发限制
+	MaxSessions int `json:"max_sessions"` // 同时存在的SSH会话数上限，0表示不限制
+	MaxChannels int `json:"max_channels"` // 同时打开的转发通道数上限（所有会话合计），0表示不限制
+
+	// 会话超时（分钟），0表示使用全局设置
+	IdleTimeout        int `json:"idle_timeout"`         // 没有通道流量超过该时长后断开SSH连接
+	MaxSessionDuration int `json:"max_session_duration"` // SSH连接的最长持续时长
 }
 
+// 流量配额用尽后的处理方式
+const (
+	QuotaActionReject   = "reject"   // 拒绝新的通道，已有通道继续
+	QuotaActionThrottle = "throttle" // 允许新的通道，但限制该用户所有通道的速率
+	QuotaActionDrop     = "drop"     // 拒绝新的通道并断开已有通道
+)
+
 // Connection 连接记录模型
 type Connection struct {
-	ID             int        // 连接ID
-	UserID         int        // 用户ID
-	Username       string     // 用户名
-	IP             string     // 客户端IP地址
-	ConnectedAt    time.Time  // 连接时间
-	DisconnectedAt *time.Time // 断开时间
-	SessionID      string     // 会话ID
+	ID               int        `json:"id"`                // 连接ID
+	UserID           int        `json:"user_id"`           // 用户ID
+	Username         string     `json:"username"`          // 用户名
+	IP               string     `json:"ip"`                // 客户端IP地址
+	ConnectedAt      time.Time  `json:"connected_at"`      // 连接时间
+	DisconnectedAt   *time.Time `json:"disconnected_at"`   // 断开时间
+	SessionID        string     `json:"session_id"`        // 会话ID
+	DisconnectReason string     `json:"disconnect_reason"` // 断开原因，见DisconnectReason*常量，未断开时为空
 }
 
+// SSH连接的断开原因
+const (
+	DisconnectReasonClient      = "client"       // 客户端断开或网络中断
+	DisconnectReasonIdleTimeout = "idle_timeout" // 空闲超时
+	DisconnectReasonMaxDuration = "max_duration" // 超过最长会话时长
+	DisconnectReasonAdmin       = "admin"        // 管理员强制断开
+)
+
 // TargetConnection 目标连接记录模型
 type TargetConnection struct {
-	ID             int        // 目标连接ID
-	ConnectionID   int        // SSH连接ID
-	Target         string     // 目标地址
-	ConnectedAt    time.Time  // 连接时间
-	DisconnectedAt *time.Time // 断开时间
-	BytesUp        int64      // 上行流量（字节）
-	BytesDown      int64      // 下行流量（字节）
+	ID             int        `json:"id"`              // 目标连接ID
+	ConnectionID   int        `json:"connection_id"`   // SSH连接ID
+	Target         string     `json:"target"`          // 目标地址
+	ChannelType    string     `json:"channel_type"`    // 通道类型："direct-tcpip"（本地转发）或"forwarded-tcpip"（远程转发）
+	ConnectedAt    time.Time  `json:"connected_at"`    // 连接时间
+	DisconnectedAt *time.Time `json:"disconnected_at"` // 断开时间
+	BytesUp        int64      `json:"bytes_up"`        // 上行流量（字节）
+	BytesDown      int64      `json:"bytes_down"`      // 下行流量（字节）
 }
 
+// 目标连接的通道类型
+const (
+	ChannelTypeDirectTCPIP    = "direct-tcpip"    // 本地/动态端口转发（ssh -L / -D）
+	ChannelTypeForwardedTCPIP = "forwarded-tcpip" // 远程端口转发（ssh -R）
+)
+
+// TrafficRollup 按时间桶、用户和目标地址汇总的流量
+type TrafficRollup struct {
+	Granularity string    // 时间桶粒度
+	BucketStart time.Time // 时间桶开始时间
+	UserID      int       // 用户ID
+	Username    string    // 用户名
+	Target      string    // 目标地址
+	BytesUp     int64     // 上行流量（字节）
+	BytesDown   int64     // 下行流量（字节）
+	Channels    int       // 新打开的目标连接数
+}
+
+// 流量汇总的时间桶粒度
+const (
+	RollupGranularityMinute = "minute" // 按分钟
+	RollupGranularityHour   = "hour"   // 按小时
+	RollupGranularityDay    = "day"    // 按天
+)
+
 // FirewallRule 防火墙规则模型
 type FirewallRule struct {
-	ID      int    // 规则ID
-	Type    string // 规则类型："whitelist"（白名单）或"blacklist"（黑名单）
-	Pattern string // 正则表达式模式
-	Active  bool   // 是否激活
-}
\ No newline at end of file
+	ID       int    `json:"id"`       // 规则ID
+	Type     string `json:"type"`     // 规则类型："whitelist"（白名单）或"blacklist"（黑名单），与Action一一对应
+	Action   string `json:"action"`   // 规则动作："allow"（允许）或"deny"（拒绝）
+	Priority int    `json:"priority"` // 优先级，数值越小越先评估（顺序模式）
+	Kind     string `json:"kind"`     // 匹配方式："regex"（正则）、"cidr"（IP网段）或"host"（主机名通配）
+	Pattern  string `json:"pattern"`  // 匹配模式：正则表达式、CIDR网段（如10.0.0.0/8）或主机名通配（如*.example.com）
+	Ports    string `json:"ports"`    // 端口范围，如"443,8000-8100"，为空表示任意端口
+	UserID   int    `json:"user_id"`  // 规则所属用户ID，0表示不限定用户
+	Group    string `json:"group"`    // 规则所属用户组，为空表示不限定用户组
+	Active   bool   `json:"active"`   // 是否激活
+
+	// 时间窗口，均为空时规则始终生效
+	Days      string     `json:"days"`       // 生效的星期，如"mon-fri"或"sat,sun"，为空表示每天
+	TimeRange string     `json:"time_range"` // 每天生效的时间段，如"09:00-18:00"（支持跨午夜，如"22:00-06:00"），为空表示全天
+	Timezone  string     `json:"timezone"`   // 星期和时间段使用的时区，如"Asia/Shanghai"，为空表示服务器本地时区
+	StartsAt  *time.Time `json:"starts_at"`  // 开始生效的时间，为nil表示立即生效
+	ExpiresAt *time.Time `json:"expires_at"` // 过期时间，过期后规则自动失效，为nil表示永不过期
+
+	HitCount int64      `json:"hit_count"` // 命中次数
+	LastHit  *time.Time `json:"last_hit"`  // 最后命中时间，从未命中时为nil
+}
+
+// FirewallDecision 防火墙决策记录模型
+type FirewallDecision struct {
+	ID       int       // 记录ID
+	UserID   int       // 用户ID
+	Username string    // 用户名
+	Target   string    // 客户端请求的目标地址（主机:端口）
+	IP       string    // 做出决策时检查的IP地址
+	RuleID   int       // 命中的规则ID，0表示未命中任何规则（使用默认策略）
+	Action   string    // 决策结果："allow"（允许）或"deny"（拒绝）
+	Time     time.Time // 决策时间
+}
+
+// 防火墙规则的动作
+const (
+	FirewallActionAllow = "allow" // 允许连接
+	FirewallActionDeny  = "deny"  // 拒绝连接
+)
+
+// 防火墙规则的评估模式
+const (
+	FirewallModeCompat  = "compat"  // 兼容模式：白名单优先，存在白名单时拒绝其余流量，再检查黑名单
+	FirewallModeOrdered = "ordered" // 顺序模式：按优先级评估，第一条命中的规则决定结果，未命中时使用默认策略
+)
+
+// 防火墙规则的匹配方式
+const (
+	FirewallKindRegex = "regex" // 对"主机:端口"字符串进行正则匹配
+	FirewallKindCIDR  = "cidr"  // 目标IP属于指定网段
+	FirewallKindHost  = "host"  // 目标主机名匹配通配模式
+)
+
+// UserKey 用户公钥模型
+type UserKey struct {
+	ID          int       `json:"id"`          // 公钥ID
+	UserID      int       `json:"user_id"`     // 所属用户ID
+	Label       string    `json:"label"`       // 公钥标签（如设备名称）
+	PublicKey   string    `json:"public_key"`  // OpenSSH authorized_keys格式的公钥
+	Fingerprint string    `json:"fingerprint"` // SHA256指纹
+	Created     time.Time `json:"created"`     // 创建时间
+}
+
+// APIToken 访问REST API的令牌，令牌本身只在创建时返回一次，数据库中只保存其SHA-256哈希
+type APIToken struct {
+	ID         int        `json:"id"`           // 令牌ID
+	Name       string     `json:"name"`         // 令牌名称（如使用该令牌的脚本）
+	Prefix     string     `json:"prefix"`       // 令牌的前几个字符，用于辨认令牌
+	Scope      string     `json:"scope"`        // 权限范围："read"（只读）或"admin"（读写）
+	Created    time.Time  `json:"created"`      // 创建时间
+	ExpiresAt  *time.Time `json:"expires_at"`   // 过期时间，为nil表示永不过期
+	LastUsedAt *time.Time `json:"last_used_at"` // 最后使用时间，从未使用时为nil
+	LastUsedIP string     `json:"last_used_ip"` // 最后使用时的客户端地址
+}
+
+// API令牌的权限范围
+const (
+	APITokenScopeRead  = "read"  // 只能调用GET接口
+	APITokenScopeAdmin = "admin" // 可以调用所有接口
+)
+
+
+// WebAdmin Web管理界面和REST API的管理员账号
+type WebAdmin struct {
+	ID       int       `json:"id"`       // 账号ID
+	Username string    `json:"username"` // 用户名
+	Password string    `json:"-"`        // bcrypt哈希后的密码
+	Role     string    `json:"role"`     // 角色："viewer"、"operator"或"admin"
+	Created  time.Time `json:"created"`  // 创建时间
+}
+
+// 管理员角色，后者包含前者的所有权限
+const (
+	WebAdminRoleViewer   = "viewer"   // 只能查看页面和调用GET接口
+	WebAdminRoleOperator = "operator" // 另外可以断开会话、激活/停用用户、生成报表
+	WebAdminRoleAdmin    = "admin"    // 另外可以管理用户、防火墙规则、API令牌和管理员账号
+)
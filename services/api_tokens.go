@@ -0,0 +1,59 @@
+package services
+
+import (
+	"log"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/utils"
+)
+
+// CreateAPIToken 创建REST API令牌
+// 参数:
+//   name - 令牌名称
+//   scope - 权限范围（read或admin）
+//   expiresAt - 过期时间，为nil表示永不过期
+// 返回:
+//   string - 令牌明文，只在创建时返回一次
+//   *models.APIToken - 令牌信息
+//   error - 错误信息
+func CreateAPIToken(name, scope string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	plaintext, token, err := utils.CreateAPIToken(name, scope, expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Printf("Created API token %d (%s, scope %s)", token.ID, token.Name, token.Scope)
+	return plaintext, token, nil
+}
+
+// GetAPITokens 获取所有API令牌
+// 返回: []*models.APIToken - 令牌列表
+func GetAPITokens() []*models.APIToken {
+	tokens, err := utils.GetAPITokens()
+	if err != nil {
+		log.Printf("Failed to get API tokens: %v", err)
+		return []*models.APIToken{}
+	}
+	return tokens
+}
+
+// RevokeAPIToken 撤销API令牌
+// 参数: id - 令牌ID
+// 返回: error - 错误信息
+func RevokeAPIToken(id int) error {
+	if err := utils.RevokeAPIToken(id); err != nil {
+		return err
+	}
+	log.Printf("Revoked API token %d", id)
+	return nil
+}
+
+// AuthenticateAPIToken 验证请求携带的API令牌
+// 参数:
+//   plaintext - 令牌明文
+//   ip - 客户端地址，记录为令牌的最后使用地址
+// 返回:
+//   *models.APIToken - 令牌信息
+//   error - 令牌无效或已过期时返回utils.ErrInvalidAPIToken
+func AuthenticateAPIToken(plaintext, ip string) (*models.APIToken, error) {
+	return utils.AuthenticateAPIToken(plaintext, ip)
+}
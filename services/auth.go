@@ -1,15 +1,21 @@
 package services
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 	"ssh-manage/models"
 	"ssh-manage/utils"
 
 	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/ssh"
 )
 
 // AuthenticateUser 验证用户身份
@@ -22,10 +28,12 @@ import (
 func AuthenticateUser(username, password string) (*models.User, error) {
 	user, err := utils.GetUserByUsername(username)
 	if err != nil {
+		// 用户不存在时同样执行一次哈希比较，避免通过耗时差异探测用户名
+		utils.CheckDummyPassword(password)
 		return nil, err
 	}
 	
-	if user.Password != password {
+	if !utils.CheckPassword(user.Password, password) {
 		return nil, nil // 密码错误
 	}
 	
@@ -36,6 +44,42 @@ func AuthenticateUser(username, password string) (*models.User, error) {
 	return user, nil
 }
 
+// AuthenticatePublicKey 使用公钥验证用户身份
+// 参数:
+//   username - 用户名
+//   key - 客户端提供的公钥
+// 返回:
+//   *models.User - 用户信息（公钥未登记或用户未激活时为nil）
+//   error - 错误信息
+func AuthenticatePublicKey(username string, key ssh.PublicKey) (*models.User, error) {
+	user, err := utils.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Active {
+		return nil, nil // 用户未激活
+	}
+
+	// 按指纹查找该用户登记的公钥，并比对完整的公钥数据
+	userKey, err := utils.GetUserKeyByFingerprint(user.ID, ssh.FingerprintSHA256(key))
+	if err != nil {
+		return nil, nil // 公钥未登记
+	}
+
+	storedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userKey.PublicKey))
+	if err != nil {
+		log.Printf("Invalid stored public key %d for user %s: %v", userKey.ID, username, err)
+		return nil, nil
+	}
+
+	if !bytes.Equal(storedKey.Marshal(), key.Marshal()) {
+		return nil, nil // 公钥不匹配
+	}
+
+	return user, nil
+}
+
 // GetUserByID 根据ID获取用户信息
 // 参数: id - 用户ID
 // 返回: *models.User - 用户信息
@@ -48,13 +92,162 @@ func GetUserByID(id int) *models.User {
 	return user
 }
 
-// UpdateUser 更新用户信息
+// UpdateUser 更新用户信息，不修改密码（修改密码使用ResetUserPassword）
 // 参数: user - 用户信息
 // 返回: error - 错误信息
 func UpdateUser(user *models.User) error {
 	return utils.UpdateUser(user)
 }
 
+// UpdateRemoteForwardPolicy 更新用户的远程端口转发策略
+// 参数:
+//   userID - 用户ID
+//   addrs - 允许绑定的地址，逗号分隔
+//   ports - 允许绑定的端口范围，如"8000-8100,9000"
+//   maxForwards - 同时存在的监听器数量上限，0表示不限制
+//   autoPort - 请求端口为0时是否自动分配端口
+// 返回: error - 错误信息
+func UpdateRemoteForwardPolicy(userID int, addrs, ports string, maxForwards int, autoPort bool) error {
+	user, err := utils.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	user.RemoteForwardAddrs = strings.TrimSpace(addrs)
+	user.RemoteForwardPorts = strings.TrimSpace(ports)
+	user.MaxRemoteForwards = maxForwards
+	user.RemoteForwardAutoPort = autoPort
+
+	if err := utils.ValidateRemoteForwardPolicy(user); err != nil {
+		return err
+	}
+
+	return utils.UpdateUser(user)
+}
+
+// UpdateUserQuota 更新用户的流量配额
+// 参数:
+//   userID - 用户ID
+//   daily - 每日流量配额（字节），0表示不限制
+//   monthly - 每月流量配额（字节），0表示不限制
+//   action - 配额用尽后的处理方式
+// 返回: error - 错误信息
+func UpdateUserQuota(userID int, daily, monthly int64, action string) error {
+	user, err := utils.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	user.DailyQuota = daily
+	user.MonthlyQuota = monthly
+	user.QuotaAction = action
+
+	if err := utils.ValidateQuota(user); err != nil {
+		return err
+	}
+
+	return utils.UpdateUser(user)
+}
+
+// UpdateUserRateLimit 更新用户的带宽限速
+// 参数:
+//   userID - 用户ID
+//   up - 上行速率上限（字节/秒），0表示不限制
+//   down - 下行速率上限（字节/秒），0表示不限制
+// 返回: error - 错误信息
+func UpdateUserRateLimit(userID int, up, down int64) error {
+	if up < 0 || down < 0 {
+		return fmt.Errorf("rate limit must not be negative")
+	}
+
+	user, err := utils.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	user.RateLimitUp = up
+	user.RateLimitDown = down
+
+	return utils.UpdateUser(user)
+}
+
+// UpdateUserConcurrencyLimits 更新用户的并发会话数和通道数上限
+// 参数:
+//   userID - 用户ID
+//   maxSessions - 同时存在的SSH会话数上限，0表示不限制
+//   maxChannels - 同时打开的转发通道数上限，0表示不限制
+// 返回: error - 错误信息
+func UpdateUserConcurrencyLimits(userID, maxSessions, maxChannels int) error {
+	if maxSessions < 0 || maxChannels < 0 {
+		return fmt.Errorf("concurrency limits must not be negative")
+	}
+
+	user, err := utils.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	user.MaxSessions = maxSessions
+	user.MaxChannels = maxChannels
+
+	return utils.UpdateUser(user)
+}
+
+// UpdateUserSessionTimeouts 更新用户的空闲超时和最长会话时长
+// 参数:
+//   userID - 用户ID
+//   idleTimeout - 空闲超时（分钟），0表示使用全局设置
+//   maxSessionDuration - 最长会话时长（分钟），0表示使用全局设置
+// 返回: error - 错误信息
+func UpdateUserSessionTimeouts(userID, idleTimeout, maxSessionDuration int) error {
+	if idleTimeout < 0 || maxSessionDuration < 0 {
+		return fmt.Errorf("session timeouts must not be negative")
+	}
+
+	user, err := utils.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	user.IdleTimeout = idleTimeout
+	user.MaxSessionDuration = maxSessionDuration
+
+	return utils.UpdateUser(user)
+}
+
+// GetUserTrafficUsage 获取用户本日和本月已使用的流量
+// 参数: userID - 用户ID
+// 返回:
+//   int64 - 本日流量（字节）
+//   int64 - 本月流量（字节）
+func GetUserTrafficUsage(userID int) (int64, int64) {
+	now := time.Now()
+	daily, err := utils.GetUserTrafficSince(userID, utils.DayStart(now))
+	if err != nil {
+		log.Printf("Failed to get daily traffic for user %d: %v", userID, err)
+	}
+	monthly, err := utils.GetUserTrafficSince(userID, utils.MonthStart(now))
+	if err != nil {
+		log.Printf("Failed to get monthly traffic for user %d: %v", userID, err)
+	}
+	return daily, monthly
+}
+
+// UpdateUserGroup 更新用户所属的用户组
+// 参数:
+//   userID - 用户ID
+//   group - 用户组名称，为空表示不属于任何用户组
+// 返回: error - 错误信息
+func UpdateUserGroup(userID int, group string) error {
+	user, err := utils.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	user.Group = strings.TrimSpace(group)
+	return utils.UpdateUser(user)
+}
+
 // GetAllUsers 获取所有用户
 // 返回: []*models.User - 用户列表
 func GetAllUsers() []*models.User {
@@ -67,12 +260,128 @@ func GetAllUsers() []*models.User {
 }
 
 // AddUser 添加用户
-// 参数: user - 用户信息
+// 参数: user - 用户信息，Password为明文密码，保存前进行哈希
 // 返回: error - 错误信息
 func AddUser(user *models.User) error {
+	hash, err := utils.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
 	return utils.AddUser(user)
 }
 
+// ErrUserExists 添加用户时用户名已存在
+var ErrUserExists = errors.New("username already exists")
+
+// CreateUser 校验并添加用户，与AddUser不同，用户名已存在时返回ErrUserExists
+// 参数: user - 用户信息，Password为明文密码
+// 返回:
+//   *models.User - 已保存的用户信息（包含ID）
+//   error - 错误信息
+func CreateUser(user *models.User) (*models.User, error) {
+	if user.Password == "" {
+		return nil, fmt.Errorf("password is required")
+	}
+	if err := ValidateUser(user); err != nil {
+		return nil, err
+	}
+	if _, err := utils.GetUserByUsername(user.Username); err == nil {
+		return nil, ErrUserExists
+	}
+
+	if err := AddUser(user); err != nil {
+		return nil, err
+	}
+	return utils.GetUserByUsername(user.Username)
+}
+
+// ValidateUser 校验用户的基本信息和各项限制设置
+// 参数: user - 用户信息，QuotaAction为空时补全为默认值
+// 返回: error - 配置错误
+func ValidateUser(user *models.User) error {
+	user.Name = strings.TrimSpace(user.Name)
+	user.Username = strings.TrimSpace(user.Username)
+	user.Group = strings.TrimSpace(user.Group)
+	if user.Name == "" || user.Username == "" {
+		return fmt.Errorf("name and username are required")
+	}
+
+	if user.QuotaAction == "" {
+		user.QuotaAction = models.QuotaActionReject
+	}
+	if err := utils.ValidateQuota(user); err != nil {
+		return err
+	}
+	if err := utils.ValidateRemoteForwardPolicy(user); err != nil {
+		return err
+	}
+	if user.RateLimitUp < 0 || user.RateLimitDown < 0 {
+		return fmt.Errorf("rate limit must not be negative")
+	}
+	if user.MaxSessions < 0 || user.MaxChannels < 0 {
+		return fmt.Errorf("concurrency limits must not be negative")
+	}
+	if user.IdleTimeout < 0 || user.MaxSessionDuration < 0 {
+		return fmt.Errorf("session timeouts must not be negative")
+	}
+	return nil
+}
+
+// SetUserActive 激活或停用用户
+// 参数:
+//   userID - 用户ID
+//   active - 是否激活
+// 返回:
+//   *models.User - 更新后的用户信息
+//   error - 错误信息
+func SetUserActive(userID int, active bool) (*models.User, error) {
+	user, err := utils.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Active = active
+	if err := utils.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ResetUserPassword 重置用户密码
+// 参数:
+//   userID - 用户ID
+//   password - 新的明文密码，为空时生成随机密码
+// 返回:
+//   string - 新的明文密码
+//   error - 错误信息
+func ResetUserPassword(userID int, password string) (string, error) {
+	if password == "" {
+		var err error
+		if password, err = utils.GeneratePassword(); err != nil {
+			return "", err
+		}
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.SetUserPassword(userID, hash); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// DeleteUser 删除用户及其公钥和只针对该用户的防火墙规则
+// 参数:
+//   userID - 用户ID
+//   purgeConnections - 是否同时删除该用户的连接记录，为false且存在连接记录时返回utils.ErrUserHasConnections
+// 返回: error - 错误信息
+func DeleteUser(userID int, purgeConnections bool) error {
+	return utils.DeleteUser(userID, purgeConnections)
+}
+
 // GetConnectionsByUserID 根据用户ID获取连接记录
 // 参数: userID - 用户ID
 // 返回: []*models.Connection - 连接记录列表
@@ -130,6 +439,18 @@ func GetStatistics() map[string]interface{} {
 	return stats
 }
 
+// GetConnectionCountsByDay 按天和用户统计自指定时间以来建立的SSH连接数
+// 参数: since - 开始时间
+// 返回: map[string]map[int]int - 日期（2006-01-02）到各用户ID连接数的映射
+func GetConnectionCountsByDay(since time.Time) map[string]map[int]int {
+	counts, err := utils.GetConnectionCountsByDay(since)
+	if err != nil {
+		log.Printf("Failed to get connection counts: %v", err)
+		return map[string]map[int]int{}
+	}
+	return counts
+}
+
 // GenerateRSAKey 生成RSA密钥对
 // 返回: 
 //   []byte - 私钥PEM格式数据
@@ -161,4 +482,73 @@ func GenerateRSAKey() ([]byte, []byte, error) {
 	}
 
 	return pem.EncodeToMemory(privateKeyPEM), pem.EncodeToMemory(publicKeyPEM), nil
-}
\ No newline at end of file
+}
+
+// AddUserKey 为用户添加公钥
+// 参数:
+//   userID - 用户ID
+//   label - 公钥标签，为空时使用公钥自带的注释
+//   authorizedKey - OpenSSH authorized_keys格式的公钥
+// 返回:
+//   *models.UserKey - 已保存的公钥信息
+//   error - 错误信息
+func AddUserKey(userID int, label, authorizedKey string) (*models.UserKey, error) {
+	if _, err := utils.GetUserByID(userID); err != nil {
+		return nil, fmt.Errorf("user %d not found: %v", userID, err)
+	}
+
+	publicKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(authorizedKey)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+
+	if label == "" {
+		label = comment
+	}
+
+	key := &models.UserKey{
+		UserID:      userID,
+		Label:       label,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+		Created:     time.Now(),
+	}
+
+	id, err := utils.AddUserKey(key)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+
+	return key, nil
+}
+
+// GetUserKeys 获取指定用户的所有公钥
+// 参数: userID - 用户ID
+// 返回: []*models.UserKey - 公钥列表
+func GetUserKeys(userID int) []*models.UserKey {
+	keys, err := utils.GetUserKeysByUserID(userID)
+	if err != nil {
+		log.Printf("Failed to get keys for user ID %d: %v", userID, err)
+		return []*models.UserKey{}
+	}
+	return keys
+}
+
+// GetAllUserKeys 获取所有用户的公钥
+// 返回: []*models.UserKey - 公钥列表
+func GetAllUserKeys() []*models.UserKey {
+	keys, err := utils.GetAllUserKeys()
+	if err != nil {
+		log.Printf("Failed to get all user keys: %v", err)
+		return []*models.UserKey{}
+	}
+	return keys
+}
+
+// DeleteUserKey 撤销公钥
+// 参数: id - 公钥ID
+// 返回: error - 错误信息，公钥不存在时返回sql.ErrNoRows
+func DeleteUserKey(id int) error {
+	return utils.DeleteUserKey(id)
+}
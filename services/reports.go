@@ -0,0 +1,104 @@
+package services
+
+import (
+	"log"
+	"time"
+	"ssh-manage/config"
+	"ssh-manage/utils"
+)
+
+// reportCheckInterval 检查是否需要自动生成使用报表的间隔
+const reportCheckInterval = time.Hour
+
+// StartUsageReportScheduler 按REPORT_SCHEDULE定期生成上一个完整周期的使用报表
+// 每小时检查一次，报表目录中还没有该周期的报表时才生成，因此服务停机错过的报表会在启动后补上
+// 参数: cfg - 应用配置，ReportSchedule为空时不启动
+func StartUsageReportScheduler(cfg *config.Config) {
+	if cfg.ReportSchedule == "" {
+		return
+	}
+	if _, _, err := utils.UsageReportPeriod(cfg.ReportSchedule, time.Now()); err != nil {
+		log.Printf("Usage report scheduler disabled: %v", err)
+		return
+	}
+	formats, err := utils.ParseReportFormats(cfg.ReportFormats)
+	if err != nil {
+		log.Printf("Usage report scheduler disabled: %v", err)
+		return
+	}
+
+	log.Printf("Generating %s usage reports (%v) in %s", cfg.ReportSchedule, formats, cfg.ReportDir)
+	go func() {
+		ticker := time.NewTicker(reportCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			generateScheduledUsageReport(cfg.ReportSchedule, cfg.ReportDir, formats)
+			<-ticker.C
+		}
+	}()
+}
+
+// generateScheduledUsageReport 生成上一个完整周期的使用报表，已存在时跳过
+func generateScheduledUsageReport(schedule, dir string, formats []string) {
+	from, to, err := utils.UsageReportPeriod(schedule, time.Now())
+	if err != nil {
+		log.Printf("Failed to get usage report period: %v", err)
+		return
+	}
+
+	var missing []string
+	for _, format := range formats {
+		if !utils.UsageReportExists(dir, from, to, format) {
+			missing = append(missing, format)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	if _, err := GenerateUsageReportFiles(from, to, missing); err != nil {
+		log.Printf("Failed to generate scheduled usage report: %v", err)
+	}
+}
+
+// GenerateUsageReportFiles 生成指定时间段的使用报表并写入REPORT_DIR
+// 参数:
+//   from - 开始时间（含）
+//   to - 结束时间（不含）
+//   formats - 文件格式列表，为nil时使用REPORT_FORMATS
+// 返回:
+//   []string - 写入的文件路径
+//   error - 生成或写入过程中的错误
+func GenerateUsageReportFiles(from, to time.Time, formats []string) ([]string, error) {
+	cfg := config.Load()
+	if formats == nil {
+		var err error
+		if formats, err = utils.ParseReportFormats(cfg.ReportFormats); err != nil {
+			return nil, err
+		}
+	}
+
+	report, err := utils.GenerateUsageReport(from, to)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := utils.SaveUsageReport(report, cfg.ReportDir, formats)
+	if err != nil {
+		return paths, err
+	}
+
+	log.Printf("Generated usage report for %s - %s: %v", from.Format("2006-01-02"), to.Format("2006-01-02"), paths)
+	return paths, nil
+}
+
+// GetUsageReportFiles 获取REPORT_DIR中已生成的使用报表文件
+// 返回: []*utils.UsageReportFile - 报表文件列表
+func GetUsageReportFiles() []*utils.UsageReportFile {
+	files, err := utils.ListUsageReportFiles(config.Load().ReportDir)
+	if err != nil {
+		log.Printf("Failed to list usage reports: %v", err)
+		return []*utils.UsageReportFile{}
+	}
+	return files
+}
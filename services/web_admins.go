@@ -0,0 +1,148 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"ssh-manage/models"
+	"ssh-manage/utils"
+)
+
+// webAdminCacheTTL 管理员密码验证结果的缓存时间
+// 浏览器每个请求都携带基础认证，缓存避免每个请求都执行一次bcrypt
+const webAdminCacheTTL = 5 * time.Minute
+
+// ErrInvalidWebAdmin 管理员用户名或密码错误
+var ErrInvalidWebAdmin = errors.New("invalid username or password")
+
+// webAdminCacheEntry 一次成功的密码验证
+type webAdminCacheEntry struct {
+	passwordHash string   // 验证时数据库中的密码哈希，密码修改后缓存自动失效
+	digest       [32]byte // 验证通过的密码的SHA-256
+	expires      time.Time
+}
+
+var (
+	webAdminCache   = make(map[string]webAdminCacheEntry)
+	webAdminCacheMu sync.Mutex
+)
+
+// AuthenticateWebAdmin 验证Web管理界面和REST API的管理员账号
+// 参数:
+//   username - 用户名
+//   password - 密码
+// 返回:
+//   *models.WebAdmin - 验证通过的管理员账号
+//   error - 用户名或密码错误时返回ErrInvalidWebAdmin
+func AuthenticateWebAdmin(username, password string) (*models.WebAdmin, error) {
+	admin, err := utils.GetWebAdminByUsername(username)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to get web admin %s: %v", username, err)
+		}
+		utils.CheckDummyPassword(password)
+		return nil, ErrInvalidWebAdmin
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	now := time.Now()
+
+	webAdminCacheMu.Lock()
+	entry, ok := webAdminCache[username]
+	webAdminCacheMu.Unlock()
+	if ok && entry.passwordHash == admin.Password && now.Before(entry.expires) &&
+		subtle.ConstantTimeCompare(entry.digest[:], digest[:]) == 1 {
+		return admin, nil
+	}
+
+	if !utils.CheckPassword(admin.Password, password) {
+		return nil, ErrInvalidWebAdmin
+	}
+
+	webAdminCacheMu.Lock()
+	webAdminCache[username] = webAdminCacheEntry{passwordHash: admin.Password, digest: digest, expires: now.Add(webAdminCacheTTL)}
+	webAdminCacheMu.Unlock()
+	return admin, nil
+}
+
+// GetWebAdmins 获取所有管理员账号
+// 返回: []*models.WebAdmin - 管理员账号列表
+func GetWebAdmins() []*models.WebAdmin {
+	admins, err := utils.GetWebAdmins()
+	if err != nil {
+		log.Printf("Failed to get web admins: %v", err)
+		return []*models.WebAdmin{}
+	}
+	return admins
+}
+
+// AddWebAdmin 添加管理员账号
+// 参数:
+//   username - 用户名
+//   password - 密码明文
+//   role - 角色
+// 返回: error - 错误信息
+func AddWebAdmin(username, password, role string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	admin := &models.WebAdmin{Username: username, Password: passwordHash, Role: role}
+	if err := utils.AddWebAdmin(admin); err != nil {
+		return err
+	}
+	log.Printf("Added web admin %s (%s)", admin.Username, admin.Role)
+	return nil
+}
+
+// UpdateWebAdminRole 修改管理员的角色
+// 参数:
+//   id - 账号ID
+//   role - 新角色
+// 返回: error - 错误信息，降级最后一个admin账号时返回utils.ErrLastWebAdmin
+func UpdateWebAdminRole(id int, role string) error {
+	if err := utils.UpdateWebAdminRole(id, role); err != nil {
+		return err
+	}
+	log.Printf("Changed role of web admin %d to %s", id, role)
+	return nil
+}
+
+// ResetWebAdminPassword 修改管理员的密码
+// 参数:
+//   id - 账号ID
+//   password - 新密码明文
+// 返回: error - 错误信息
+func ResetWebAdminPassword(id int, password string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := utils.SetWebAdminPassword(id, passwordHash); err != nil {
+		return err
+	}
+	log.Printf("Reset password of web admin %d", id)
+	return nil
+}
+
+// DeleteWebAdmin 删除管理员账号
+// 参数: id - 账号ID
+// 返回: error - 错误信息，删除最后一个admin账号时返回utils.ErrLastWebAdmin
+func DeleteWebAdmin(id int) error {
+	if err := utils.DeleteWebAdmin(id); err != nil {
+		return err
+	}
+	log.Printf("Deleted web admin %d", id)
+	return nil
+}
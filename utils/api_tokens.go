@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"ssh-manage/models"
+)
+
+// apiTokenPrefix 令牌的固定前缀，便于在日志和代码仓库中识别泄露的令牌
+const apiTokenPrefix = "sshm_"
+
+// apiTokenDisplayLength 列表中显示的令牌前缀长度（包含apiTokenPrefix）
+const apiTokenDisplayLength = 12
+
+// apiTokenTouchInterval 同一令牌最后使用时间的最小更新间隔，避免每个请求都写数据库
+const apiTokenTouchInterval = time.Minute
+
+// ErrInvalidAPIToken 令牌不存在或已过期
+var ErrInvalidAPIToken = errors.New("invalid or expired API token")
+
+// createAPITokenTable 创建API令牌表
+func createAPITokenTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		prefix TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		last_used_ip TEXT NOT NULL DEFAULT ''
+	);`)
+	return err
+}
+
+// hashAPIToken 计算令牌的SHA-256哈希
+// 令牌是32字节的随机数，不需要像密码一样使用bcrypt，直接哈希即可按哈希值查找
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken 生成并保存新的API令牌
+// 参数:
+//   name - 令牌名称
+//   scope - 权限范围（read或admin）
+//   expiresAt - 过期时间，为nil表示永不过期
+// 返回:
+//   string - 令牌明文，只在此时返回一次
+//   *models.APIToken - 已保存的令牌信息
+//   error - 参数无效或保存过程中的错误
+func CreateAPIToken(name, scope string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("token name is required")
+	}
+	if scope != models.APITokenScopeRead && scope != models.APITokenScopeAdmin {
+		return "", nil, fmt.Errorf("invalid token scope %q", scope)
+	}
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return "", nil, fmt.Errorf("expiry must be in the future")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	plaintext := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	token := &models.APIToken{
+		Name:      name,
+		Prefix:    plaintext[:apiTokenDisplayLength],
+		Scope:     scope,
+		Created:   now,
+		ExpiresAt: expiresAt,
+	}
+	result, err := GetDB().Exec(`INSERT INTO api_tokens (name, token_hash, prefix, scope, created, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		token.Name, hashAPIToken(plaintext), token.Prefix, token.Scope, now.Format("2006-01-02 15:04:05"), formatNullableTime(expiresAt))
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", nil, err
+	}
+	token.ID = int(id)
+	return plaintext, token, nil
+}
+
+// scanAPIToken 从查询结果中解析API令牌
+func scanAPIToken(row rowScanner) (*models.APIToken, error) {
+	var token models.APIToken
+	var createdStr string
+	var expiresAtStr, lastUsedAtStr *string
+	if err := row.Scan(&token.ID, &token.Name, &token.Prefix, &token.Scope, &createdStr, &expiresAtStr, &lastUsedAtStr, &token.LastUsedIP); err != nil {
+		return nil, err
+	}
+
+	created, err := parseNullableTime(&createdStr)
+	if err != nil {
+		return nil, err
+	}
+	token.Created = *created
+	if token.ExpiresAt, err = parseNullableTime(expiresAtStr); err != nil {
+		return nil, err
+	}
+	if token.LastUsedAt, err = parseNullableTime(lastUsedAtStr); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// apiTokenColumns 查询API令牌时选择的列，与scanAPIToken的顺序一致
+const apiTokenColumns = "id, name, prefix, scope, created, expires_at, last_used_at, last_used_ip"
+
+// GetAPITokens 获取所有API令牌（不包含令牌明文和哈希），按创建顺序排序
+// 返回:
+//   []*models.APIToken - 令牌列表
+//   error - 查询过程中的错误
+func GetAPITokens() ([]*models.APIToken, error) {
+	rows, err := GetDB().Query("SELECT " + apiTokenColumns + " FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*models.APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken 撤销（删除）API令牌，撤销后立即失效
+// 参数: id - 令牌ID
+// 返回: error - 删除过程中的错误，令牌不存在时返回sql.ErrNoRows
+func RevokeAPIToken(id int) error {
+	result, err := GetDB().Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AuthenticateAPIToken 验证令牌明文并记录最后使用时间和客户端地址
+// 参数:
+//   plaintext - 请求中携带的令牌
+//   ip - 客户端地址
+// 返回:
+//   *models.APIToken - 令牌信息
+//   error - 令牌不存在或已过期时返回ErrInvalidAPIToken
+func AuthenticateAPIToken(plaintext, ip string) (*models.APIToken, error) {
+	if !strings.HasPrefix(plaintext, apiTokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	db := GetDB()
+	token, err := scanAPIToken(db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hashAPIToken(plaintext)))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval || token.LastUsedIP != ip {
+		if _, err := db.Exec("UPDATE api_tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ?", now.Format("2006-01-02 15:04:05"), ip, token.ID); err != nil {
+			return nil, err
+		}
+		token.LastUsedAt = &now
+		token.LastUsedIP = ip
+	}
+	return token, nil
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+func TestCreateAPIToken(t *testing.T) {
+	openTestDB(t)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	plaintext, token, err := CreateAPIToken(" ci ", models.APITokenScopeRead, &expiresAt)
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if !strings.HasPrefix(plaintext, apiTokenPrefix) || token.Prefix != plaintext[:apiTokenDisplayLength] || token.Name != "ci" {
+		t.Errorf("got plaintext %q, token %+v", plaintext, token)
+	}
+
+	// 数据库中只保存令牌的哈希
+	var hash, prefix string
+	if err := GetDB().QueryRow("SELECT token_hash, prefix FROM api_tokens WHERE id = ?", token.ID).Scan(&hash, &prefix); err != nil {
+		t.Fatalf("read stored token: %v", err)
+	}
+	if hash != hashAPIToken(plaintext) || strings.Contains(hash, plaintext) || prefix == plaintext {
+		t.Errorf("got stored hash %q and prefix %q for token %q", hash, prefix, plaintext)
+	}
+
+	tokens, err := GetAPITokens()
+	if err != nil {
+		t.Fatalf("get tokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].Scope != models.APITokenScopeRead || tokens[0].ExpiresAt == nil || !tokens[0].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("got tokens %+v", tokens)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	invalid := []struct {
+		name, scope string
+		expiresAt   *time.Time
+	}{
+		{"", models.APITokenScopeRead, nil},
+		{"ci", "write", nil},
+		{"ci", models.APITokenScopeAdmin, &past},
+	}
+	for _, tt := range invalid {
+		if _, _, err := CreateAPIToken(tt.name, tt.scope, tt.expiresAt); err == nil {
+			t.Errorf("created token %q with scope %q, expiry %v", tt.name, tt.scope, tt.expiresAt)
+		}
+	}
+}
+
+func TestAuthenticateAPIToken(t *testing.T) {
+	openTestDB(t)
+	plaintext, created, err := CreateAPIToken("ci", models.APITokenScopeAdmin, nil)
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	token, err := AuthenticateAPIToken(plaintext, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if token.ID != created.ID || token.Scope != models.APITokenScopeAdmin || token.LastUsedIP != "10.0.0.1" || token.LastUsedAt == nil {
+		t.Errorf("got token %+v", token)
+	}
+	tokens, _ := GetAPITokens()
+	if tokens[0].LastUsedIP != "10.0.0.1" || tokens[0].LastUsedAt == nil {
+		t.Errorf("last use not stored: %+v", tokens[0])
+	}
+
+	for _, wrong := range []string{"", plaintext[len(apiTokenPrefix):], plaintext + "x", apiTokenPrefix + "unknown"} {
+		if _, err := AuthenticateAPIToken(wrong, "10.0.0.1"); err != ErrInvalidAPIToken {
+			t.Errorf("%q: got %v, want ErrInvalidAPIToken", wrong, err)
+		}
+	}
+
+	// 撤销后立即失效
+	if err := RevokeAPIToken(created.ID); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	if _, err := AuthenticateAPIToken(plaintext, "10.0.0.1"); err != ErrInvalidAPIToken {
+		t.Errorf("revoked token: got %v, want ErrInvalidAPIToken", err)
+	}
+	if err := RevokeAPIToken(created.ID); err != sql.ErrNoRows {
+		t.Errorf("revoke twice: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestAuthenticateExpiredAPIToken(t *testing.T) {
+	openTestDB(t)
+	expiresAt := time.Now().Add(time.Hour)
+	plaintext, token, err := CreateAPIToken("ci", models.APITokenScopeRead, &expiresAt)
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if _, err := AuthenticateAPIToken(plaintext, "10.0.0.1"); err != nil {
+		t.Fatalf("authenticate before expiry: %v", err)
+	}
+
+	// 模拟令牌已过期
+	expired := time.Now().Add(-time.Second).Format("2006-01-02 15:04:05")
+	if _, err := GetDB().Exec("UPDATE api_tokens SET expires_at = ? WHERE id = ?", expired, token.ID); err != nil {
+		t.Fatalf("expire token: %v", err)
+	}
+	if _, err := AuthenticateAPIToken(plaintext, "10.0.0.1"); err != ErrInvalidAPIToken {
+		t.Errorf("expired token: got %v, want ErrInvalidAPIToken", err)
+	}
+}
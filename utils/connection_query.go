@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"ssh-manage/models"
+)
+
+// 目标连接查询的分页大小
+const (
+	defaultTargetConnectionLimit = 100
+	maxTargetConnectionLimit     = 1000
+)
+
+// TargetConnectionQuery 目标连接查询条件，零值字段表示不限
+type TargetConnectionQuery struct {
+	UserID       int       // 用户ID
+	Username     string    // 用户名
+	ConnectionID int       // SSH连接ID
+	Target       string    // 目标地址包含的字符串
+	ChannelType  string    // 通道类型
+	From         time.Time // 建立时间不早于该时间
+	To           time.Time // 建立时间早于该时间
+	Active       *bool     // true只返回未断开的连接，false只返回已断开的连接
+	Limit        int       // 每页条数，0表示使用默认值，最大1000
+	Offset       int       // 跳过的条数
+}
+
+// TargetConnectionRecord 目标连接记录及其所属SSH连接的用户和客户端地址
+type TargetConnectionRecord struct {
+	models.TargetConnection
+	UserID   int    `json:"user_id"`  // 用户ID
+	Username string `json:"username"` // 用户名
+	IP       string `json:"ip"`       // 客户端IP地址
+}
+
+// TargetConnectionPage 一页目标连接记录
+type TargetConnectionPage struct {
+	Total  int                       `json:"total"`  // 符合条件的记录总数
+	Limit  int                       `json:"limit"`  // 每页条数
+	Offset int                       `json:"offset"` // 跳过的条数
+	Items  []*TargetConnectionRecord `json:"items"`  // 本页记录，按ID倒序（最新的在前）
+}
+
+// QueryTargetConnections 按条件分页查询目标连接记录
+// 参数: query - 查询条件
+// 返回:
+//   *TargetConnectionPage - 一页目标连接记录
+//   error - 查询条件无效或查询过程中的错误
+func QueryTargetConnections(query *TargetConnectionQuery) (*TargetConnectionPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultTargetConnectionLimit
+	}
+	if limit > maxTargetConnectionLimit {
+		return nil, fmt.Errorf("limit must not exceed %d", maxTargetConnectionLimit)
+	}
+	if query.Offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative")
+	}
+
+	var conditions []string
+	var args []interface{}
+	if query.UserID != 0 {
+		conditions = append(conditions, "c.user_id = ?")
+		args = append(args, query.UserID)
+	}
+	if query.Username != "" {
+		conditions = append(conditions, "c.username = ?")
+		args = append(args, query.Username)
+	}
+	if query.ConnectionID != 0 {
+		conditions = append(conditions, "tc.connection_id = ?")
+		args = append(args, query.ConnectionID)
+	}
+	if query.Target != "" {
+		conditions = append(conditions, "instr(tc.target, ?) > 0")
+		args = append(args, query.Target)
+	}
+	if query.ChannelType != "" {
+		conditions = append(conditions, "tc.channel_type = ?")
+		args = append(args, query.ChannelType)
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "tc.connected_at >= ?")
+		args = append(args, query.From.Local().Format("2006-01-02 15:04:05"))
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "tc.connected_at < ?")
+		args = append(args, query.To.Local().Format("2006-01-02 15:04:05"))
+	}
+	if query.Active != nil {
+		if *query.Active {
+			conditions = append(conditions, "tc.disconnected_at IS NULL")
+		} else {
+			conditions = append(conditions, "tc.disconnected_at IS NOT NULL")
+		}
+	}
+
+	from := " FROM target_connections tc JOIN connections c ON c.id = tc.connection_id"
+	if len(conditions) > 0 {
+		from += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	db := GetDB()
+	page := &TargetConnectionPage{Limit: limit, Offset: query.Offset, Items: []*TargetConnectionRecord{}}
+	if err := db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT tc.id, tc.connection_id, tc.target, tc.channel_type, tc.connected_at, tc.disconnected_at,
+		tc.bytes_up, tc.bytes_down, c.user_id, c.username, c.ip`+from+" ORDER BY tc.id DESC LIMIT ? OFFSET ?",
+		append(args, limit, query.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record TargetConnectionRecord
+		var connectedAtStr string
+		var disconnectedAtStr *string
+		err := rows.Scan(&record.ID, &record.ConnectionID, &record.Target, &record.ChannelType, &connectedAtStr, &disconnectedAtStr,
+			&record.BytesUp, &record.BytesDown, &record.UserID, &record.Username, &record.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		// 数据库中的时间为本地时间
+		connectedAt, err := parseNullableTime(&connectedAtStr)
+		if err != nil {
+			return nil, err
+		}
+		record.ConnectedAt = *connectedAt
+		if record.DisconnectedAt, err = parseNullableTime(disconnectedAtStr); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, &record)
+	}
+	return page, rows.Err()
+}
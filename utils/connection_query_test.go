@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// targetConnectionIDs 获取一页记录的目标连接ID
+func targetConnectionIDs(page *TargetConnectionPage) []int {
+	ids := make([]int, 0, len(page.Items))
+	for _, item := range page.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestQueryTargetConnections(t *testing.T) {
+	openTestDB(t)
+	alice := addTestUser(t, "alice", "secret")
+	bob := addTestUser(t, "bob", "secret")
+	base := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+
+	aliceConn := recordTestConnection(t, alice, base)
+	closed := recordTestTargetConnection(t, aliceConn, "db.example:5432", base.Add(time.Minute), 100, 200)
+	if err := UpdateTargetConnectionDisconnectTime(closed, base.Add(5*time.Minute)); err != nil {
+		t.Fatalf("update disconnect time: %v", err)
+	}
+	web := recordTestTargetConnection(t, aliceConn, "web.example:443", base.Add(2*time.Minute), 0, 0)
+	forwarded, err := RecordTargetConnection(&models.TargetConnection{ConnectionID: aliceConn, Target: "127.0.0.1:8080",
+		ChannelType: models.ChannelTypeForwardedTCPIP, ConnectedAt: base.Add(3 * time.Minute)})
+	if err != nil {
+		t.Fatalf("record forwarded connection: %v", err)
+	}
+	bobConn := recordTestConnection(t, bob, base.Add(time.Hour))
+	bobDB := recordTestTargetConnection(t, bobConn, "db.example:5432", base.Add(time.Hour), 0, 0)
+
+	active, inactive := true, false
+	tests := []struct {
+		name  string
+		query TargetConnectionQuery
+		want  []int
+	}{
+		{"all", TargetConnectionQuery{}, []int{bobDB, forwarded, web, closed}},
+		{"user id", TargetConnectionQuery{UserID: alice.ID}, []int{forwarded, web, closed}},
+		{"username", TargetConnectionQuery{Username: "bob"}, []int{bobDB}},
+		{"connection id", TargetConnectionQuery{ConnectionID: aliceConn}, []int{forwarded, web, closed}},
+		{"target substring", TargetConnectionQuery{Target: "db."}, []int{bobDB, closed}},
+		{"channel type", TargetConnectionQuery{ChannelType: models.ChannelTypeForwardedTCPIP}, []int{forwarded}},
+		{"time range", TargetConnectionQuery{From: base.Add(2 * time.Minute), To: base.Add(time.Hour)}, []int{forwarded, web}},
+		{"active", TargetConnectionQuery{Active: &active}, []int{bobDB, forwarded, web}},
+		{"inactive", TargetConnectionQuery{Active: &inactive}, []int{closed}},
+		{"combined", TargetConnectionQuery{UserID: alice.ID, Target: "db.", Active: &active}, []int{}},
+	}
+	for _, tt := range tests {
+		page, err := QueryTargetConnections(&tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := targetConnectionIDs(page)
+		if page.Total != len(tt.want) || len(got) != len(tt.want) {
+			t.Errorf("%s: got total %d, ids %v, want %v", tt.name, page.Total, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got ids %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// 记录包含所属SSH连接的用户和客户端地址，以及断开时间
+	page, err := QueryTargetConnections(&TargetConnectionQuery{Active: &inactive})
+	if err != nil {
+		t.Fatalf("query inactive: %v", err)
+	}
+	record := page.Items[0]
+	if record.UserID != alice.ID || record.Username != "alice" || record.IP != "127.0.0.1" || record.BytesDown != 200 ||
+		record.DisconnectedAt == nil || !record.DisconnectedAt.Equal(base.Add(5*time.Minute)) {
+		t.Errorf("got record %+v", record)
+	}
+}
+
+func TestQueryTargetConnectionsPaging(t *testing.T) {
+	openTestDB(t)
+	alice := addTestUser(t, "alice", "secret")
+	base := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+	connID := recordTestConnection(t, alice, base)
+	var ids []int
+	for i := 0; i < 4; i++ {
+		ids = append(ids, recordTestTargetConnection(t, connID, "a:22", base.Add(time.Duration(i)*time.Minute), 0, 0))
+	}
+
+	// 总数不受分页影响
+	page, err := QueryTargetConnections(&TargetConnectionQuery{Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatalf("query page: %v", err)
+	}
+	if got := targetConnectionIDs(page); page.Total != 4 || page.Limit != 2 || len(got) != 2 || got[0] != ids[2] || got[1] != ids[1] {
+		t.Errorf("got total %d, ids %v", page.Total, got)
+	}
+	page, err = QueryTargetConnections(&TargetConnectionQuery{Offset: 10})
+	if err != nil {
+		t.Fatalf("query past the end: %v", err)
+	}
+	if page.Total != 4 || page.Limit != defaultTargetConnectionLimit || page.Items == nil || len(page.Items) != 0 {
+		t.Errorf("got page %+v past the end", page)
+	}
+
+	for _, query := range []*TargetConnectionQuery{{Limit: maxTargetConnectionLimit + 1}, {Offset: -1}} {
+		if _, err := QueryTargetConnections(query); err == nil {
+			t.Errorf("accepted limit %d, offset %d", query.Limit, query.Offset)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
@@ -24,9 +25,16 @@ func InitDB() error {
 		return err
 	}
 	
+	return OpenDB(cfg.DBPath)
+}
+
+// OpenDB 打开指定路径的数据库并创建、迁移数据表
+// 参数: path - 数据库文件路径，":memory:"表示内存数据库（用于测试）
+// 返回: error - 打开或迁移过程中的错误
+func OpenDB(path string) error {
 	// 打开数据库
 	var err error
-	db, err = sql.Open("sqlite", cfg.DBPath)
+	db, err = sql.Open("sqlite", path)
 	if err != nil {
 		return err
 	}
@@ -134,7 +142,25 @@ func createTables() error {
 	if err != nil {
 		return err
 	}
-	
+
+	// 创建用户公钥表（每个用户可以有多个公钥）
+	userKeyTable := `
+	CREATE TABLE IF NOT EXISTS user_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		public_key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, fingerprint),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+	_, err = tx.Exec(userKeyTable)
+	if err != nil {
+		return err
+	}
+
 	// 提交事务
 	return tx.Commit()
 }
@@ -179,10 +205,147 @@ func migrateTables() error {
 		}
 	}
 	
+	// 检查并添加channel_type字段（区分本地转发和远程转发）
+	if err := addColumnIfNotExists(tx, "target_connections", "channel_type", "TEXT NOT NULL DEFAULT 'direct-tcpip'"); err != nil {
+		return err
+	}
+
+	// 检查并添加用户组字段
+	if err := addColumnIfNotExists(tx, "users", "group_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// 检查并添加远程端口转发策略字段
+	if err := addColumnIfNotExists(tx, "users", "remote_forward_addrs", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "remote_forward_ports", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "max_remote_forwards", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "remote_forward_auto_port", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// 检查并添加流量配额字段
+	if err := addColumnIfNotExists(tx, "users", "daily_quota", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "monthly_quota", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "quota_action", "TEXT NOT NULL DEFAULT 'reject'"); err != nil {
+		return err
+	}
+
+	// 检查并添加带宽限速字段
+	if err := addColumnIfNotExists(tx, "users", "rate_limit_up", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "rate_limit_down", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// 检查并添加并发会话数和通道数上限字段
+	if err := addColumnIfNotExists(tx, "users", "max_sessions", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "max_channels", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// 检查并添加会话超时字段和连接断开原因字段
+	if err := addColumnIfNotExists(tx, "users", "idle_timeout", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "users", "max_session_duration", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "connections", "disconnect_reason", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// 创建流量汇总表，以及按时间统计连接数所需的索引
+	if err := createTrafficRollupTable(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_connections_connected_at ON connections(connected_at)"); err != nil {
+		return err
+	}
+
+	// 按时间段统计流量排行所需的索引
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_target_connections_connected_at ON target_connections(connected_at)"); err != nil {
+		return err
+	}
+
+	// 创建REST API令牌表
+	if err := createAPITokenTable(tx); err != nil {
+		return err
+	}
+
+	// 创建管理员账号表
+	if err := createWebAdminTable(tx); err != nil {
+		return err
+	}
+
+	// 将明文存储的旧密码一次性迁移为bcrypt哈希
+	if err := migratePlaintextPasswords(tx); err != nil {
+		return err
+	}
+
 	// 提交事务
 	return tx.Commit()
 }
 
+// migratePlaintextPasswords 将users表中仍为明文的密码重新哈希
+// 已经是bcrypt哈希的记录保持不变，因此可以在每次启动时安全执行
+func migratePlaintextPasswords(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, password FROM users")
+	if err != nil {
+		return err
+	}
+
+	// 先读取全部需要迁移的记录再更新，避免在遍历结果集时写入同一张表
+	plaintext := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var password string
+		if err := rows.Scan(&id, &password); err != nil {
+			rows.Close()
+			return err
+		}
+		if !IsPasswordHash(password) {
+			plaintext[id] = password
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	migrated := 0
+	for id, password := range plaintext {
+		hash, err := HashPassword(password)
+		if err != nil {
+			// 例如超过72字节的密码无法用bcrypt哈希，跳过该用户而不是阻止服务启动
+			log.Printf("Skipping password migration for user %d: %v", id, err)
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", hash, id); err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		log.Printf("Migrated %d plaintext user passwords to bcrypt hashes", migrated)
+	}
+
+	return nil
+}
+
 // columnExists 检查表中是否存在指定字段
 func columnExists(tx *sql.Tx, table, column string) bool {
 	query := `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
@@ -231,8 +394,12 @@ func CreateDefaultUser() error {
 	}
 	
 	if count == 0 {
+		passwordHash, err := HashPassword("admin123")
+		if err != nil {
+			return err
+		}
 		_, err = tx.Exec("INSERT INTO users (name, username, password, active) VALUES (?, ?, ?, ?)",
-			"Admin User", "admin", "admin123", true)
+			"Admin User", "admin", passwordHash, true)
 		if err != nil {
 			return err
 		}
@@ -243,15 +410,25 @@ func CreateDefaultUser() error {
 	return tx.Commit()
 }
 
-// GetUserByUsername 根据用户名获取用户信息
-func GetUserByUsername(username string) (*models.User, error) {
-	db := GetDB()
-	
+// userColumns 查询用户时使用的字段列表，与scanUser的扫描顺序保持一致
+const userColumns = `id, name, username, password, created, active, group_name,
+	remote_forward_addrs, remote_forward_ports, max_remote_forwards, remote_forward_auto_port,
+	daily_quota, monthly_quota, quota_action, rate_limit_up, rate_limit_down, max_sessions, max_channels,
+	idle_timeout, max_session_duration`
+
+// rowScanner 抽象*sql.Row和*sql.Rows的Scan方法
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser 从查询结果中解析用户信息
+func scanUser(row rowScanner) (*models.User, error) {
 	var user models.User
 	var created string
-	err := db.QueryRow("SELECT id, name, username, password, created, active FROM users WHERE username = ?", username).Scan(
-		&user.ID, &user.Name, &user.Username, &user.Password, &created, &user.Active)
-	
+	err := row.Scan(&user.ID, &user.Name, &user.Username, &user.Password, &created, &user.Active, &user.Group,
+		&user.RemoteForwardAddrs, &user.RemoteForwardPorts, &user.MaxRemoteForwards, &user.RemoteForwardAutoPort,
+		&user.DailyQuota, &user.MonthlyQuota, &user.QuotaAction, &user.RateLimitUp, &user.RateLimitDown,
+		&user.MaxSessions, &user.MaxChannels, &user.IdleTimeout, &user.MaxSessionDuration)
 	if err != nil {
 		return nil, err
 	}
@@ -269,47 +446,59 @@ func GetUserByUsername(username string) (*models.User, error) {
 	return &user, nil
 }
 
+// GetUserByUsername 根据用户名获取用户信息
+func GetUserByUsername(username string) (*models.User, error) {
+	db := GetDB()
+
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
 // GetUserByID 根据ID获取用户信息
 func GetUserByID(id int) (*models.User, error) {
 	db := GetDB()
 	
-	var user models.User
-	var created string
-	err := db.QueryRow("SELECT id, name, username, password, created, active FROM users WHERE id = ?", id).Scan(
-		&user.ID, &user.Name, &user.Username, &user.Password, &created, &user.Active)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	// 解析时间
-	user.Created, err = time.Parse("2006-01-02 15:04:05", created)
-	if err != nil {
-		// 尝试其他时间格式
-		user.Created, err = time.Parse(time.RFC3339, created)
-		if err != nil {
-			return nil, err
-		}
-	}
-	
-	return &user, nil
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
 }
 
-// UpdateUser 更新用户信息
+// UpdateUser 更新用户信息，不修改密码（修改密码使用SetUserPassword）
 func UpdateUser(user *models.User) error {
 	db := GetDB()
 	
-	_, err := db.Exec("UPDATE users SET name = ?, username = ?, password = ?, active = ? WHERE id = ?",
-		user.Name, user.Username, user.Password, user.Active, user.ID)
+	_, err := db.Exec(`UPDATE users SET name = ?, username = ?, active = ?, group_name = ?,
+		remote_forward_addrs = ?, remote_forward_ports = ?, max_remote_forwards = ?, remote_forward_auto_port = ?,
+		daily_quota = ?, monthly_quota = ?, quota_action = ?, rate_limit_up = ?, rate_limit_down = ?,
+		max_sessions = ?, max_channels = ?, idle_timeout = ?, max_session_duration = ?
+		WHERE id = ?`,
+		user.Name, user.Username, user.Active, user.Group,
+		user.RemoteForwardAddrs, user.RemoteForwardPorts, user.MaxRemoteForwards, user.RemoteForwardAutoPort,
+		user.DailyQuota, user.MonthlyQuota, quotaActionOrDefault(user.QuotaAction), user.RateLimitUp, user.RateLimitDown,
+		user.MaxSessions, user.MaxChannels, user.IdleTimeout, user.MaxSessionDuration,
+		user.ID)
 	
 	return err
 }
 
+// SetUserPassword 修改用户的密码
+// 参数:
+//   id - 用户ID
+//   passwordHash - bcrypt哈希后的新密码
+// 返回: error - 修改过程中的错误，用户不存在时返回sql.ErrNoRows
+func SetUserPassword(id int, passwordHash string) error {
+	result, err := GetDB().Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // GetAllUsers 获取所有用户
 func GetAllUsers() ([]*models.User, error) {
 	db := GetDB()
 	
-	rows, err := db.Query("SELECT id, name, username, password, created, active FROM users")
+	rows, err := db.Query("SELECT " + userColumns + " FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -317,24 +506,12 @@ func GetAllUsers() ([]*models.User, error) {
 	
 	var users []*models.User
 	for rows.Next() {
-		var user models.User
-		var created string
-		err := rows.Scan(&user.ID, &user.Name, &user.Username, &user.Password, &created, &user.Active)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		
-		// 解析时间
-		user.Created, err = time.Parse("2006-01-02 15:04:05", created)
-		if err != nil {
-			// 尝试其他时间格式
-			user.Created, err = time.Parse(time.RFC3339, created)
-			if err != nil {
-				return nil, err
-			}
-		}
-		
-		users = append(users, &user)
+		users = append(users, user)
 	}
 	
 	return users, nil
@@ -364,8 +541,15 @@ func AddUser(user *models.User) error {
 	}
 	
 	// 插入新用户
-	_, err = tx.Exec("INSERT INTO users (name, username, password, active, created) VALUES (?, ?, ?, ?, ?)",
-		user.Name, user.Username, user.Password, user.Active, user.Created.Format("2006-01-02 15:04:05"))
+	_, err = tx.Exec(`INSERT INTO users (name, username, password, active, created, group_name,
+		remote_forward_addrs, remote_forward_ports, max_remote_forwards, remote_forward_auto_port,
+		daily_quota, monthly_quota, quota_action, rate_limit_up, rate_limit_down, max_sessions, max_channels,
+		idle_timeout, max_session_duration)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.Name, user.Username, user.Password, user.Active, user.Created.Format("2006-01-02 15:04:05"), user.Group,
+		user.RemoteForwardAddrs, user.RemoteForwardPorts, user.MaxRemoteForwards, user.RemoteForwardAutoPort,
+		user.DailyQuota, user.MonthlyQuota, quotaActionOrDefault(user.QuotaAction), user.RateLimitUp, user.RateLimitDown,
+		user.MaxSessions, user.MaxChannels, user.IdleTimeout, user.MaxSessionDuration)
 	if err != nil {
 		return err
 	}
@@ -374,8 +558,69 @@ func AddUser(user *models.User) error {
 	return tx.Commit()
 }
 
+// ErrUserHasConnections 删除用户时该用户仍有连接记录
+var ErrUserHasConnections = errors.New("user has connection records")
+
+// DeleteUser 删除用户及其公钥和只针对该用户的防火墙规则
+// 参数:
+//   id - 用户ID
+//   purgeConnections - 是否同时删除该用户的SSH连接和目标连接记录；为false且存在连接记录时返回ErrUserHasConnections
+// 返回: error - 删除过程中的错误，用户不存在时返回sql.ErrNoRows
+func DeleteUser(id int, purgeConnections bool) error {
+	db := GetDB()
+
+	// 开始事务
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var connections int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM connections WHERE user_id = ?", id).Scan(&connections); err != nil {
+		return err
+	}
+	if connections > 0 {
+		if !purgeConnections {
+			return ErrUserHasConnections
+		}
+		if _, err := tx.Exec("DELETE FROM target_connections WHERE connection_id IN (SELECT id FROM connections WHERE user_id = ?)", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM connections WHERE user_id = ?", id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_keys WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	rules, err := tx.Exec("DELETE FROM firewall_rules WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	// 提交事务
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if n, _ := rules.RowsAffected(); n > 0 {
+		reloadFirewallRulesAfterChange()
+	}
+	return nil
+}
+
 // RecordConnection 记录SSH连接信息并返回数据库ID
 func RecordConnection(conn *models.Connection) (int, error) {
+	defer observeDBWrite("record_connection", time.Now())
 	db := GetDB()
 	
 	// 开始事务
@@ -411,12 +656,12 @@ func RecordConnection(conn *models.Connection) (int, error) {
 func GetConnectionBySessionID(sessionID string) (*models.Connection, error) {
 	db := GetDB()
 	
-	row := db.QueryRow("SELECT id, user_id, username, ip, connected_at, disconnected_at, session_id FROM connections WHERE session_id = ?", sessionID)
+	row := db.QueryRow("SELECT id, user_id, username, ip, connected_at, disconnected_at, session_id, disconnect_reason FROM connections WHERE session_id = ?", sessionID)
 	
 	var conn models.Connection
 	var connectedAtStr string
 	var disconnectedAtStr *string
-	err := row.Scan(&conn.ID, &conn.UserID, &conn.Username, &conn.IP, &connectedAtStr, &disconnectedAtStr, &conn.SessionID)
+	err := row.Scan(&conn.ID, &conn.UserID, &conn.Username, &conn.IP, &connectedAtStr, &disconnectedAtStr, &conn.SessionID, &conn.DisconnectReason)
 	if err != nil {
 		return nil, err
 	}
@@ -446,6 +691,7 @@ func GetConnectionBySessionID(sessionID string) (*models.Connection, error) {
 
 // RecordTargetConnection 记录目标连接信息
 func RecordTargetConnection(targetConn *models.TargetConnection) (int, error) {
+	defer observeDBWrite("record_target_connection", time.Now())
 	db := GetDB()
 	
 	// 开始事务
@@ -455,9 +701,15 @@ func RecordTargetConnection(targetConn *models.TargetConnection) (int, error) {
 	}
 	defer tx.Rollback()
 	
-	result, err := tx.Exec(`INSERT INTO target_connections (connection_id, target, connected_at, bytes_up, bytes_down) 
-		VALUES (?, ?, ?, ?, ?)`,
-		targetConn.ConnectionID, targetConn.Target, targetConn.ConnectedAt.Format("2006-01-02 15:04:05"), targetConn.BytesUp, targetConn.BytesDown)
+	// 未指定通道类型时视为direct-tcpip
+	channelType := targetConn.ChannelType
+	if channelType == "" {
+		channelType = models.ChannelTypeDirectTCPIP
+	}
+
+	result, err := tx.Exec(`INSERT INTO target_connections (connection_id, target, channel_type, connected_at, bytes_up, bytes_down)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		targetConn.ConnectionID, targetConn.Target, channelType, targetConn.ConnectedAt.Format("2006-01-02 15:04:05"), targetConn.BytesUp, targetConn.BytesDown)
 	if err != nil {
 		return 0, err
 	}
@@ -479,6 +731,7 @@ func RecordTargetConnection(targetConn *models.TargetConnection) (int, error) {
 
 // UpdateTargetConnectionTraffic 更新目标连接的流量统计
 func UpdateTargetConnectionTraffic(targetConnID int, bytesUp, bytesDown int64) error {
+	defer observeDBWrite("update_target_traffic", time.Now())
 	db := GetDB()
 	
 	// 开始事务
@@ -500,6 +753,7 @@ func UpdateTargetConnectionTraffic(targetConnID int, bytesUp, bytesDown int64) e
 
 // UpdateTargetConnectionDisconnectTime 更新目标连接的断开时间
 func UpdateTargetConnectionDisconnectTime(targetConnID int, disconnectedAt time.Time) error {
+	defer observeDBWrite("update_target_disconnect", time.Now())
 	db := GetDB()
 	
 	// 开始事务
@@ -519,8 +773,9 @@ func UpdateTargetConnectionDisconnectTime(targetConnID int, disconnectedAt time.
 	return tx.Commit()
 }
 
-// UpdateConnectionDisconnectTime 更新SSH连接的断开时间
-func UpdateConnectionDisconnectTime(sessionID string, disconnectedAt time.Time) error {
+// UpdateConnectionDisconnectTime 更新SSH连接的断开时间和断开原因
+func UpdateConnectionDisconnectTime(sessionID string, disconnectedAt time.Time, reason string) error {
+	defer observeDBWrite("update_connection_disconnect", time.Now())
 	db := GetDB()
 	
 	// 开始事务
@@ -530,8 +785,8 @@ func UpdateConnectionDisconnectTime(sessionID string, disconnectedAt time.Time)
 	}
 	defer tx.Rollback()
 	
-	_, err = tx.Exec("UPDATE connections SET disconnected_at = ? WHERE session_id = ?",
-		disconnectedAt.Format("2006-01-02 15:04:05"), sessionID)
+	_, err = tx.Exec("UPDATE connections SET disconnected_at = ?, disconnect_reason = ? WHERE session_id = ?",
+		disconnectedAt.Format("2006-01-02 15:04:05"), reason, sessionID)
 	if err != nil {
 		return err
 	}
@@ -548,7 +803,7 @@ func UpdateConnectionDisconnectTime(sessionID string, disconnectedAt time.Time)
 func GetConnectionsByUserID(userID int) ([]*models.Connection, error) {
 	db := GetDB()
 	
-	rows, err := db.Query("SELECT id, user_id, username, ip, connected_at, disconnected_at, session_id FROM connections WHERE user_id = ? ORDER BY connected_at DESC", userID)
+	rows, err := db.Query("SELECT id, user_id, username, ip, connected_at, disconnected_at, session_id, disconnect_reason FROM connections WHERE user_id = ? ORDER BY connected_at DESC", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -559,7 +814,7 @@ func GetConnectionsByUserID(userID int) ([]*models.Connection, error) {
 		var conn models.Connection
 		var connectedAtStr string
 		var disconnectedAtStr *string
-		err := rows.Scan(&conn.ID, &conn.UserID, &conn.Username, &conn.IP, &connectedAtStr, &disconnectedAtStr, &conn.SessionID)
+		err := rows.Scan(&conn.ID, &conn.UserID, &conn.Username, &conn.IP, &connectedAtStr, &disconnectedAtStr, &conn.SessionID, &conn.DisconnectReason)
 		if err != nil {
 			return nil, err
 		}
@@ -600,7 +855,7 @@ func GetConnectionsByUserID(userID int) ([]*models.Connection, error) {
 func GetAllConnections() ([]*models.Connection, error) {
 	db := GetDB()
 	
-	rows, err := db.Query("SELECT id, user_id, username, ip, connected_at, disconnected_at, session_id FROM connections ORDER BY connected_at DESC")
+	rows, err := db.Query("SELECT id, user_id, username, ip, connected_at, disconnected_at, session_id, disconnect_reason FROM connections ORDER BY connected_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -611,7 +866,7 @@ func GetAllConnections() ([]*models.Connection, error) {
 		var conn models.Connection
 		var connectedAtStr string
 		var disconnectedAtStr *string
-		err := rows.Scan(&conn.ID, &conn.UserID, &conn.Username, &conn.IP, &connectedAtStr, &disconnectedAtStr, &conn.SessionID)
+		err := rows.Scan(&conn.ID, &conn.UserID, &conn.Username, &conn.IP, &connectedAtStr, &disconnectedAtStr, &conn.SessionID, &conn.DisconnectReason)
 		if err != nil {
 			return nil, err
 		}
@@ -654,7 +909,7 @@ func GetTargetConnectionsByUserID(userID int) ([]*models.TargetConnection, error
 	db := GetDB()
 	
 	rows, err := db.Query(`
-		SELECT tc.id, tc.connection_id, tc.target, tc.connected_at, tc.disconnected_at, tc.bytes_up, tc.bytes_down
+		SELECT tc.id, tc.connection_id, tc.target, tc.channel_type, tc.connected_at, tc.disconnected_at, tc.bytes_up, tc.bytes_down
 		FROM target_connections tc
 		JOIN connections c ON tc.connection_id = c.id
 		WHERE c.user_id = ?
@@ -669,7 +924,7 @@ func GetTargetConnectionsByUserID(userID int) ([]*models.TargetConnection, error
 		var targetConn models.TargetConnection
 		var connectedAtStr string
 		var disconnectedAtStr *string
-		err := rows.Scan(&targetConn.ID, &targetConn.ConnectionID, &targetConn.Target, &connectedAtStr, &disconnectedAtStr, &targetConn.BytesUp, &targetConn.BytesDown)
+		err := rows.Scan(&targetConn.ID, &targetConn.ConnectionID, &targetConn.Target, &targetConn.ChannelType, &connectedAtStr, &disconnectedAtStr, &targetConn.BytesUp, &targetConn.BytesDown)
 		if err != nil {
 			return nil, err
 		}
@@ -711,7 +966,7 @@ func GetAllTargetConnections() ([]*models.TargetConnection, error) {
 	db := GetDB()
 	
 	rows, err := db.Query(`
-		SELECT id, connection_id, target, connected_at, disconnected_at, bytes_up, bytes_down
+		SELECT id, connection_id, target, channel_type, connected_at, disconnected_at, bytes_up, bytes_down
 		FROM target_connections
 		ORDER BY connected_at DESC`)
 	if err != nil {
@@ -724,7 +979,7 @@ func GetAllTargetConnections() ([]*models.TargetConnection, error) {
 		var targetConn models.TargetConnection
 		var connectedAtStr string
 		var disconnectedAtStr *string
-		err := rows.Scan(&targetConn.ID, &targetConn.ConnectionID, &targetConn.Target, &connectedAtStr, &disconnectedAtStr, &targetConn.BytesUp, &targetConn.BytesDown)
+		err := rows.Scan(&targetConn.ID, &targetConn.ConnectionID, &targetConn.Target, &targetConn.ChannelType, &connectedAtStr, &disconnectedAtStr, &targetConn.BytesUp, &targetConn.BytesDown)
 		if err != nil {
 			return nil, err
 		}
@@ -800,4 +1055,35 @@ func GetStatistics() (map[string]interface{}, error) {
 	stats["total_traffic_down"] = totalTrafficDown
 	
 	return stats, nil
-}
\ No newline at end of file
+}
+
+// GetConnectionCountsByDay 按天和用户统计自指定时间以来建立的SSH连接数，在数据库中聚合而不加载所有连接
+// 参数: since - 开始时间
+// 返回:
+//   map[string]map[int]int - 日期（2006-01-02）到各用户ID连接数的映射
+//   error - 查询过程中的错误
+func GetConnectionCountsByDay(since time.Time) (map[string]map[int]int, error) {
+	db := GetDB()
+
+	rows, err := db.Query(`SELECT substr(connected_at, 1, 10) AS day, user_id, COUNT(*) FROM connections
+		WHERE connected_at >= ? GROUP BY day, user_id`, since.Local().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]map[int]int)
+	for rows.Next() {
+		var day string
+		var userID, count int
+		if err := rows.Scan(&day, &userID, &count); err != nil {
+			return nil, err
+		}
+		if counts[day] == nil {
+			counts[day] = make(map[int]int)
+		}
+		counts[day][userID] = count
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// openTestDB 使用内存数据库并初始化防火墙规则表，测试结束后关闭
+// 结束时清除缓存的防火墙规则集，避免影响后续测试
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := OpenDB(":memory:"); err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	InitFirewall()
+	t.Cleanup(func() {
+		CloseDB()
+		resetFirewallRuleSet()
+	})
+}
+
+// resetFirewallRuleSet 清除缓存的防火墙规则集
+func resetFirewallRuleSet() {
+	firewallRuleSetMutex.Lock()
+	firewallRuleSet = nil
+	firewallRuleSetMutex.Unlock()
+}
+
+// addTestUser 直接写入已激活的测试用户，返回包含数据库ID的用户信息
+// 参数:
+//   username - 用户名
+//   password - 存储的密码字段，不做哈希处理
+func addTestUser(t *testing.T, username, password string) *models.User {
+	t.Helper()
+	user := &models.User{Name: username, Username: username, Password: password, Active: true, Created: time.Now()}
+	if err := AddUser(user); err != nil {
+		t.Fatalf("add user %s: %v", username, err)
+	}
+	added, err := GetUserByUsername(username)
+	if err != nil {
+		t.Fatalf("get user %s: %v", username, err)
+	}
+	return added
+}
+
+func TestMigratePlaintextPasswords(t *testing.T) {
+	openTestDB(t)
+	hash, err := HashPassword("hashed")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	addTestUser(t, "plain", "secret")
+	addTestUser(t, "hashed", hash)
+	tooLong := strings.Repeat("x", 73)
+	addTestUser(t, "long", tooLong)
+
+	// 迁移在每次启动时执行，已经是哈希的记录保持不变，无法哈希的记录被跳过
+	for i := 0; i < 2; i++ {
+		if err := migrateTables(); err != nil {
+			t.Fatalf("migrate tables: %v", err)
+		}
+	}
+
+	plain, _ := GetUserByUsername("plain")
+	if !IsPasswordHash(plain.Password) || !CheckPassword(plain.Password, "secret") {
+		t.Errorf("plaintext password not migrated: %q", plain.Password)
+	}
+	if hashed, _ := GetUserByUsername("hashed"); hashed.Password != hash {
+		t.Errorf("existing hash changed to %q", hashed.Password)
+	}
+	if long, _ := GetUserByUsername("long"); long.Password != tooLong {
+		t.Errorf("unmigratable password changed to %q", long.Password)
+	}
+}
+
+// recordTestConnection 写入用户的SSH连接记录，返回连接ID
+func recordTestConnection(t *testing.T, user *models.User, at time.Time) int {
+	t.Helper()
+	id, err := RecordConnection(&models.Connection{UserID: user.ID, Username: user.Username, IP: "127.0.0.1", ConnectedAt: at,
+		SessionID: user.Username + at.Format(time.RFC3339Nano)})
+	if err != nil {
+		t.Fatalf("record connection: %v", err)
+	}
+	return id
+}
+
+// recordTestTargetConnection 写入direct-tcpip目标连接记录，返回目标连接ID
+func recordTestTargetConnection(t *testing.T, connID int, target string, at time.Time, bytesUp, bytesDown int64) int {
+	t.Helper()
+	id, err := RecordTargetConnection(&models.TargetConnection{ConnectionID: connID, Target: target, ConnectedAt: at, BytesUp: bytesUp, BytesDown: bytesDown})
+	if err != nil {
+		t.Fatalf("record target connection: %v", err)
+	}
+	return id
+}
@@ -1,22 +1,86 @@
 package utils
 
 import (
+	"database/sql"
+	"fmt"
 	"log"
-	"regexp"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"ssh-manage/config"
+	"ssh-manage/models"
 )
 
-// FirewallRule 防火墙规则类型
-type FirewallRule struct {
-	ID      int
-	Type    string // "whitelist" 或 "blacklist"
-	Pattern string // 正则表达式模式
-	Active  bool
-}
+// 防火墙评估模式和顺序模式的默认策略
+var (
+	firewallMode          = models.FirewallModeCompat
+	firewallDefaultPolicy = models.FirewallActionAllow
+	firewallPolicyMutex   sync.RWMutex
+)
 
 // InitFirewall 初始化防火墙模块
 func InitFirewall() {
 	// 创建防火墙规则表
 	createFirewallTable()
+
+	// 迁移防火墙规则表结构（添加新字段）
+	if err := migrateFirewallTable(); err != nil {
+		log.Printf("Failed to migrate firewall_rules table: %v", err)
+	}
+
+	// 加载评估模式和默认策略
+	cfg := config.Load()
+	if err := SetFirewallPolicy(cfg.FirewallMode, cfg.FirewallDefaultPolicy); err != nil {
+		log.Printf("Invalid firewall policy configuration, using compat mode: %v", err)
+	}
+
+	// 编译规则集
+	if err := ReloadFirewallRules(); err != nil {
+		log.Printf("Failed to load firewall rules: %v", err)
+	}
+}
+
+// SetFirewallPolicy 设置防火墙评估模式和顺序模式的默认策略
+// 参数:
+//   mode - 评估模式："compat"或"ordered"
+//   defaultPolicy - 顺序模式下未命中任何规则时的动作："allow"或"deny"
+// 返回: error - 参数错误
+func SetFirewallPolicy(mode, defaultPolicy string) error {
+	mode, defaultPolicy, err := normalizeFirewallPolicy(mode, defaultPolicy)
+	if err != nil {
+		return err
+	}
+
+	firewallPolicyMutex.Lock()
+	firewallMode = mode
+	firewallDefaultPolicy = defaultPolicy
+	firewallPolicyMutex.Unlock()
+	return nil
+}
+
+// normalizeFirewallPolicy 统一评估模式和默认策略的写法并校验
+func normalizeFirewallPolicy(mode, defaultPolicy string) (string, string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	defaultPolicy = strings.ToLower(strings.TrimSpace(defaultPolicy))
+	if mode != models.FirewallModeCompat && mode != models.FirewallModeOrdered {
+		return "", "", fmt.Errorf("invalid firewall mode %q", mode)
+	}
+	if defaultPolicy != models.FirewallActionAllow && defaultPolicy != models.FirewallActionDeny {
+		return "", "", fmt.Errorf("invalid firewall default policy %q", defaultPolicy)
+	}
+	return mode, defaultPolicy, nil
+}
+
+// GetFirewallPolicy 获取防火墙评估模式和顺序模式的默认策略
+// 返回:
+//   string - 评估模式
+//   string - 默认策略
+func GetFirewallPolicy() (string, string) {
+	firewallPolicyMutex.RLock()
+	defer firewallPolicyMutex.RUnlock()
+	return firewallMode, firewallDefaultPolicy
 }
 
 // createFirewallTable 创建防火墙规则表
@@ -35,108 +99,511 @@ func createFirewallTable() {
 	if err != nil {
 		log.Printf("Failed to create firewall_rules table: %v", err)
 	}
+
+	// 创建防火墙决策记录表
+	query = `
+	CREATE TABLE IF NOT EXISTS firewall_decisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		username TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL,
+		ip TEXT NOT NULL DEFAULT '',
+		rule_id INTEGER NOT NULL DEFAULT 0, -- 0表示未命中任何规则
+		action TEXT NOT NULL, -- 'allow' 或 'deny'
+		decided_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_firewall_decisions_decided_at ON firewall_decisions(decided_at);
+	`
+
+	_, err = db.Exec(query)
+	if err != nil {
+		log.Printf("Failed to create firewall_decisions table: %v", err)
+	}
+}
+
+// migrateFirewallTable 迁移防火墙规则表结构以支持新字段
+func migrateFirewallTable() error {
+	db := GetDB()
+
+	// 开始事务
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 检查并添加kind字段（已有规则均为正则规则）
+	if err := addColumnIfNotExists(tx, "firewall_rules", "kind", "TEXT NOT NULL DEFAULT 'regex'"); err != nil {
+		return err
+	}
+
+	// 检查并添加ports字段
+	if err := addColumnIfNotExists(tx, "firewall_rules", "ports", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// 检查并添加user_id和group_name字段（规则适用范围）
+	if err := addColumnIfNotExists(tx, "firewall_rules", "user_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "firewall_rules", "group_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// 检查并添加action和priority字段（顺序模式）
+	if err := addColumnIfNotExists(tx, "firewall_rules", "action", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "firewall_rules", "priority", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// 已有规则按类型补全动作，按ID顺序补全优先级
+	if _, err := tx.Exec(`UPDATE firewall_rules SET action = CASE WHEN type = 'whitelist' THEN 'allow' ELSE 'deny' END WHERE action = ''`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE firewall_rules SET priority = id * 10 WHERE priority = 0`); err != nil {
+		return err
+	}
+
+	// 检查并添加hit_count和last_hit字段（规则命中统计）
+	if err := addColumnIfNotExists(tx, "firewall_rules", "hit_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "firewall_rules", "last_hit", "DATETIME"); err != nil {
+		return err
+	}
+
+	// 检查并添加时间窗口字段
+	if err := addColumnIfNotExists(tx, "firewall_rules", "days", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "firewall_rules", "time_range", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "firewall_rules", "timezone", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "firewall_rules", "starts_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(tx, "firewall_rules", "expires_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// ValidateFirewallRule 校验防火墙规则的配置
+// 参数: rule - 防火墙规则
+// 返回: error - 配置错误
+func ValidateFirewallRule(rule *models.FirewallRule) error {
+	if rule.Type != "whitelist" && rule.Type != "blacklist" {
+		return fmt.Errorf("invalid rule type %q", rule.Type)
+	}
+	if rule.Action != models.FirewallActionAllow && rule.Action != models.FirewallActionDeny {
+		return fmt.Errorf("invalid rule action %q", rule.Action)
+	}
+	if firewallTypeForAction(rule.Action) != rule.Type {
+		return fmt.Errorf("rule action %q does not match rule type %q", rule.Action, rule.Type)
+	}
+
+	if rule.Kind != models.FirewallKindRegex && rule.Kind != models.FirewallKindCIDR && rule.Kind != models.FirewallKindHost {
+		return fmt.Errorf("invalid rule kind %q", rule.Kind)
+	}
+
+	// 编译匹配模式和端口范围，无效的正则等在添加时即被拒绝
+	if _, err := compileFirewallRule(rule); err != nil {
+		return err
+	}
+
+	if rule.UserID != 0 && rule.Group != "" {
+		return fmt.Errorf("a rule can be scoped to a user or a group, not both")
+	}
+
+	return nil
+}
+
+// firewallTypeForAction 获取动作对应的兼容模式规则类型
+func firewallTypeForAction(action string) string {
+	if action == models.FirewallActionAllow {
+		return "whitelist"
+	}
+	return "blacklist"
+}
+
+// firewallActionForType 获取兼容模式规则类型对应的动作
+func firewallActionForType(ruleType string) string {
+	if ruleType == "whitelist" {
+		return models.FirewallActionAllow
+	}
+	return models.FirewallActionDeny
 }
 
 // AddFirewallRule 添加防火墙规则
-// 参数:
-//   ruleType - 规则类型("whitelist"或"blacklist")
-//   pattern - 正则表达式模式
+// Type和Action只需设置其一，另一个自动补全；Priority为0时排在所有规则之后
+// 参数: rule - 防火墙规则（Kind为空时视为正则规则）
 // 返回: error - 添加过程中的错误
-func AddFirewallRule(ruleType, pattern string) error {
+func AddFirewallRule(rule *models.FirewallRule) error {
+	if err := normalizeFirewallRule(rule); err != nil {
+		return err
+	}
+
 	db := GetDB()
-	query := `INSERT INTO firewall_rules (type, pattern, active) VALUES (?, ?, ?)`
-	_, err := db.Exec(query, ruleType, pattern, true)
-	return err
+	if rule.Priority <= 0 {
+		var maxPriority int
+		if err := db.QueryRow(`SELECT COALESCE(MAX(priority), 0) FROM firewall_rules`).Scan(&maxPriority); err != nil {
+			return err
+		}
+		rule.Priority = maxPriority + 10
+	}
+
+	query := `INSERT INTO firewall_rules (type, action, priority, kind, pattern, ports, user_id, group_name, days, time_range, timezone, starts_at, expires_at, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(query, rule.Type, rule.Action, rule.Priority, rule.Kind, rule.Pattern, rule.Ports, rule.UserID, rule.Group,
+		rule.Days, rule.TimeRange, rule.Timezone, formatNullableTime(rule.StartsAt), formatNullableTime(rule.ExpiresAt), true)
+	if err != nil {
+		return err
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		rule.ID = int(id)
+	}
+	rule.Active = true
+
+	reloadFirewallRulesAfterChange()
+	return nil
 }
 
-// GetFirewallRules 获取所有防火墙规则
-// 返回: 
-//   []*FirewallRule - 防火墙规则列表
+// normalizeFirewallRule 补全防火墙规则的默认值并校验
+func normalizeFirewallRule(rule *models.FirewallRule) error {
+	if rule.Kind == "" {
+		rule.Kind = models.FirewallKindRegex
+	}
+	if rule.Action == "" && rule.Type != "" {
+		rule.Action = firewallActionForType(rule.Type)
+	}
+	if rule.Type == "" && rule.Action != "" {
+		rule.Type = firewallTypeForAction(rule.Action)
+	}
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	rule.Ports = strings.TrimSpace(rule.Ports)
+	rule.Group = strings.TrimSpace(rule.Group)
+	rule.Days = strings.TrimSpace(rule.Days)
+	rule.TimeRange = strings.TrimSpace(rule.TimeRange)
+	rule.Timezone = strings.TrimSpace(rule.Timezone)
+
+	return ValidateFirewallRule(rule)
+}
+
+// firewallRuleColumns 查询防火墙规则时选择的列，与scanFirewallRule的顺序一致
+const firewallRuleColumns = `id, type, action, priority, kind, pattern, ports, user_id, group_name, active, hit_count, last_hit,
+		days, time_range, timezone, starts_at, expires_at`
+
+// scanFirewallRule 从查询结果中解析防火墙规则
+func scanFirewallRule(row rowScanner) (*models.FirewallRule, error) {
+	var rule models.FirewallRule
+	var lastHitStr, startsAtStr, expiresAtStr *string
+	err := row.Scan(&rule.ID, &rule.Type, &rule.Action, &rule.Priority, &rule.Kind, &rule.Pattern, &rule.Ports, &rule.UserID, &rule.Group, &rule.Active, &rule.HitCount, &lastHitStr,
+		&rule.Days, &rule.TimeRange, &rule.Timezone, &startsAtStr, &expiresAtStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析最后命中时间和时间窗口（可能为NULL）
+	if rule.LastHit, err = parseNullableTime(lastHitStr); err != nil {
+		return nil, err
+	}
+	if rule.StartsAt, err = parseNullableTime(startsAtStr); err != nil {
+		return nil, err
+	}
+	if rule.ExpiresAt, err = parseNullableTime(expiresAtStr); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// GetFirewallRules 获取所有防火墙规则，按优先级排序
+// 返回:
+//   []*models.FirewallRule - 防火墙规则列表
 //   error - 查询过程中的错误
-func GetFirewallRules() ([]*FirewallRule, error) {
+func GetFirewallRules() ([]*models.FirewallRule, error) {
 	db := GetDB()
-	query := `SELECT id, type, pattern, active FROM firewall_rules WHERE active = 1 ORDER BY id`
+	query := `SELECT ` + firewallRuleColumns + `
+		FROM firewall_rules WHERE active = 1 ORDER BY priority, id`
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
-	var rules []*FirewallRule
+	var rules []*models.FirewallRule
 	for rows.Next() {
-		var rule FirewallRule
-		err := rows.Scan(&rule.ID, &rule.Type, &rule.Pattern, &rule.Active)
+		rule, err := scanFirewallRule(rows)
 		if err != nil {
 			return nil, err
 		}
-		rules = append(rules, &rule)
+		rules = append(rules, rule)
 	}
 	
 	return rules, nil
 }
 
+// GetFirewallRule 根据ID获取防火墙规则
+// 参数: id - 规则ID
+// 返回:
+//   *models.FirewallRule - 防火墙规则
+//   error - 规则不存在时返回sql.ErrNoRows
+func GetFirewallRule(id int) (*models.FirewallRule, error) {
+	db := GetDB()
+	return scanFirewallRule(db.QueryRow(`SELECT `+firewallRuleColumns+` FROM firewall_rules WHERE id = ? AND active = 1`, id))
+}
+
+// UpdateFirewallRule 更新防火墙规则的定义，命中次数等统计信息保持不变
+// Type和Action的补全与AddFirewallRule相同；Priority为0时保持原优先级
+// 参数: rule - 防火墙规则，ID为要更新的规则
+// 返回: error - 更新过程中的错误，规则不存在时返回sql.ErrNoRows
+func UpdateFirewallRule(rule *models.FirewallRule) error {
+	if err := normalizeFirewallRule(rule); err != nil {
+		return err
+	}
+
+	db := GetDB()
+	query := `UPDATE firewall_rules SET type = ?, action = ?, priority = CASE WHEN ? > 0 THEN ? ELSE priority END,
+		kind = ?, pattern = ?, ports = ?, user_id = ?, group_name = ?, days = ?, time_range = ?, timezone = ?, starts_at = ?, expires_at = ?
+		WHERE id = ? AND active = 1`
+	result, err := db.Exec(query, rule.Type, rule.Action, rule.Priority, rule.Priority, rule.Kind, rule.Pattern, rule.Ports, rule.UserID, rule.Group,
+		rule.Days, rule.TimeRange, rule.Timezone, formatNullableTime(rule.StartsAt), formatNullableTime(rule.ExpiresAt), rule.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	reloadFirewallRulesAfterChange()
+	return nil
+}
+
+// parseNullableTime 解析数据库中可能为NULL的时间
+func parseNullableTime(value *string) (*time.Time, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", *value, time.Local)
+	if err != nil {
+		// 尝试其他时间格式
+		t, err = time.Parse(time.RFC3339, *value)
+		if err != nil {
+			return nil, err
+		}
+		// SQLite驱动把DATETIME列按UTC返回，但写入的是本地时间，需要按本地时区重新解释
+		if t.Location() == time.UTC {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		}
+	}
+	return &t, nil
+}
+
+// formatNullableTime 将可能为nil的时间格式化为数据库中的本地时间
+func formatNullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
 // DeleteFirewallRule 删除防火墙规则
 // 参数: id - 规则ID
-// 返回: error - 删除过程中的错误
+// 返回: error - 删除过程中的错误，规则不存在时返回sql.ErrNoRows
 func DeleteFirewallRule(id int) error {
 	db := GetDB()
 	query := `DELETE FROM firewall_rules WHERE id = ?`
-	_, err := db.Exec(query, id)
-	return err
+	result, err := db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	reloadFirewallRulesAfterChange()
+	return nil
+}
+
+// SetFirewallRulePriority 设置防火墙规则的优先级
+// 参数:
+//   id - 规则ID
+//   priority - 优先级，数值越小越先评估
+// 返回: error - 更新过程中的错误
+func SetFirewallRulePriority(id, priority int) error {
+	if priority <= 0 {
+		return fmt.Errorf("priority must be a positive number")
+	}
+
+	db := GetDB()
+	result, err := db.Exec(`UPDATE firewall_rules SET priority = ? WHERE id = ?`, priority, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("firewall rule %d not found", id)
+	}
+
+	reloadFirewallRulesAfterChange()
+	return nil
 }
 
-// IsAddressAllowed 检查目标地址是否被允许
+// MoveFirewallRule 将防火墙规则上移或下移一位
+// 移动前会按当前顺序将优先级重新编号为10、20、30……，再与相邻规则交换
+// 参数:
+//   id - 规则ID
+//   up - true为上移，false为下移
+// 返回: error - 移动过程中的错误
+func MoveFirewallRule(id int, up bool) error {
+	rules, err := GetFirewallRules()
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i, rule := range rules {
+		if rule.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("firewall rule %d not found", id)
+	}
+
+	other := index + 1
+	if up {
+		other = index - 1
+	}
+	if other < 0 || other >= len(rules) {
+		// 已经在最前或最后
+		return nil
+	}
+	rules[index], rules[other] = rules[other], rules[index]
+
+	db := GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, rule := range rules {
+		if _, err := tx.Exec(`UPDATE firewall_rules SET priority = ? WHERE id = ?`, (i+1)*10, rule.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	reloadFirewallRulesAfterChange()
+	return nil
+}
+
+// FirewallTarget 防火墙检查的目标
+type FirewallTarget struct {
+	Host string    // 客户端请求的主机（主机名或IP）
+	IP   net.IP    // 解析得到的IP地址，未解析时为nil
+	Port string    // 目标端口
+	Time time.Time // 检查时间，用于判断规则的时间窗口，零值表示当前时间
+}
+
+// IsAddressAllowed 检查目标地址是否被全局规则允许
+// 该函数不进行DNS解析，仅当主机本身是IP地址时才会匹配网段规则；
+// 转发连接时应使用ResolveAllowedTarget，以便检查主机名解析出的所有IP以及用户/用户组规则
 // 参数: address - 目标地址
 // 返回: bool - 是否允许连接
 func IsAddressAllowed(address string) bool {
-	rules, err := GetFirewallRules()
+	host, port, err := net.SplitHostPort(address)
 	if err != nil {
-		log.Printf("Failed to get firewall rules: %v", err)
-		// 出错时默认允许连接
-		return true
-	}
-	
-	// 如果没有规则，默认允许所有流量
-	if len(rules) == 0 {
-		return true
+		host, port = address, ""
 	}
-	
-	// 检查白名单规则
-	whitelistExists := false
-	for _, rule := range rules {
-		if rule.Type == "whitelist" {
-			whitelistExists = true
-			match, err := regexp.MatchString(rule.Pattern, address)
-			if err != nil {
-				log.Printf("Invalid whitelist pattern '%s': %v", rule.Pattern, err)
-				continue
-			}
-			if match {
-				return true
-			}
+
+	return IsTargetAllowed(nil, FirewallTarget{Host: host, IP: ParseIPAddress(host), Port: port})
+}
+
+// portInRanges 检查端口是否在任一端口范围内
+func portInRanges(port uint32, ranges []PortRange) bool {
+	for _, r := range ranges {
+		if port >= r.Start && port <= r.End {
+			return true
 		}
 	}
+	return false
+}
+
+// normalizeHostname 统一主机名写法（小写、去掉末尾的点）
+func normalizeHostname(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// ParseIPAddress 解析目标主机中的IP地址，兼容各种等价写法
+// 除标准写法外，还支持IPv6区域标识（fe80::1%eth0）以及inet_aton风格的IPv4写法
+// （如127.1、0x7f.0.0.1、0177.0.0.1、2130706433），避免通过不同写法绕过网段规则
+// 参数: host - 目标主机
+// 返回: net.IP - 解析出的IP地址，不是IP地址时返回nil
+func ParseIPAddress(host string) net.IP {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
 	
-	// 如果存在白名单但地址不匹配任何白名单规则，则拒绝
-	if whitelistExists {
-		return false
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
 	}
 	
-	// 检查黑名单规则
-	for _, rule := range rules {
-		if rule.Type == "blacklist" {
-			match, err := regexp.MatchString(rule.Pattern, address)
-			if err != nil {
-				log.Printf("Invalid blacklist pattern '%s': %v", rule.Pattern, err)
-				continue
-			}
-			// 如果匹配黑名单规则，则拒绝
-			if match {
-				return false
-			}
+	return parseLegacyIPv4(host)
+}
+
+// parseLegacyIPv4 按inet_aton规则解析IPv4地址
+func parseLegacyIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) == 0 || len(parts) > 4 {
+		return nil
+	}
+
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		if part == "" {
+			return nil
+		}
+		// 0x前缀为十六进制，0前缀为八进制，其余为十进制
+		base, digits := 10, part
+		switch {
+		case len(part) > 2 && (part[:2] == "0x" || part[:2] == "0X"):
+			base, digits = 16, part[2:]
+		case len(part) > 1 && part[0] == '0':
+			base, digits = 8, part[1:]
+		}
+		v, err := strconv.ParseUint(digits, base, 32)
+		if err != nil || strings.ContainsAny(digits, "+-_") {
+			return nil
 		}
+		values[i] = v
 	}
 	
-	// 如果没有匹配任何黑名单规则，则允许
-	return true
-}
\ No newline at end of file
+	// 最后一部分填充剩余的所有字节
+	var addr uint64
+	for i, v := range values[:len(values)-1] {
+		if v > 0xff {
+			return nil
+		}
+		addr |= v << (24 - 8*uint(i))
+	}
+	last := values[len(values)-1]
+	if last >= 1<<(8*uint(5-len(values))) {
+		return nil
+	}
+	addr |= last
+
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"ssh-manage/models"
+)
+
+// maxPendingFirewallDecisions 内存中等待写入的防火墙决策记录上限
+// 超过后丢弃新的决策记录（规则命中次数仍然累计），避免数据库不可用时内存无限增长
+const maxPendingFirewallDecisions = 10000
+
+// firewallRuleHits 一条规则在两次写入之间的命中统计
+type firewallRuleHits struct {
+	count   int64
+	lastHit time.Time
+}
+
+// 等待FlushFirewallDecisions写入数据库的防火墙决策和规则命中统计
+var (
+	pendingFirewallDecisions []*models.FirewallDecision
+	pendingFirewallRuleHits  = make(map[int]*firewallRuleHits)
+	droppedFirewallDecisions int
+	pendingFirewallMutex     sync.Mutex
+)
+
+// RecordFirewallDecision 记录防火墙决策，并累计命中规则的命中次数和最后命中时间
+// 决策只写入内存，由FlushFirewallDecisions定期批量写入数据库，打开通道时不访问数据库
+// 参数: decision - 防火墙决策（Time为零值时使用当前时间）
+func RecordFirewallDecision(decision *models.FirewallDecision) {
+	if decision.Action == models.FirewallActionDeny {
+		firewallDenialsTotal.Inc(strconv.Itoa(decision.RuleID))
+	}
+	if decision.Time.IsZero() {
+		decision.Time = time.Now()
+	}
+
+	pendingFirewallMutex.Lock()
+	defer pendingFirewallMutex.Unlock()
+
+	if len(pendingFirewallDecisions) < maxPendingFirewallDecisions {
+		pendingFirewallDecisions = append(pendingFirewallDecisions, decision)
+	} else {
+		droppedFirewallDecisions++
+	}
+
+	// 命中次数不影响规则评估，无需重建规则集
+	if decision.RuleID != 0 {
+		hits := pendingFirewallRuleHits[decision.RuleID]
+		if hits == nil {
+			hits = &firewallRuleHits{}
+			pendingFirewallRuleHits[decision.RuleID] = hits
+		}
+		hits.count++
+		if decision.Time.After(hits.lastHit) {
+			hits.lastHit = decision.Time
+		}
+	}
+}
+
+// FlushFirewallDecisions 将内存中的防火墙决策和规则命中统计在一个事务中写入数据库
+// 写入失败时丢弃本批数据，避免数据库持续不可用时内存无限增长
+// 返回: error - 写入过程中的错误
+func FlushFirewallDecisions() error {
+	pendingFirewallMutex.Lock()
+	decisions, ruleHits, dropped := pendingFirewallDecisions, pendingFirewallRuleHits, droppedFirewallDecisions
+	pendingFirewallDecisions = nil
+	pendingFirewallRuleHits = make(map[int]*firewallRuleHits)
+	droppedFirewallDecisions = 0
+	pendingFirewallMutex.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Dropped %d firewall decisions because more than %d were pending", dropped, maxPendingFirewallDecisions)
+	}
+	if len(decisions) == 0 && len(ruleHits) == 0 {
+		return nil
+	}
+	defer observeDBWrite("record_firewall_decision", time.Now())
+
+	tx, err := GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`INSERT INTO firewall_decisions (user_id, username, target, ip, rule_id, action, decided_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, decision := range decisions {
+		_, err := insert.Exec(decision.UserID, decision.Username, decision.Target, decision.IP, decision.RuleID, decision.Action,
+			decision.Time.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+	}
+
+	// 更新命中规则的统计，已删除的规则不受影响
+	for ruleID, hits := range ruleHits {
+		_, err := tx.Exec(`UPDATE firewall_rules SET hit_count = hit_count + ?, last_hit = ? WHERE id = ?`,
+			hits.count, hits.lastHit.Format("2006-01-02 15:04:05"), ruleID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PruneFirewallDecisions 删除超过保留时长的防火墙决策记录
+// 参数: retention - 保留时长，0表示永久保留
+// 返回:
+//   int64 - 删除的记录数
+//   error - 删除过程中的错误
+func PruneFirewallDecisions(retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	result, err := GetDB().Exec("DELETE FROM firewall_decisions WHERE decided_at < ?",
+		time.Now().Add(-retention).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetFirewallDecisions 获取最近的防火墙决策记录
+// 参数:
+//   action - 按决策结果筛选（"allow"或"deny"），为空时不筛选
+//   limit - 最多返回的记录数
+// 返回:
+//   []*models.FirewallDecision - 决策记录列表，按时间倒序
+//   error - 查询过程中的错误
+func GetFirewallDecisions(action string, limit int) ([]*models.FirewallDecision, error) {
+	db := GetDB()
+
+	rows, err := db.Query(`
+		SELECT id, user_id, username, target, ip, rule_id, action, decided_at
+		FROM firewall_decisions
+		WHERE ? = '' OR action = ?
+		ORDER BY id DESC
+		LIMIT ?`, action, action, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []*models.FirewallDecision
+	for rows.Next() {
+		var decision models.FirewallDecision
+		var decidedAtStr string
+		err := rows.Scan(&decision.ID, &decision.UserID, &decision.Username, &decision.Target, &decision.IP, &decision.RuleID, &decision.Action, &decidedAtStr)
+		if err != nil {
+			return nil, err
+		}
+
+		// 解析时间
+		decision.Time, err = time.Parse("2006-01-02 15:04:05", decidedAtStr)
+		if err != nil {
+			// 尝试其他时间格式
+			decision.Time, err = time.Parse(time.RFC3339, decidedAtStr)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		decisions = append(decisions, &decision)
+	}
+
+	return decisions, rows.Err()
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// openTestDecisionDB 打开测试数据库，并丢弃之前的测试留在内存中的防火墙决策
+func openTestDecisionDB(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+	if err := FlushFirewallDecisions(); err != nil {
+		t.Fatalf("flush pending decisions: %v", err)
+	}
+}
+
+func TestFlushFirewallDecisions(t *testing.T) {
+	openTestDecisionDB(t)
+	rule := &models.FirewallRule{Action: models.FirewallActionDeny, Kind: models.FirewallKindCIDR, Pattern: "10.0.0.0/8"}
+	if err := AddFirewallRule(rule); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+
+	first := time.Now().Add(-time.Minute).Truncate(time.Second)
+	last := first.Add(30 * time.Second)
+	RecordFirewallDecision(&models.FirewallDecision{UserID: 1, Username: "alice", Target: "10.0.0.1:22", IP: "10.0.0.1", RuleID: rule.ID, Action: models.FirewallActionDeny, Time: last})
+	RecordFirewallDecision(&models.FirewallDecision{UserID: 1, Username: "alice", Target: "10.0.0.2:22", IP: "10.0.0.2", RuleID: rule.ID, Action: models.FirewallActionDeny, Time: first})
+	RecordFirewallDecision(&models.FirewallDecision{UserID: 1, Username: "alice", Target: "example.com:443", IP: "93.184.216.34", Action: models.FirewallActionAllow})
+	// 已删除的规则的命中统计被忽略
+	RecordFirewallDecision(&models.FirewallDecision{Target: "10.0.0.3:22", RuleID: 99, Action: models.FirewallActionDeny})
+
+	// 写入数据库之前只保存在内存中
+	if decisions, err := GetFirewallDecisions("", 10); err != nil || len(decisions) != 0 {
+		t.Fatalf("got %d decisions, %v before flush", len(decisions), err)
+	}
+	if err := FlushFirewallDecisions(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	decisions, err := GetFirewallDecisions("", 10)
+	if err != nil || len(decisions) != 4 {
+		t.Fatalf("got %d decisions, %v after flush, want 4", len(decisions), err)
+	}
+	// 按写入顺序倒序返回，未指定时间时使用记录时的时间
+	if decisions[3].Target != "10.0.0.1:22" || decisions[1].Action != models.FirewallActionAllow || decisions[1].Time.IsZero() {
+		t.Errorf("got decisions %+v, %+v", decisions[3], decisions[1])
+	}
+	if denied, _ := GetFirewallDecisions(models.FirewallActionDeny, 10); len(denied) != 3 {
+		t.Errorf("got %d denied decisions, want 3", len(denied))
+	}
+	if limited, _ := GetFirewallDecisions("", 2); len(limited) != 2 {
+		t.Errorf("got %d decisions with limit 2", len(limited))
+	}
+
+	stored, err := GetFirewallRule(rule.ID)
+	if err != nil {
+		t.Fatalf("get rule: %v", err)
+	}
+	if stored.HitCount != 2 || stored.LastHit == nil || !stored.LastHit.Equal(last) {
+		t.Errorf("got hit count %d, last hit %v, want 2 and %v", stored.HitCount, stored.LastHit, last)
+	}
+
+	// 再次写入时不重复累计
+	if err := FlushFirewallDecisions(); err != nil {
+		t.Fatalf("second flush: %v", err)
+	}
+	if stored, _ := GetFirewallRule(rule.ID); stored.HitCount != 2 {
+		t.Errorf("got hit count %d after a second flush, want 2", stored.HitCount)
+	}
+}
+
+func TestRecordFirewallDecisionPendingLimit(t *testing.T) {
+	openTestDecisionDB(t)
+	rule := &models.FirewallRule{Action: models.FirewallActionDeny, Kind: models.FirewallKindHost, Pattern: "*"}
+	if err := AddFirewallRule(rule); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+
+	// 超过上限的决策记录被丢弃，命中次数仍然累计
+	for i := 0; i < maxPendingFirewallDecisions+5; i++ {
+		RecordFirewallDecision(&models.FirewallDecision{Target: "example.com:22", RuleID: rule.ID, Action: models.FirewallActionDeny})
+	}
+	if err := FlushFirewallDecisions(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	var count int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM firewall_decisions").Scan(&count); err != nil {
+		t.Fatalf("count decisions: %v", err)
+	}
+	if count != maxPendingFirewallDecisions {
+		t.Errorf("got %d stored decisions, want %d", count, maxPendingFirewallDecisions)
+	}
+	if stored, _ := GetFirewallRule(rule.ID); stored.HitCount != maxPendingFirewallDecisions+5 {
+		t.Errorf("got hit count %d, want %d", stored.HitCount, maxPendingFirewallDecisions+5)
+	}
+}
+
+func TestPruneFirewallDecisions(t *testing.T) {
+	openTestDecisionDB(t)
+	now := time.Now()
+	RecordFirewallDecision(&models.FirewallDecision{Target: "old:22", Action: models.FirewallActionAllow, Time: now.AddDate(0, 0, -10)})
+	RecordFirewallDecision(&models.FirewallDecision{Target: "recent:22", Action: models.FirewallActionAllow, Time: now.AddDate(0, 0, -1)})
+	if err := FlushFirewallDecisions(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	// 保留时长为0表示永久保留
+	if deleted, err := PruneFirewallDecisions(0); err != nil || deleted != 0 {
+		t.Fatalf("prune with retention 0 deleted %d, %v", deleted, err)
+	}
+	if deleted, err := PruneFirewallDecisions(7 * 24 * time.Hour); err != nil || deleted != 1 {
+		t.Fatalf("prune with 7 day retention deleted %d, %v, want 1", deleted, err)
+	}
+	decisions, _ := GetFirewallDecisions("", 10)
+	if len(decisions) != 1 || decisions[0].Target != "recent:22" {
+		t.Errorf("got %+v after prune, want only the recent decision", decisions)
+	}
+}
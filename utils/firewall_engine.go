@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"ssh-manage/models"
+)
+
+// compiledFirewallRule 预编译的防火墙规则
+type compiledFirewallRule struct {
+	rule     *models.FirewallRule
+	regex    *regexp.Regexp    // 正则规则编译后的表达式
+	network  *net.IPNet        // 网段规则解析后的网段
+	host     string            // 主机名通配规则（已转为小写）
+	ports    []PortRange       // 端口范围，为空表示任意端口
+	schedule *firewallSchedule // 时间窗口，为nil表示始终生效
+}
+
+// FirewallRuleSet 编译后的防火墙规则集，创建后只读，可以在多个goroutine中并发使用
+type FirewallRuleSet struct {
+	rules        []*compiledFirewallRule // 按优先级排序的规则
+	hasSchedules bool                    // 是否有设置了时间窗口的规则（评估结果与时间有关）
+}
+
+// 当前生效的规则集，规则变更时整体替换
+var (
+	firewallRuleSet      *FirewallRuleSet
+	firewallRuleSetMutex sync.RWMutex
+)
+
+// CompileFirewallRules 编译防火墙规则集
+// 无法编译的规则会被记录并跳过（视为不匹配任何目标）
+// 参数: rules - 按优先级排序的防火墙规则
+// 返回: *FirewallRuleSet - 编译后的规则集
+func CompileFirewallRules(rules []*models.FirewallRule) *FirewallRuleSet {
+	set := &FirewallRuleSet{rules: make([]*compiledFirewallRule, 0, len(rules))}
+	for _, rule := range rules {
+		compiled, err := compileFirewallRule(rule)
+		if err != nil {
+			log.Printf("Skipping invalid firewall rule %d: %v", rule.ID, err)
+			continue
+		}
+		set.rules = append(set.rules, compiled)
+		if compiled.schedule != nil {
+			set.hasSchedules = true
+		}
+	}
+	return set
+}
+
+// compileFirewallRule 编译单条防火墙规则
+func compileFirewallRule(rule *models.FirewallRule) (*compiledFirewallRule, error) {
+	ports, err := ParsePortRanges(rule.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := compileFirewallSchedule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := &compiledFirewallRule{rule: rule, ports: ports, schedule: schedule}
+	switch rule.Kind {
+	case models.FirewallKindCIDR:
+		_, network, err := net.ParseCIDR(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", rule.Pattern, err)
+		}
+		compiled.network = network
+	case models.FirewallKindHost:
+		compiled.host = strings.ToLower(rule.Pattern)
+		if _, err := path.Match(compiled.host, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %v", rule.Pattern, err)
+		}
+	default:
+		regex, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %v", rule.Pattern, err)
+		}
+		compiled.regex = regex
+	}
+	return compiled, nil
+}
+
+// ReloadFirewallRules 从数据库重新加载并编译防火墙规则
+// 规则发生变更后调用；加载失败时保留原有规则集
+// 返回: error - 加载过程中的错误
+func ReloadFirewallRules() error {
+	rules, err := GetFirewallRules()
+	if err != nil {
+		return err
+	}
+
+	set := CompileFirewallRules(rules)
+
+	firewallRuleSetMutex.Lock()
+	firewallRuleSet = set
+	firewallRuleSetMutex.Unlock()
+	return nil
+}
+
+// reloadFirewallRulesAfterChange 在规则变更后重建规则集，失败时只记录日志
+func reloadFirewallRulesAfterChange() {
+	if err := ReloadFirewallRules(); err != nil {
+		log.Printf("Failed to reload firewall rules: %v", err)
+	}
+}
+
+// currentFirewallRuleSet 获取当前生效的规则集，尚未加载时从数据库加载
+func currentFirewallRuleSet() (*FirewallRuleSet, error) {
+	firewallRuleSetMutex.RLock()
+	set := firewallRuleSet
+	firewallRuleSetMutex.RUnlock()
+	if set != nil {
+		return set, nil
+	}
+
+	if err := ReloadFirewallRules(); err != nil {
+		return nil, err
+	}
+	firewallRuleSetMutex.RLock()
+	defer firewallRuleSetMutex.RUnlock()
+	return firewallRuleSet, nil
+}
+
+// IsTargetAllowed 检查目标是否被当前生效的防火墙规则允许
+// 参数:
+//   user - 发起连接的用户，为nil时只评估全局规则
+//   target - 防火墙检查的目标
+// 返回: bool - 是否允许连接
+func IsTargetAllowed(user *models.User, target FirewallTarget) bool {
+	allowed, _ := CheckTarget(user, target)
+	return allowed
+}
+
+// CheckTarget 使用当前生效的防火墙规则评估目标，并返回命中的规则
+// 参数:
+//   user - 发起连接的用户，为nil时只评估全局规则
+//   target - 防火墙检查的目标
+// 返回:
+//   bool - 是否允许连接
+//   int - 命中的规则ID，未命中任何规则时为0
+func CheckTarget(user *models.User, target FirewallTarget) (bool, int) {
+	set, err := currentFirewallRuleSet()
+	if err != nil {
+		log.Printf("Failed to get firewall rules: %v", err)
+		// 出错时默认允许连接
+		return true, 0
+	}
+
+	mode, defaultPolicy := GetFirewallPolicy()
+	return EvaluateFirewallRules(set, mode, defaultPolicy, user, target)
+}
+
+// EvaluateFirewallRules 使用指定的规则集评估目标是否被允许
+// 该函数不访问数据库和全局状态，可以直接用于基准测试；
+// 时间窗口按target.Time判断，为零值时使用当前时间
+// 参数:
+//   set - 编译后的规则集
+//   mode - 评估模式："compat"或"ordered"
+//   defaultPolicy - 顺序模式下未命中任何规则时的动作
+//   user - 发起连接的用户，为nil时只评估全局规则
+//   target - 防火墙检查的目标
+// 返回:
+//   bool - 是否允许连接
+//   int - 命中的规则ID，未命中任何规则时为0
+func EvaluateFirewallRules(set *FirewallRuleSet, mode, defaultPolicy string, user *models.User, target FirewallTarget) (bool, int) {
+	if mode == models.FirewallModeOrdered {
+		return set.evaluateOrdered(user, target, defaultPolicy)
+	}
+	return set.evaluateCompat(user, target)
+}
+
+// 规则适用范围，数值越小越先评估
+const (
+	firewallScopeUser   = iota // 用户规则
+	firewallScopeGroup         // 用户组规则
+	firewallScopeGlobal        // 全局规则
+	firewallScopeCount
+)
+
+// firewallRuleScope 获取规则对指定用户的适用范围
+// 返回: int - 适用范围，规则不适用于该用户时返回-1
+func firewallRuleScope(rule *models.FirewallRule, user *models.User) int {
+	switch {
+	case rule.UserID != 0:
+		if user != nil && user.ID == rule.UserID {
+			return firewallScopeUser
+		}
+		return -1
+	case rule.Group != "":
+		if user != nil && user.Group == rule.Group {
+			return firewallScopeGroup
+		}
+		return -1
+	default:
+		return firewallScopeGlobal
+	}
+}
+
+// activeRules 获取在目标检查时间处于时间窗口内的规则，顺序模式中不在时间窗口内的规则视为不存在
+func (s *FirewallRuleSet) activeRules(target FirewallTarget) []*compiledFirewallRule {
+	if !s.hasSchedules {
+		return s.rules
+	}
+
+	now := s.evaluationTime(target)
+	rules := make([]*compiledFirewallRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		if rule.activeAt(now) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// evaluationTime 获取判断时间窗口使用的时间，target.Time为零值时使用当前时间
+// 规则集中没有时间窗口时不需要时间，直接返回零值
+func (s *FirewallRuleSet) evaluationTime(target FirewallTarget) time.Time {
+	if !target.Time.IsZero() || !s.hasSchedules {
+		return target.Time
+	}
+	return time.Now()
+}
+
+// activeAt 判断规则在指定时间是否处于时间窗口内
+func (r *compiledFirewallRule) activeAt(now time.Time) bool {
+	return r.schedule == nil || r.schedule.active(now)
+}
+
+// evaluateOrdered 按顺序模式评估防火墙规则
+// 适用于该用户的规则按优先级依次匹配，第一条命中的规则决定结果，都未命中时使用默认策略
+func (s *FirewallRuleSet) evaluateOrdered(user *models.User, target FirewallTarget, defaultPolicy string) (bool, int) {
+	for _, rule := range s.activeRules(target) {
+		if firewallRuleScope(rule.rule, user) < 0 {
+			continue
+		}
+		if rule.match(target) {
+			return rule.rule.Action == models.FirewallActionAllow, rule.rule.ID
+		}
+	}
+	return defaultPolicy == models.FirewallActionAllow, 0
+}
+
+// evaluateCompat 按兼容模式评估防火墙规则
+// 按用户规则、用户组规则、全局规则的顺序评估，范围越具体优先级越高：
+// 在同一范围内先检查白名单再检查黑名单，第一个命中规则的范围决定结果；
+// 所有范围都未命中时，如果适用的规则中存在白名单则拒绝，否则允许。
+// 不在时间窗口内的规则不参与匹配，但白名单即使不在时间窗口内也算作存在白名单，
+// 因此"只在工作时间可访问"或已过期的白名单在窗口外拒绝连接，而不是退回到允许所有流量
+func (s *FirewallRuleSet) evaluateCompat(user *models.User, target FirewallTarget) (bool, int) {
+	now := s.evaluationTime(target)
+
+	// 按适用范围对规则分组
+	var scoped [firewallScopeCount][]*compiledFirewallRule
+	for _, rule := range s.rules {
+		if scope := firewallRuleScope(rule.rule, user); scope >= 0 {
+			scoped[scope] = append(scoped[scope], rule)
+		}
+	}
+
+	whitelistExists := false
+	for _, scopeRules := range scoped {
+		// 检查白名单规则
+		for _, rule := range scopeRules {
+			if rule.rule.Type == "whitelist" {
+				whitelistExists = true
+				if rule.activeAt(now) && rule.match(target) {
+					return true, rule.rule.ID
+				}
+			}
+		}
+
+		// 检查黑名单规则
+		for _, rule := range scopeRules {
+			if rule.rule.Type == "blacklist" {
+				// 如果匹配黑名单规则，则拒绝
+				if rule.activeAt(now) && rule.match(target) {
+					return false, rule.rule.ID
+				}
+			}
+		}
+	}
+
+	// 如果存在白名单但地址不匹配任何白名单规则，则拒绝；没有任何规则时允许所有流量
+	return !whitelistExists, 0
+}
+
+// match 检查目标是否匹配防火墙规则
+func (r *compiledFirewallRule) match(target FirewallTarget) bool {
+	// 检查端口范围
+	if len(r.ports) > 0 {
+		port, err := strconv.ParseUint(target.Port, 10, 16)
+		if err != nil || !portInRanges(uint32(port), r.ports) {
+			return false
+		}
+	}
+
+	switch {
+	case r.network != nil:
+		return target.IP != nil && r.network.Contains(target.IP)
+	case r.regex != nil:
+		// 正则同时匹配请求的"主机:端口"和解析后的"IP:端口"
+		if r.regex.MatchString(net.JoinHostPort(target.Host, target.Port)) {
+			return true
+		}
+		return target.IP != nil && r.regex.MatchString(net.JoinHostPort(target.IP.String(), target.Port))
+	default:
+		match, _ := path.Match(r.host, normalizeHostname(target.Host))
+		return match
+	}
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// newTestRule 构造测试用的防火墙规则，Type与Action一一对应
+func newTestRule(id int, action, kind, pattern, ports string) *models.FirewallRule {
+	ruleType := "whitelist"
+	if action == models.FirewallActionDeny {
+		ruleType = "blacklist"
+	}
+	return &models.FirewallRule{ID: id, Type: ruleType, Action: action, Priority: id, Kind: kind, Pattern: pattern, Ports: ports, Active: true}
+}
+
+// newTestTarget 构造测试用的防火墙检查目标，host为IP地址时同时填写IP
+func newTestTarget(host, port string) FirewallTarget {
+	return FirewallTarget{Host: host, IP: net.ParseIP(host), Port: port}
+}
+
+func TestEvaluateFirewallRulesCompat(t *testing.T) {
+	user := &models.User{ID: 7, Group: "contractors"}
+
+	userRule := newTestRule(3, models.FirewallActionAllow, models.FirewallKindCIDR, "10.1.2.3/32", "")
+	userRule.UserID = user.ID
+	groupRule := newTestRule(4, models.FirewallActionDeny, models.FirewallKindHost, "*.internal", "")
+	groupRule.Group = user.Group
+
+	tests := []struct {
+		name    string
+		rules   []*models.FirewallRule
+		user    *models.User
+		target  FirewallTarget
+		allowed bool
+		ruleID  int
+	}{
+		{"no rules allows", nil, user, newTestTarget("1.2.3.4", "22"), true, 0},
+		{"blacklist cidr denies", []*models.FirewallRule{newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "10.0.0.0/8", "")},
+			user, newTestTarget("10.9.9.9", "80"), false, 1},
+		{"blacklist cidr misses", []*models.FirewallRule{newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "10.0.0.0/8", "")},
+			user, newTestTarget("192.168.1.1", "80"), true, 0},
+		{"whitelist match allows", []*models.FirewallRule{newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", "")},
+			user, newTestTarget("www.example.com", "443"), true, 1},
+		{"whitelist miss denies", []*models.FirewallRule{newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", "")},
+			user, newTestTarget("www.example.org", "443"), false, 0},
+		{"whitelist checked before blacklist", []*models.FirewallRule{
+			newTestRule(1, models.FirewallActionDeny, models.FirewallKindRegex, `^example\.com:`, ""),
+			newTestRule(2, models.FirewallActionAllow, models.FirewallKindRegex, `^example\.com:443$`, ""),
+		}, user, newTestTarget("example.com", "443"), true, 2},
+		{"port range limits match", []*models.FirewallRule{newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "0.0.0.0/0", "22,8000-8100")},
+			user, newTestTarget("1.2.3.4", "443"), true, 0},
+		{"port range matches", []*models.FirewallRule{newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "0.0.0.0/0", "22,8000-8100")},
+			user, newTestTarget("1.2.3.4", "8080"), false, 1},
+		{"user rule overrides global deny", []*models.FirewallRule{
+			newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "10.0.0.0/8", ""),
+			userRule,
+		}, user, newTestTarget("10.1.2.3", "22"), true, 3},
+		{"user rule ignored for other users", []*models.FirewallRule{
+			newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "10.0.0.0/8", ""),
+			userRule,
+		}, &models.User{ID: 8}, newTestTarget("10.1.2.3", "22"), false, 1},
+		{"group rule denies group member", []*models.FirewallRule{groupRule}, user, newTestTarget("db.internal", "5432"), false, 4},
+		{"group rule ignored without user", []*models.FirewallRule{groupRule}, nil, newTestTarget("db.internal", "5432"), true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := CompileFirewallRules(tt.rules)
+			allowed, ruleID := EvaluateFirewallRules(set, models.FirewallModeCompat, models.FirewallActionAllow, tt.user, tt.target)
+			if allowed != tt.allowed || ruleID != tt.ruleID {
+				t.Errorf("got (%v, %d), want (%v, %d)", allowed, ruleID, tt.allowed, tt.ruleID)
+			}
+		})
+	}
+}
+
+func TestEvaluateFirewallRulesCompatSchedules(t *testing.T) {
+	user := &models.User{ID: 7, Group: "contractors"}
+
+	// 2024-01-01是星期一
+	monday := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	sunday := time.Date(2024, 1, 7, 10, 0, 0, 0, time.UTC)
+	expired := monday.Add(-time.Hour)
+	future := monday.Add(time.Hour)
+
+	officeHours := func(rule *models.FirewallRule) *models.FirewallRule {
+		rule.Days = "mon-fri"
+		rule.TimeRange = "09:00-18:00"
+		rule.Timezone = "UTC"
+		return rule
+	}
+	expiresAt := func(rule *models.FirewallRule, at time.Time) *models.FirewallRule {
+		rule.ExpiresAt = &at
+		return rule
+	}
+	forUser := func(rule *models.FirewallRule) *models.FirewallRule {
+		rule.UserID = user.ID
+		return rule
+	}
+
+	tests := []struct {
+		name    string
+		rules   []*models.FirewallRule
+		user    *models.User
+		target  FirewallTarget
+		allowed bool
+		ruleID  int
+	}{
+		{"whitelist inside window allows", []*models.FirewallRule{officeHours(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""))},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, true, 1},
+		{"whitelist inside window denies other targets", []*models.FirewallRule{officeHours(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""))},
+			user, FirewallTarget{Host: "www.example.org", Port: "443", Time: monday}, false, 0},
+		{"whitelist outside window denies", []*models.FirewallRule{officeHours(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""))},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: sunday}, false, 0},
+		{"whitelist before expiry allows", []*models.FirewallRule{expiresAt(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""), future)},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, true, 1},
+		{"expired whitelist denies", []*models.FirewallRule{expiresAt(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""), expired)},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, false, 0},
+		{"expired user whitelist still restricts user", []*models.FirewallRule{forUser(expiresAt(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""), expired))},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, false, 0},
+		{"expired user whitelist ignored for other users", []*models.FirewallRule{forUser(expiresAt(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""), expired))},
+			&models.User{ID: 8}, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, true, 0},
+		{"expired whitelist falls through to active whitelist", []*models.FirewallRule{
+			expiresAt(newTestRule(1, models.FirewallActionAllow, models.FirewallKindHost, "*.example.com", ""), expired),
+			newTestRule(2, models.FirewallActionAllow, models.FirewallKindHost, "www.*", ""),
+		}, user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, true, 2},
+		{"blacklist inside window denies", []*models.FirewallRule{officeHours(newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*.example.com", ""))},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, false, 1},
+		{"blacklist outside window allows", []*models.FirewallRule{officeHours(newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*.example.com", ""))},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: sunday}, true, 0},
+		{"expired blacklist allows", []*models.FirewallRule{expiresAt(newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*.example.com", ""), expired)},
+			user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, true, 0},
+		{"expired user blacklist falls through to global blacklist", []*models.FirewallRule{
+			newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*.example.com", ""),
+			forUser(expiresAt(newTestRule(2, models.FirewallActionDeny, models.FirewallKindHost, "www.example.com", ""), expired)),
+		}, user, FirewallTarget{Host: "www.example.com", Port: "443", Time: monday}, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := CompileFirewallRules(tt.rules)
+			allowed, ruleID := EvaluateFirewallRules(set, models.FirewallModeCompat, models.FirewallActionAllow, tt.user, tt.target)
+			if allowed != tt.allowed || ruleID != tt.ruleID {
+				t.Errorf("got (%v, %d), want (%v, %d)", allowed, ruleID, tt.allowed, tt.ruleID)
+			}
+		})
+	}
+}
+
+func TestEvaluateFirewallRulesOrdered(t *testing.T) {
+	user := &models.User{ID: 7, Group: "contractors"}
+
+	exception := []*models.FirewallRule{
+		newTestRule(1, models.FirewallActionAllow, models.FirewallKindCIDR, "10.1.2.3/32", ""),
+		newTestRule(2, models.FirewallActionDeny, models.FirewallKindCIDR, "10.0.0.0/8", ""),
+	}
+	officeHours := newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*", "")
+	officeHours.Days = "mon-fri"
+	officeHours.TimeRange = "09:00-18:00"
+	officeHours.Timezone = "UTC"
+	// 2024-01-01是星期一
+	monday := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	sunday := time.Date(2024, 1, 7, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		rules         []*models.FirewallRule
+		defaultPolicy string
+		target        FirewallTarget
+		allowed       bool
+		ruleID        int
+	}{
+		{"default allow", nil, models.FirewallActionAllow, newTestTarget("1.2.3.4", "22"), true, 0},
+		{"default deny", nil, models.FirewallActionDeny, newTestTarget("1.2.3.4", "22"), false, 0},
+		{"first match wins for exception", exception, models.FirewallActionAllow, newTestTarget("10.1.2.3", "22"), true, 1},
+		{"later rule denies rest of range", exception, models.FirewallActionAllow, newTestTarget("10.1.2.4", "22"), false, 2},
+		{"no match uses default deny", exception, models.FirewallActionDeny, newTestTarget("192.168.0.1", "22"), false, 0},
+		{"regex matches resolved ip", []*models.FirewallRule{newTestRule(1, models.FirewallActionDeny, models.FirewallKindRegex, `^10\.`, "")},
+			models.FirewallActionAllow, FirewallTarget{Host: "intranet.example.com", IP: net.ParseIP("10.0.0.5"), Port: "80"}, false, 1},
+		{"host pattern is case insensitive", []*models.FirewallRule{newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*.example.com", "")},
+			models.FirewallActionAllow, newTestTarget("WWW.Example.COM.", "443"), false, 1},
+		{"schedule active", []*models.FirewallRule{officeHours}, models.FirewallActionAllow,
+			FirewallTarget{Host: "example.com", Port: "80", Time: monday}, false, 1},
+		{"schedule inactive", []*models.FirewallRule{officeHours}, models.FirewallActionAllow,
+			FirewallTarget{Host: "example.com", Port: "80", Time: sunday}, true, 0},
+		{"invalid rule skipped", []*models.FirewallRule{newTestRule(1, models.FirewallActionDeny, models.FirewallKindRegex, "(", "")},
+			models.FirewallActionAllow, newTestTarget("1.2.3.4", "22"), true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := CompileFirewallRules(tt.rules)
+			allowed, ruleID := EvaluateFirewallRules(set, models.FirewallModeOrdered, tt.defaultPolicy, user, tt.target)
+			if allowed != tt.allowed || ruleID != tt.ruleID {
+				t.Errorf("got (%v, %d), want (%v, %d)", allowed, ruleID, tt.allowed, tt.ruleID)
+			}
+		})
+	}
+}
+
+func BenchmarkEvaluateFirewallRules(b *testing.B) {
+	// 混合三种匹配方式的规则，目标不命中任何规则，因此每次评估都会检查所有规则
+	var rules []*models.FirewallRule
+	for i := 0; i < 300; i++ {
+		id := len(rules) + 1
+		switch i % 3 {
+		case 0:
+			rules = append(rules, newTestRule(id, models.FirewallActionDeny, models.FirewallKindCIDR, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256), ""))
+		case 1:
+			rules = append(rules, newTestRule(id, models.FirewallActionDeny, models.FirewallKindHost, fmt.Sprintf("*.host%d.example.com", i), "443"))
+		default:
+			rules = append(rules, newTestRule(id, models.FirewallActionDeny, models.FirewallKindRegex, fmt.Sprintf(`^svc%d\.example\.com:\d+$`, i), ""))
+		}
+	}
+	set := CompileFirewallRules(rules)
+	user := &models.User{ID: 1}
+	target := FirewallTarget{Host: "www.example.org", IP: net.ParseIP("192.168.1.1"), Port: "443"}
+
+	for _, mode := range []string{models.FirewallModeCompat, models.FirewallModeOrdered} {
+		b.Run(mode, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				EvaluateFirewallRules(set, mode, models.FirewallActionAllow, user, target)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"ssh-manage/models"
+)
+
+// firewallSchedule 编译后的防火墙规则时间窗口
+type firewallSchedule struct {
+	days      [7]bool        // 生效的星期，按time.Weekday索引
+	anyDay    bool           // 是否每天生效
+	startMin  int            // 每天生效的开始时间（分钟）
+	endMin    int            // 每天生效的结束时间（分钟），小于startMin时表示跨午夜
+	allDay    bool           // 是否全天生效
+	location  *time.Location // 星期和时间段使用的时区
+	startsAt  *time.Time     // 生效开始时间
+	expiresAt *time.Time     // 过期时间
+}
+
+// weekdayNames 星期的英文缩写
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// HasFirewallSchedule 判断规则是否设置了时间窗口
+// 参数: rule - 防火墙规则
+// 返回: bool - 是否设置了时间窗口
+func HasFirewallSchedule(rule *models.FirewallRule) bool {
+	return rule.Days != "" || rule.TimeRange != "" || rule.StartsAt != nil || rule.ExpiresAt != nil
+}
+
+// compileFirewallSchedule 编译规则的时间窗口，未设置时间窗口时返回nil
+func compileFirewallSchedule(rule *models.FirewallRule) (*firewallSchedule, error) {
+	if !HasFirewallSchedule(rule) {
+		if rule.Timezone != "" {
+			if _, err := time.LoadLocation(rule.Timezone); err != nil {
+				return nil, fmt.Errorf("invalid timezone %q: %v", rule.Timezone, err)
+			}
+		}
+		return nil, nil
+	}
+
+	schedule := &firewallSchedule{
+		location:  time.Local,
+		startsAt:  rule.StartsAt,
+		expiresAt: rule.ExpiresAt,
+	}
+	if rule.Timezone != "" {
+		location, err := time.LoadLocation(rule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", rule.Timezone, err)
+		}
+		schedule.location = location
+	}
+
+	if err := schedule.parseDays(rule.Days); err != nil {
+		return nil, err
+	}
+	if err := schedule.parseTimeRange(rule.TimeRange); err != nil {
+		return nil, err
+	}
+
+	if rule.StartsAt != nil && rule.ExpiresAt != nil && !rule.StartsAt.Before(*rule.ExpiresAt) {
+		return nil, fmt.Errorf("schedule start must be before its expiry")
+	}
+	return schedule, nil
+}
+
+// parseDays 解析生效的星期，如"mon-fri"、"sat,sun"或"fri-mon"
+func (s *firewallSchedule) parseDays(spec string) error {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" || spec == "*" {
+		s.anyDay = true
+		return nil
+	}
+
+	for _, part := range splitList(spec) {
+		startStr, endStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			endStr = startStr
+		}
+		start, ok := weekdayNames[strings.TrimSpace(startStr)]
+		if !ok {
+			return fmt.Errorf("invalid day %q, expected mon, tue, wed, thu, fri, sat or sun", startStr)
+		}
+		end, ok := weekdayNames[strings.TrimSpace(endStr)]
+		if !ok {
+			return fmt.Errorf("invalid day %q, expected mon, tue, wed, thu, fri, sat or sun", endStr)
+		}
+
+		// 支持跨周的范围，如fri-mon
+		for day := start; ; day = (day + 1) % 7 {
+			s.days[day] = true
+			if day == end {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeRange 解析每天生效的时间段，如"09:00-18:00"或"22:00-06:00"
+func (s *firewallSchedule) parseTimeRange(spec string) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		s.allDay = true
+		return nil
+	}
+
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", spec)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(startStr))
+	if err != nil {
+		return fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", spec)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(endStr))
+	if err != nil {
+		return fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", spec)
+	}
+
+	s.startMin = start.Hour()*60 + start.Minute()
+	s.endMin = end.Hour()*60 + end.Minute()
+	if s.startMin == s.endMin {
+		return fmt.Errorf("invalid time range %q: start and end must differ", spec)
+	}
+	return nil
+}
+
+// active 判断指定时间是否在时间窗口内
+func (s *firewallSchedule) active(now time.Time) bool {
+	if s.startsAt != nil && now.Before(*s.startsAt) {
+		return false
+	}
+	if s.expiresAt != nil && !now.Before(*s.expiresAt) {
+		return false
+	}
+
+	local := now.In(s.location)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+
+	if !s.allDay {
+		if s.startMin < s.endMin {
+			if minute < s.startMin || minute >= s.endMin {
+				return false
+			}
+		} else {
+			// 跨午夜的时间段：凌晨部分属于前一天开始的窗口
+			switch {
+			case minute >= s.startMin:
+			case minute < s.endMin:
+				day = (day + 6) % 7
+			default:
+				return false
+			}
+		}
+	}
+
+	return s.anyDay || s.days[day]
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// newScheduleRule 构造只设置了时间窗口字段的防火墙规则
+func newScheduleRule(days, timeRange, timezone string) *models.FirewallRule {
+	return &models.FirewallRule{Days: days, TimeRange: timeRange, Timezone: timezone}
+}
+
+func TestCompileFirewallSchedule(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		rule    *models.FirewallRule
+		wantNil bool
+		wantErr bool
+	}{
+		{"no schedule", newScheduleRule("", "", ""), true, false},
+		{"timezone only", newScheduleRule("", "", "UTC"), true, false},
+		{"timezone only invalid", newScheduleRule("", "", "Mars/Olympus"), true, true},
+		{"weekday range", newScheduleRule("mon-fri", "", ""), false, false},
+		{"weekday list", newScheduleRule("sat, sun", "", ""), false, false},
+		{"weekday range across week", newScheduleRule("fri-mon", "", ""), false, false},
+		{"any day", newScheduleRule("*", "09:00-18:00", ""), false, false},
+		{"day case insensitive", newScheduleRule("MON", "", ""), false, false},
+		{"invalid day", newScheduleRule("monday", "", ""), false, true},
+		{"invalid day range end", newScheduleRule("mon-xyz", "", ""), false, true},
+		{"overnight range", newScheduleRule("", "22:00-06:00", ""), false, false},
+		{"range without end", newScheduleRule("", "09:00", ""), false, true},
+		{"range without minutes", newScheduleRule("", "9-18", ""), false, true},
+		{"range out of bounds", newScheduleRule("", "09:00-24:30", ""), false, true},
+		{"empty range", newScheduleRule("", "09:00-09:00", ""), false, true},
+		{"invalid timezone", newScheduleRule("mon", "", "Mars/Olympus"), false, true},
+		{"start before expiry", &models.FirewallRule{StartsAt: &start, ExpiresAt: &end}, false, false},
+		{"start after expiry", &models.FirewallRule{StartsAt: &end, ExpiresAt: &start}, false, true},
+		{"start equals expiry", &models.FirewallRule{StartsAt: &start, ExpiresAt: &start}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := compileFirewallSchedule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (schedule == nil) != tt.wantNil {
+				t.Errorf("got schedule %+v, want nil %v", schedule, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestFirewallScheduleActive(t *testing.T) {
+	// 2024-01-01是星期一
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	start := at(2, 0, 0)
+	expiry := at(3, 0, 0)
+
+	tests := []struct {
+		name   string
+		rule   *models.FirewallRule
+		now    time.Time
+		active bool
+	}{
+		{"office hours inside", newScheduleRule("mon-fri", "09:00-18:00", "UTC"), at(1, 10, 0), true},
+		{"office hours at start", newScheduleRule("mon-fri", "09:00-18:00", "UTC"), at(1, 9, 0), true},
+		{"office hours before start", newScheduleRule("mon-fri", "09:00-18:00", "UTC"), at(1, 8, 59), false},
+		{"office hours end is exclusive", newScheduleRule("mon-fri", "09:00-18:00", "UTC"), at(1, 18, 0), false},
+		{"office hours on weekend", newScheduleRule("mon-fri", "09:00-18:00", "UTC"), at(6, 10, 0), false},
+		{"weekend list", newScheduleRule("sat,sun", "", "UTC"), at(7, 23, 59), true},
+		{"weekday range across week", newScheduleRule("fri-mon", "", "UTC"), at(7, 12, 0), true},
+		{"weekday range across week excludes midweek", newScheduleRule("fri-mon", "", "UTC"), at(3, 12, 0), false},
+
+		// 02:00 UTC是上海时间10:00，10:00 UTC是上海时间18:00
+		{"timezone inside", newScheduleRule("mon", "09:00-18:00", "Asia/Shanghai"), at(1, 2, 0), true},
+		{"timezone outside", newScheduleRule("mon", "09:00-18:00", "Asia/Shanghai"), at(1, 10, 0), false},
+		// 星期也按规则时区判断：星期日20:00 UTC是上海时间星期一04:00
+		{"timezone shifts weekday", newScheduleRule("mon", "", "Asia/Shanghai"), at(7, 20, 0), true},
+
+		// 跨午夜的时间段：凌晨部分属于前一天开始的窗口
+		{"overnight evening", newScheduleRule("fri", "22:00-06:00", "UTC"), at(5, 23, 0), true},
+		{"overnight early morning next day", newScheduleRule("fri", "22:00-06:00", "UTC"), at(6, 3, 0), true},
+		{"overnight early morning same day", newScheduleRule("fri", "22:00-06:00", "UTC"), at(5, 3, 0), false},
+		{"overnight end is exclusive", newScheduleRule("fri", "22:00-06:00", "UTC"), at(6, 6, 0), false},
+		{"overnight daytime", newScheduleRule("", "22:00-06:00", "UTC"), at(5, 12, 0), false},
+
+		{"before start", &models.FirewallRule{StartsAt: &start, ExpiresAt: &expiry}, at(1, 23, 59), false},
+		{"at start", &models.FirewallRule{StartsAt: &start, ExpiresAt: &expiry}, start, true},
+		{"at expiry", &models.FirewallRule{StartsAt: &start, ExpiresAt: &expiry}, expiry, false},
+		{"expiry only", &models.FirewallRule{ExpiresAt: &expiry}, at(1, 0, 0), true},
+		{"expiry and office hours", &models.FirewallRule{Days: "mon-fri", TimeRange: "09:00-18:00", Timezone: "UTC", ExpiresAt: &expiry}, at(3, 10, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := compileFirewallSchedule(tt.rule)
+			if err != nil || schedule == nil {
+				t.Fatalf("got schedule %v, error %v", schedule, err)
+			}
+			if active := schedule.active(tt.now); active != tt.active {
+				t.Errorf("active(%s) = %v, want %v", tt.now.Format(time.RFC3339), active, tt.active)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+	"ssh-manage/models"
+)
+
+// defaultSimulationLimit 模拟时默认最多检查的历史连接数
+const defaultSimulationLimit = 10000
+
+// 模拟时解析历史目标主机名的并发数和总时长上限，超时后未解析的主机只按主机名评估
+const (
+	simulationResolveConcurrency = 16
+	simulationResolveBudget      = 15 * time.Second
+)
+
+// FirewallSimulation 防火墙规则模拟（dry-run）请求
+type FirewallSimulation struct {
+	Rules         []*models.FirewallRule `json:"rules"`          // 完整的拟议规则集，为nil时以当前规则为基础
+	AddRules      []*models.FirewallRule `json:"add_rules"`      // 追加到规则集中的拟议规则
+	Mode          string                 `json:"mode"`           // 评估模式，为空时使用当前模式
+	DefaultPolicy string                 `json:"default_policy"` // 顺序模式默认策略，为空时使用当前默认策略
+	UserID        int                    `json:"user_id"`        // 只检查指定用户的连接，0表示所有用户
+	Since         time.Time              `json:"since"`          // 只检查该时间之后的连接，零值表示不限
+	Until         time.Time              `json:"until"`          // 只检查该时间之前的连接，零值表示不限
+	Limit         int                    `json:"limit"`          // 最多检查的连接数，0表示使用默认值
+}
+
+// FirewallSimulationEntry 模拟结果中决策发生变化的历史连接
+type FirewallSimulationEntry struct {
+	TargetConnectionID int       `json:"target_connection_id"` // 目标连接ID
+	UserID             int       `json:"user_id"`              // 用户ID
+	Username           string    `json:"username"`             // 用户名
+	Target             string    `json:"target"`               // 目标地址
+	ConnectedAt        time.Time `json:"connected_at"`         // 连接时间
+	CurrentRuleID      int       `json:"current_rule_id"`      // 当前规则下命中的规则ID，0表示未命中
+	ProposedRuleID     int       `json:"proposed_rule_id"`     // 拟议规则下命中的规则ID，0表示未命中，负数为新增的规则
+}
+
+// FirewallSimulationResult 防火墙规则模拟结果
+type FirewallSimulationResult struct {
+	Checked         int                        `json:"checked"`           // 检查的历史连接数
+	Unresolved      int                        `json:"unresolved"`        // 无法解析主机名、仅按主机名评估的连接数
+	ResolveTimedOut bool                       `json:"resolve_timed_out"` // 是否因超过解析总时长上限而有主机名未被解析
+	NewlyDenied     []*FirewallSimulationEntry `json:"newly_denied"`      // 当前允许、拟议规则下会被拒绝的连接
+	NewlyAllowed    []*FirewallSimulationEntry `json:"newly_allowed"`     // 当前拒绝、拟议规则下会被允许的连接
+}
+
+// simulationVerdict 一个用户和目标组合在当前和拟议规则下的评估结果
+type simulationVerdict struct {
+	currentAllowed  bool
+	currentRuleID   int
+	proposedAllowed bool
+	proposedRuleID  int
+}
+
+// SimulateFirewallRules 使用拟议的规则集评估历史目标连接，找出决策会发生变化的连接
+// 只检查direct-tcpip连接；主机名按当前DNS解析结果评估，与实际转发时一样任意IP被拒绝即视为拒绝；
+// 规则的时间窗口按连接发生的时间判断
+// 参数: sim - 模拟请求
+// 返回:
+//   *FirewallSimulationResult - 模拟结果
+//   error - 规则无效或查询过程中的错误
+func SimulateFirewallRules(sim *FirewallSimulation) (*FirewallSimulationResult, error) {
+	currentMode, currentDefault := GetFirewallPolicy()
+	proposedMode, proposedDefault := sim.Mode, sim.DefaultPolicy
+	if proposedMode == "" {
+		proposedMode = currentMode
+	}
+	if proposedDefault == "" {
+		proposedDefault = currentDefault
+	}
+	proposedMode, proposedDefault, err := normalizeFirewallPolicy(proposedMode, proposedDefault)
+	if err != nil {
+		return nil, err
+	}
+
+	currentSet, err := currentFirewallRuleSet()
+	if err != nil {
+		return nil, err
+	}
+	proposedRules, err := buildProposedRules(sim)
+	if err != nil {
+		return nil, err
+	}
+	proposedSet := CompileFirewallRules(proposedRules)
+
+	history, err := getSimulationHistory(sim)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FirewallSimulationResult{}
+	users := make(map[int]*models.User)
+	resolved, timedOut := resolveSimulationHosts(history)
+	result.ResolveTimedOut = timedOut
+	verdicts := make(map[string]*simulationVerdict)
+	timeDependent := currentSet.hasSchedules || proposedSet.hasSchedules
+
+	for _, entry := range history {
+		result.Checked++
+
+		host, port, err := net.SplitHostPort(entry.Target)
+		if err != nil {
+			host, port = entry.Target, ""
+		}
+
+		// 同一用户和目标只解析和评估一次；有时间窗口规则时结果与连接时间有关，需要逐条评估
+		key := fmt.Sprintf("%d|%s", entry.UserID, entry.Target)
+		verdict, ok := verdicts[key]
+		if !ok || timeDependent {
+			ips := resolved[host]
+
+			user := users[entry.UserID]
+			if user == nil {
+				user, err = GetUserByID(entry.UserID)
+				if err != nil {
+					// 用户已被删除时只评估全局规则和用户ID规则
+					user = &models.User{ID: entry.UserID, Username: entry.Username}
+				}
+				users[entry.UserID] = user
+			}
+
+			verdict = &simulationVerdict{}
+			target := FirewallTarget{Host: host, Port: port, Time: entry.ConnectedAt}
+			verdict.currentAllowed, verdict.currentRuleID = evaluateResolvedTarget(currentSet, currentMode, currentDefault, user, target, ips)
+			verdict.proposedAllowed, verdict.proposedRuleID = evaluateResolvedTarget(proposedSet, proposedMode, proposedDefault, user, target, ips)
+			verdicts[key] = verdict
+		}
+		if len(resolved[host]) == 0 {
+			result.Unresolved++
+		}
+
+		if verdict.currentAllowed == verdict.proposedAllowed {
+			continue
+		}
+		entry.CurrentRuleID = verdict.currentRuleID
+		entry.ProposedRuleID = verdict.proposedRuleID
+		if verdict.currentAllowed {
+			result.NewlyDenied = append(result.NewlyDenied, entry)
+		} else {
+			result.NewlyAllowed = append(result.NewlyAllowed, entry)
+		}
+	}
+
+	return result, nil
+}
+
+// resolveSimulationHosts 并发解析历史连接中的所有目标主机名
+// 并发数为simulationResolveConcurrency，总时长不超过simulationResolveBudget
+// 返回:
+//   map[string][]net.IP - 主机名到解析结果的映射，无法解析或未来得及解析的主机没有结果
+//   bool - 是否因超时有主机未被解析
+func resolveSimulationHosts(history []*FirewallSimulationEntry) (map[string][]net.IP, bool) {
+	hosts := make(chan string)
+	resolved := make(map[string][]net.IP)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	ctx, cancel := context.WithTimeout(context.Background(), simulationResolveBudget)
+	defer cancel()
+
+	for i := 0; i < simulationResolveConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range hosts {
+				ips, err := resolveTargetContext(ctx, host)
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				resolved[host] = ips
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool)
+	timedOut := false
+	for _, entry := range history {
+		host, _, err := net.SplitHostPort(entry.Target)
+		if err != nil {
+			host = entry.Target
+		}
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+
+		select {
+		case hosts <- host:
+		case <-ctx.Done():
+			timedOut = true
+		}
+		if timedOut {
+			break
+		}
+	}
+	close(hosts)
+	wg.Wait()
+
+	// 已分发的主机可能因总时长用尽而解析失败
+	if ctx.Err() != nil {
+		timedOut = true
+	}
+	return resolved, timedOut
+}
+
+// evaluateResolvedTarget 评估已解析的目标，任意一个IP被拒绝即视为拒绝；无法解析时只按主机名评估
+func evaluateResolvedTarget(set *FirewallRuleSet, mode, defaultPolicy string, user *models.User, target FirewallTarget, ips []net.IP) (bool, int) {
+	if len(ips) == 0 {
+		return EvaluateFirewallRules(set, mode, defaultPolicy, user, target)
+	}
+
+	ruleID := 0
+	for i, ip := range ips {
+		target.IP = ip
+		ipAllowed, ipRuleID := EvaluateFirewallRules(set, mode, defaultPolicy, user, target)
+		if !ipAllowed {
+			return false, ipRuleID
+		}
+		if i == 0 {
+			ruleID = ipRuleID
+		}
+	}
+	return true, ruleID
+}
+
+// buildProposedRules 生成拟议的规则集并按优先级排序
+// 新增的规则（ID为0）使用负数ID以便在结果中区分，未设置优先级时依次排在最后
+func buildProposedRules(sim *FirewallSimulation) ([]*models.FirewallRule, error) {
+	base := sim.Rules
+	if base == nil {
+		current, err := GetFirewallRules()
+		if err != nil {
+			return nil, err
+		}
+		base = current
+	}
+
+	rules := make([]*models.FirewallRule, 0, len(base)+len(sim.AddRules))
+	maxPriority := 0
+	for _, rule := range base {
+		if rule.Priority > maxPriority {
+			maxPriority = rule.Priority
+		}
+	}
+
+	nextID := -1
+	for _, source := range [][]*models.FirewallRule{base, sim.AddRules} {
+		for _, rule := range source {
+			// 复制规则，避免修改调用方的数据
+			proposed := *rule
+			if err := normalizeFirewallRule(&proposed); err != nil {
+				return nil, fmt.Errorf("invalid proposed rule %q: %v", proposed.Pattern, err)
+			}
+			if proposed.ID == 0 {
+				proposed.ID = nextID
+				nextID--
+			}
+			if proposed.Priority <= 0 {
+				maxPriority += 10
+				proposed.Priority = maxPriority
+			}
+			rules = append(rules, &proposed)
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+	return rules, nil
+}
+
+// getSimulationHistory 查询需要模拟的历史direct-tcpip目标连接
+func getSimulationHistory(sim *FirewallSimulation) ([]*FirewallSimulationEntry, error) {
+	limit := sim.Limit
+	if limit <= 0 {
+		limit = defaultSimulationLimit
+	}
+
+	// 连接时间以本地时间存储
+	var since, until string
+	if !sim.Since.IsZero() {
+		since = sim.Since.Local().Format("2006-01-02 15:04:05")
+	}
+	if !sim.Until.IsZero() {
+		until = sim.Until.Local().Format("2006-01-02 15:04:05")
+	}
+
+	db := GetDB()
+	rows, err := db.Query(`
+		SELECT tc.id, c.user_id, c.username, tc.target, tc.connected_at
+		FROM target_connections tc
+		JOIN connections c ON c.id = tc.connection_id
+		WHERE tc.channel_type = ?
+			AND (? = 0 OR c.user_id = ?)
+			AND (? = '' OR tc.connected_at >= ?)
+			AND (? = '' OR tc.connected_at < ?)
+		ORDER BY tc.connected_at DESC
+		LIMIT ?`,
+		models.ChannelTypeDirectTCPIP, sim.UserID, sim.UserID, since, since, until, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*FirewallSimulationEntry
+	for rows.Next() {
+		var entry FirewallSimulationEntry
+		var connectedAtStr string
+		err := rows.Scan(&entry.TargetConnectionID, &entry.UserID, &entry.Username, &entry.Target, &connectedAtStr)
+		if err != nil {
+			return nil, err
+		}
+
+		// 解析时间（按本地时间，用于判断规则的时间窗口）
+		entry.ConnectedAt, err = time.ParseInLocation("2006-01-02 15:04:05", connectedAtStr, time.Local)
+		if err != nil {
+			// 尝试其他时间格式
+			entry.ConnectedAt, err = time.Parse(time.RFC3339, connectedAtStr)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		history = append(history, &entry)
+	}
+
+	return history, rows.Err()
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// useTestResolver 替换解析器，测试结束后恢复
+func useTestResolver(t *testing.T, resolver Resolver) {
+	previous := getResolver()
+	SetResolver(resolver)
+	t.Cleanup(func() { SetResolver(previous) })
+}
+
+// simulationTargets 获取模拟结果条目的目标地址
+func simulationTargets(entries []*FirewallSimulationEntry) []string {
+	var targets []string
+	for _, entry := range entries {
+		targets = append(targets, entry.Target)
+	}
+	return targets
+}
+
+func TestSimulateFirewallRules(t *testing.T) {
+	openTestDB(t)
+	useTestResolver(t, &stubResolver{addrs: map[string][]string{
+		"db.example.com":  {"10.0.0.5"},
+		"www.example.com": {"93.184.216.34"},
+	}})
+	alice := addTestUser(t, "alice", "x")
+	bob := addTestUser(t, "bob", "x")
+
+	now := time.Now()
+	aliceConn := recordTestConnection(t, alice, now.Add(-time.Hour))
+	recordTestTargetConnection(t, aliceConn, "db.example.com:5432", now.Add(-50*time.Minute), 0, 0)
+	recordTestTargetConnection(t, aliceConn, "www.example.com:443", now.Add(-40*time.Minute), 0, 0)
+	recordTestTargetConnection(t, aliceConn, "10.9.9.9:22", now.Add(-30*time.Minute), 0, 0)
+	recordTestTargetConnection(t, aliceConn, "10.9.9.9:22", now.Add(-20*time.Minute), 0, 0)
+	recordTestTargetConnection(t, aliceConn, "unknown.example.com:80", now.Add(-10*time.Minute), 0, 0)
+	bobConn := recordTestConnection(t, bob, now.Add(-time.Hour))
+	recordTestTargetConnection(t, bobConn, "10.1.1.1:22", now.Add(-3*time.Hour), 0, 0)
+	// 远程转发的连接不参与模拟
+	if _, err := RecordTargetConnection(&models.TargetConnection{ConnectionID: bobConn, Target: "10.2.2.2:80",
+		ChannelType: models.ChannelTypeForwardedTCPIP, ConnectedAt: now}); err != nil {
+		t.Fatalf("record forwarded connection: %v", err)
+	}
+
+	// 当前没有规则，新增拒绝内网的规则；主机名按解析出的IP评估
+	denyInternal := &models.FirewallRule{Action: models.FirewallActionDeny, Kind: models.FirewallKindCIDR, Pattern: "10.0.0.0/8"}
+	result, err := SimulateFirewallRules(&FirewallSimulation{AddRules: []*models.FirewallRule{denyInternal}})
+	if err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+	if result.Checked != 6 || result.Unresolved != 1 || result.ResolveTimedOut {
+		t.Errorf("got checked %d, unresolved %d, timed out %v, want 6, 1, false", result.Checked, result.Unresolved, result.ResolveTimedOut)
+	}
+	if got := simulationTargets(result.NewlyDenied); len(got) != 4 || len(result.NewlyAllowed) != 0 {
+		t.Fatalf("got newly denied %v, newly allowed %d", got, len(result.NewlyAllowed))
+	}
+	for _, entry := range result.NewlyDenied {
+		if entry.ProposedRuleID != -1 || entry.CurrentRuleID != 0 {
+			t.Errorf("%s: got rules %d -> %d, want 0 -> -1", entry.Target, entry.CurrentRuleID, entry.ProposedRuleID)
+		}
+	}
+	if denyInternal.ID != 0 || denyInternal.Type != "" {
+		t.Errorf("proposed rule modified: %+v", denyInternal)
+	}
+
+	// 按用户和时间筛选历史连接
+	result, err = SimulateFirewallRules(&FirewallSimulation{AddRules: []*models.FirewallRule{denyInternal}, UserID: bob.ID})
+	if err != nil || result.Checked != 1 || len(result.NewlyDenied) != 1 {
+		t.Errorf("bob only: got %+v, %v", result, err)
+	}
+	// 按时间倒序取最近的连接
+	result, err = SimulateFirewallRules(&FirewallSimulation{AddRules: []*models.FirewallRule{denyInternal}, Since: now.Add(-2 * time.Hour), Until: now.Add(-25 * time.Minute), Limit: 1})
+	if err != nil || result.Checked != 1 || len(result.NewlyDenied) != 1 || result.NewlyDenied[0].Target != "10.9.9.9:22" {
+		t.Errorf("time range and limit: got %+v, %v", result, err)
+	}
+
+	// 保存规则后，以空规则集模拟删除所有规则
+	if err := AddFirewallRule(&models.FirewallRule{Action: models.FirewallActionDeny, Kind: models.FirewallKindCIDR, Pattern: "10.0.0.0/8"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	result, err = SimulateFirewallRules(&FirewallSimulation{Rules: []*models.FirewallRule{}})
+	if err != nil {
+		t.Fatalf("simulate without rules: %v", err)
+	}
+	if len(result.NewlyAllowed) != 4 || len(result.NewlyDenied) != 0 || result.NewlyAllowed[0].CurrentRuleID != 1 {
+		t.Errorf("got newly allowed %v, newly denied %v", simulationTargets(result.NewlyAllowed), simulationTargets(result.NewlyDenied))
+	}
+
+	// 顺序模式默认拒绝，只允许数据库主机
+	result, err = SimulateFirewallRules(&FirewallSimulation{
+		Rules:         []*models.FirewallRule{{Action: models.FirewallActionAllow, Kind: models.FirewallKindHost, Pattern: "db.*"}},
+		Mode:          models.FirewallModeOrdered,
+		DefaultPolicy: models.FirewallActionDeny,
+	})
+	if err != nil {
+		t.Fatalf("simulate ordered mode: %v", err)
+	}
+	if got := simulationTargets(result.NewlyDenied); len(got) != 2 || got[0] != "unknown.example.com:80" || got[1] != "www.example.com:443" {
+		t.Errorf("ordered mode: got newly denied %v", got)
+	}
+	if got := simulationTargets(result.NewlyAllowed); len(got) != 1 || got[0] != "db.example.com:5432" {
+		t.Errorf("ordered mode: got newly allowed %v", got)
+	}
+
+	// 无效的拟议规则和模式
+	if _, err := SimulateFirewallRules(&FirewallSimulation{AddRules: []*models.FirewallRule{{Action: models.FirewallActionDeny, Kind: models.FirewallKindRegex, Pattern: "("}}}); err == nil {
+		t.Errorf("invalid proposed rule accepted")
+	}
+	if _, err := SimulateFirewallRules(&FirewallSimulation{Mode: "strict"}); err == nil {
+		t.Errorf("invalid mode accepted")
+	}
+}
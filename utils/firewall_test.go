@@ -0,0 +1,360 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+func TestParseIPAddress(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"127.0.0.1", "127.0.0.1"},
+		{"::1", "::1"},
+		{"[::1]", "::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"[fe80::1%25eth0]", "fe80::1"},
+		// inet_aton风格的IPv4写法
+		{"127.1", "127.0.0.1"},
+		{"10.1.2", "10.1.0.2"},
+		{"127.65536", "127.1.0.0"},
+		{"0x7f.0.0.1", "127.0.0.1"},
+		{"0X7F.1", "127.0.0.1"},
+		{"0177.0.0.1", "127.0.0.1"},
+		{"017700000001", "127.0.0.1"},
+		{"2130706433", "127.0.0.1"},
+		{"0x7f000001", "127.0.0.1"},
+		{"0", "0.0.0.0"},
+		// 不是IP地址
+		{"", ""},
+		{"example.com", ""},
+		{"127.0.0.1.1", ""},
+		{"256.0.0.1", ""},
+		{"127.16777216", ""},
+		{"4294967296", ""},
+		{"127..1", ""},
+		{"08.0.0.1", ""},
+		{"0x", ""},
+		{"+1.0.0.1", ""},
+		{"1.0.0.-1", ""},
+	}
+
+	for _, tt := range tests {
+		got := ParseIPAddress(tt.host)
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("ParseIPAddress(%q) = %v, want nil", tt.host, got)
+			}
+			continue
+		}
+		if !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("ParseIPAddress(%q) = %v, want %s", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFirewallRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		pattern string
+		ports   string
+		wantErr bool
+	}{
+		{"ipv4 cidr", models.FirewallKindCIDR, "10.0.0.0/8", "", false},
+		{"ipv6 cidr", models.FirewallKindCIDR, "fd00::/8", "", false},
+		{"cidr without prefix", models.FirewallKindCIDR, "10.0.0.1", "", true},
+		{"cidr prefix too long", models.FirewallKindCIDR, "10.0.0.0/33", "", true},
+		{"host glob", models.FirewallKindHost, "*.example.com", "", false},
+		{"host glob with class", models.FirewallKindHost, "db[0-9].internal", "", false},
+		{"malformed host glob", models.FirewallKindHost, "db[.internal", "", true},
+		{"regex", models.FirewallKindRegex, `^example\.com:443$`, "", false},
+		{"invalid regex", models.FirewallKindRegex, "(", "", true},
+		{"port ranges", models.FirewallKindCIDR, "0.0.0.0/0", "22, 8000-8100", false},
+		{"reversed port range", models.FirewallKindCIDR, "0.0.0.0/0", "8100-8000", true},
+		{"port out of range", models.FirewallKindCIDR, "0.0.0.0/0", "70000", true},
+		{"unknown kind", "prefix", "10.", "", true},
+	}
+
+	for _, tt := range tests {
+		rule := newTestRule(1, models.FirewallActionDeny, tt.kind, tt.pattern, tt.ports)
+		if err := ValidateFirewallRule(rule); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// 类型与动作必须一致，规则不能同时针对用户和用户组
+	rule := newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*", "")
+	rule.Type = "whitelist"
+	if err := ValidateFirewallRule(rule); err == nil {
+		t.Errorf("whitelist with deny action accepted")
+	}
+	rule = newTestRule(1, models.FirewallActionDeny, models.FirewallKindHost, "*", "")
+	rule.UserID, rule.Group = 1, "ops"
+	if err := ValidateFirewallRule(rule); err == nil {
+		t.Errorf("rule scoped to both a user and a group accepted")
+	}
+}
+
+func TestFirewallRuleMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		ports   string
+		pattern string
+		target  FirewallTarget
+		want    bool
+	}{
+		{"cidr contains ip", models.FirewallKindCIDR, "", "10.0.0.0/8", newTestTarget("10.1.2.3", "22"), true},
+		{"cidr excludes ip", models.FirewallKindCIDR, "", "10.0.0.0/8", newTestTarget("11.0.0.1", "22"), false},
+		{"cidr needs an ip", models.FirewallKindCIDR, "", "10.0.0.0/8", FirewallTarget{Host: "intranet", Port: "22"}, false},
+		{"cidr matches resolved ip", models.FirewallKindCIDR, "", "10.0.0.0/8", FirewallTarget{Host: "intranet", IP: net.ParseIP("10.0.0.5"), Port: "22"}, true},
+		{"ipv6 cidr", models.FirewallKindCIDR, "", "fd00::/8", newTestTarget("fd12::1", "22"), true},
+		{"ipv4 cidr with mapped ipv6", models.FirewallKindCIDR, "", "127.0.0.0/8", newTestTarget("::ffff:127.0.0.1", "22"), true},
+		{"glob subdomain", models.FirewallKindHost, "", "*.example.com", newTestTarget("api.example.com", "443"), true},
+		{"glob excludes apex", models.FirewallKindHost, "", "*.example.com", newTestTarget("example.com", "443"), false},
+		{"glob normalizes case and trailing dot", models.FirewallKindHost, "", "*.Example.com", newTestTarget("API.EXAMPLE.COM.", "443"), true},
+		{"glob character class", models.FirewallKindHost, "", "db[0-9].internal", newTestTarget("db7.internal", "5432"), true},
+		{"regex matches host and port", models.FirewallKindRegex, "", `^example\.com:22$`, newTestTarget("example.com", "22"), true},
+		{"regex port mismatch", models.FirewallKindRegex, "", `^example\.com:22$`, newTestTarget("example.com", "2222"), false},
+		{"regex matches ipv6 in brackets", models.FirewallKindRegex, "", `^\[::1\]:`, newTestTarget("::1", "22"), true},
+		{"port in single port", models.FirewallKindHost, "22", "*", newTestTarget("example.com", "22"), true},
+		{"port range start", models.FirewallKindHost, "8000-8100", "*", newTestTarget("example.com", "8000"), true},
+		{"port range end", models.FirewallKindHost, "8000-8100", "*", newTestTarget("example.com", "8100"), true},
+		{"port outside range", models.FirewallKindHost, "8000-8100", "*", newTestTarget("example.com", "8101"), false},
+		{"invalid target port", models.FirewallKindHost, "22", "*", newTestTarget("example.com", "ssh"), false},
+	}
+
+	for _, tt := range tests {
+		compiled, err := compileFirewallRule(newTestRule(1, models.FirewallActionDeny, tt.kind, tt.pattern, tt.ports))
+		if err != nil {
+			t.Fatalf("%s: compile rule: %v", tt.name, err)
+		}
+		if got := compiled.match(tt.target); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsAddressAllowedAlternativeSpellings(t *testing.T) {
+	useTestFirewall(t, &stubResolver{}, []*models.FirewallRule{
+		newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "127.0.0.0/8", ""),
+	})
+
+	// 不同写法的回环地址都被网段规则拒绝
+	for _, address := range []string{"127.0.0.1:22", "127.1:22", "0x7f.0.0.1:22", "0177.0.0.1:22", "2130706433:22", "[::ffff:127.0.0.1]:22"} {
+		if IsAddressAllowed(address) {
+			t.Errorf("%s allowed", address)
+		}
+	}
+	if !IsAddressAllowed("192.168.1.1:22") {
+		t.Errorf("192.168.1.1:22 denied")
+	}
+}
+
+func TestFirewallRuleScope(t *testing.T) {
+	user := &models.User{ID: 7, Group: "ops"}
+	userRule := &models.FirewallRule{UserID: 7}
+	groupRule := &models.FirewallRule{Group: "ops"}
+
+	tests := []struct {
+		name string
+		rule *models.FirewallRule
+		user *models.User
+		want int
+	}{
+		{"global rule", &models.FirewallRule{}, user, firewallScopeGlobal},
+		{"global rule without user", &models.FirewallRule{}, nil, firewallScopeGlobal},
+		{"own user rule", userRule, user, firewallScopeUser},
+		{"other user's rule", userRule, &models.User{ID: 8, Group: "ops"}, -1},
+		{"user rule without user", userRule, nil, -1},
+		{"own group rule", groupRule, user, firewallScopeGroup},
+		{"other group's rule", groupRule, &models.User{ID: 7, Group: "dev"}, -1},
+		{"group rule for user without group", groupRule, &models.User{ID: 7}, -1},
+	}
+
+	for _, tt := range tests {
+		if got := firewallRuleScope(tt.rule, tt.user); got != tt.want {
+			t.Errorf("%s: got scope %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScopedFirewallRulesStored(t *testing.T) {
+	openTestDB(t)
+	alice := addTestUser(t, "alice", "x")
+	bob := addTestUser(t, "bob", "x")
+	bob.Group = "ops"
+
+	// 全局拒绝内网，alice单独允许一台主机，ops用户组禁止访问数据库端口
+	for _, rule := range []*models.FirewallRule{
+		{Action: models.FirewallActionDeny, Kind: models.FirewallKindCIDR, Pattern: "10.0.0.0/8"},
+		{Action: models.FirewallActionAllow, Kind: models.FirewallKindCIDR, Pattern: "10.1.2.3/32", UserID: alice.ID},
+		{Action: models.FirewallActionDeny, Kind: models.FirewallKindHost, Pattern: "*", Ports: "5432", Group: "ops"},
+	} {
+		if err := AddFirewallRule(rule); err != nil {
+			t.Fatalf("add rule: %v", err)
+		}
+	}
+
+	rules, err := GetFirewallRules()
+	if err != nil || len(rules) != 3 {
+		t.Fatalf("got %d rules, %v", len(rules), err)
+	}
+	if rules[1].UserID != alice.ID || rules[2].Group != "ops" {
+		t.Fatalf("rule scopes not stored: %+v, %+v", rules[1], rules[2])
+	}
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		target  FirewallTarget
+		allowed bool
+	}{
+		{"alice's exception", alice, newTestTarget("10.1.2.3", "22"), true},
+		{"global rule for alice", alice, newTestTarget("10.1.2.4", "22"), false},
+		{"alice's exception not shared", bob, newTestTarget("10.1.2.3", "22"), false},
+		{"group rule for member", bob, newTestTarget("192.168.1.1", "5432"), false},
+		{"group rule not applied to others", &models.User{ID: 99, Group: "dev"}, newTestTarget("192.168.1.1", "5432"), true},
+		// 兼容模式中alice的白名单只限制alice自己
+		{"alice's whitelist restricts alice", alice, newTestTarget("192.168.1.1", "22"), false},
+		{"alice's whitelist ignored for others", bob, newTestTarget("192.168.1.1", "22"), true},
+		{"no user uses global rules only", nil, newTestTarget("10.1.2.3", "22"), false},
+	}
+	for _, tt := range tests {
+		if allowed := IsTargetAllowed(tt.user, tt.target); allowed != tt.allowed {
+			t.Errorf("%s: got %v, want %v", tt.name, allowed, tt.allowed)
+		}
+	}
+
+	// 删除用户时同时删除只针对该用户的规则
+	if err := DeleteUser(alice.ID, false); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	if rules, _ := GetFirewallRules(); len(rules) != 2 {
+		t.Errorf("got %d rules after deleting the user, want 2", len(rules))
+	}
+	if IsTargetAllowed(alice, newTestTarget("10.1.2.3", "22")) {
+		t.Errorf("deleted user's rule still in the cached rule set")
+	}
+}
+
+// firewallRuleOrder 获取当前规则的ID和优先级，按评估顺序排列
+func firewallRuleOrder(t *testing.T) ([]int, []int) {
+	t.Helper()
+	rules, err := GetFirewallRules()
+	if err != nil {
+		t.Fatalf("get rules: %v", err)
+	}
+	var ids, priorities []int
+	for _, rule := range rules {
+		ids = append(ids, rule.ID)
+		priorities = append(priorities, rule.Priority)
+	}
+	return ids, priorities
+}
+
+func TestOrderedFirewallRulePriorities(t *testing.T) {
+	openTestDB(t)
+	previousMode, previousPolicy := GetFirewallPolicy()
+	if err := SetFirewallPolicy(models.FirewallModeOrdered, models.FirewallActionDeny); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	t.Cleanup(func() { SetFirewallPolicy(previousMode, previousPolicy) })
+
+	// 未指定优先级时依次排在最后；只指定类型时补全动作
+	for _, rule := range []*models.FirewallRule{
+		{Type: "whitelist", Kind: models.FirewallKindCIDR, Pattern: "10.1.2.3/32"},
+		{Action: models.FirewallActionDeny, Kind: models.FirewallKindCIDR, Pattern: "10.0.0.0/8"},
+		{Action: models.FirewallActionAllow, Kind: models.FirewallKindCIDR, Pattern: "10.0.0.0/16"},
+	} {
+		if err := AddFirewallRule(rule); err != nil {
+			t.Fatalf("add rule: %v", err)
+		}
+	}
+	ids, priorities := firewallRuleOrder(t)
+	if !reflect.DeepEqual(ids, []int{1, 2, 3}) || !reflect.DeepEqual(priorities, []int{10, 20, 30}) {
+		t.Fatalf("got ids %v, priorities %v", ids, priorities)
+	}
+	if rule, _ := GetFirewallRule(1); rule.Action != models.FirewallActionAllow {
+		t.Errorf("got action %q for a whitelist rule", rule.Action)
+	}
+
+	// 第一条命中的规则决定结果，都未命中时使用默认策略
+	check := func(host string, wantAllowed bool, wantRule int) {
+		t.Helper()
+		if allowed, ruleID := CheckTarget(nil, newTestTarget(host, "22")); allowed != wantAllowed || ruleID != wantRule {
+			t.Errorf("%s: got (%v, %d), want (%v, %d)", host, allowed, ruleID, wantAllowed, wantRule)
+		}
+	}
+	check("10.1.2.3", true, 1)
+	check("10.0.5.5", false, 2)
+	check("192.168.1.1", false, 0)
+
+	// 上移后允许规则先于拒绝规则命中，优先级重新编号
+	if err := MoveFirewallRule(3, true); err != nil {
+		t.Fatalf("move rule: %v", err)
+	}
+	ids, priorities = firewallRuleOrder(t)
+	if !reflect.DeepEqual(ids, []int{1, 3, 2}) || !reflect.DeepEqual(priorities, []int{10, 20, 30}) {
+		t.Fatalf("after move got ids %v, priorities %v", ids, priorities)
+	}
+	check("10.0.5.5", true, 3)
+
+	// 已在最前的规则上移不改变顺序，不存在的规则返回错误
+	if err := MoveFirewallRule(1, true); err != nil {
+		t.Errorf("move first rule up: %v", err)
+	}
+	if err := MoveFirewallRule(99, false); err == nil {
+		t.Errorf("moved a missing rule")
+	}
+
+	// 直接设置优先级
+	if err := SetFirewallRulePriority(2, 5); err != nil {
+		t.Fatalf("set priority: %v", err)
+	}
+	check("10.1.2.3", false, 2)
+	if err := SetFirewallRulePriority(2, 0); err == nil {
+		t.Errorf("priority 0 accepted")
+	}
+	if err := SetFirewallRulePriority(99, 10); err == nil {
+		t.Errorf("set the priority of a missing rule")
+	}
+
+	// 更新规则时优先级为0保持原优先级
+	rule, err := GetFirewallRule(2)
+	if err != nil {
+		t.Fatalf("get rule: %v", err)
+	}
+	rule.Priority = 0
+	rule.Pattern = "10.0.0.0/12"
+	if err := UpdateFirewallRule(rule); err != nil {
+		t.Fatalf("update rule: %v", err)
+	}
+	if rule, _ := GetFirewallRule(2); rule.Priority != 5 || rule.Pattern != "10.0.0.0/12" {
+		t.Errorf("got priority %d, pattern %q after update", rule.Priority, rule.Pattern)
+	}
+}
+
+func TestParseNullableTime(t *testing.T) {
+	// 模拟非UTC时区，数据库中的时间为本地时间
+	previous := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*60*60)
+	t.Cleanup(func() { time.Local = previous })
+	want := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+
+	// SQLite驱动把DATETIME列的值按UTC格式化返回
+	for _, value := range []string{"2026-03-10 10:00:00", "2026-03-10T10:00:00Z", "2026-03-10T10:00:00+08:00"} {
+		got, err := parseNullableTime(&value)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%q: got %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if got, err := parseNullableTime(nil); got != nil || err != nil {
+		t.Errorf("nil: got %v, %v", got, err)
+	}
+}
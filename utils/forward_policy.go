@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"ssh-manage/models"
+)
+
+// PortRange 端口范围（包含起止端口）
+type PortRange struct {
+	Start uint32
+	End   uint32
+}
+
+// ParsePortRanges 解析端口范围配置
+// 参数: spec - 逗号分隔的端口或端口范围，如"8000-8100,9000"
+// 返回:
+//   []PortRange - 端口范围列表
+//   error - 格式错误
+func ParsePortRanges(spec string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		startStr, endStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			endStr = startStr
+		}
+
+		start, err := parsePort(startStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port range %q: %v", part, err)
+		}
+		end, err := parsePort(endStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port range %q: %v", part, err)
+		}
+		if start > end {
+			return nil, fmt.Errorf("invalid port range %q: start is greater than end", part)
+		}
+
+		ranges = append(ranges, PortRange{Start: start, End: end})
+	}
+	return ranges, nil
+}
+
+// parsePort 解析单个端口号（1-65535）
+func parsePort(s string) (uint32, error) {
+	port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("port must be between 1 and 65535")
+	}
+	return uint32(port), nil
+}
+
+// ValidateRemoteForwardPolicy 校验用户的远程端口转发策略配置
+// 参数: user - 用户信息
+// 返回: error - 配置错误
+func ValidateRemoteForwardPolicy(user *models.User) error {
+	ranges, err := ParsePortRanges(user.RemoteForwardPorts)
+	if err != nil {
+		return err
+	}
+	// 自动分配只在允许的端口范围内选择空闲端口，没有端口范围时无法分配
+	if user.RemoteForwardAutoPort && len(ranges) == 0 {
+		return fmt.Errorf("automatic port allocation requires allowed remote forward ports")
+	}
+	if user.MaxRemoteForwards < 0 {
+		return fmt.Errorf("max remote forwards must not be negative")
+	}
+	return nil
+}
+
+// IsRemoteForwardAddrAllowed 检查用户是否允许在指定地址上绑定远程转发监听器
+// 参数:
+//   user - 用户信息
+//   bindAddr - 客户端请求的绑定地址
+// 返回: bool - 是否允许
+func IsRemoteForwardAddrAllowed(user *models.User, bindAddr string) bool {
+	allowed := splitList(user.RemoteForwardAddrs)
+
+	// 未配置时仅允许回环地址
+	if len(allowed) == 0 {
+		return isLoopbackBindAddr(bindAddr)
+	}
+
+	bind := normalizeBindAddr(bindAddr)
+	for _, addr := range allowed {
+		if addr == "*" || normalizeBindAddr(addr) == bind {
+			return true
+		}
+		if strings.EqualFold(addr, "localhost") && isLoopbackBindAddr(bindAddr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRemoteForwardPortAllowed 检查端口是否在用户允许的远程转发端口范围内
+// 参数:
+//   user - 用户信息
+//   port - 端口号
+// 返回: bool - 是否允许
+func IsRemoteForwardPortAllowed(user *models.User, port uint32) bool {
+	ranges, err := ParsePortRanges(user.RemoteForwardPorts)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if port >= r.Start && port <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeBindAddr 统一绑定地址的写法（空地址等同于0.0.0.0）
+func normalizeBindAddr(addr string) string {
+	addr = strings.ToLower(strings.TrimSpace(addr))
+	if addr == "" {
+		return "0.0.0.0"
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// isLoopbackBindAddr 判断绑定地址是否为回环地址
+func isLoopbackBindAddr(addr string) bool {
+	if strings.EqualFold(addr, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// splitList 拆分逗号分隔的列表并去除空白项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
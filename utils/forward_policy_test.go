@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"ssh-manage/models"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []PortRange
+		wantErr bool
+	}{
+		{"", nil, false},
+		{" , ", nil, false},
+		{"22", []PortRange{{22, 22}}, false},
+		{"8000-8100, 9000", []PortRange{{8000, 8100}, {9000, 9000}}, false},
+		{" 1 - 65535 ", []PortRange{{1, 65535}}, false},
+		{"0", nil, true},
+		{"65536", nil, true},
+		{"8100-8000", nil, true},
+		{"80-", nil, true},
+		{"http", nil, true},
+		{"-1", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePortRanges(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePortRanges(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePortRanges(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRemoteForwardPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    models.User
+		wantErr bool
+	}{
+		{"empty policy", models.User{}, false},
+		{"ports and auto port", models.User{RemoteForwardPorts: "8000-8100", RemoteForwardAutoPort: true}, false},
+		{"auto port without ports", models.User{RemoteForwardAutoPort: true}, true},
+		{"invalid ports", models.User{RemoteForwardPorts: "80-70"}, true},
+		{"negative max forwards", models.User{RemoteForwardPorts: "8000", MaxRemoteForwards: -1}, true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateRemoteForwardPolicy(&tt.user); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIsRemoteForwardAddrAllowed(t *testing.T) {
+	tests := []struct {
+		allowed string
+		bind    string
+		want    bool
+	}{
+		// 未配置时仅允许回环地址
+		{"", "localhost", true},
+		{"", "127.0.0.1", true},
+		{"", "::1", true},
+		{"", "", false},
+		{"", "0.0.0.0", false},
+		{"", "192.168.1.10", false},
+
+		{"*", "0.0.0.0", true},
+		{"*", "", true},
+		{"0.0.0.0", "", true},
+		{"192.168.1.10", "192.168.1.10", true},
+		{"192.168.1.10", "192.168.1.11", false},
+		{"localhost", "127.0.0.1", true},
+		{"localhost", "0.0.0.0", false},
+		{"::1", "0:0:0:0:0:0:0:1", true},
+		{"10.0.0.1, 10.0.0.2", "10.0.0.2", true},
+	}
+
+	for _, tt := range tests {
+		user := &models.User{RemoteForwardAddrs: tt.allowed}
+		if got := IsRemoteForwardAddrAllowed(user, tt.bind); got != tt.want {
+			t.Errorf("allowed %q, bind %q: got %v, want %v", tt.allowed, tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestIsRemoteForwardPortAllowed(t *testing.T) {
+	tests := []struct {
+		ports string
+		port  uint32
+		want  bool
+	}{
+		{"", 8000, false},
+		{"8000-8100,9000", 8000, true},
+		{"8000-8100,9000", 8100, true},
+		{"8000-8100,9000", 8101, false},
+		{"8000-8100,9000", 9000, true},
+		{"bad", 8000, false},
+	}
+
+	for _, tt := range tests {
+		user := &models.User{RemoteForwardPorts: tt.ports}
+		if got := IsRemoteForwardPortAllowed(user, tt.port); got != tt.want {
+			t.Errorf("ports %q, port %d: got %v, want %v", tt.ports, tt.port, got, tt.want)
+		}
+	}
+}
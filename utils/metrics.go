@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指标类型（Prometheus文本格式中的TYPE）
+const (
+	MetricTypeCounter   = "counter"
+	MetricTypeGauge     = "gauge"
+	MetricTypeHistogram = "histogram"
+)
+
+// defaultLatencyBuckets 延迟直方图默认的桶上界（秒）
+var defaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// 由utils包直接记录的指标
+var (
+	// 按命中规则统计的防火墙拒绝次数，rule_id为0表示由默认策略拒绝
+	firewallDenialsTotal = NewCounterVec("ssh_manage_firewall_denials_total",
+		"Number of connections denied by the firewall, by rule ID (0 means the default policy).", "rule_id")
+	// 数据库写操作耗时
+	dbWriteDuration = NewHistogramVec("ssh_manage_db_write_duration_seconds",
+		"Duration of database write operations in seconds.", nil, "operation")
+)
+
+// observeDBWrite 记录数据库写操作的耗时，用法: defer observeDBWrite("operation", time.Now())
+func observeDBWrite(operation string, start time.Time) {
+	dbWriteDuration.ObserveSince(start, operation)
+}
+
+// MetricSample 一个带标签的指标值
+type MetricSample struct {
+	Labels []string // 标签值，与指标的标签名一一对应
+	Value  float64  // 指标值
+}
+
+// metric 可以输出为Prometheus文本格式的指标
+type metric interface {
+	write(w io.Writer)
+}
+
+// 已注册的指标，按注册顺序输出
+var metricsRegistry []metric
+var metricsRegistryMutex sync.Mutex
+
+// registerMetric 注册指标
+func registerMetric(m metric) {
+	metricsRegistryMutex.Lock()
+	metricsRegistry = append(metricsRegistry, m)
+	metricsRegistryMutex.Unlock()
+}
+
+// WriteMetrics 以Prometheus文本格式输出所有已注册的指标
+// 参数: w - 输出目标
+func WriteMetrics(w io.Writer) {
+	metricsRegistryMutex.Lock()
+	metrics := make([]metric, len(metricsRegistry))
+	copy(metrics, metricsRegistry)
+	metricsRegistryMutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*MetricSample
+}
+
+// NewCounterVec 创建并注册带标签的计数器
+// 参数:
+//   name - 指标名
+//   help - 指标说明
+//   labels - 标签名
+// 返回: *CounterVec - 计数器
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*MetricSample)}
+	if len(labels) == 0 {
+		// 没有标签的计数器从0开始输出
+		c.values[""] = &MetricSample{}
+	}
+	registerMetric(c)
+	return c
+}
+
+// Inc 将指定标签值的计数加1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 将指定标签值的计数增加v
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sample, exists := c.values[key]
+	if !exists {
+		sample = &MetricSample{Labels: labelValues}
+		c.values[key] = sample
+	}
+	sample.Value += v
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	samples := make([]MetricSample, 0, len(c.values))
+	for _, sample := range c.values {
+		samples = append(samples, *sample)
+	}
+	c.mu.Unlock()
+
+	writeSamples(w, c.name, c.help, MetricTypeCounter, c.labels, samples)
+}
+
+// MetricFunc 在输出时通过回调函数读取的指标，用于从已有的内存状态（如活动连接）生成指标
+type MetricFunc struct {
+	name       string
+	help       string
+	metricType string
+	labels     []string
+	collect    func() []MetricSample
+}
+
+// NewMetricFunc 创建并注册通过回调函数读取的指标
+// 参数:
+//   name - 指标名
+//   help - 指标说明
+//   metricType - 指标类型（MetricTypeCounter或MetricTypeGauge）
+//   collect - 返回当前指标值的函数
+//   labels - 标签名
+// 返回: *MetricFunc - 指标
+func NewMetricFunc(name, help, metricType string, collect func() []MetricSample, labels ...string) *MetricFunc {
+	m := &MetricFunc{name: name, help: help, metricType: metricType, labels: labels, collect: collect}
+	registerMetric(m)
+	return m
+}
+
+func (m *MetricFunc) write(w io.Writer) {
+	writeSamples(w, m.name, m.help, m.metricType, m.labels, m.collect())
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+// histogramValue 一组标签值对应的直方图数据
+type histogramValue struct {
+	labels []string
+	counts []uint64 // 每个桶的计数（不累计）
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec 创建并注册带标签的直方图
+// 参数:
+//   name - 指标名
+//   help - 指标说明
+//   buckets - 桶上界，为nil时使用默认的延迟桶
+//   labels - 标签名
+// 返回: *HistogramVec - 直方图
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = defaultLatencyBuckets
+	}
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	registerMetric(h)
+	return h
+}
+
+// Observe 记录一个观测值
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	value, exists := h.values[key]
+	if !exists {
+		value = &histogramValue{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			value.counts[i]++
+			break
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+// ObserveSince 记录从start到现在经过的秒数，便于配合defer使用
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", h.name, MetricTypeHistogram)
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := withLabel(h.labels, "le")
+	for _, key := range keys {
+		value := h.values[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, withLabel(value.labels, formatFloat(upper))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, withLabel(value.labels, "+Inf")), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, value.labels), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, value.labels), value.count)
+	}
+}
+
+// withLabel 返回在values末尾追加value后的新切片
+// 不能直接append：values有剩余容量时会写入共享的底层数组，覆盖其他调用方看到的标签
+func withLabel(values []string, value string) []string {
+	result := make([]string, len(values)+1)
+	copy(result, values)
+	result[len(values)] = value
+	return result
+}
+
+// writeSamples 输出一个指标的说明、类型和所有值，值按标签排序以保证输出稳定
+func writeSamples(w io.Writer, name, help, metricType string, labels []string, samples []MetricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\xff") < strings.Join(samples[j].Labels, "\xff")
+	})
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, sample.Labels), formatFloat(sample.Value))
+	}
+}
+
+// formatLabels 格式化标签，如{user="alice",direction="up"}，没有标签时返回空字符串
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escapeLabelValue(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue 转义标签值中的反斜杠、双引号和换行符
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat 按Prometheus文本格式输出数值
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
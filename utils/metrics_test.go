@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHistogramVecWriteKeepsLabelSlices(t *testing.T) {
+	// 标签名和标签值切片都留有剩余容量，输出le标签时不能写入它们的底层数组
+	labels := make([]string, 1, 2)
+	labels[0] = "user"
+	h := &HistogramVec{name: "test_duration_seconds", help: "test", labels: labels, buckets: []float64{0.1, 1}, values: make(map[string]*histogramValue)}
+
+	labelValues := make([]string, 1, 2)
+	labelValues[0] = "alice"
+	h.Observe(0.5, labelValues...)
+
+	var buf bytes.Buffer
+	h.write(&buf)
+
+	if extra := labels[:2][1]; extra != "" {
+		t.Errorf("label names backing array overwritten with %q", extra)
+	}
+	if extra := labelValues[:2][1]; extra != "" {
+		t.Errorf("caller label values backing array overwritten with %q", extra)
+	}
+	for _, line := range []string{
+		`test_duration_seconds_bucket{user="alice",le="0.1"} 0`,
+		`test_duration_seconds_bucket{user="alice",le="1"} 1`,
+		`test_duration_seconds_bucket{user="alice",le="+Inf"} 1`,
+		`test_duration_seconds_count{user="alice"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("output missing %q:\n%s", line, buf.String())
+		}
+	}
+}
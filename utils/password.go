@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash 用于用户不存在时执行一次等价的哈希比较，避免通过响应时间探测用户名
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("ssh-manage-dummy-password"), bcrypt.DefaultCost)
+
+// HashPassword 使用bcrypt对密码进行哈希
+// 参数: password - 明文密码
+// 返回:
+//   string - bcrypt哈希值
+//   error - 哈希过程中的错误
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 以恒定时间比较明文密码与bcrypt哈希值
+// 参数:
+//   hash - 存储的bcrypt哈希值
+//   password - 待验证的明文密码
+// 返回: bool - 密码是否匹配
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// CheckDummyPassword 对固定哈希执行一次比较，用于用户不存在时保持与正常验证相同的耗时
+func CheckDummyPassword(password string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
+
+// IsPasswordHash 判断存储的密码是否已经是bcrypt哈希值
+// 参数: password - 存储的密码字段
+// 返回: bool - 是否为bcrypt哈希
+func IsPasswordHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
+// GeneratePassword 生成随机密码，用于重置密码时未指定新密码的情况
+// 返回:
+//   string - 16个字符的随机密码（URL安全的Base64字符）
+//   error - 读取随机数时的错误
+func GeneratePassword() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
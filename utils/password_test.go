@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if hash == "secret" || !IsPasswordHash(hash) {
+		t.Fatalf("got %q, want a bcrypt hash", hash)
+	}
+	if !CheckPassword(hash, "secret") {
+		t.Errorf("correct password rejected")
+	}
+	if CheckPassword(hash, "Secret") || CheckPassword(hash, "") {
+		t.Errorf("wrong password accepted")
+	}
+
+	// 相同的密码每次使用不同的盐
+	if again, _ := HashPassword("secret"); again == hash {
+		t.Errorf("hashing the same password twice returned the same hash")
+	}
+
+	// bcrypt不支持超过72字节的密码
+	if _, err := HashPassword(strings.Repeat("x", 73)); err == nil {
+		t.Errorf("hashed a password longer than 72 bytes")
+	}
+}
+
+func TestCheckPasswordPlaintext(t *testing.T) {
+	// 未迁移的明文密码不能直接用于比较
+	if IsPasswordHash("secret") {
+		t.Errorf("plaintext detected as a hash")
+	}
+	if CheckPassword("secret", "secret") {
+		t.Errorf("plaintext stored password accepted")
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	first, err := GeneratePassword()
+	if err != nil {
+		t.Fatalf("generate password: %v", err)
+	}
+	second, _ := GeneratePassword()
+	if len(first) != 16 || first == second {
+		t.Errorf("got %q and %q, want two different 16 character passwords", first, second)
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+	"ssh-manage/models"
+)
+
+// ValidateQuota 校验用户的流量配额配置
+// 参数: user - 用户信息
+// 返回: error - 配置错误
+func ValidateQuota(user *models.User) error {
+	if user.DailyQuota < 0 || user.MonthlyQuota < 0 {
+		return fmt.Errorf("quota must not be negative")
+	}
+	switch user.QuotaAction {
+	case models.QuotaActionReject, models.QuotaActionThrottle, models.QuotaActionDrop:
+		return nil
+	default:
+		return fmt.Errorf("invalid quota action %q", user.QuotaAction)
+	}
+}
+
+// quotaActionOrDefault 配额处理方式为空时使用默认值reject
+func quotaActionOrDefault(action string) string {
+	if action == "" {
+		return models.QuotaActionReject
+	}
+	return action
+}
+
+// DayStart 获取指定时间所在日的开始时间（本地时间）
+func DayStart(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// MonthStart 获取指定时间所在月的开始时间（本地时间）
+func MonthStart(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
+
+// GetUserTrafficSince 统计用户自指定时间以来建立的目标连接的流量（上行与下行之和）
+// 活动连接的流量每30秒写入一次数据库，因此结果可能略低于实时流量
+// 参数:
+//   userID - 用户ID
+//   since - 开始时间
+// 返回:
+//   int64 - 流量（字节）
+//   error - 查询过程中的错误
+func GetUserTrafficSince(userID int, since time.Time) (int64, error) {
+	db := GetDB()
+
+	var total int64
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(tc.bytes_up + tc.bytes_down), 0)
+		FROM target_connections tc
+		JOIN connections c ON c.id = tc.connection_id
+		WHERE c.user_id = ? AND tc.connected_at >= ?`,
+		userID, since.Local().Format("2006-01-02 15:04:05")).Scan(&total)
+	return total, err
+}
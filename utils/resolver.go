@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"ssh-manage/models"
+	"time"
+)
+
+// ErrTargetDenied 目标地址被防火墙规则拒绝
+var ErrTargetDenied = errors.New("target address is prohibited by firewall rules")
+
+// resolveTimeout 解析目标主机名的超时时间
+const resolveTimeout = 5 * time.Second
+
+// Resolver 目标主机名解析器，*net.Resolver实现了该接口，测试时可替换为桩实现
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+var (
+	targetResolver      Resolver = net.DefaultResolver
+	targetResolverMutex sync.RWMutex
+)
+
+// NewResolver 创建目标主机名解析器
+// 参数: dnsServer - DNS服务器地址（host:port），为空时使用系统解析器
+// 返回: Resolver - 解析器
+func NewResolver(dnsServer string) Resolver {
+	if dnsServer == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, dnsServer)
+		},
+	}
+}
+
+// SetResolver 设置解析目标主机名使用的解析器
+// 参数: r - 解析器
+func SetResolver(r Resolver) {
+	targetResolverMutex.Lock()
+	targetResolver = r
+	targetResolverMutex.Unlock()
+}
+
+// getResolver 获取当前的解析器
+func getResolver() Resolver {
+	targetResolverMutex.RLock()
+	defer targetResolverMutex.RUnlock()
+	return targetResolver
+}
+
+// ResolveTarget 将目标主机解析为IP地址列表
+// 主机本身是IP地址（包括127.1等等价写法）时不进行DNS查询
+// 参数: host - 目标主机
+// 返回:
+//   []net.IP - 解析得到的IP地址
+//   error - 解析过程中的错误
+func ResolveTarget(host string) ([]net.IP, error) {
+	return resolveTargetContext(context.Background(), host)
+}
+
+// resolveTargetContext 将目标主机解析为IP地址列表，单次解析最长resolveTimeout，ctx结束时提前返回
+func resolveTargetContext(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := ParseIPAddress(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := getResolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// ResolveAllowedTarget 解析目标主机并对每个解析出的IP进行防火墙检查
+// 只要有一个IP被拒绝就拒绝整个目标，避免主机名指向内网地址绕过规则；
+// 调用方应直接连接返回的地址，而不是再次解析主机名
+// 参数:
+//   user - 发起连接的用户，用于评估用户和用户组规则
+//   host - 目标主机
+//   port - 目标端口
+// 返回:
+//   []string - 经过检查、可直接拨号的"IP:端口"列表
+//   *models.FirewallDecision - 防火墙决策（拒绝时为被拒绝的IP，允许时为第一个IP），解析失败时为nil
+//   error - 解析失败或ErrTargetDenied
+func ResolveAllowedTarget(user *models.User, host, port string) ([]string, *models.FirewallDecision, error) {
+	ips, err := ResolveTarget(host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var decision *models.FirewallDecision
+	dialAddrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		allowed, ruleID := CheckTarget(user, FirewallTarget{Host: host, IP: ip, Port: port})
+		if !allowed {
+			return nil, newFirewallDecision(user, host, port, ip, ruleID, models.FirewallActionDeny),
+				fmt.Errorf("%w: %s resolved to %s", ErrTargetDenied, host, ip)
+		}
+		if decision == nil {
+			decision = newFirewallDecision(user, host, port, ip, ruleID, models.FirewallActionAllow)
+		}
+		dialAddrs = append(dialAddrs, net.JoinHostPort(ip.String(), port))
+	}
+	return dialAddrs, decision, nil
+}
+
+// newFirewallDecision 创建防火墙决策记录
+func newFirewallDecision(user *models.User, host, port string, ip net.IP, ruleID int, action string) *models.FirewallDecision {
+	decision := &models.FirewallDecision{
+		Target: net.JoinHostPort(host, port),
+		IP:     ip.String(),
+		RuleID: ruleID,
+		Action: action,
+		Time:   time.Now(),
+	}
+	if user != nil {
+		decision.UserID = user.ID
+		decision.Username = user.Username
+	}
+	return decision
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"ssh-manage/models"
+)
+
+// stubResolver 返回固定地址的解析器，记录被查询的主机名
+type stubResolver struct {
+	addrs   map[string][]string
+	lookups []string
+}
+
+func (r *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups = append(r.lookups, host)
+	var addrs []net.IPAddr
+	for _, addr := range r.addrs[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return addrs, nil
+}
+
+// useTestFirewall 替换解析器和当前规则集，测试结束后恢复
+func useTestFirewall(t *testing.T, resolver Resolver, rules []*models.FirewallRule) {
+	previousResolver := getResolver()
+	firewallRuleSetMutex.Lock()
+	previousSet := firewallRuleSet
+	firewallRuleSet = CompileFirewallRules(rules)
+	firewallRuleSetMutex.Unlock()
+	SetResolver(resolver)
+
+	t.Cleanup(func() {
+		SetResolver(previousResolver)
+		firewallRuleSetMutex.Lock()
+		firewallRuleSet = previousSet
+		firewallRuleSetMutex.Unlock()
+	})
+}
+
+func TestResolveAllowedTargetDeniesInternalIP(t *testing.T) {
+	// 公网主机名被解析为内网地址（DNS重绑定），应按解析出的IP拒绝
+	resolver := &stubResolver{addrs: map[string][]string{
+		"public.example.com": {"10.0.0.5"},
+		"mixed.example.com":  {"93.184.216.34", "10.0.0.6"},
+	}}
+	useTestFirewall(t, resolver, []*models.FirewallRule{
+		newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "10.0.0.0/8", ""),
+	})
+
+	for _, host := range []string{"public.example.com", "mixed.example.com"} {
+		dialAddrs, decision, err := ResolveAllowedTarget(nil, host, "443")
+		if !errors.Is(err, ErrTargetDenied) {
+			t.Fatalf("%s: got error %v, want ErrTargetDenied", host, err)
+		}
+		// 调用方只拨号返回的地址，被拒绝时不能返回任何地址，也不能退回到按主机名拨号
+		if len(dialAddrs) != 0 {
+			t.Errorf("%s: got dial addresses %v, want none", host, dialAddrs)
+		}
+		if decision == nil || decision.Action != models.FirewallActionDeny || decision.RuleID != 1 {
+			t.Errorf("%s: got decision %+v, want deny by rule 1", host, decision)
+		}
+	}
+
+	if len(resolver.lookups) != 2 {
+		t.Errorf("got lookups %v, want one lookup per host through the stub resolver", resolver.lookups)
+	}
+}
+
+func TestResolveAllowedTargetDialsResolvedIPs(t *testing.T) {
+	resolver := &stubResolver{addrs: map[string][]string{"public.example.com": {"93.184.216.34"}}}
+	useTestFirewall(t, resolver, []*models.FirewallRule{
+		newTestRule(1, models.FirewallActionDeny, models.FirewallKindCIDR, "10.0.0.0/8", ""),
+	})
+
+	dialAddrs, decision, err := ResolveAllowedTarget(nil, "public.example.com", "443")
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	// 允许时拨号解析出的IP而不是主机名，避免拨号时再次解析得到不同的地址
+	if len(dialAddrs) != 1 || dialAddrs[0] != "93.184.216.34:443" {
+		t.Errorf("got dial addresses %v, want [93.184.216.34:443]", dialAddrs)
+	}
+	if decision == nil || decision.Action != models.FirewallActionAllow {
+		t.Errorf("got decision %+v, want allow", decision)
+	}
+}
@@ -0,0 +1,386 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"ssh-manage/config"
+	"ssh-manage/models"
+)
+
+// rollupTimeLayout 时间桶开始时间的存储格式（本地时间），与其他表的时间格式一致，可以按字符串比较
+const rollupTimeLayout = "2006-01-02 15:04:05"
+
+// maxSeriesBuckets 一次查询最多返回的时间桶数，避免按分钟查询很长的时间范围
+const maxSeriesBuckets = 5000
+
+// defaultSeriesLimit 分组查询时默认最多返回的分组数，其余分组合并为一条
+const defaultSeriesLimit = 10
+
+// rollupGranularities 写入流量时同时累加的所有粒度
+var rollupGranularities = []string{models.RollupGranularityMinute, models.RollupGranularityHour, models.RollupGranularityDay}
+
+// rollupBackfillFormats 从target_connections回填汇总数据时各粒度对应的strftime格式
+var rollupBackfillFormats = map[string]string{
+	models.RollupGranularityMinute: "%Y-%m-%d %H:%M:00",
+	models.RollupGranularityHour:   "%Y-%m-%d %H:00:00",
+	models.RollupGranularityDay:    "%Y-%m-%d 00:00:00",
+}
+
+// TrafficSeriesQuery 流量时间序列查询条件
+type TrafficSeriesQuery struct {
+	From        time.Time `json:"from"`        // 开始时间，零值表示结束时间前24小时
+	To          time.Time `json:"to"`          // 结束时间（不含），零值表示当前时间
+	Granularity string    `json:"granularity"` // 时间桶粒度，为空时根据时间范围自动选择
+	UserID      int       `json:"user_id"`     // 只统计指定用户，0表示所有用户
+	Target      string    `json:"target"`      // 只统计指定目标地址，为空表示所有目标
+	GroupBy     string    `json:"group_by"`    // 分组方式："user"、"target"，为空表示不分组
+	Limit       int       `json:"limit"`       // 分组时最多返回的分组数，按总流量排序，0表示使用默认值
+}
+
+// TrafficSeries 流量时间序列
+type TrafficSeries struct {
+	Granularity string               `json:"granularity"` // 时间桶粒度
+	From        time.Time            `json:"from"`        // 第一个时间桶的开始时间
+	To          time.Time            `json:"to"`          // 结束时间（不含）
+	Buckets     []time.Time          `json:"buckets"`     // 所有时间桶的开始时间，没有流量的时间桶也包含在内
+	Series      []*TrafficSeriesLine `json:"series"`      // 每个分组一条序列，按总流量从大到小排序
+}
+
+// TrafficSeriesLine 一个分组的流量序列，各数组与TrafficSeries.Buckets一一对应
+type TrafficSeriesLine struct {
+	Key       string  `json:"key"`        // 分组键（用户名或目标地址），不分组时为空
+	Other     bool    `json:"other"`      // 是否为超出分组数上限后合并的其他分组
+	BytesUp   []int64 `json:"bytes_up"`   // 上行流量（字节）
+	BytesDown []int64 `json:"bytes_down"` // 下行流量（字节）
+	Channels  []int   `json:"channels"`   // 新打开的目标连接数
+	TotalUp   int64   `json:"total_up"`   // 上行流量合计
+	TotalDown int64   `json:"total_down"` // 下行流量合计
+}
+
+// RollupBucketStart 获取指定时间所在时间桶的开始时间（本地时间）
+// 参数:
+//   granularity - 时间桶粒度
+//   t - 时间
+// 返回: time.Time - 时间桶开始时间
+func RollupBucketStart(granularity string, t time.Time) time.Time {
+	t = t.Local()
+	switch granularity {
+	case models.RollupGranularityMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+	case models.RollupGranularityHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+	default:
+		return DayStart(t)
+	}
+}
+
+// nextRollupBucket 获取下一个时间桶的开始时间
+func nextRollupBucket(granularity string, bucket time.Time) time.Time {
+	switch granularity {
+	case models.RollupGranularityMinute:
+		return RollupBucketStart(granularity, bucket.Add(time.Minute))
+	case models.RollupGranularityHour:
+		return RollupBucketStart(granularity, bucket.Add(time.Hour))
+	default:
+		return bucket.AddDate(0, 0, 1)
+	}
+}
+
+// ChooseRollupGranularity 根据时间范围选择时间桶粒度：6小时以内按分钟，7天以内按小时，否则按天
+func ChooseRollupGranularity(from, to time.Time) string {
+	switch span := to.Sub(from); {
+	case span <= 6*time.Hour:
+		return models.RollupGranularityMinute
+	case span <= 7*24*time.Hour:
+		return models.RollupGranularityHour
+	default:
+		return models.RollupGranularityDay
+	}
+}
+
+// createTrafficRollupTable 创建流量汇总表，首次创建时从target_connections回填历史数据
+// 历史连接的流量全部计入连接建立时所在的时间桶
+func createTrafficRollupTable(tx *sql.Tx) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'traffic_rollups'").Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// 主键同时用于按时间范围查询，另外为按用户和按目标过滤建立索引
+	statements := []string{`
+	CREATE TABLE traffic_rollups (
+		granularity TEXT NOT NULL,
+		bucket_start TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL,
+		bytes_up INTEGER NOT NULL DEFAULT 0,
+		bytes_down INTEGER NOT NULL DEFAULT 0,
+		channels INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (granularity, bucket_start, user_id, target)
+	);`,
+		"CREATE INDEX idx_traffic_rollups_user ON traffic_rollups(granularity, user_id, bucket_start);",
+		"CREATE INDEX idx_traffic_rollups_target ON traffic_rollups(granularity, target, bucket_start);",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	// 分钟和小时汇总只回填保留时长内的数据，否则回填的数据在第一次清理时就会被删除
+	cfg := config.Load()
+	retentionDays := map[string]int64{
+		models.RollupGranularityMinute: cfg.RollupMinuteRetentionDays,
+		models.RollupGranularityHour:   cfg.RollupHourRetentionDays,
+	}
+	for _, granularity := range rollupGranularities {
+		since := ""
+		if days := retentionDays[granularity]; days > 0 {
+			since = time.Now().AddDate(0, 0, -int(days)).Format(rollupTimeLayout)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO traffic_rollups (granularity, bucket_start, user_id, username, target, bytes_up, bytes_down, channels)
+			SELECT ?, strftime(?, tc.connected_at) AS bucket, c.user_id, MAX(c.username), tc.target,
+				COALESCE(SUM(tc.bytes_up), 0), COALESCE(SUM(tc.bytes_down), 0), COUNT(*)
+			FROM target_connections tc
+			JOIN connections c ON c.id = tc.connection_id
+			WHERE strftime(?, tc.connected_at) IS NOT NULL AND (? = '' OR tc.connected_at >= ?)
+			GROUP BY bucket, c.user_id, tc.target`,
+			granularity, rollupBackfillFormats[granularity], rollupBackfillFormats[granularity], since, since)
+		if err != nil {
+			return fmt.Errorf("failed to backfill %s traffic rollups: %v", granularity, err)
+		}
+	}
+	return nil
+}
+
+// RecordTrafficRollups 将一批流量增量累加到所有粒度的时间桶中
+// 参数: rollups - 流量增量，BucketStart为流量发生的时间，Granularity被忽略
+// 返回: error - 写入过程中的错误
+func RecordTrafficRollups(rollups []*models.TrafficRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	defer observeDBWrite("record_traffic_rollups", time.Now())
+	db := GetDB()
+
+	// 开始事务
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO traffic_rollups (granularity, bucket_start, user_id, username, target, bytes_up, bytes_down, channels)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (granularity, bucket_start, user_id, target) DO UPDATE SET
+			username = excluded.username,
+			bytes_up = bytes_up + excluded.bytes_up,
+			bytes_down = bytes_down + excluded.bytes_down,
+			channels = channels + excluded.channels`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rollup := range rollups {
+		for _, granularity := range rollupGranularities {
+			bucket := RollupBucketStart(granularity, rollup.BucketStart).Format(rollupTimeLayout)
+			_, err = stmt.Exec(granularity, bucket, rollup.UserID, rollup.Username, rollup.Target, rollup.BytesUp, rollup.BytesDown, rollup.Channels)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// PruneTrafficRollups 删除超过保留期的分钟和小时汇总数据，按天汇总的数据永久保留
+// 参数:
+//   minuteRetention - 分钟汇总的保留时长，0表示永久保留
+//   hourRetention - 小时汇总的保留时长，0表示永久保留
+// 返回:
+//   int64 - 删除的行数
+//   error - 删除过程中的错误
+func PruneTrafficRollups(minuteRetention, hourRetention time.Duration) (int64, error) {
+	db := GetDB()
+	now := time.Now()
+
+	var deleted int64
+	retentions := map[string]time.Duration{
+		models.RollupGranularityMinute: minuteRetention,
+		models.RollupGranularityHour:   hourRetention,
+	}
+	for granularity, retention := range retentions {
+		if retention <= 0 {
+			continue
+		}
+		result, err := db.Exec("DELETE FROM traffic_rollups WHERE granularity = ? AND bucket_start < ?",
+			granularity, now.Add(-retention).Local().Format(rollupTimeLayout))
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// QueryTrafficSeries 查询流量时间序列，只读取汇总表，查询耗时与时间桶数相关，与连接记录数无关
+// 参数: query - 查询条件
+// 返回:
+//   *TrafficSeries - 流量时间序列
+//   error - 查询条件无效或查询过程中的错误
+func QueryTrafficSeries(query *TrafficSeriesQuery) (*TrafficSeries, error) {
+	to := query.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	from := query.From
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	granularity := query.Granularity
+	if granularity == "" {
+		granularity = ChooseRollupGranularity(from, to)
+	}
+	if _, ok := rollupBackfillFormats[granularity]; !ok {
+		return nil, fmt.Errorf("invalid granularity %q", granularity)
+	}
+
+	var keyColumn string
+	switch query.GroupBy {
+	case "":
+		keyColumn = "''"
+	case "user":
+		keyColumn = "username"
+	case "target":
+		keyColumn = "target"
+	default:
+		return nil, fmt.Errorf("invalid group_by %q", query.GroupBy)
+	}
+
+	// 列出范围内的所有时间桶
+	series := &TrafficSeries{Granularity: granularity, From: RollupBucketStart(granularity, from), To: to}
+	bucketIndex := make(map[string]int)
+	for bucket := series.From; bucket.Before(to); bucket = nextRollupBucket(granularity, bucket) {
+		if len(series.Buckets) >= maxSeriesBuckets {
+			return nil, fmt.Errorf("time range contains more than %d %s buckets", maxSeriesBuckets, granularity)
+		}
+		bucketIndex[bucket.Format(rollupTimeLayout)] = len(series.Buckets)
+		series.Buckets = append(series.Buckets, bucket)
+	}
+
+	conditions := []string{"granularity = ?", "bucket_start >= ?", "bucket_start < ?"}
+	args := []interface{}{granularity, series.From.Format(rollupTimeLayout), to.Local().Format(rollupTimeLayout)}
+	if query.UserID != 0 {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, query.UserID)
+	}
+	if query.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, query.Target)
+	}
+
+	rows, err := GetDB().Query(`
+		SELECT bucket_start, `+keyColumn+`, SUM(bytes_up), SUM(bytes_down), SUM(channels)
+		FROM traffic_rollups
+		WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY bucket_start, `+keyColumn, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make(map[string]*TrafficSeriesLine)
+	for rows.Next() {
+		var bucket, key string
+		var bytesUp, bytesDown int64
+		var channels int
+		if err := rows.Scan(&bucket, &key, &bytesUp, &bytesDown, &channels); err != nil {
+			return nil, err
+		}
+		i, ok := bucketIndex[bucket]
+		if !ok {
+			continue
+		}
+
+		line, exists := lines[key]
+		if !exists {
+			line = newTrafficSeriesLine(key, len(series.Buckets))
+			lines[key] = line
+		}
+		line.add(i, bytesUp, bytesDown, channels)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	series.Series = limitTrafficSeries(lines, query.Limit, len(series.Buckets))
+	return series, nil
+}
+
+// newTrafficSeriesLine 创建包含n个时间桶的空序列
+func newTrafficSeriesLine(key string, n int) *TrafficSeriesLine {
+	return &TrafficSeriesLine{
+		Key:       key,
+		BytesUp:   make([]int64, n),
+		BytesDown: make([]int64, n),
+		Channels:  make([]int, n),
+	}
+}
+
+// add 累加第i个时间桶的流量
+func (l *TrafficSeriesLine) add(i int, bytesUp, bytesDown int64, channels int) {
+	l.BytesUp[i] += bytesUp
+	l.BytesDown[i] += bytesDown
+	l.Channels[i] += channels
+	l.TotalUp += bytesUp
+	l.TotalDown += bytesDown
+}
+
+// limitTrafficSeries 按总流量排序，保留前limit个分组，其余合并为一条Other序列
+func limitTrafficSeries(lines map[string]*TrafficSeriesLine, limit, n int) []*TrafficSeriesLine {
+	if limit <= 0 {
+		limit = defaultSeriesLimit
+	}
+
+	sorted := make([]*TrafficSeriesLine, 0, len(lines))
+	for _, line := range lines {
+		sorted = append(sorted, line)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		ti, tj := sorted[i].TotalUp+sorted[i].TotalDown, sorted[j].TotalUp+sorted[j].TotalDown
+		if ti != tj {
+			return ti > tj
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	if len(sorted) <= limit {
+		return sorted
+	}
+
+	other := newTrafficSeriesLine("", n)
+	other.Other = true
+	for _, line := range sorted[limit:] {
+		for i := 0; i < n; i++ {
+			other.add(i, line.BytesUp[i], line.BytesDown[i], line.Channels[i])
+		}
+	}
+	return append(sorted[:limit], other)
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// countTrafficRollups 统计指定粒度的汇总行数
+func countTrafficRollups(t *testing.T, granularity string) int {
+	t.Helper()
+	var count int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM traffic_rollups WHERE granularity = ?", granularity).Scan(&count); err != nil {
+		t.Fatalf("count %s rollups: %v", granularity, err)
+	}
+	return count
+}
+
+func TestRollupBucketStart(t *testing.T) {
+	at := time.Date(2026, 3, 10, 10, 42, 17, 0, time.Local)
+	tests := []struct {
+		granularity string
+		want        time.Time
+	}{
+		{models.RollupGranularityMinute, time.Date(2026, 3, 10, 10, 42, 0, 0, time.Local)},
+		{models.RollupGranularityHour, time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)},
+		{models.RollupGranularityDay, time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		if got := RollupBucketStart(tt.granularity, at); !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.granularity, got, tt.want)
+		}
+	}
+
+	if got := ChooseRollupGranularity(at, at.Add(6*time.Hour)); got != models.RollupGranularityMinute {
+		t.Errorf("6 hours: got %s", got)
+	}
+	if got := ChooseRollupGranularity(at, at.Add(7*24*time.Hour)); got != models.RollupGranularityHour {
+		t.Errorf("7 days: got %s", got)
+	}
+	if got := ChooseRollupGranularity(at, at.Add(8*24*time.Hour)); got != models.RollupGranularityDay {
+		t.Errorf("8 days: got %s", got)
+	}
+}
+
+func TestQueryTrafficSeries(t *testing.T) {
+	openTestDB(t)
+	base := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+
+	// 同一时间桶、用户和目标的增量累加到同一行
+	err := RecordTrafficRollups([]*models.TrafficRollup{
+		{BucketStart: base.Add(time.Minute + 5*time.Second), UserID: 1, Username: "alice", Target: "a:22", BytesUp: 100, BytesDown: 200, Channels: 1},
+		{BucketStart: base.Add(time.Minute + 40*time.Second), UserID: 1, Username: "alice", Target: "a:22", BytesUp: 10, BytesDown: 20},
+		{BucketStart: base.Add(5 * time.Minute), UserID: 2, Username: "bob", Target: "b:22", BytesUp: 1000, Channels: 1},
+	})
+	if err != nil {
+		t.Fatalf("record rollups: %v", err)
+	}
+
+	series, err := QueryTrafficSeries(&TrafficSeriesQuery{From: base, To: base.Add(10 * time.Minute)})
+	if err != nil {
+		t.Fatalf("query series: %v", err)
+	}
+	if series.Granularity != models.RollupGranularityMinute || len(series.Buckets) != 10 || len(series.Series) != 1 {
+		t.Fatalf("got granularity %s, %d buckets, %d lines", series.Granularity, len(series.Buckets), len(series.Series))
+	}
+	line := series.Series[0]
+	if line.BytesUp[1] != 110 || line.BytesDown[1] != 220 || line.Channels[1] != 1 || line.BytesUp[5] != 1000 || line.BytesUp[0] != 0 {
+		t.Errorf("got line %+v", line)
+	}
+	if line.TotalUp != 1110 || line.TotalDown != 220 {
+		t.Errorf("got totals %d/%d, want 1110/220", line.TotalUp, line.TotalDown)
+	}
+
+	// 分组按总流量排序，超出上限的分组合并为Other
+	series, err = QueryTrafficSeries(&TrafficSeriesQuery{From: base, To: base.Add(10 * time.Minute), GroupBy: "user", Limit: 1})
+	if err != nil {
+		t.Fatalf("query grouped series: %v", err)
+	}
+	if len(series.Series) != 2 || series.Series[0].Key != "bob" || !series.Series[1].Other || series.Series[1].TotalUp != 110 {
+		t.Errorf("got grouped series %+v", series.Series)
+	}
+
+	// 按用户和目标过滤，按小时汇总时所有流量落在同一个时间桶
+	series, err = QueryTrafficSeries(&TrafficSeriesQuery{From: base, To: base.Add(time.Hour), Granularity: models.RollupGranularityHour, Target: "a:22"})
+	if err != nil {
+		t.Fatalf("query hourly series: %v", err)
+	}
+	if len(series.Buckets) != 1 || len(series.Series) != 1 || series.Series[0].TotalUp != 110 || series.Series[0].Channels[0] != 1 {
+		t.Errorf("got hourly series %+v", series.Series)
+	}
+	series, err = QueryTrafficSeries(&TrafficSeriesQuery{From: base, To: base.Add(time.Hour), UserID: 3})
+	if err != nil {
+		t.Fatalf("query series of user without traffic: %v", err)
+	}
+	if len(series.Series) != 0 {
+		t.Errorf("got %d lines for a user without traffic", len(series.Series))
+	}
+}
+
+func TestQueryTrafficSeriesInvalid(t *testing.T) {
+	openTestDB(t)
+	base := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+
+	queries := map[string]*TrafficSeriesQuery{
+		"from after to":       {From: base, To: base.Add(-time.Minute)},
+		"invalid granularity": {From: base, To: base.Add(time.Hour), Granularity: "week"},
+		"invalid group_by":    {From: base, To: base.Add(time.Hour), GroupBy: "ip"},
+		"too many buckets":    {From: base, To: base.Add(30 * 24 * time.Hour), Granularity: models.RollupGranularityMinute},
+	}
+	for name, query := range queries {
+		if _, err := QueryTrafficSeries(query); err == nil {
+			t.Errorf("%s: query succeeded", name)
+		}
+	}
+}
+
+func TestPruneTrafficRollups(t *testing.T) {
+	openTestDB(t)
+	err := RecordTrafficRollups([]*models.TrafficRollup{
+		{BucketStart: time.Now().Add(-3 * time.Hour), UserID: 1, Username: "alice", Target: "a:22", BytesUp: 1},
+	})
+	if err != nil {
+		t.Fatalf("record rollups: %v", err)
+	}
+
+	// 只删除超过保留时长的分钟汇总，保留时长为0的小时汇总和按天汇总保留
+	deleted, err := PruneTrafficRollups(time.Hour, 0)
+	if err != nil {
+		t.Fatalf("prune rollups: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("got %d deleted rows, want 1", deleted)
+	}
+	for granularity, want := range map[string]int{
+		models.RollupGranularityMinute: 0,
+		models.RollupGranularityHour:   1,
+		models.RollupGranularityDay:    1,
+	} {
+		if got := countTrafficRollups(t, granularity); got != want {
+			t.Errorf("%s: got %d rows, want %d", granularity, got, want)
+		}
+	}
+}
+
+func TestTrafficRollupBackfill(t *testing.T) {
+	t.Setenv("ROLLUP_MINUTE_RETENTION_DAYS", "7")
+	t.Setenv("ROLLUP_HOUR_RETENTION_DAYS", "90")
+	openTestDB(t)
+	user := addTestUser(t, "alice", "secret")
+	now := time.Now()
+	for _, age := range []time.Duration{time.Hour, 30 * 24 * time.Hour, 100 * 24 * time.Hour} {
+		connID := recordTestConnection(t, user, now.Add(-age))
+		recordTestTargetConnection(t, connID, "a:22", now.Add(-age), 10, 20)
+	}
+
+	// 汇总表不存在时重新迁移，从target_connections回填
+	if _, err := GetDB().Exec("DROP TABLE traffic_rollups"); err != nil {
+		t.Fatalf("drop rollups: %v", err)
+	}
+	if err := migrateTables(); err != nil {
+		t.Fatalf("migrate tables: %v", err)
+	}
+
+	// 分钟和小时汇总只回填保留时长内的连接
+	for granularity, want := range map[string]int{
+		models.RollupGranularityMinute: 1,
+		models.RollupGranularityHour:   2,
+		models.RollupGranularityDay:    3,
+	} {
+		if got := countTrafficRollups(t, granularity); got != want {
+			t.Errorf("%s: got %d rows, want %d", granularity, got, want)
+		}
+	}
+
+	series, err := QueryTrafficSeries(&TrafficSeriesQuery{From: now.Add(-2 * time.Hour), To: now, GroupBy: "user"})
+	if err != nil {
+		t.Fatalf("query backfilled series: %v", err)
+	}
+	if len(series.Series) != 1 || series.Series[0].Key != "alice" || series.Series[0].TotalUp != 10 || series.Series[0].TotalDown != 20 {
+		t.Errorf("got backfilled series %+v", series.Series)
+	}
+
+	// 汇总表已存在时不再回填
+	if err := migrateTables(); err != nil {
+		t.Fatalf("migrate tables again: %v", err)
+	}
+	if got := countTrafficRollups(t, models.RollupGranularityDay); got != 3 {
+		t.Errorf("got %d day rows after a second migration, want 3", got)
+	}
+}
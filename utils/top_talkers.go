@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// defaultTopTalkersLimit 流量排行默认的条目数
+const defaultTopTalkersLimit = 10
+
+// TopTalkersQuery 流量排行查询条件
+type TopTalkersQuery struct {
+	From   time.Time `json:"from"`    // 开始时间，零值表示结束时间前7天
+	To     time.Time `json:"to"`      // 结束时间（不含），零值表示当前时间
+	UserID int       `json:"user_id"` // 只统计指定用户，0表示所有用户
+	Limit  int       `json:"limit"`   // 每个排行的条目数，0表示使用默认值
+}
+
+// TopTalkersReport 指定时间段内的流量排行
+type TopTalkersReport struct {
+	From             time.Time           `json:"from"`              // 开始时间
+	To               time.Time           `json:"to"`                // 结束时间（不含）
+	Total            *TalkerEntry        `json:"total"`             // 时间段内所有目标连接的合计
+	Users            []*TalkerEntry      `json:"users"`             // 流量最多的用户
+	Hosts            []*TalkerEntry      `json:"hosts"`             // 流量最多的目标主机
+	Ports            []*TalkerEntry      `json:"ports"`             // 流量最多的目标端口
+	UserDestinations []*UserDestinations `json:"user_destinations"` // 流量最多的用户各自流量最多的目标地址
+}
+
+// TalkerEntry 流量排行中的一个条目
+type TalkerEntry struct {
+	Key         string `json:"key"`         // 用户名、主机、端口或目标地址
+	UserID      int    `json:"user_id"`     // 用户ID，仅用户排行有
+	BytesUp     int64  `json:"bytes_up"`    // 上行流量（字节）
+	BytesDown   int64  `json:"bytes_down"`  // 下行流量（字节）
+	Connections int    `json:"connections"` // 目标连接数
+	Users       int    `json:"users"`       // 访问过的用户数，仅主机和端口排行有
+}
+
+// Bytes 上行与下行流量之和
+func (e *TalkerEntry) Bytes() int64 {
+	return e.BytesUp + e.BytesDown
+}
+
+// UserDestinations 一个用户的目标地址流量明细
+type UserDestinations struct {
+	User         *TalkerEntry   `json:"user"`         // 用户合计
+	Destinations []*TalkerEntry `json:"destinations"` // 流量最多的目标地址（主机:端口）
+}
+
+// talkerAggregate 按键累加流量，countUsers为true时同时统计每个键的用户数
+type talkerAggregate struct {
+	entries    map[string]*TalkerEntry
+	users      map[string]map[int]bool
+	countUsers bool
+}
+
+func newTalkerAggregate(countUsers bool) *talkerAggregate {
+	return &talkerAggregate{entries: make(map[string]*TalkerEntry), users: make(map[string]map[int]bool), countUsers: countUsers}
+}
+
+// add 累加一组用户和目标地址的流量
+func (a *talkerAggregate) add(key string, userID int, bytesUp, bytesDown int64, connections int) *TalkerEntry {
+	entry, exists := a.entries[key]
+	if !exists {
+		entry = &TalkerEntry{Key: key}
+		a.entries[key] = entry
+		a.users[key] = make(map[int]bool)
+	}
+	entry.BytesUp += bytesUp
+	entry.BytesDown += bytesDown
+	entry.Connections += connections
+	if a.countUsers {
+		a.users[key][userID] = true
+		entry.Users = len(a.users[key])
+	}
+	return entry
+}
+
+// top 按总流量从大到小排序，返回前limit个条目
+func (a *talkerAggregate) top(limit int) []*TalkerEntry {
+	entries := make([]*TalkerEntry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes() != entries[j].Bytes() {
+			return entries[i].Bytes() > entries[j].Bytes()
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// GetTopTalkers 统计指定时间段内流量最多的用户、目标主机、目标端口，以及每个排行靠前的用户的目标地址明细
+// 以目标连接的建立时间判断是否属于该时间段，活动连接的流量每30秒写入一次数据库
+// 参数: query - 查询条件
+// 返回:
+//   *TopTalkersReport - 流量排行
+//   error - 查询条件无效或查询过程中的错误
+func GetTopTalkers(query *TopTalkersQuery) (*TopTalkersReport, error) {
+	to := query.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	from := query.From
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -7)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultTopTalkersLimit
+	}
+
+	// 在数据库中按用户和目标地址聚合，主机和端口在Go中拆分（目标地址可能是带方括号的IPv6地址）
+	sqlQuery := `
+		SELECT c.user_id, MAX(c.username), tc.target,
+			COALESCE(SUM(tc.bytes_up), 0), COALESCE(SUM(tc.bytes_down), 0), COUNT(*)
+		FROM target_connections tc
+		JOIN connections c ON c.id = tc.connection_id
+		WHERE tc.connected_at >= ? AND tc.connected_at < ?`
+	args := []interface{}{from.Local().Format("2006-01-02 15:04:05"), to.Local().Format("2006-01-02 15:04:05")}
+	if query.UserID != 0 {
+		sqlQuery += " AND c.user_id = ?"
+		args = append(args, query.UserID)
+	}
+	sqlQuery += " GROUP BY c.user_id, tc.target"
+
+	rows, err := GetDB().Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &TopTalkersReport{From: from, To: to, Total: &TalkerEntry{}}
+	users := newTalkerAggregate(false)
+	hosts := newTalkerAggregate(true)
+	ports := newTalkerAggregate(true)
+	destinations := make(map[int]*talkerAggregate)
+	userIDs := make(map[string]int)
+	for rows.Next() {
+		var userID, connections int
+		var username, target string
+		var bytesUp, bytesDown int64
+		if err := rows.Scan(&userID, &username, &target, &bytesUp, &bytesDown, &connections); err != nil {
+			return nil, err
+		}
+
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			host, port = target, ""
+		}
+
+		users.add(username, userID, bytesUp, bytesDown, connections).UserID = userID
+		userIDs[username] = userID
+		hosts.add(host, userID, bytesUp, bytesDown, connections)
+		ports.add(port, userID, bytesUp, bytesDown, connections)
+		if destinations[userID] == nil {
+			destinations[userID] = newTalkerAggregate(false)
+		}
+		destinations[userID].add(target, userID, bytesUp, bytesDown, connections)
+
+		report.Total.BytesUp += bytesUp
+		report.Total.BytesDown += bytesDown
+		report.Total.Connections += connections
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report.Total.Users = len(users.entries)
+	report.Users = users.top(limit)
+	report.Hosts = hosts.top(limit)
+	report.Ports = ports.top(limit)
+	for _, user := range report.Users {
+		report.UserDestinations = append(report.UserDestinations, &UserDestinations{
+			User:         user,
+			Destinations: destinations[userIDs[user.Key]].top(limit),
+		})
+	}
+	return report, nil
+}
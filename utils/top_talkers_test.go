@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetTopTalkers(t *testing.T) {
+	openTestDB(t)
+	alice := addTestUser(t, "alice", "secret")
+	bob := addTestUser(t, "bob", "secret")
+	base := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+
+	aliceConn := recordTestConnection(t, alice, base.Add(time.Hour))
+	recordTestTargetConnection(t, aliceConn, "a.example:22", base.Add(time.Hour), 100, 100)
+	recordTestTargetConnection(t, aliceConn, "a.example:22", base.Add(time.Hour), 100, 100)
+	recordTestTargetConnection(t, aliceConn, "a.example:443", base.Add(time.Hour), 50, 0)
+	bobConn := recordTestConnection(t, bob, base.Add(time.Hour))
+	recordTestTargetConnection(t, bobConn, "a.example:22", base.Add(time.Hour), 1000, 0)
+	recordTestTargetConnection(t, bobConn, "[::1]:22", base.Add(time.Hour), 10, 10)
+	// 时间段之外的连接不计入
+	recordTestTargetConnection(t, aliceConn, "a.example:22", base.Add(-time.Hour), 99999, 99999)
+	recordTestTargetConnection(t, aliceConn, "a.example:22", base.Add(2*time.Hour), 99999, 99999)
+
+	report, err := GetTopTalkers(&TopTalkersQuery{From: base, To: base.Add(2 * time.Hour), Limit: 2})
+	if err != nil {
+		t.Fatalf("get top talkers: %v", err)
+	}
+	if total := report.Total; total.BytesUp != 1260 || total.BytesDown != 210 || total.Connections != 5 || total.Users != 2 {
+		t.Errorf("got total %+v", total)
+	}
+	if len(report.Users) != 2 || report.Users[0].Key != "bob" || report.Users[0].UserID != bob.ID || report.Users[1].Bytes() != 450 {
+		t.Errorf("got users %+v %+v", report.Users[0], report.Users[1])
+	}
+
+	// 主机和端口从目标地址拆分，IPv6地址去掉方括号，用户数按不同用户计算
+	if len(report.Hosts) != 2 || report.Hosts[0].Key != "a.example" || report.Hosts[0].Users != 2 || report.Hosts[0].Connections != 4 || report.Hosts[1].Key != "::1" {
+		t.Errorf("got hosts %+v %+v", report.Hosts[0], report.Hosts[1])
+	}
+	if len(report.Ports) != 2 || report.Ports[0].Key != "22" || report.Ports[0].Bytes() != 1420 || report.Ports[1].Key != "443" || report.Ports[1].Users != 1 {
+		t.Errorf("got ports %+v %+v", report.Ports[0], report.Ports[1])
+	}
+
+	if len(report.UserDestinations) != 2 {
+		t.Fatalf("got %d user destinations, want 2", len(report.UserDestinations))
+	}
+	aliceDestinations := report.UserDestinations[1]
+	if aliceDestinations.User.Key != "alice" || len(aliceDestinations.Destinations) != 2 ||
+		aliceDestinations.Destinations[0].Key != "a.example:22" || aliceDestinations.Destinations[0].Connections != 2 {
+		t.Errorf("got alice destinations %+v", aliceDestinations.Destinations)
+	}
+
+	// 按用户过滤，限制条目数
+	report, err = GetTopTalkers(&TopTalkersQuery{From: base, To: base.Add(2 * time.Hour), UserID: alice.ID, Limit: 1})
+	if err != nil {
+		t.Fatalf("get top talkers of alice: %v", err)
+	}
+	if report.Total.Bytes() != 450 || len(report.Users) != 1 || len(report.Hosts) != 1 || len(report.Ports) != 1 || report.Ports[0].Key != "22" {
+		t.Errorf("got report for alice: total %+v, users %d, hosts %d, ports %+v", report.Total, len(report.Users), len(report.Hosts), report.Ports)
+	}
+
+	if _, err := GetTopTalkers(&TopTalkersQuery{From: base, To: base}); err == nil {
+		t.Errorf("accepted an empty time range")
+	}
+}
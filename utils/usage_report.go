@@ -0,0 +1,379 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 自动生成使用报表的周期
+const (
+	ReportScheduleDaily   = "daily"   // 每天生成前一天的报表
+	ReportScheduleWeekly  = "weekly"  // 每周一生成上一周（周一至周日）的报表
+	ReportScheduleMonthly = "monthly" // 每月1日生成上个月的报表
+)
+
+// 使用报表的文件格式
+const (
+	ReportFormatCSV  = "csv"
+	ReportFormatJSON = "json"
+)
+
+// usageReportFilePattern 使用报表文件名的格式，下载时用于防止访问报表目录以外的文件
+var usageReportFilePattern = regexp.MustCompile(`^usage_\d{4}-\d{2}-\d{2}_\d{4}-\d{2}-\d{2}\.(csv|json)$`)
+
+// UsageReport 指定时间段内每个用户的使用情况
+type UsageReport struct {
+	From        time.Time    `json:"from"`         // 开始时间（含）
+	To          time.Time    `json:"to"`           // 结束时间（不含）
+	GeneratedAt time.Time    `json:"generated_at"` // 生成时间
+	Users       []*UserUsage `json:"users"`        // 每个用户的使用情况，按用户ID排序
+	Total       *UserUsage   `json:"total"`        // 所有用户合计
+}
+
+// UserUsage 一个用户在报表时间段内的使用情况
+type UserUsage struct {
+	UserID          int    `json:"user_id"`          // 用户ID
+	Username        string `json:"username"`         // 用户名
+	Name            string `json:"name"`             // 姓名
+	Group           string `json:"group"`            // 用户组
+	Sessions        int    `json:"sessions"`         // 与时间段有重叠的SSH连接数
+	DurationSeconds int64  `json:"duration_seconds"` // SSH连接在时间段内的累计时长（秒）
+	BytesUp         int64  `json:"bytes_up"`         // 时间段内建立的目标连接的上行流量（字节）
+	BytesDown       int64  `json:"bytes_down"`       // 时间段内建立的目标连接的下行流量（字节）
+	Destinations    int    `json:"destinations"`     // 时间段内访问过的不同目标地址数
+}
+
+// UsageReportFile 报表目录中已生成的报表文件
+type UsageReportFile struct {
+	Name    string    // 文件名
+	Size    int64     // 文件大小（字节）
+	ModTime time.Time // 生成时间
+}
+
+// UsageReportPeriod 获取指定周期在now之前最近一个完整的报表时间段
+// 参数:
+//   schedule - 报表周期（daily、weekly、monthly）
+//   now - 当前时间
+// 返回:
+//   time.Time - 开始时间（含）
+//   time.Time - 结束时间（不含）
+//   error - 周期无效
+func UsageReportPeriod(schedule string, now time.Time) (time.Time, time.Time, error) {
+	today := DayStart(now)
+	switch schedule {
+	case ReportScheduleDaily:
+		return today.AddDate(0, 0, -1), today, nil
+	case ReportScheduleWeekly:
+		// 以周一为一周的开始
+		weekStart := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return weekStart.AddDate(0, 0, -7), weekStart, nil
+	case ReportScheduleMonthly:
+		monthStart := MonthStart(now)
+		return monthStart.AddDate(0, -1, 0), monthStart, nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid report schedule %q", schedule)
+	}
+}
+
+// ParseReportFormats 解析逗号分隔的报表格式列表
+// 参数: spec - 格式列表，如"csv,json"
+// 返回:
+//   []string - 格式列表
+//   error - 包含无效格式或为空时返回错误
+func ParseReportFormats(spec string) ([]string, error) {
+	formats := splitList(spec)
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no report format specified")
+	}
+	for _, format := range formats {
+		if format != ReportFormatCSV && format != ReportFormatJSON {
+			return nil, fmt.Errorf("invalid report format %q", format)
+		}
+	}
+	return formats, nil
+}
+
+// GenerateUsageReport 统计指定时间段内每个用户的SSH连接数、连接时长、流量和访问过的目标地址数
+// 连接时长只计算与时间段重叠的部分，未记录断开时间的连接按结束时间和当前时间中较早者计算；
+// 流量和目标地址按目标连接的建立时间统计，与流量配额和流量排行一致
+// 参数:
+//   from - 开始时间（含）
+//   to - 结束时间（不含）
+// 返回:
+//   *UsageReport - 使用报表
+//   error - 查询过程中的错误
+func GenerateUsageReport(from, to time.Time) (*UsageReport, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	users, err := GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &UsageReport{From: from, To: to, GeneratedAt: now, Total: &UserUsage{}}
+	usages := make(map[int]*UserUsage)
+	for _, user := range users {
+		usages[user.ID] = &UserUsage{UserID: user.ID, Username: user.Username, Name: user.Name, Group: user.Group}
+	}
+	usageOf := func(userID int, username string) *UserUsage {
+		usage, exists := usages[userID]
+		if !exists {
+			// 连接记录中的用户已不存在
+			usage = &UserUsage{UserID: userID, Username: username}
+			usages[userID] = usage
+		}
+		return usage
+	}
+
+	if err := addUsageReportSessions(from, to, now, usageOf); err != nil {
+		return nil, err
+	}
+	destinations := make(map[*UserUsage]map[string]bool)
+	if err := addUsageReportTraffic(from, to, usageOf, destinations); err != nil {
+		return nil, err
+	}
+
+	allDestinations := make(map[string]bool)
+	for _, usage := range usages {
+		usage.Destinations = len(destinations[usage])
+		for target := range destinations[usage] {
+			allDestinations[target] = true
+		}
+		report.Users = append(report.Users, usage)
+		report.Total.Sessions += usage.Sessions
+		report.Total.DurationSeconds += usage.DurationSeconds
+		report.Total.BytesUp += usage.BytesUp
+		report.Total.BytesDown += usage.BytesDown
+	}
+	report.Total.Destinations = len(allDestinations)
+	sort.Slice(report.Users, func(i, j int) bool {
+		return report.Users[i].UserID < report.Users[j].UserID
+	})
+	return report, nil
+}
+
+// addUsageReportSessions 统计与时间段重叠的SSH连接的连接数和连接时长，只查询重叠的连接
+func addUsageReportSessions(from, to, now time.Time, usageOf func(userID int, username string) *UserUsage) error {
+	rows, err := GetDB().Query(`SELECT user_id, username, connected_at, disconnected_at FROM connections
+		WHERE connected_at < ? AND (disconnected_at IS NULL OR disconnected_at >= ?)`,
+		to.Local().Format("2006-01-02 15:04:05"), from.Local().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		var username, connectedAtStr string
+		var disconnectedAtStr *string
+		if err := rows.Scan(&userID, &username, &connectedAtStr, &disconnectedAtStr); err != nil {
+			return err
+		}
+
+		// 数据库中的时间为本地时间
+		connectedAt, err := parseNullableTime(&connectedAtStr)
+		if err != nil {
+			return err
+		}
+		disconnectedAt, err := parseNullableTime(disconnectedAtStr)
+		if err != nil {
+			return err
+		}
+
+		start, end := *connectedAt, to
+		if disconnectedAt != nil {
+			end = *disconnectedAt
+		} else if now.Before(end) {
+			end = now
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+
+		usage := usageOf(userID, username)
+		usage.Sessions++
+		if end.After(start) {
+			usage.DurationSeconds += int64(end.Sub(start).Seconds())
+		}
+	}
+	return rows.Err()
+}
+
+// addUsageReportTraffic 按用户和目标地址汇总时间段内建立的目标连接的流量
+// 参数: destinations - 每个用户访问过的目标地址，由本函数填写
+func addUsageReportTraffic(from, to time.Time, usageOf func(userID int, username string) *UserUsage, destinations map[*UserUsage]map[string]bool) error {
+	rows, err := GetDB().Query(`SELECT c.user_id, MAX(c.username), tc.target, COALESCE(SUM(tc.bytes_up), 0), COALESCE(SUM(tc.bytes_down), 0)
+		FROM target_connections tc
+		JOIN connections c ON c.id = tc.connection_id
+		WHERE tc.connected_at >= ? AND tc.connected_at < ?
+		GROUP BY c.user_id, tc.target`,
+		from.Local().Format("2006-01-02 15:04:05"), to.Local().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		var username, target string
+		var bytesUp, bytesDown int64
+		if err := rows.Scan(&userID, &username, &target, &bytesUp, &bytesDown); err != nil {
+			return err
+		}
+
+		usage := usageOf(userID, username)
+		usage.BytesUp += bytesUp
+		usage.BytesDown += bytesDown
+		if destinations[usage] == nil {
+			destinations[usage] = make(map[string]bool)
+		}
+		destinations[usage][target] = true
+	}
+	return rows.Err()
+}
+
+// WriteUsageReportCSV 以CSV格式输出使用报表，最后一行为合计
+func WriteUsageReportCSV(w io.Writer, report *UsageReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"user_id", "username", "name", "group", "sessions", "duration_seconds", "duration", "bytes_up", "bytes_down", "bytes_total", "destinations"})
+	row := func(userID, username, name, group string, usage *UserUsage) {
+		writer.Write([]string{
+			userID, username, name, group,
+			strconv.Itoa(usage.Sessions),
+			strconv.FormatInt(usage.DurationSeconds, 10),
+			formatReportDuration(usage.DurationSeconds),
+			strconv.FormatInt(usage.BytesUp, 10),
+			strconv.FormatInt(usage.BytesDown, 10),
+			strconv.FormatInt(usage.BytesUp+usage.BytesDown, 10),
+			strconv.Itoa(usage.Destinations),
+		})
+	}
+	for _, usage := range report.Users {
+		row(strconv.Itoa(usage.UserID), usage.Username, usage.Name, usage.Group, usage)
+	}
+	row("", "TOTAL", "", "", report.Total)
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteUsageReportJSON 以JSON格式输出使用报表
+func WriteUsageReportJSON(w io.Writer, report *UsageReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// formatReportDuration 将秒数格式化为"小时:分:秒"
+func formatReportDuration(seconds int64) string {
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// UsageReportFileName 获取报表文件名，如usage_2026-09-01_2026-09-30.csv（结束日期为包含的最后一天）
+func UsageReportFileName(from, to time.Time, format string) string {
+	return fmt.Sprintf("usage_%s_%s.%s", from.Format("2006-01-02"), to.Add(-time.Nanosecond).Format("2006-01-02"), format)
+}
+
+// IsUsageReportFileName 判断文件名是否为报表文件名
+func IsUsageReportFileName(name string) bool {
+	return usageReportFilePattern.MatchString(name)
+}
+
+// SaveUsageReport 将使用报表按指定格式写入报表目录，已存在的同名文件会被覆盖
+// 参数:
+//   report - 使用报表
+//   dir - 报表目录，不存在时自动创建
+//   formats - 文件格式列表
+// 返回:
+//   []string - 写入的文件路径
+//   error - 写入过程中的错误
+func SaveUsageReport(report *UsageReport, dir string, formats []string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, format := range formats {
+		path := filepath.Join(dir, UsageReportFileName(report.From, report.To, format))
+
+		// 先写入临时文件再重命名，避免读取到不完整的报表
+		tmp, err := os.CreateTemp(dir, ".usage-*.tmp")
+		if err != nil {
+			return paths, err
+		}
+		// CreateTemp创建的文件只有所有者可读
+		if err = tmp.Chmod(0644); err == nil {
+			switch format {
+			case ReportFormatCSV:
+				err = WriteUsageReportCSV(tmp, report)
+			case ReportFormatJSON:
+				err = WriteUsageReportJSON(tmp, report)
+			default:
+				err = fmt.Errorf("invalid report format %q", format)
+			}
+		}
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// UsageReportExists 判断报表目录中是否已有指定时间段和格式的报表
+func UsageReportExists(dir string, from, to time.Time, format string) bool {
+	_, err := os.Stat(filepath.Join(dir, UsageReportFileName(from, to, format)))
+	return err == nil
+}
+
+// ListUsageReportFiles 列出报表目录中的报表文件，按文件名倒序（最近的时间段在前）
+// 参数: dir - 报表目录，不存在时返回空列表
+// 返回:
+//   []*UsageReportFile - 报表文件列表
+//   error - 读取目录时的错误
+func ListUsageReportFiles(dir string) ([]*UsageReportFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*UsageReportFile
+	for _, entry := range entries {
+		if entry.IsDir() || !IsUsageReportFileName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, &UsageReportFile{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name > files[j].Name
+	})
+	return files, nil
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// recordTestSession 写入用户的SSH连接记录，disconnectedAt为零值时表示连接未断开
+func recordTestSession(t *testing.T, user *models.User, connectedAt, disconnectedAt time.Time) int {
+	t.Helper()
+	id := recordTestConnection(t, user, connectedAt)
+	if !disconnectedAt.IsZero() {
+		sessionID := user.Username + connectedAt.Format(time.RFC3339Nano)
+		if err := UpdateConnectionDisconnectTime(sessionID, disconnectedAt, models.DisconnectReasonClient); err != nil {
+			t.Fatalf("update disconnect time: %v", err)
+		}
+	}
+	return id
+}
+
+func TestUsageReportPeriod(t *testing.T) {
+	// 2026-03-11是周三
+	now := time.Date(2026, 3, 11, 15, 0, 0, 0, time.Local)
+	tests := []struct {
+		schedule string
+		from, to time.Time
+	}{
+		{ReportScheduleDaily, time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local), time.Date(2026, 3, 11, 0, 0, 0, 0, time.Local)},
+		{ReportScheduleWeekly, time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local), time.Date(2026, 3, 9, 0, 0, 0, 0, time.Local)},
+		{ReportScheduleMonthly, time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		from, to, err := UsageReportPeriod(tt.schedule, now)
+		if err != nil || !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("%s: got (%v, %v, %v), want (%v, %v)", tt.schedule, from, to, err, tt.from, tt.to)
+		}
+	}
+	if _, _, err := UsageReportPeriod("yearly", now); err == nil {
+		t.Errorf("accepted an invalid schedule")
+	}
+
+	if formats, err := ParseReportFormats("csv, json"); err != nil || len(formats) != 2 {
+		t.Errorf("got formats %v, %v", formats, err)
+	}
+	for _, spec := range []string{"", "csv,xml"} {
+		if _, err := ParseReportFormats(spec); err == nil {
+			t.Errorf("accepted formats %q", spec)
+		}
+	}
+}
+
+func TestGenerateUsageReport(t *testing.T) {
+	openTestDB(t)
+	alice := addTestUser(t, "alice", "secret")
+	bob := addTestUser(t, "bob", "secret")
+	from := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 0, 1)
+
+	// 跨越开始时间的连接只计算时间段内的1小时，未断开的连接计算到结束时间
+	first := recordTestSession(t, alice, from.Add(-time.Hour), from.Add(time.Hour))
+	second := recordTestSession(t, alice, to.Add(-30*time.Minute), time.Time{})
+	recordTestTargetConnection(t, first, "a:22", from.Add(30*time.Minute), 100, 200)
+	recordTestTargetConnection(t, second, "b:22", to.Add(-20*time.Minute), 10, 0)
+	recordTestTargetConnection(t, second, "a:22", to.Add(-10*time.Minute), 1, 1)
+
+	// 时间段之前的连接和流量不计入
+	earlier := recordTestSession(t, bob, from.Add(-14*time.Hour), from.Add(-13*time.Hour))
+	recordTestTargetConnection(t, earlier, "a:22", from.Add(-13*time.Hour), 1000, 1000)
+
+	report, err := GenerateUsageReport(from, to)
+	if err != nil {
+		t.Fatalf("generate usage report: %v", err)
+	}
+	if len(report.Users) != 2 || report.Users[0].UserID != alice.ID || report.Users[1].UserID != bob.ID {
+		t.Fatalf("got users %+v", report.Users)
+	}
+	want := UserUsage{UserID: alice.ID, Username: "alice", Name: "alice", Sessions: 2, DurationSeconds: 5400, BytesUp: 111, BytesDown: 201, Destinations: 2}
+	if *report.Users[0] != want {
+		t.Errorf("got alice %+v, want %+v", report.Users[0], want)
+	}
+	if bobUsage := report.Users[1]; bobUsage.Sessions != 0 || bobUsage.BytesUp != 0 {
+		t.Errorf("got bob %+v, want no usage", bobUsage)
+	}
+	if total := report.Total; total.Sessions != 2 || total.DurationSeconds != 5400 || total.BytesUp != 111 || total.Destinations != 2 {
+		t.Errorf("got total %+v", total)
+	}
+
+	var csvOut bytes.Buffer
+	if err := WriteUsageReportCSV(&csvOut, report); err != nil {
+		t.Fatalf("write CSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "user_id,username,") || lines[3] != ",TOTAL,,,2,5400,1:30:00,111,201,312,2" {
+		t.Errorf("got CSV %q", csvOut.String())
+	}
+
+	var jsonOut bytes.Buffer
+	if err := WriteUsageReportJSON(&jsonOut, report); err != nil {
+		t.Fatalf("write JSON: %v", err)
+	}
+	var decoded UsageReport
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode JSON: %v", err)
+	}
+	if len(decoded.Users) != 2 || *decoded.Users[0] != want || !decoded.From.Equal(from) {
+		t.Errorf("got decoded report %+v", decoded)
+	}
+
+	if _, err := GenerateUsageReport(to, from); err == nil {
+		t.Errorf("accepted from after to")
+	}
+}
+
+func TestSaveUsageReport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "reports")
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)
+	report := &UsageReport{From: from, To: from.AddDate(0, 1, 0), Total: &UserUsage{}}
+
+	paths, err := SaveUsageReport(report, dir, []string{ReportFormatCSV, ReportFormatJSON})
+	if err != nil {
+		t.Fatalf("save usage report: %v", err)
+	}
+	// 文件名中的结束日期为包含的最后一天
+	if len(paths) != 2 || filepath.Base(paths[0]) != "usage_2026-03-01_2026-03-31.csv" {
+		t.Errorf("got paths %v", paths)
+	}
+	if !UsageReportExists(dir, report.From, report.To, ReportFormatJSON) {
+		t.Errorf("JSON report not found")
+	}
+
+	// 报表目录中的其他文件不会被列出
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+	files, err := ListUsageReportFiles(dir)
+	if err != nil {
+		t.Fatalf("list report files: %v", err)
+	}
+	if len(files) != 2 || files[0].Name != "usage_2026-03-01_2026-03-31.json" {
+		t.Errorf("got files %+v", files)
+	}
+	for _, name := range []string{"../usage_2026-03-01_2026-03-31.csv", "usage_2026-03-01_2026-03-31.txt"} {
+		if IsUsageReportFileName(name) {
+			t.Errorf("%q accepted as a report file name", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"database/sql"
+	"ssh-manage/models"
+	"time"
+)
+
+// AddUserKey 为用户添加公钥
+// 参数: key - 公钥信息（需已填写UserID、PublicKey和Fingerprint）
+// 返回:
+//   int - 新公钥的数据库ID
+//   error - 添加过程中的错误
+func AddUserKey(key *models.UserKey) (int, error) {
+	db := GetDB()
+
+	result, err := db.Exec("INSERT INTO user_keys (user_id, label, public_key, fingerprint, created) VALUES (?, ?, ?, ?, ?)",
+		key.UserID, key.Label, key.PublicKey, key.Fingerprint, key.Created.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetUserKeysByUserID 获取指定用户的所有公钥
+// 参数: userID - 用户ID
+// 返回:
+//   []*models.UserKey - 公钥列表
+//   error - 查询过程中的错误
+func GetUserKeysByUserID(userID int) ([]*models.UserKey, error) {
+	return queryUserKeys("SELECT id, user_id, label, public_key, fingerprint, created FROM user_keys WHERE user_id = ? ORDER BY id", userID)
+}
+
+// GetAllUserKeys 获取所有用户的公钥
+// 返回:
+//   []*models.UserKey - 公钥列表
+//   error - 查询过程中的错误
+func GetAllUserKeys() ([]*models.UserKey, error) {
+	return queryUserKeys("SELECT id, user_id, label, public_key, fingerprint, created FROM user_keys ORDER BY user_id, id")
+}
+
+// GetUserKeyByFingerprint 根据用户ID和指纹获取公钥
+// 参数:
+//   userID - 用户ID
+//   fingerprint - 公钥SHA256指纹
+// 返回:
+//   *models.UserKey - 公钥信息
+//   error - 查询过程中的错误（未找到时为sql.ErrNoRows）
+func GetUserKeyByFingerprint(userID int, fingerprint string) (*models.UserKey, error) {
+	keys, err := queryUserKeys("SELECT id, user_id, label, public_key, fingerprint, created FROM user_keys WHERE user_id = ? AND fingerprint = ?", userID, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return keys[0], nil
+}
+
+// DeleteUserKey 撤销（删除）公钥
+// 参数: id - 公钥ID
+// 返回: error - 删除过程中的错误，公钥不存在时返回sql.ErrNoRows
+func DeleteUserKey(id int) error {
+	db := GetDB()
+	result, err := db.Exec("DELETE FROM user_keys WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// queryUserKeys 执行公钥查询并解析结果
+func queryUserKeys(query string, args ...interface{}) ([]*models.UserKey, error) {
+	db := GetDB()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.UserKey
+	for rows.Next() {
+		var key models.UserKey
+		var created string
+		err := rows.Scan(&key.ID, &key.UserID, &key.Label, &key.PublicKey, &key.Fingerprint, &created)
+		if err != nil {
+			return nil, err
+		}
+
+		// 解析时间
+		key.Created, err = time.Parse("2006-01-02 15:04:05", created)
+		if err != nil {
+			// 尝试其他时间格式
+			key.Created, err = time.Parse(time.RFC3339, created)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+	"ssh-manage/models"
+)
+
+// newTestUserKey 构造指定用户和指纹的公钥记录
+func newTestUserKey(userID int, fingerprint string) *models.UserKey {
+	return &models.UserKey{UserID: userID, Label: "laptop", PublicKey: "ssh-ed25519 AAAA", Fingerprint: fingerprint, Created: time.Now()}
+}
+
+func TestUserKeyFingerprintUnique(t *testing.T) {
+	openTestDB(t)
+	alice := addTestUser(t, "alice", "x")
+	bob := addTestUser(t, "bob", "x")
+
+	if _, err := AddUserKey(newTestUserKey(alice.ID, "SHA256:one")); err != nil {
+		t.Fatalf("add key: %v", err)
+	}
+	// 同一用户不能重复登记同一公钥
+	if _, err := AddUserKey(newTestUserKey(alice.ID, "SHA256:one")); err == nil {
+		t.Fatalf("duplicate fingerprint accepted for the same user")
+	}
+	// 不同用户可以登记同一公钥
+	if _, err := AddUserKey(newTestUserKey(bob.ID, "SHA256:one")); err != nil {
+		t.Fatalf("add the same key for another user: %v", err)
+	}
+
+	key, err := GetUserKeyByFingerprint(bob.ID, "SHA256:one")
+	if err != nil || key.UserID != bob.ID {
+		t.Fatalf("got %+v, %v looking up bob's key", key, err)
+	}
+	if _, err := GetUserKeyByFingerprint(alice.ID, "SHA256:two"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got error %v for an unknown fingerprint, want sql.ErrNoRows", err)
+	}
+	if keys, err := GetUserKeysByUserID(alice.ID); err != nil || len(keys) != 1 {
+		t.Errorf("got %d keys, %v for alice, want 1", len(keys), err)
+	}
+}
+
+func TestDeleteUserKey(t *testing.T) {
+	openTestDB(t)
+	user := addTestUser(t, "alice", "x")
+
+	id, err := AddUserKey(newTestUserKey(user.ID, "SHA256:one"))
+	if err != nil {
+		t.Fatalf("add key: %v", err)
+	}
+	if err := DeleteUserKey(id); err != nil {
+		t.Fatalf("delete key: %v", err)
+	}
+	if _, err := GetUserKeyByFingerprint(user.ID, "SHA256:one"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("key still found after delete: %v", err)
+	}
+	if err := DeleteUserKey(id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got error %v deleting a missing key, want sql.ErrNoRows", err)
+	}
+}
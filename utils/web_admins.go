@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"ssh-manage/config"
+	"ssh-manage/models"
+)
+
+// webAdminRoleRanks 管理员角色的权限等级，等级高的角色包含等级低的角色的所有权限
+var webAdminRoleRanks = map[string]int{
+	models.WebAdminRoleViewer:   1,
+	models.WebAdminRoleOperator: 2,
+	models.WebAdminRoleAdmin:    3,
+}
+
+// ErrLastWebAdmin 删除或降级最后一个admin角色的账号
+var ErrLastWebAdmin = errors.New("at least one admin account is required")
+
+// ValidWebAdminRole 判断是否为有效的管理员角色
+func ValidWebAdminRole(role string) bool {
+	_, ok := webAdminRoleRanks[role]
+	return ok
+}
+
+// WebAdminRoleAllows 判断角色是否具有required角色的权限
+// 参数:
+//   role - 管理员的角色
+//   required - 操作需要的角色
+// 返回: bool - 是否允许
+func WebAdminRoleAllows(role, required string) bool {
+	rank, ok := webAdminRoleRanks[role]
+	return ok && rank >= webAdminRoleRanks[required]
+}
+
+// createWebAdminTable 创建管理员账号表，表中没有账号时用WEB_USERNAME/WEB_PASSWORD创建一个admin账号
+func createWebAdminTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS web_admins (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		password TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM web_admins").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	cfg := config.Load()
+	passwordHash, err := HashPassword(cfg.WebPassword)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO web_admins (username, password, role, created) VALUES (?, ?, ?, ?)",
+		cfg.WebUsername, passwordHash, models.WebAdminRoleAdmin, time.Now().Format("2006-01-02 15:04:05")); err != nil {
+		return err
+	}
+	log.Printf("Created web admin account %s from WEB_USERNAME/WEB_PASSWORD", cfg.WebUsername)
+	return nil
+}
+
+// webAdminColumns 查询管理员账号时选择的列，与scanWebAdmin的顺序一致
+const webAdminColumns = "id, username, password, role, created"
+
+// scanWebAdmin 从查询结果中解析管理员账号
+func scanWebAdmin(row rowScanner) (*models.WebAdmin, error) {
+	var admin models.WebAdmin
+	var createdStr string
+	if err := row.Scan(&admin.ID, &admin.Username, &admin.Password, &admin.Role, &createdStr); err != nil {
+		return nil, err
+	}
+
+	created, err := parseNullableTime(&createdStr)
+	if err != nil {
+		return nil, err
+	}
+	admin.Created = *created
+	return &admin, nil
+}
+
+// GetWebAdmins 获取所有管理员账号，按ID排序
+// 返回:
+//   []*models.WebAdmin - 管理员账号列表
+//   error - 查询过程中的错误
+func GetWebAdmins() ([]*models.WebAdmin, error) {
+	rows, err := GetDB().Query("SELECT " + webAdminColumns + " FROM web_admins ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var admins []*models.WebAdmin
+	for rows.Next() {
+		admin, err := scanWebAdmin(rows)
+		if err != nil {
+			return nil, err
+		}
+		admins = append(admins, admin)
+	}
+	return admins, rows.Err()
+}
+
+// GetWebAdminByUsername 根据用户名获取管理员账号
+// 返回: error - 账号不存在时返回sql.ErrNoRows
+func GetWebAdminByUsername(username string) (*models.WebAdmin, error) {
+	return scanWebAdmin(GetDB().QueryRow("SELECT "+webAdminColumns+" FROM web_admins WHERE username = ?", username))
+}
+
+// AddWebAdmin 添加管理员账号
+// 参数: admin - 管理员账号，Password为bcrypt哈希
+// 返回: error - 参数无效、用户名已存在或保存过程中的错误
+func AddWebAdmin(admin *models.WebAdmin) error {
+	admin.Username = strings.TrimSpace(admin.Username)
+	if admin.Username == "" || admin.Password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+	if !ValidWebAdminRole(admin.Role) {
+		return fmt.Errorf("invalid role %q", admin.Role)
+	}
+	if _, err := GetWebAdminByUsername(admin.Username); err == nil {
+		return fmt.Errorf("web admin %s already exists", admin.Username)
+	}
+
+	admin.Created = time.Now()
+	result, err := GetDB().Exec("INSERT INTO web_admins (username, password, role, created) VALUES (?, ?, ?, ?)",
+		admin.Username, admin.Password, admin.Role, admin.Created.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		admin.ID = int(id)
+	}
+	return nil
+}
+
+// UpdateWebAdminRole 修改管理员的角色，不能降级最后一个admin账号
+// 参数:
+//   id - 账号ID
+//   role - 新角色
+// 返回: error - 修改过程中的错误，账号不存在时返回sql.ErrNoRows
+func UpdateWebAdminRole(id int, role string) error {
+	if !ValidWebAdminRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	return withOtherAdminCheck(id, role != models.WebAdminRoleAdmin, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec("UPDATE web_admins SET role = ? WHERE id = ?", role, id)
+	})
+}
+
+// SetWebAdminPassword 修改管理员的密码
+// 参数:
+//   id - 账号ID
+//   passwordHash - bcrypt哈希后的新密码
+// 返回: error - 修改过程中的错误，账号不存在时返回sql.ErrNoRows
+func SetWebAdminPassword(id int, passwordHash string) error {
+	result, err := GetDB().Exec("UPDATE web_admins SET password = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteWebAdmin 删除管理员账号，不能删除最后一个admin账号
+// 参数: id - 账号ID
+// 返回: error - 删除过程中的错误，账号不存在时返回sql.ErrNoRows
+func DeleteWebAdmin(id int) error {
+	return withOtherAdminCheck(id, true, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec("DELETE FROM web_admins WHERE id = ?", id)
+	})
+}
+
+// withOtherAdminCheck 在事务中执行对账号id的修改；removesAdmin为true时，如果修改后不再有其他admin账号则返回ErrLastWebAdmin
+func withOtherAdminCheck(id int, removesAdmin bool, change func(tx *sql.Tx) (sql.Result, error)) error {
+	tx, err := GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if removesAdmin {
+		var others int
+		err := tx.QueryRow("SELECT COUNT(*) FROM web_admins WHERE role = ? AND id != ?", models.WebAdminRoleAdmin, id).Scan(&others)
+		if err != nil {
+			return err
+		}
+		if others == 0 {
+			return ErrLastWebAdmin
+		}
+	}
+
+	result, err := change(tx)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
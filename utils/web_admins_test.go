@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"database/sql"
+	"testing"
+	"ssh-manage/models"
+)
+
+// addTestWebAdmin 添加指定角色的管理员账号，返回包含数据库ID的账号信息
+func addTestWebAdmin(t *testing.T, username, role string) *models.WebAdmin {
+	t.Helper()
+	admin := &models.WebAdmin{Username: username, Password: "hash", Role: role}
+	if err := AddWebAdmin(admin); err != nil {
+		t.Fatalf("add web admin %s: %v", username, err)
+	}
+	return admin
+}
+
+func TestWebAdminRoleAllows(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{models.WebAdminRoleViewer, models.WebAdminRoleViewer, true},
+		{models.WebAdminRoleViewer, models.WebAdminRoleOperator, false},
+		{models.WebAdminRoleOperator, models.WebAdminRoleOperator, true},
+		{models.WebAdminRoleOperator, models.WebAdminRoleAdmin, false},
+		{models.WebAdminRoleAdmin, models.WebAdminRoleViewer, true},
+		{models.WebAdminRoleAdmin, models.WebAdminRoleAdmin, true},
+		{"", models.WebAdminRoleViewer, false},
+		{"root", models.WebAdminRoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := WebAdminRoleAllows(tt.role, tt.required); got != tt.want {
+			t.Errorf("WebAdminRoleAllows(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestAddWebAdmin(t *testing.T) {
+	openTestDB(t)
+	admins, err := GetWebAdmins()
+	if err != nil {
+		t.Fatalf("get web admins: %v", err)
+	}
+	// 首次初始化时创建一个admin账号
+	if len(admins) != 1 || admins[0].Role != models.WebAdminRoleAdmin {
+		t.Fatalf("got initial admins %+v", admins)
+	}
+
+	viewer := addTestWebAdmin(t, " viewer ", models.WebAdminRoleViewer)
+	if viewer.ID == 0 || viewer.Username != "viewer" {
+		t.Errorf("got %+v", viewer)
+	}
+	invalid := []*models.WebAdmin{
+		{Username: "viewer", Password: "hash", Role: models.WebAdminRoleViewer},
+		{Username: "other", Password: "hash", Role: "root"},
+		{Username: "other", Role: models.WebAdminRoleViewer},
+	}
+	for _, admin := range invalid {
+		if err := AddWebAdmin(admin); err == nil {
+			t.Errorf("added %+v", admin)
+		}
+	}
+}
+
+func TestLastWebAdminGuard(t *testing.T) {
+	openTestDB(t)
+	admins, err := GetWebAdmins()
+	if err != nil || len(admins) != 1 {
+		t.Fatalf("get initial admin: %v", err)
+	}
+	first := admins[0]
+	viewer := addTestWebAdmin(t, "viewer", models.WebAdminRoleViewer)
+
+	// 唯一的admin账号不能降级或删除，其他账号不受影响
+	if err := UpdateWebAdminRole(first.ID, models.WebAdminRoleOperator); err != ErrLastWebAdmin {
+		t.Errorf("demote last admin: got %v, want ErrLastWebAdmin", err)
+	}
+	if err := DeleteWebAdmin(first.ID); err != ErrLastWebAdmin {
+		t.Errorf("delete last admin: got %v, want ErrLastWebAdmin", err)
+	}
+	if err := UpdateWebAdminRole(viewer.ID, models.WebAdminRoleOperator); err != nil {
+		t.Errorf("promote viewer: %v", err)
+	}
+
+	// 有其他admin账号后可以降级，之后新的admin成为唯一的admin
+	if err := UpdateWebAdminRole(viewer.ID, models.WebAdminRoleAdmin); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+	if err := UpdateWebAdminRole(first.ID, models.WebAdminRoleViewer); err != nil {
+		t.Fatalf("demote first admin: %v", err)
+	}
+	if err := DeleteWebAdmin(viewer.ID); err != ErrLastWebAdmin {
+		t.Errorf("delete new last admin: got %v, want ErrLastWebAdmin", err)
+	}
+	if err := DeleteWebAdmin(first.ID); err != nil {
+		t.Errorf("delete demoted admin: %v", err)
+	}
+
+	if err := UpdateWebAdminRole(99, models.WebAdminRoleAdmin); err != sql.ErrNoRows {
+		t.Errorf("update missing admin: got %v, want sql.ErrNoRows", err)
+	}
+	if err := DeleteWebAdmin(99); err != sql.ErrNoRows {
+		t.Errorf("delete missing admin: got %v, want sql.ErrNoRows", err)
+	}
+	if err := UpdateWebAdminRole(viewer.ID, "root"); err == nil {
+		t.Errorf("accepted an invalid role")
+	}
+}
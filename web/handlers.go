@@ -6,9 +6,12 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"ssh-manage/api"
 	"ssh-manage/config"
 	"ssh-manage/models"
 	"ssh-manage/services"
@@ -16,15 +19,22 @@ import (
 )
 
 func Handler(w http.ResponseWriter, r *http.Request) {
-	// 添加基础认证
-	cfg := config.Load()
-	if !checkAuth(r, cfg.WebUsername, cfg.WebPassword) {
+	// 添加基础认证，使用管理员账号登录
+	admin, ok := checkAuth(r)
+	if !ok {
 		w.Header().Set("WWW-Authenticate", `Basic realm="SSH Manage"`)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Unauthorized\n"))
 		return
 	}
 	
+	// 检查管理员的角色是否允许该操作
+	if required := requiredRole(r); !utils.WebAdminRoleAllows(admin.Role, required) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Forbidden: this page or action requires the " + required + " role\n"))
+		return
+	}
+
 	switch r.URL.Path {
 	case "/":
 		if r.Method == "POST" {
@@ -36,30 +46,90 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		serveConnectionsPage(w, r)
 	case "/stats":
 		serveStatsPage(w, r)
+	case "/stats/traffic":
+		serveTrafficSeriesAPI(w, r)
+	case "/top-talkers":
+		serveTopTalkersPage(w, r)
+	case "/top-talkers/data":
+		serveTopTalkersAPI(w, r)
+	case "/reports":
+		serveUsageReportsPage(w, r)
+	case "/reports/usage":
+		serveUsageReportAPI(w, r)
+	case "/reports/download":
+		serveUsageReportDownload(w, r)
+	case "/tokens":
+		serveAPITokensPage(w, r)
+	case "/admins":
+		serveWebAdminsPage(w, r, admin)
 	case "/firewall":
 		if r.Method == "POST" {
 			serveFirewallPage(w, r)
 		} else {
 			serveFirewallPage(w, r)
 		}
+	case "/firewall/simulate":
+		serveFirewallSimulateAPI(w, r)
+	case "/sessions":
+		serveSessionsPage(w, r)
+	case "/sessions/live":
+		serveLiveSessionsAPI(w, r)
+	case "/sessions/kill":
+		serveKillSessionAPI(w, r)
+	case "/dashboard":
+		serveDashboardPage(w, r)
+	case "/dashboard/events":
+		serveDashboardEvents(w, r)
+	case "/metrics":
+		serveMetrics(w, r)
 	default:
 		http.NotFound(w, r)
 	}
 }
 
 // checkAuth 检查基础认证
-func checkAuth(r *http.Request, username, password string) bool {
-	// 如果用户名或密码为空，则不启用认证
-	if username == "" || password == "" {
-		return true
-	}
-	
+// 返回:
+//   *models.WebAdmin - 登录的管理员账号
+//   bool - 是否认证通过
+func checkAuth(r *http.Request) (*models.WebAdmin, bool) {
 	user, pass, ok := r.BasicAuth()
 	if !ok {
-		return false
+		return nil, false
+	}
+
+	admin, err := services.AuthenticateWebAdmin(user, pass)
+	if err != nil {
+		return nil, false
+	}
+	return admin, true
+}
+
+// requiredRole 返回请求需要的最低角色
+// 查看页面只需要viewer；断开会话、激活/停用用户、生成报表和模拟防火墙规则需要operator；
+// 其他修改操作以及API令牌和管理员账号页面需要admin
+func requiredRole(r *http.Request) string {
+	switch r.URL.Path {
+	case "/tokens", "/admins":
+		return models.WebAdminRoleAdmin
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return models.WebAdminRoleViewer
 	}
 	
-	return user == username && pass == password
+	switch r.URL.Path {
+	case "/":
+		if r.FormValue("action") == "toggle_active" {
+			return models.WebAdminRoleOperator
+		}
+	case "/sessions", "/sessions/kill", "/reports", "/firewall/simulate":
+		return models.WebAdminRoleOperator
+	case "/firewall":
+		// 模拟不修改规则，但需要解析大量历史主机名，不开放给viewer
+		if r.FormValue("action") == "simulate" {
+			return models.WebAdminRoleOperator
+		}
+	}
+	return models.WebAdminRoleAdmin
 }
 
 func serveUsersPage(w http.ResponseWriter, r *http.Request) {
@@ -74,6 +144,7 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
 			username := r.FormValue("username")
 			password := r.FormValue("password")
 			activeStr := r.FormValue("active")
+			group := strings.TrimSpace(r.FormValue("group"))
 			
 			if name != "" && username != "" && password != "" {
 				active := activeStr == "true"
@@ -83,6 +154,7 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
 					Username: username,
 					Password: password,
 					Active:   active,
+					Group:    group,
 					Created:  time.Now(),
 				}
 				
@@ -100,7 +172,108 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
 					user := services.GetUserByID(userID)
 					if user != nil {
 						user.Active = !user.Active
-						services.UpdateUser(user)
+						if err := services.UpdateUser(user); err != nil {
+							log.Printf("Failed to update active state for user %d: %v", userID, err)
+						} else if !user.Active {
+							// 与REST API一致，停用后立即断开该用户的所有SSH连接
+							api.CloseUserSessions(userID)
+						}
+					}
+				}
+			}
+
+		case "update_group":
+			// 处理修改用户组
+			userIDStr := r.FormValue("user_id")
+			if userID, err := strconv.Atoi(userIDStr); err == nil {
+				if err := services.UpdateUserGroup(userID, r.FormValue("group")); err != nil {
+					log.Printf("Failed to update group for user %d: %v", userID, err)
+				}
+			}
+
+		case "update_forward_policy":
+			// 处理更新远程端口转发策略
+			userIDStr := r.FormValue("user_id")
+			maxForwards, _ := strconv.Atoi(r.FormValue("max_remote_forwards"))
+			autoPort := r.FormValue("remote_forward_auto_port") == "true"
+
+			if userID, err := strconv.Atoi(userIDStr); err == nil {
+				err := services.UpdateRemoteForwardPolicy(userID, r.FormValue("remote_forward_addrs"), r.FormValue("remote_forward_ports"), maxForwards, autoPort)
+				if err != nil {
+					log.Printf("Failed to update remote forward policy for user %d: %v", userID, err)
+				}
+			}
+
+		case "update_quota":
+			// 处理更新流量配额（表单以MB为单位）
+			userIDStr := r.FormValue("user_id")
+			dailyMB, _ := strconv.ParseInt(r.FormValue("daily_quota_mb"), 10, 64)
+			monthlyMB, _ := strconv.ParseInt(r.FormValue("monthly_quota_mb"), 10, 64)
+
+			if userID, err := strconv.Atoi(userIDStr); err == nil {
+				err := services.UpdateUserQuota(userID, dailyMB*1024*1024, monthlyMB*1024*1024, r.FormValue("quota_action"))
+				if err != nil {
+					log.Printf("Failed to update traffic quota for user %d: %v", userID, err)
+				}
+			}
+
+		case "update_rate_limit":
+			// 处理更新带宽限速（表单以KB/s为单位）
+			userIDStr := r.FormValue("user_id")
+			upKB, _ := strconv.ParseInt(r.FormValue("rate_limit_up_kb"), 10, 64)
+			downKB, _ := strconv.ParseInt(r.FormValue("rate_limit_down_kb"), 10, 64)
+
+			if userID, err := strconv.Atoi(userIDStr); err == nil {
+				if err := services.UpdateUserRateLimit(userID, upKB*1024, downKB*1024); err != nil {
+					log.Printf("Failed to update rate limit for user %d: %v", userID, err)
+				}
+			}
+
+		case "update_concurrency_limits":
+			// 处理更新并发会话数和通道数上限
+			userIDStr := r.FormValue("user_id")
+			maxSessions, _ := strconv.Atoi(r.FormValue("max_sessions"))
+			maxChannels, _ := strconv.Atoi(r.FormValue("max_channels"))
+
+			if userID, err := strconv.Atoi(userIDStr); err == nil {
+				if err := services.UpdateUserConcurrencyLimits(userID, maxSessions, maxChannels); err != nil {
+					log.Printf("Failed to update concurrency limits for user %d: %v", userID, err)
+				}
+			}
+
+		case "update_session_timeouts":
+			// 处理更新会话超时（表单以分钟为单位）
+			userIDStr := r.FormValue("user_id")
+			idleTimeout, _ := strconv.Atoi(r.FormValue("idle_timeout"))
+			maxSessionDuration, _ := strconv.Atoi(r.FormValue("max_session_duration"))
+
+			if userID, err := strconv.Atoi(userIDStr); err == nil {
+				if err := services.UpdateUserSessionTimeouts(userID, idleTimeout, maxSessionDuration); err != nil {
+					log.Printf("Failed to update session timeouts for user %d: %v", userID, err)
+				}
+			}
+
+		case "add_key":
+			// 处理为用户添加公钥
+			userIDStr := r.FormValue("user_id")
+			label := r.FormValue("label")
+			publicKey := r.FormValue("public_key")
+
+			if userIDStr != "" && publicKey != "" {
+				if userID, err := strconv.Atoi(userIDStr); err == nil {
+					if _, err := services.AddUserKey(userID, label, publicKey); err != nil {
+						log.Printf("Failed to add public key for user %d: %v", userID, err)
+					}
+				}
+			}
+
+		case "delete_key":
+			// 处理撤销公钥
+			keyIDStr := r.FormValue("key_id")
+			if keyIDStr != "" {
+				if keyID, err := strconv.Atoi(keyIDStr); err == nil {
+					if err := services.DeleteUserKey(keyID); err != nil {
+						log.Printf("Failed to delete public key %d: %v", keyID, err)
 					}
 				}
 			}
@@ -112,11 +285,27 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	users := services.GetAllUsers()
+	keys := services.GetAllUserKeys()
+
+	// 创建用户映射，方便公钥列表显示用户名；同时统计每个用户的流量使用情况
+	userMap := make(map[int]*models.User)
+	usageMap := make(map[int]*quotaUsage)
+	for _, user := range users {
+		userMap[user.ID] = user
+		daily, monthly := services.GetUserTrafficUsage(user.ID)
+		usageMap[user.ID] = &quotaUsage{Daily: daily, Monthly: monthly}
+	}
 	
 	data := struct {
-		Users []*models.User
+		Users    []*models.User
+		Keys     []*models.UserKey
+		UserMap  map[int]*models.User
+		UsageMap map[int]*quotaUsage
 	}{
-		Users: users,
+		Users:    users,
+		Keys:     keys,
+		UserMap:  userMap,
+		UsageMap: usageMap,
 	}
 	
 	tmpl := `
@@ -172,6 +361,24 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
             <li class="nav-item">
                 <a class="nav-link" href="/firewall">防火墙规则</a>
             </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
         </ul>
         
         <!-- 增加用户表单 -->
@@ -200,6 +407,12 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
                                 <input type="password" class="form-control" id="password" name="password" required>
                             </div>
                         </div>
+                        <div class="col-md-6">
+                            <div class="form-group">
+                                <label for="group" class="form-label">用户组</label>
+                                <input type="text" class="form-control" id="group" name="group" placeholder="可选">
+                            </div>
+                        </div>
                         <div class="col-md-6">
                             <div class="form-group">
                                 <label for="active" class="form-label">状态</label>
@@ -231,7 +444,13 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
                                 <th>ID</th>
                                 <th>昵称</th>
                                 <th>用户名</th>
+                                <th>用户组</th>
                                 <th>创建时间</th>
+                                <th>远程转发</th>
+                                <th>流量配额</th>
+                                <th>带宽限速</th>
+                                <th>并发限制</th>
+                                <th>会话超时</th>
                                 <th>状态</th>
                             </tr>
                         </thead>
@@ -241,7 +460,62 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
                                 <td>{{.ID}}</td>
                                 <td>{{.Name}}</td>
                                 <td>{{.Username}}</td>
+                                <td>
+                                    <form method="POST" class="d-flex" style="max-width: 200px;">
+                                        <input type="hidden" name="user_id" value="{{.ID}}">
+                                        <input type="text" class="form-control form-control-sm me-1" name="group" value="{{.Group}}" placeholder="无">
+                                        <button type="submit" name="action" value="update_group" class="btn btn-sm btn-outline-primary">保存</button>
+                                    </form>
+                                </td>
                                 <td>{{.Created.Format "2006-01-02 15:04:05"}}</td>
+                                <td>
+                                    {{if .RemoteForwardPorts}}
+                                        <small>
+                                            端口: {{.RemoteForwardPorts}}<br>
+                                            地址: {{if .RemoteForwardAddrs}}{{.RemoteForwardAddrs}}{{else}}仅回环地址{{end}}<br>
+                                            上限: {{if .MaxRemoteForwards}}{{.MaxRemoteForwards}}{{else}}不限{{end}}{{if .RemoteForwardAutoPort}}，自动分配端口{{end}}
+                                        </small>
+                                    {{else}}
+                                        <span class="text-muted">禁止</span>
+                                    {{end}}
+                                </td>
+                                <td>
+                                    {{$user := .}}
+                                    {{with index $.UsageMap .ID}}
+                                        <small>
+                                            今日: {{formatBytes .Daily}} / {{if $user.DailyQuota}}{{formatBytes $user.DailyQuota}}{{else}}不限{{end}}<br>
+                                            本月: {{formatBytes .Monthly}} / {{if $user.MonthlyQuota}}{{formatBytes $user.MonthlyQuota}}{{else}}不限{{end}}
+                                            {{if or $user.DailyQuota $user.MonthlyQuota}}<br>
+                                                用尽后: {{if eq $user.QuotaAction "throttle"}}限速{{else if eq $user.QuotaAction "drop"}}断开{{else}}拒绝新连接{{end}}
+                                                {{if or (and $user.DailyQuota (ge .Daily $user.DailyQuota)) (and $user.MonthlyQuota (ge .Monthly $user.MonthlyQuota))}}
+                                                    <span class="badge bg-danger">已用尽</span>
+                                                {{end}}
+                                            {{end}}
+                                        </small>
+                                    {{end}}
+                                </td>
+                                <td>
+                                    {{if or .RateLimitUp .RateLimitDown}}
+                                        <small>
+                                            上行: {{if .RateLimitUp}}{{formatBytes .RateLimitUp}}/s{{else}}不限{{end}}<br>
+                                            下行: {{if .RateLimitDown}}{{formatBytes .RateLimitDown}}/s{{else}}不限{{end}}
+                                        </small>
+                                    {{else}}
+                                        <span class="text-muted">不限</span>
+                                    {{end}}
+                                </td>
+                                <td>
+                                    <small>
+                                        会话: {{if .MaxSessions}}{{.MaxSessions}}{{else}}不限{{end}}<br>
+                                        通道: {{if .MaxChannels}}{{.MaxChannels}}{{else}}不限{{end}}
+                                    </small>
+                                </td>
+                                <td>
+                                    <small>
+                                        空闲: {{if .IdleTimeout}}{{.IdleTimeout}}分钟{{else}}全局设置{{end}}<br>
+                                        最长: {{if .MaxSessionDuration}}{{.MaxSessionDuration}}分钟{{else}}全局设置{{end}}
+                                    </small>
+                                </td>
                                 <td>
                                     <form method="POST" style="display: inline;">
                                         <input type="hidden" name="user_id" value="{{.ID}}">
@@ -257,285 +531,2654 @@ func serveUsersPage(w http.ResponseWriter, r *http.Request) {
                 </div>
             </div>
         </div>
-    </div>
-    
-    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
-</body>
-</html>
-`
-	
-	t, _ := template.New("users").Parse(tmpl)
-	t.Execute(w, data)
-}
-
-func serveConnectionsPage(w http.ResponseWriter, r *http.Request) {
-	// 获取查询参数中的用户ID和页码
-	userIDStr := r.URL.Query().Get("user_id")
-	pageStr := r.URL.Query().Get("page")
-	
-	page := 1
-	if pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
-		}
-	}
-	
-	// 每页显示的记录数
-	pageSize := 20
-	offset := (page - 1) * pageSize
-	
-	var targetConnections []*models.TargetConnection
-	var sshConnections []*models.Connection
-	var totalConnections int
-	
-	if userIDStr != "" {
-		// 如果指定了用户ID，则只显示该用户的目标连接记录
-		userID, err := strconv.Atoi(userIDStr)
-		if err == nil {
-			targetConnections = services.GetTargetConnectionsByUserID(userID)
-			sshConnections = services.GetConnectionsByUserID(userID)
-			totalConnections = len(targetConnections)
-			
-			// 应用分页
-			start := offset
-			end := start + pageSize
-			if start < totalConnections {
-				if end > totalConnections {
-					end = totalConnections
-				}
-				targetConnections = targetConnections[start:end]
-			} else {
-				targetConnections = []*models.TargetConnection{}
-			}
-		} else {
-			targetConnections = services.GetAllTargetConnections()
-			sshConnections = services.GetAllConnections()
-			totalConnections = len(targetConnections)
-			
-			// 应用分页
-			start := offset
-			end := start + pageSize
-			if start < totalConnections {
-				if end > totalConnections {
-					end = totalConnections
-				}
-				targetConnections = targetConnections[start:end]
-			} else {
-				targetConnections = []*models.TargetConnection{}
-			}
-		}
-	} else {
-		// 否则显示所有目标连接记录
-		allTargetConnections := services.GetAllTargetConnections()
-		sshConnections = services.GetAllConnections()
-		totalConnections = len(allTargetConnections)
-		
-		// 应用分页
-		start := offset
-		end := start + pageSize
-		if start < totalConnections {
-			if end > totalConnections {
-				end = totalConnections
-			}
-			targetConnections = allTargetConnections[start:end]
-		} else {
-			targetConnections = []*models.TargetConnection{}
-		}
-	}
-	
-	// 创建SSH连接映射，方便通过ID查找
-	sshConnectionMap := make(map[int]*models.Connection)
-	for _, conn := range sshConnections {
-		sshConnectionMap[conn.ID] = conn
-	}
-	
-	// 获取所有用户用于筛选下拉框
-	users := services.GetAllUsers()
-	
-	// 计算总页数
-	totalPages := (totalConnections + pageSize - 1) / pageSize
-	if totalPages == 0 {
-		totalPages = 1
-	}
-	
-	data := struct {
-		TargetConnections []*models.TargetConnection
-		SSHConnections    map[int]*models.Connection
-		Users             []*models.User
-		SelectedUserID    string
-		CurrentPage       int
-		TotalPages        int
-		TotalConnections  int
-		PageSize          int
-	}{
-		TargetConnections: targetConnections,
-		SSHConnections:    sshConnectionMap,
-		Users:             users,
-		SelectedUserID:    userIDStr,
-		CurrentPage:       page,
-		TotalPages:        totalPages,
-		TotalConnections:  totalConnections,
-		PageSize:          pageSize,
-	}
-	
-	tmpl := `
-<!DOCTYPE html>
-<html lang="zh-CN">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>SSH隧道连接记录</title>
-    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
-    <style>
-        body { padding: 20px 0; }
-        .pagination { justify-content: center; }
-        .table th, .table td { 
-            white-space: nowrap; 
-            text-align: center;
-        }
-        .table th:nth-child(1), .table td:nth-child(1) { width: 8%; }  /* SSH连接ID */
-        .table th:nth-child(2), .table td:nth-child(2) { width: 10%; } /* 用户名 */
-        .table th:nth-child(3), .table td:nth-child(3) { width: 12%; } /* 客户端IP */
-        .table th:nth-child(4), .table td:nth-child(4) { width: 15%; } /* 目标地址 */
-        .table th:nth-child(5), .table td:nth-child(5) { width: 15%; } /* 连接时间 */
-        .table th:nth-child(6), .table td:nth-child(6) { width: 15%; } /* 断开时间 */
-        .table th:nth-child(7), .table td:nth-child(7) { width: 10%; } /* 上行流量 */
-        .table th:nth-child(8), .table td:nth-child(8) { width: 10%; } /* 下行流量 */
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1 class="text-center mb-4">SSH隧道连接记录</h1>
         
-        <ul class="nav nav-tabs mb-4">
-            <li class="nav-item">
-                <a class="nav-link" href="/">用户管理</a>
-            </li>
-            <li class="nav-item">
-                <a class="nav-link active" href="/connections">连接记录</a>
-            </li>
-            <li class="nav-item">
-                <a class="nav-link" href="/stats">统计数据</a>
-            </li>
-            <li class="nav-item">
-                <a class="nav-link" href="/firewall">防火墙规则</a>
-            </li>
-        </ul>
+        <!-- 远程端口转发策略 -->
+        <div class="card mt-4">
+            <div class="card-header">
+                <h5 class="mb-0">远程端口转发策略（ssh -R）</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-3">
+                    <div class="col-md-2">
+                        <label for="policy_user_id" class="form-label">用户</label>
+                        <select class="form-select" id="policy_user_id" name="user_id" required>
+                            {{range .Users}}
+                                <option value="{{.ID}}">{{.Name}} ({{.Username}})</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-3">
+                        <label for="remote_forward_addrs" class="form-label">允许绑定地址</label>
+                        <input type="text" class="form-control" id="remote_forward_addrs" name="remote_forward_addrs" placeholder="例如: 127.0.0.1,0.0.0.0 或 *">
+                    </div>
+                    <div class="col-md-3">
+                        <label for="remote_forward_ports" class="form-label">允许端口范围</label>
+                        <input type="text" class="form-control" id="remote_forward_ports" name="remote_forward_ports" placeholder="例如: 8000-8100,9000">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="max_remote_forwards" class="form-label">监听器上限</label>
+                        <input type="number" class="form-control" id="max_remote_forwards" name="max_remote_forwards" min="0" value="0">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="remote_forward_auto_port" class="form-label">端口0自动分配</label>
+                        <select class="form-select" id="remote_forward_auto_port" name="remote_forward_auto_port">
+                            <option value="false">否</option>
+                            <option value="true">是</option>
+                        </select>
+                    </div>
+                    <div class="col-12">
+                        <div class="d-flex justify-content-end">
+                            <button type="submit" class="btn btn-primary" name="action" value="update_forward_policy">保存策略</button>
+                        </div>
+                    </div>
+                </form>
+                <div class="form-text">
+                    <ul class="mb-0">
+                        <li>端口范围为空时禁止该用户使用远程端口转发</li>
+                        <li>绑定地址为空时仅允许回环地址（localhost/127.0.0.1/::1），填写 * 表示允许任意地址</li>
+                        <li>监听器上限为该用户所有会话合计的数量，0表示不限制</li>
+                        <li>端口0自动分配只在允许端口范围内选择空闲端口，因此必须同时填写端口范围，否则策略不会保存</li>
+                    </ul>
+                </div>
+            </div>
+        </div>
         
-        <div class="row mb-3">
-            <div class="col-md-4">
-                <form method="GET" class="row g-3">
-                    <div class="col">
-                        <label for="user_id" class="form-label">用户</label>
-                        <select class="form-select" id="user_id" name="user_id">
-                            <option value="">所有用户</option>
+        <!-- 流量配额 -->
+        <div class="card mt-4">
+            <div class="card-header">
+                <h5 class="mb-0">流量配额</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-3">
+                    <div class="col-md-3">
+                        <label for="quota_user_id" class="form-label">用户</label>
+                        <select class="form-select" id="quota_user_id" name="user_id" required>
                             {{range .Users}}
-                                <option value="{{.ID}}" {{if eq $.SelectedUserID (printf "%d" .ID)}}selected{{end}}>{{.Name}} ({{.Username}})</option>
+                                <option value="{{.ID}}">{{.Name}} ({{.Username}})</option>
                             {{end}}
                         </select>
                     </div>
-                    <div class="col-auto d-flex align-items-end">
-                        <button type="submit" class="btn btn-primary">筛选</button>
+                    <div class="col-md-3">
+                        <label for="daily_quota_mb" class="form-label">每日配额（MB）</label>
+                        <input type="number" class="form-control" id="daily_quota_mb" name="daily_quota_mb" min="0" value="0">
+                    </div>
+                    <div class="col-md-3">
+                        <label for="monthly_quota_mb" class="form-label">每月配额（MB）</label>
+                        <input type="number" class="form-control" id="monthly_quota_mb" name="monthly_quota_mb" min="0" value="0">
+                    </div>
+                    <div class="col-md-3">
+                        <label for="quota_action" class="form-label">用尽后的处理</label>
+                        <select class="form-select" id="quota_action" name="quota_action">
+                            <option value="reject">拒绝新连接</option>
+                            <option value="throttle">限速</option>
+                            <option value="drop">拒绝新连接并断开已有连接</option>
+                        </select>
+                    </div>
+                    <div class="col-12">
+                        <div class="d-flex justify-content-end">
+                            <button type="submit" class="btn btn-primary" name="action" value="update_quota">保存配额</button>
+                        </div>
                     </div>
                 </form>
+                <div class="form-text">
+                    <ul class="mb-0">
+                        <li>配额按上行和下行流量合计，0表示不限制；每日和每月分别按服务器本地时间的自然日和自然月计算</li>
+                        <li>打开新通道和传输数据时都会检查配额；"限速"时该用户所有通道共享速率上限（环境变量QUOTA_THROTTLE_RATE，单位字节/秒，默认64KB/s）</li>
+                        <li>"断开"时该用户的通道在下一次传输数据时被关闭</li>
+                        <li>列表中的已用流量来自数据库，活动连接的流量每30秒写入一次</li>
+                    </ul>
+                </div>
             </div>
         </div>
         
-        <div class="card">
+        <!-- 带宽限速 -->
+        <div class="card mt-4">
             <div class="card-header">
-                <h5 class="mb-0">连接记录列表</h5>
+                <h5 class="mb-0">带宽限速</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-3">
+                    <div class="col-md-4">
+                        <label for="rate_user_id" class="form-label">用户</label>
+                        <select class="form-select" id="rate_user_id" name="user_id" required>
+                            {{range .Users}}
+                                <option value="{{.ID}}">{{.Name}} ({{.Username}})</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-4">
+                        <label for="rate_limit_up_kb" class="form-label">上行限速（KB/s）</label>
+                        <input type="number" class="form-control" id="rate_limit_up_kb" name="rate_limit_up_kb" min="0" value="0">
+                    </div>
+                    <div class="col-md-4">
+                        <label for="rate_limit_down_kb" class="form-label">下行限速（KB/s）</label>
+                        <input type="number" class="form-control" id="rate_limit_down_kb" name="rate_limit_down_kb" min="0" value="0">
+                    </div>
+                    <div class="col-12">
+                        <div class="d-flex justify-content-end">
+                            <button type="submit" class="btn btn-primary" name="action" value="update_rate_limit">保存限速</button>
+                        </div>
+                    </div>
+                </form>
+                <div class="form-text">
+                    <ul class="mb-0">
+                        <li>上行为从SSH客户端发往目标的流量，下行为从目标发往SSH客户端的流量，0表示不限制</li>
+                        <li>限速由该用户的所有SSH连接和通道共享，新的设置在打开下一个通道时生效</li>
+                        <li>所有用户合计的全局限速通过环境变量GLOBAL_RATE_LIMIT_UP和GLOBAL_RATE_LIMIT_DOWN设置（字节/秒）</li>
+                    </ul>
+                </div>
+            </div>
+        </div>
+
+        <!-- 并发限制 -->
+        <div class="card mt-4">
+            <div class="card-header">
+                <h5 class="mb-0">并发限制</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-3">
+                    <div class="col-md-4">
+                        <label for="limit_user_id" class="form-label">用户</label>
+                        <select class="form-select" id="limit_user_id" name="user_id" required>
+                            {{range .Users}}
+                                <option value="{{.ID}}">{{.Name}} ({{.Username}})</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-4">
+                        <label for="max_sessions" class="form-label">SSH会话数上限</label>
+                        <input type="number" class="form-control" id="max_sessions" name="max_sessions" min="0" value="0">
+                    </div>
+                    <div class="col-md-4">
+                        <label for="max_channels" class="form-label">转发通道数上限</label>
+                        <input type="number" class="form-control" id="max_channels" name="max_channels" min="0" value="0">
+                    </div>
+                    <div class="col-12">
+                        <div class="d-flex justify-content-end">
+                            <button type="submit" class="btn btn-primary" name="action" value="update_concurrency_limits">保存上限</button>
+                        </div>
+                    </div>
+                </form>
+                <div class="form-text">
+                    <ul class="mb-0">
+                        <li>0表示不限制；通道数为该用户所有会话中同时打开的本地转发和远程转发连接合计</li>
+                        <li>会话数达到上限时登录被拒绝，客户端会收到说明原因的横幅；通道数达到上限时新通道以资源不足被拒绝</li>
+                    </ul>
+                </div>
+            </div>
+        </div>
+
+        <!-- 会话超时 -->
+        <div class="card mt-4">
+            <div class="card-header">
+                <h5 class="mb-0">会话超时</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-3">
+                    <div class="col-md-4">
+                        <label for="timeout_user_id" class="form-label">用户</label>
+                        <select class="form-select" id="timeout_user_id" name="user_id" required>
+                            {{range .Users}}
+                                <option value="{{.ID}}">{{.Name}} ({{.Username}})</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-4">
+                        <label for="idle_timeout" class="form-label">空闲超时（分钟）</label>
+                        <input type="number" class="form-control" id="idle_timeout" name="idle_timeout" min="0" value="0">
+                    </div>
+                    <div class="col-md-4">
+                        <label for="max_session_duration" class="form-label">最长会话时长（分钟）</label>
+                        <input type="number" class="form-control" id="max_session_duration" name="max_session_duration" min="0" value="0">
+                    </div>
+                    <div class="col-12">
+                        <div class="d-flex justify-content-end">
+                            <button type="submit" class="btn btn-primary" name="action" value="update_session_timeouts">保存超时</button>
+                        </div>
+                    </div>
+                </form>
+                <div class="form-text">
+                    <ul class="mb-0">
+                        <li>空闲超时：SSH连接的所有通道都没有流量超过该时长后断开；最长会话时长：无论是否活动，连接建立超过该时长后断开</li>
+                        <li>0表示使用全局设置（环境变量IDLE_TIMEOUT_MINUTES和MAX_SESSION_MINUTES，默认不限制）；修改后对已有连接同样生效，约每15秒检查一次</li>
+                        <li>断开原因记录在连接记录中</li>
+                    </ul>
+                </div>
+            </div>
+        </div>
+
+        <!-- 公钥管理 -->
+        <div class="card mt-4">
+            <div class="card-header">
+                <h5 class="mb-0">SSH公钥管理</h5>
             </div>
             <div class="card-body">
+                <form method="POST" class="row g-3 mb-4">
+                    <div class="col-md-3">
+                        <label for="key_user_id" class="form-label">用户</label>
+                        <select class="form-select" id="key_user_id" name="user_id" required>
+                            {{range .Users}}
+                                <option value="{{.ID}}">{{.Name}} ({{.Username}})</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-3">
+                        <label for="label" class="form-label">标签</label>
+                        <input type="text" class="form-control" id="label" name="label" placeholder="例如: 办公室笔记本">
+                    </div>
+                    <div class="col-md-6">
+                        <label for="public_key" class="form-label">公钥（authorized_keys格式）</label>
+                        <textarea class="form-control" id="public_key" name="public_key" rows="2" placeholder="ssh-ed25519 AAAA... user@host" required></textarea>
+                    </div>
+                    <div class="col-12">
+                        <div class="d-flex justify-content-end">
+                            <button type="submit" class="btn btn-primary" name="action" value="add_key">添加公钥</button>
+                        </div>
+                    </div>
+                </form>
                 <div class="table-responsive">
                     <table class="table table-striped table-hover">
                         <thead class="table-dark">
                             <tr>
-                                <th>SSH连接ID</th>
-                                <th>用户名</th>
-                                <th>客户端IP</th>
-                                <th>目标地址</th>
-                                <th>连接时间</th>
-                                <th>断开时间</th>
-                                <th>上行流量</th>
-                                <th>下行流量</th>
+                                <th>ID</th>
+                                <th>用户</th>
+                                <th>标签</th>
+                                <th>指纹</th>
+                                <th>添加时间</th>
+                                <th>操作</th>
                             </tr>
                         </thead>
                         <tbody>
-                            {{range .TargetConnections}}
+                            {{range .Keys}}
                             <tr>
-                                <td>{{.ConnectionID}}</td>
-                                <td>{{(index $.SSHConnections .ConnectionID).Username}}</td>
-                                <td>{{(index $.SSHConnections .ConnectionID).IP}}</td>
-                                <td>{{.Target}}</td>
-                                <td>{{.ConnectedAt.Format "2006-01-02 15:04:05"}}</td>
+                                <td>{{.ID}}</td>
+                                <td>{{with index $.UserMap .UserID}}{{.Username}}{{else}}-{{end}}</td>
+                                <td>{{.Label}}</td>
+                                <td><code>{{.Fingerprint}}</code></td>
+                                <td>{{.Created.Format "2006-01-02 15:04:05"}}</td>
                                 <td>
-                                    {{if .DisconnectedAt}}
-                                        {{.DisconnectedAt.Format "2006-01-02 15:04:05"}}
-                                    {{else}}
-                                        未断开
-                                    {{end}}
+                                    <form method="POST" style="display: inline;">
+                                        <input type="hidden" name="key_id" value="{{.ID}}">
+                                        <button type="submit" name="action" value="delete_key" class="btn btn-sm btn-danger"
+                                            onclick="return confirm('确定要撤销这个公钥吗？')">撤销</button>
+                                    </form>
                                 </td>
-                                <td>{{formatBytes .BytesUp}}</td>
-                                <td>{{formatBytes .BytesDown}}</td>
                             </tr>
                             {{else}}
                             <tr>
-                                <td colspan="8" class="text-center">暂无连接记录</td>
+                                <td colspan="6" class="text-center">暂无公钥</td>
                             </tr>
                             {{end}}
                         </tbody>
                     </table>
                 </div>
-                
-                <!-- 分页 -->
-                {{if gt .TotalPages 1}}
-                <nav aria-label="分页导航">
-                    <ul class="pagination">
-                        <!-- 上一页 -->
-                        {{if gt .CurrentPage 1}}
-                        <li class="page-item">
-                            <a class="page-link" href="?page={{sub .CurrentPage 1}}{{if ne .SelectedUserID ""}}&user_id={{.SelectedUserID}}{{end}}" aria-label="上一页">
-                                <span aria-hidden="true">&laquo;</span>
-                            </a>
-                        </li>
-                        {{else}}
-                        <li class="page-item disabled">
-                            <span class="page-link">&laquo;</span>
-                        </li>
-                        {{end}}
-                        
-                        <!-- 页码 -->
-                        {{range $i := until .TotalPages}}
-                        {{$pageNum := add $i 1}}
-                        {{if eq $pageNum $.CurrentPage}}
-                        <li class="page-item active" aria-current="page">
-                            <span class="page-link">{{$pageNum}}</span>
-                        </li>
-                        {{else}}
-                        <li class="page-item">
-                            <a class="page-link" href="?page={{$pageNum}}{{if ne $.SelectedUserID ""}}&user_id={{$.SelectedUserID}}{{end}}">{{$pageNum}}</a>
-                        </li>
-                        {{end}}
-                        {{end}}
-                        
-                        <!-- 下一页 -->
-                        {{if lt .CurrentPage .TotalPages}}
-                        <li class="page-item">
-                            <a class="page-link" href="?page={{add .CurrentPage 1}}{{if ne .SelectedUserID ""}}&user_id={{.SelectedUserID}}{{end}}" aria-label="下一页">
-                                <span aria-hidden="true">&raquo;</span>
-                            </a>
-                        </li>
+            </div>
+        </div>
+    </div>
+
+    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
+</body>
+</html>
+`
+
+	funcMap := template.FuncMap{
+		"formatBytes": formatBytes,
+	}
+
+	t, _ := template.New("users").Funcs(funcMap).Parse(tmpl)
+	t.Execute(w, data)
+}
+
+func serveConnectionsPage(w http.ResponseWriter, r *http.Request) {
+	// 获取查询参数中的用户ID和页码
+	userIDStr := r.URL.Query().Get("user_id")
+	pageStr := r.URL.Query().Get("page")
+
+	page := 1
+	if pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	// 每页显示的记录数
+	pageSize := 20
+	offset := (page - 1) * pageSize
+
+	var targetConnections []*models.TargetConnection
+	var sshConnections []*models.Connection
+	var totalConnections int
+
+	if userIDStr != "" {
+		// 如果指定了用户ID，则只显示该用户的目标连接记录
+		userID, err := strconv.Atoi(userIDStr)
+		if err == nil {
+			targetConnections = services.GetTargetConnectionsByUserID(userID)
+			sshConnections = services.GetConnectionsByUserID(userID)
+			totalConnections = len(targetConnections)
+
+			// 应用分页
+			start := offset
+			end := start + pageSize
+			if start < totalConnections {
+				if end > totalConnections {
+					end = totalConnections
+				}
+				targetConnections = targetConnections[start:end]
+			} else {
+				targetConnections = []*models.TargetConnection{}
+			}
+		} else {
+			targetConnections = services.GetAllTargetConnections()
+			sshConnections = services.GetAllConnections()
+			totalConnections = len(targetConnections)
+
+			// 应用分页
+			start := offset
+			end := start + pageSize
+			if start < totalConnections {
+				if end > totalConnections {
+					end = totalConnections
+				}
+				targetConnections = targetConnections[start:end]
+			} else {
+				targetConnections = []*models.TargetConnection{}
+			}
+		}
+	} else {
+		// 否则显示所有目标连接记录
+		allTargetConnections := services.GetAllTargetConnections()
+		sshConnections = services.GetAllConnections()
+		totalConnections = len(allTargetConnections)
+
+		// 应用分页
+		start := offset
+		end := start + pageSize
+		if start < totalConnections {
+			if end > totalConnections {
+				end = totalConnections
+			}
+			targetConnections = allTargetConnections[start:end]
+		} else {
+			targetConnections = []*models.TargetConnection{}
+		}
+	}
+
+	// 创建SSH连接映射，方便通过ID查找
+	sshConnectionMap := make(map[int]*models.Connection)
+	for _, conn := range sshConnections {
+		sshConnectionMap[conn.ID] = conn
+	}
+
+	// 获取所有用户用于筛选下拉框
+	users := services.GetAllUsers()
+
+	// 计算总页数
+	totalPages := (totalConnections + pageSize - 1) / pageSize
+	if totalPages == 0 {
+		totalPages = 1
+	}
+
+	data := struct {
+		TargetConnections []*models.TargetConnection
+		SSHConnections    map[int]*models.Connection
+		Users             []*models.User
+		SelectedUserID    string
+		CurrentPage       int
+		TotalPages        int
+		TotalConnections  int
+		PageSize          int
+	}{
+		TargetConnections: targetConnections,
+		SSHConnections:    sshConnectionMap,
+		Users:             users,
+		SelectedUserID:    userIDStr,
+		CurrentPage:       page,
+		TotalPages:        totalPages,
+		TotalConnections:  totalConnections,
+		PageSize:          pageSize,
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>SSH隧道连接记录</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
+    <style>
+        body { padding: 20px 0; }
+        .pagination { justify-content: center; }
+        .table th, .table td {
+            white-space: nowrap;
+            text-align: center;
+        }
+        .table th:nth-child(1), .table td:nth-child(1) { width: 8%; }  /* SSH连接ID */
+        .table th:nth-child(2), .table td:nth-child(2) { width: 10%; } /* 用户名 */
+        .table th:nth-child(3), .table td:nth-child(3) { width: 12%; } /* 客户端IP */
+        .table th:nth-child(4), .table td:nth-child(4) { width: 15%; } /* 目标地址 */
+        .table th:nth-child(5), .table td:nth-child(5) { width: 15%; } /* 连接时间 */
+        .table th:nth-child(6), .table td:nth-child(6) { width: 15%; } /* 断开时间 */
+        .table th:nth-child(7), .table td:nth-child(7) { width: 10%; } /* 上行流量 */
+        .table th:nth-child(8), .table td:nth-child(8) { width: 10%; } /* 下行流量 */
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="text-center mb-4">SSH隧道连接记录</h1>
+
+        <ul class="nav nav-tabs mb-4">
+            <li class="nav-item">
+                <a class="nav-link" href="/">用户管理</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/connections">连接记录</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/stats">统计数据</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
+        </ul>
+
+        <div class="row mb-3">
+            <div class="col-md-4">
+                <form method="GET" class="row g-3">
+                    <div class="col">
+                        <label for="user_id" class="form-label">用户</label>
+                        <select class="form-select" id="user_id" name="user_id">
+                            <option value="">所有用户</option>
+                            {{range .Users}}
+                                <option value="{{.ID}}" {{if eq $.SelectedUserID (printf "%d" .ID)}}selected{{end}}>{{.Name}} ({{.Username}})</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-auto d-flex align-items-end">
+                        <button type="submit" class="btn btn-primary">筛选</button>
+                    </div>
+                </form>
+            </div>
+        </div>
+
+        <div class="card">
+            <div class="card-header">
+                <h5 class="mb-0">连接记录列表</h5>
+            </div>
+            <div class="card-body">
+                <div class="table-responsive">
+                    <table class="table table-striped table-hover">
+                        <thead class="table-dark">
+                            <tr>
+                                <th>SSH连接ID</th>
+                                <th>用户名</th>
+                                <th>客户端IP</th>
+                                <th>目标地址</th>
+                                <th>连接时间</th>
+                                <th>断开时间</th>
+                                <th>SSH断开原因</th>
+                                <th>上行流量</th>
+                                <th>下行流量</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {{range .TargetConnections}}
+                            <tr>
+                                <td>{{.ConnectionID}}</td>
+                                <td>{{(index $.SSHConnections .ConnectionID).Username}}</td>
+                                <td>{{(index $.SSHConnections .ConnectionID).IP}}</td>
+                                <td>{{.Target}}{{if eq .ChannelType "forwarded-tcpip"}} <span class="badge bg-info">远程转发</span>{{end}}</td>
+                                <td>{{.ConnectedAt.Format "2006-01-02 15:04:05"}}</td>
+                                <td>
+                                    {{if .DisconnectedAt}}
+                                        {{.DisconnectedAt.Format "2006-01-02 15:04:05"}}
+                                    {{else}}
+                                        未断开
+                                    {{end}}
+                                </td>
+                                <td>
+                                    {{with (index $.SSHConnections .ConnectionID).DisconnectReason}}
+                                        {{if eq . "idle_timeout"}}<span class="badge bg-warning text-dark">空闲超时</span>
+                                        {{else if eq . "max_duration"}}<span class="badge bg-warning text-dark">超过最长时长</span>
+                                        {{else if eq . "admin"}}<span class="badge bg-danger">管理员断开</span>
+                                        {{else if eq . "client"}}<span class="text-muted">客户端断开</span>
+                                        {{else}}{{.}}{{end}}
+                                    {{end}}
+                                </td>
+                                <td>{{formatBytes .BytesUp}}</td>
+                                <td>{{formatBytes .BytesDown}}</td>
+                            </tr>
+                            {{else}}
+                            <tr>
+                                <td colspan="9" class="text-center">暂无连接记录</td>
+                            </tr>
+                            {{end}}
+                        </tbody>
+                    </table>
+                </div>
+
+                <!-- 分页 -->
+                {{if gt .TotalPages 1}}
+                <nav aria-label="分页导航">
+                    <ul class="pagination">
+                        <!-- 上一页 -->
+                        {{if gt .CurrentPage 1}}
+                        <li class="page-item">
+                            <a class="page-link" href="?page={{sub .CurrentPage 1}}{{if ne .SelectedUserID ""}}&user_id={{.SelectedUserID}}{{end}}" aria-label="上一页">
+                                <span aria-hidden="true">&laquo;</span>
+                            </a>
+                        </li>
+                        {{else}}
+                        <li class="page-item disabled">
+                            <span class="page-link">&laquo;</span>
+                        </li>
+                        {{end}}
+
+                        <!-- 页码 -->
+                        {{range $i := until .TotalPages}}
+                        {{$pageNum := add $i 1}}
+                        {{if eq $pageNum $.CurrentPage}}
+                        <li class="page-item active" aria-current="page">
+                            <span class="page-link">{{$pageNum}}</span>
+                        </li>
+                        {{else}}
+                        <li class="page-item">
+                            <a class="page-link" href="?page={{$pageNum}}{{if ne $.SelectedUserID ""}}&user_id={{$.SelectedUserID}}{{end}}">{{$pageNum}}</a>
+                        </li>
+                        {{end}}
+                        {{end}}
+
+                        <!-- 下一页 -->
+                        {{if lt .CurrentPage .TotalPages}}
+                        <li class="page-item">
+                            <a class="page-link" href="?page={{add .CurrentPage 1}}{{if ne .SelectedUserID ""}}&user_id={{.SelectedUserID}}{{end}}" aria-label="下一页">
+                                <span aria-hidden="true">&raquo;</span>
+                            </a>
+                        </li>
+                        {{else}}
+                        <li class="page-item disabled">
+                            <span class="page-link">&raquo;</span>
+                        </li>
+                        {{end}}
+                    </ul>
+                </nav>
+                <div class="text-center text-muted">
+                    共 {{.TotalConnections}} 条记录，第 {{.CurrentPage}} 页，共 {{.TotalPages}} 页
+                </div>
+                {{end}}
+            </div>
+        </div>
+    </div>
+
+    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
+</body>
+</html>
+`
+
+	// 定义模板函数
+	funcMap := template.FuncMap{
+		"formatBytes": formatBytes,
+		"until": func(n int) []int {
+			result := make([]int, n)
+			for i := range result {
+				result[i] = i
+			}
+			return result
+		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"sub": func(a, b int) int {
+			return a - b
+		},
+	}
+
+	t, _ := template.New("connections").Funcs(funcMap).Parse(tmpl)
+	t.Execute(w, data)
+}
+
+// firewallRuleFromForm 从表单中读取防火墙规则，动作或模式为空时返回nil
+func firewallRuleFromForm(r *http.Request) *models.FirewallRule {
+	ruleAction := r.FormValue("rule_action")
+	pattern := r.FormValue("pattern")
+	if (ruleAction != models.FirewallActionAllow && ruleAction != models.FirewallActionDeny) || pattern == "" {
+		return nil
+	}
+
+	rule := &models.FirewallRule{
+		Action:  ruleAction,
+		Kind:    r.FormValue("rule_kind"),
+		Pattern: pattern,
+		Ports:   r.FormValue("ports"),
+	}
+
+	// 优先级为空时排在所有规则之后
+	if priorityStr := r.FormValue("priority"); priorityStr != "" {
+		rule.Priority, _ = strconv.Atoi(priorityStr)
+	}
+
+	// 规则适用范围：全局、指定用户或指定用户组
+	switch r.FormValue("scope") {
+	case "user":
+		rule.UserID, _ = strconv.Atoi(r.FormValue("scope_user_id"))
+	case "group":
+		rule.Group = r.FormValue("scope_group")
+	}
+
+	// 时间窗口：开始和过期时间按规则时区（未设置时为本地时区）解析
+	rule.Days = r.FormValue("days")
+	rule.TimeRange = r.FormValue("time_range")
+	rule.Timezone = strings.TrimSpace(r.FormValue("timezone"))
+	location := time.Local
+	if rule.Timezone != "" {
+		if loc, err := time.LoadLocation(rule.Timezone); err == nil {
+			location = loc
+		}
+	}
+	if startsAt, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("starts_at"), location); err == nil {
+		rule.StartsAt = &startsAt
+	}
+	if expiresAt, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("expires_at"), location); err == nil {
+		rule.ExpiresAt = &expiresAt
+	}
+	return rule
+}
+
+// firewallSimulationFromForm 从表单中读取防火墙模拟请求
+func firewallSimulationFromForm(r *http.Request) *utils.FirewallSimulation {
+	sim := &utils.FirewallSimulation{
+		Mode:          r.FormValue("sim_mode"),
+		DefaultPolicy: r.FormValue("sim_default_policy"),
+	}
+	if rule := firewallRuleFromForm(r); rule != nil {
+		sim.AddRules = []*models.FirewallRule{rule}
+	}
+	sim.UserID, _ = strconv.Atoi(r.FormValue("sim_user_id"))
+
+	// 时间窗口使用datetime-local输入框，按本地时间解析
+	if since, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("sim_since"), time.Local); err == nil {
+		sim.Since = since
+	}
+	if until, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("sim_until"), time.Local); err == nil {
+		sim.Until = until
+	}
+	return sim
+}
+
+// serveFirewallSimulateAPI 以JSON形式提供防火墙规则模拟
+// 请求体为utils.FirewallSimulation，返回utils.FirewallSimulationResult
+func serveFirewallSimulateAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sim utils.FirewallSimulation
+	if err := json.NewDecoder(r.Body).Decode(&sim); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := utils.SimulateFirewallRules(&sim)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func serveFirewallPage(w http.ResponseWriter, r *http.Request) {
+	// 模拟结果，只在提交模拟表单时生成
+	var simulation *utils.FirewallSimulationResult
+	var simulationError string
+	// 添加规则失败的原因，失败时直接渲染页面显示
+	var ruleError string
+
+	// 处理表单提交
+	if r.Method == "POST" {
+		action := r.FormValue("action")
+
+		switch action {
+		case "add_rule":
+			// 添加防火墙规则
+			if rule := firewallRuleFromForm(r); rule != nil {
+				err := utils.AddFirewallRule(rule)
+				if err != nil {
+					log.Printf("Failed to add firewall rule: %v", err)
+					ruleError = err.Error()
+				}
+			} else {
+				ruleError = "action and pattern are required"
+			}
+
+		case "simulate":
+			// 模拟拟议规则对历史连接的影响，直接展示结果而不重定向
+			result, err := utils.SimulateFirewallRules(firewallSimulationFromForm(r))
+			if err != nil {
+				log.Printf("Failed to simulate firewall rules: %v", err)
+				simulationError = err.Error()
+			}
+			simulation = result
+
+		case "delete_rule":
+			// 删除防火墙规则
+			ruleIDStr := r.FormValue("rule_id")
+			if ruleIDStr != "" {
+				if ruleID, err := strconv.Atoi(ruleIDStr); err == nil {
+					err := utils.DeleteFirewallRule(ruleID)
+					if err != nil {
+						log.Printf("Failed to delete firewall rule: %v", err)
+					}
+				}
+			}
+
+		case "move_up", "move_down":
+			// 调整防火墙规则顺序
+			if ruleID, err := strconv.Atoi(r.FormValue("rule_id")); err == nil {
+				err := utils.MoveFirewallRule(ruleID, action == "move_up")
+				if err != nil {
+					log.Printf("Failed to move firewall rule: %v", err)
+				}
+			}
+
+		case "set_priority":
+			// 设置防火墙规则优先级
+			ruleID, err1 := strconv.Atoi(r.FormValue("rule_id"))
+			priority, err2 := strconv.Atoi(r.FormValue("priority"))
+			if err1 == nil && err2 == nil {
+				err := utils.SetFirewallRulePriority(ruleID, priority)
+				if err != nil {
+					log.Printf("Failed to set firewall rule priority: %v", err)
+				}
+			}
+		}
+
+		if action != "simulate" && ruleError == "" {
+			// 重定向以避免重复提交
+			http.Redirect(w, r, "/firewall", http.StatusSeeOther)
+			return
+		}
+	}
+
+	// 获取所有防火墙规则
+	rules, err := utils.GetFirewallRules()
+	if err != nil {
+		log.Printf("Failed to get firewall rules: %v", err)
+		rules = []*models.FirewallRule{} // 空列表
+	}
+
+	// 获取所有用户用于选择规则范围和显示用户名
+	users := services.GetAllUsers()
+	userMap := make(map[int]*models.User)
+	for _, user := range users {
+		userMap[user.ID] = user
+	}
+
+	mode, defaultPolicy := utils.GetFirewallPolicy()
+
+	// 获取最近的防火墙决策记录，可按决策结果筛选
+	decisionFilter := r.URL.Query().Get("decision")
+	if decisionFilter != models.FirewallActionAllow && decisionFilter != models.FirewallActionDeny {
+		decisionFilter = ""
+	}
+	decisions, err := utils.GetFirewallDecisions(decisionFilter, 100)
+	if err != nil {
+		log.Printf("Failed to get firewall decisions: %v", err)
+	}
+
+	data := struct {
+		Rules           []*models.FirewallRule
+		Users           []*models.User
+		UserMap         map[int]*models.User
+		Mode            string
+		DefaultPolicy   string
+		Decisions       []*models.FirewallDecision
+		DecisionFilter  string
+		Simulation      *utils.FirewallSimulationResult
+		SimulationError string
+		RuleError       string
+		Now             time.Time
+	}{
+		Rules:           rules,
+		Users:           users,
+		UserMap:         userMap,
+		Mode:            mode,
+		DefaultPolicy:   defaultPolicy,
+		Decisions:       decisions,
+		DecisionFilter:  decisionFilter,
+		Simulation:      simulation,
+		SimulationError: simulationError,
+		RuleError:       ruleError,
+		Now:             time.Now(),
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>SSH隧道防火墙规则管理</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
+    <style>
+        body { padding: 20px 0; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="text-center mb-4">SSH隧道防火墙规则管理</h1>
+
+        <ul class="nav nav-tabs mb-4">
+            <li class="nav-item">
+                <a class="nav-link" href="/">用户管理</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/connections">连接记录</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/stats">统计数据</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
+        </ul>
+
+        <div class="alert alert-info">
+            当前评估模式：
+            {{if eq .Mode "ordered"}}
+                <strong>顺序模式</strong>（按优先级首条命中，未命中时默认{{if eq .DefaultPolicy "allow"}}允许{{else}}拒绝{{end}}）
+            {{else}}
+                <strong>兼容模式</strong>（白名单/黑名单）
+            {{end}}
+            <span class="text-muted">，可通过环境变量FIREWALL_MODE和FIREWALL_DEFAULT_POLICY修改</span>
+        </div>
+
+        <!-- 添加规则表单 -->
+        {{if .RuleError}}
+        <div class="alert alert-danger">添加规则失败：{{.RuleError}}</div>
+        {{end}}
+        <div class="card mb-4">
+            <div class="card-header">
+                <h5 class="mb-0">添加防火墙规则</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-3">
+                    <div class="col-md-2">
+                        <label for="rule_action" class="form-label">动作</label>
+                        <select class="form-select" id="rule_action" name="rule_action" required>
+                            <option value="">请选择动作</option>
+                            <option value="allow">允许（白名单）</option>
+                            <option value="deny">拒绝（黑名单）</option>
+                        </select>
+                    </div>
+                    <div class="col-md-2">
+                        <label for="rule_kind" class="form-label">匹配方式</label>
+                        <select class="form-select" id="rule_kind" name="rule_kind">
+                            <option value="regex">正则表达式</option>
+                            <option value="cidr">IP网段(CIDR)</option>
+                            <option value="host">主机名通配</option>
+                        </select>
+                    </div>
+                    <div class="col-md-4">
+                        <label for="pattern" class="form-label">目标地址模式</label>
+                        <input type="text" class="form-control" id="pattern" name="pattern" placeholder="例如: .*\.google\.com:443、10.0.0.0/8、*.example.com" required>
+                    </div>
+                    <div class="col-md-2">
+                        <label for="ports" class="form-label">端口范围</label>
+                        <input type="text" class="form-control" id="ports" name="ports" placeholder="例如: 443,8000-8100">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="scope" class="form-label">适用范围</label>
+                        <select class="form-select" id="scope" name="scope">
+                            <option value="global">全局</option>
+                            <option value="user">指定用户</option>
+                            <option value="group">指定用户组</option>
+                        </select>
+                    </div>
+                    <div class="col-md-3">
+                        <label for="scope_user_id" class="form-label">用户（范围为指定用户时）</label>
+                        <select class="form-select" id="scope_user_id" name="scope_user_id">
+                            {{range .Users}}
+                                <option value="{{.ID}}">{{.Name}} ({{.Username}})</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-3">
+                        <label for="scope_group" class="form-label">用户组（范围为指定用户组时）</label>
+                        <input type="text" class="form-control" id="scope_group" name="scope_group">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="days" class="form-label">生效星期</label>
+                        <input type="text" class="form-control" id="days" name="days" placeholder="例如: mon-fri，留空为每天">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="time_range" class="form-label">生效时间段</label>
+                        <input type="text" class="form-control" id="time_range" name="time_range" placeholder="例如: 09:00-18:00">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="timezone" class="form-label">时区</label>
+                        <input type="text" class="form-control" id="timezone" name="timezone" placeholder="例如: Asia/Shanghai">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="starts_at" class="form-label">开始生效</label>
+                        <input type="datetime-local" class="form-control" id="starts_at" name="starts_at">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="expires_at" class="form-label">过期时间</label>
+                        <input type="datetime-local" class="form-control" id="expires_at" name="expires_at">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="priority" class="form-label">优先级</label>
+                        <input type="number" class="form-control" id="priority" name="priority" min="1" placeholder="留空排在最后">
+                    </div>
+                    <div class="col-md-2">
+                        <label class="form-label">&nbsp;</label>
+                        <button type="submit" class="btn btn-primary form-control" name="action" value="add_rule">添加规则</button>
+                    </div>
+                    <div class="col-12"><hr class="my-1"><strong>模拟（dry-run）</strong>：不保存规则，将上面的规则加入当前规则集后，用历史连接检查哪些连接的结果会改变；规则留空时只模拟评估模式的变化</div>
+                    <div class="col-md-2">
+                        <label for="sim_mode" class="form-label">评估模式</label>
+                        <select class="form-select" id="sim_mode" name="sim_mode">
+                            <option value="">当前模式</option>
+                            <option value="compat">兼容模式</option>
+                            <option value="ordered">顺序模式</option>
+                        </select>
+                    </div>
+                    <div class="col-md-2">
+                        <label for="sim_default_policy" class="form-label">默认策略</label>
+                        <select class="form-select" id="sim_default_policy" name="sim_default_policy">
+                            <option value="">当前策略</option>
+                            <option value="allow">允许</option>
+                            <option value="deny">拒绝</option>
+                        </select>
+                    </div>
+                    <div class="col-md-2">
+                        <label for="sim_user_id" class="form-label">只看用户</label>
+                        <select class="form-select" id="sim_user_id" name="sim_user_id">
+                            <option value="0">所有用户</option>
+                            {{range .Users}}
+                                <option value="{{.ID}}">{{.Username}}</option>
+                            {{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-2">
+                        <label for="sim_since" class="form-label">开始时间</label>
+                        <input type="datetime-local" class="form-control" id="sim_since" name="sim_since">
+                    </div>
+                    <div class="col-md-2">
+                        <label for="sim_until" class="form-label">结束时间</label>
+                        <input type="datetime-local" class="form-control" id="sim_until" name="sim_until">
+                    </div>
+                    <div class="col-md-2">
+                        <label class="form-label">&nbsp;</label>
+                        <button type="submit" class="btn btn-outline-primary form-control" name="action" value="simulate" formnovalidate>模拟</button>
+                    </div>
+                </form>
+                <div class="form-text">
+                    <p class="mb-1"><strong>使用说明：</strong></p>
+                    <ul>
+                        <li>顺序模式：规则按优先级从小到大评估，第一条命中的规则决定允许或拒绝，都未命中时使用默认策略；可以先放一条允许的例外规则，再放一条范围更大的拒绝规则</li>
+                        <li>兼容模式：如果未设置任何规则，则允许所有流量代理</li>
+                        <li>兼容模式：如果设置了白名单，则仅允许匹配白名单的目标地址转发；匹配黑名单的目标地址不允许转发；白名单优先级高于黑名单</li>
+                        <li>正则表达式匹配"主机:端口"字符串；IP网段匹配目标IP（兼容127.1、0x7f.0.0.1等写法）；主机名通配支持*和?，如*.example.com</li>
+                        <li>端口范围为空表示匹配任意端口</li>
+                        <li>时间窗口可选：只在指定星期和时间段内生效（如mon-fri、09:00-18:00，支持22:00-06:00跨午夜），或只在开始生效和过期时间之间生效；不在时间窗口内的规则不参与匹配，过期后自动失效，无需手动删除；兼容模式下白名单在窗口外或过期后不再放行，但仍会拒绝未命中任何白名单的连接</li>
+                        <li>规则可以限定到指定用户或用户组；兼容模式下按用户规则、用户组规则、全局规则的顺序评估，第一个命中规则的范围决定结果，顺序模式下只按优先级评估</li>
+                    </ul>
+                </div>
+            </div>
+        </div>
+
+        {{if .SimulationError}}
+        <div class="alert alert-danger">模拟失败：{{.SimulationError}}</div>
+        {{end}}
+        {{with .Simulation}}
+        <!-- 模拟结果 -->
+        <div class="card mb-4">
+            <div class="card-header">
+                <h5 class="mb-0">模拟结果</h5>
+            </div>
+            <div class="card-body">
+                <p>
+                    共检查 {{.Checked}} 条历史连接，
+                    <span class="text-danger">{{len .NewlyDenied}} 条会由允许变为拒绝</span>，
+                    <span class="text-success">{{len .NewlyAllowed}} 条会由拒绝变为允许</span>
+                    {{if .Unresolved}}（{{.Unresolved}} 条目标主机名当前无法解析，仅按主机名评估）{{end}}{{if .ResolveTimedOut}}（解析主机名超过时长上限，部分主机未解析）{{end}}
+                </p>
+                <div class="table-responsive">
+                    <table class="table table-sm table-striped">
+                        <thead>
+                            <tr>
+                                <th>连接时间</th>
+                                <th>用户</th>
+                                <th>目标地址</th>
+                                <th>变化</th>
+                                <th>当前命中规则</th>
+                                <th>模拟命中规则</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {{range .NewlyDenied}}
+                            <tr>
+                                <td>{{.ConnectedAt.Format "2006-01-02 15:04:05"}}</td>
+                                <td>{{.Username}}</td>
+                                <td>{{.Target}}</td>
+                                <td><span class="badge bg-danger">允许 → 拒绝</span></td>
+                                <td>{{if .CurrentRuleID}}#{{.CurrentRuleID}}{{else}}默认{{end}}</td>
+                                <td>{{if lt .ProposedRuleID 0}}新规则{{else if .ProposedRuleID}}#{{.ProposedRuleID}}{{else}}默认{{end}}</td>
+                            </tr>
+                            {{end}}
+                            {{range .NewlyAllowed}}
+                            <tr>
+                                <td>{{.ConnectedAt.Format "2006-01-02 15:04:05"}}</td>
+                                <td>{{.Username}}</td>
+                                <td>{{.Target}}</td>
+                                <td><span class="badge bg-success">拒绝 → 允许</span></td>
+                                <td>{{if .CurrentRuleID}}#{{.CurrentRuleID}}{{else}}默认{{end}}</td>
+                                <td>{{if lt .ProposedRuleID 0}}新规则{{else if .ProposedRuleID}}#{{.ProposedRuleID}}{{else}}默认{{end}}</td>
+                            </tr>
+                            {{end}}
+                        </tbody>
+                    </table>
+                </div>
+            </div>
+        </div>
+        {{end}}
+
+        <!-- 规则列表 -->
+        <div class="card">
+            <div class="card-header">
+                <h5 class="mb-0">防火墙规则列表</h5>
+            </div>
+            <div class="card-body">
+                <div class="table-responsive">
+                    <table class="table table-striped table-hover">
+                        <thead class="table-dark">
+                            <tr>
+                                <th>优先级</th>
+                                <th>ID</th>
+                                <th>动作</th>
+                                <th>匹配方式</th>
+                                <th>模式</th>
+                                <th>端口</th>
+                                <th>适用范围</th>
+                                <th>时间窗口</th>
+                                <th>命中次数</th>
+                                <th>最后命中</th>
+                                <th>操作</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {{range .Rules}}
+                            <tr>
+                                <td>
+                                    <form method="POST" class="d-flex" style="max-width: 140px;">
+                                        <input type="hidden" name="rule_id" value="{{.ID}}">
+                                        <input type="number" class="form-control form-control-sm" name="priority" value="{{.Priority}}" min="1">
+                                        <button type="submit" name="action" value="set_priority" class="btn btn-sm btn-outline-secondary ms-1">保存</button>
+                                    </form>
+                                </td>
+                                <td>{{.ID}}</td>
+                                <td>
+                                    {{if eq .Action "allow"}}
+                                        <span class="badge bg-success">允许</span>
+                                    {{else}}
+                                        <span class="badge bg-danger">拒绝</span>
+                                    {{end}}
+                                </td>
+                                <td>
+                                    {{if eq .Kind "cidr"}}IP网段{{else if eq .Kind "host"}}主机名通配{{else}}正则表达式{{end}}
+                                </td>
+                                <td>{{.Pattern}}</td>
+                                <td>{{if .Ports}}{{.Ports}}{{else}}任意{{end}}</td>
+                                <td>
+                                    {{if .UserID}}
+                                        用户: {{with index $.UserMap .UserID}}{{.Username}}{{else}}#{{.UserID}}{{end}}
+                                    {{else if .Group}}
+                                        用户组: {{.Group}}
+                                    {{else}}
+                                        全局
+                                    {{end}}
+                                </td>
+                                <td>
+                                    {{if or .Days .TimeRange .StartsAt .ExpiresAt}}
+                                        {{if .Days}}{{.Days}} {{end}}{{if .TimeRange}}{{.TimeRange}} {{end}}{{if .Timezone}}({{.Timezone}}){{end}}
+                                        {{if .StartsAt}}<br>从 {{.StartsAt.Format "2006-01-02 15:04"}}{{end}}
+                                        {{if .ExpiresAt}}<br>至 {{.ExpiresAt.Format "2006-01-02 15:04"}}
+                                            {{if .ExpiresAt.Before $.Now}}<span class="badge bg-secondary">已过期</span>{{end}}
+                                        {{end}}
+                                    {{else}}
+                                        始终
+                                    {{end}}
+                                </td>
+                                <td>
+                                    {{if .HitCount}}{{.HitCount}}{{else}}<span class="badge bg-secondary">未命中</span>{{end}}
+                                </td>
+                                <td>{{if .LastHit}}{{.LastHit.Format "2006-01-02 15:04:05"}}{{else}}-{{end}}</td>
+                                <td>
+                                    <form method="POST" style="display: inline;">
+                                        <input type="hidden" name="rule_id" value="{{.ID}}">
+                                        <button type="submit" name="action" value="move_up" class="btn btn-sm btn-outline-secondary" title="上移">↑</button>
+                                        <button type="submit" name="action" value="move_down" class="btn btn-sm btn-outline-secondary" title="下移">↓</button>
+                                        <button type="submit" name="action" value="delete_rule" class="btn btn-sm btn-danger"
+                                            onclick="return confirm('确定要删除这条规则吗？')">删除</button>
+                                    </form>
+                                </td>
+                            </tr>
+                            {{else}}
+                            <tr>
+                                <td colspan="11" class="text-center">暂无防火墙规则</td>
+                            </tr>
+                            {{end}}
+                        </tbody>
+                    </table>
+                </div>
+            </div>
+        </div>
+
+        <!-- 决策记录 -->
+        <div class="card mt-4">
+            <div class="card-header d-flex justify-content-between align-items-center">
+                <h5 class="mb-0">最近的防火墙决策</h5>
+                <div class="btn-group btn-group-sm">
+                    <a href="/firewall" class="btn btn-outline-secondary {{if eq .DecisionFilter ""}}active{{end}}">全部</a>
+                    <a href="/firewall?decision=allow" class="btn btn-outline-success {{if eq .DecisionFilter "allow"}}active{{end}}">允许</a>
+                    <a href="/firewall?decision=deny" class="btn btn-outline-danger {{if eq .DecisionFilter "deny"}}active{{end}}">拒绝</a>
+                </div>
+            </div>
+            <div class="card-body">
+                <div class="table-responsive">
+                    <table class="table table-sm table-striped">
+                        <thead>
+                            <tr>
+                                <th>时间</th>
+                                <th>用户</th>
+                                <th>目标地址</th>
+                                <th>检查的IP</th>
+                                <th>命中规则</th>
+                                <th>结果</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {{range .Decisions}}
+                            <tr>
+                                <td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+                                <td>{{.Username}}</td>
+                                <td>{{.Target}}</td>
+                                <td>{{.IP}}</td>
+                                <td>{{if .RuleID}}#{{.RuleID}}{{else}}默认策略{{end}}</td>
+                                <td>
+                                    {{if eq .Action "allow"}}
+                                        <span class="badge bg-success">允许</span>
+                                    {{else}}
+                                        <span class="badge bg-danger">拒绝</span>
+                                    {{end}}
+                                </td>
+                            </tr>
+                            {{else}}
+                            <tr>
+                                <td colspan="6" class="text-center">暂无决策记录</td>
+                            </tr>
+                            {{end}}
+                        </tbody>
+                    </table>
+                </div>
+            </div>
+        </div>
+    </div>
+
+    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
+</body>
+</html>
+`
+
+	t, _ := template.New("firewall").Parse(tmpl)
+	t.Execute(w, data)
+}
+
+// serveLiveSessionsAPI 以JSON格式返回当前活动的SSH连接及其打开的通道
+func serveLiveSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(api.GetLiveSessions())
+}
+
+// serveKillSessionAPI 强制断开SSH连接或关闭单个通道
+// 请求体为{"connection_id": N}（断开整个SSH连接）或{"channel_id": N}（只关闭一个通道）
+func serveKillSessionAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ConnectionID int `json:"connection_id"`
+		ChannelID    int `json:"channel_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch {
+	case req.ConnectionID > 0:
+		err = api.CloseLiveSession(req.ConnectionID)
+	case req.ChannelID > 0:
+		err = api.CloseLiveChannel(req.ChannelID)
+	default:
+		http.Error(w, "connection_id or channel_id is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveSessionsPage(w http.ResponseWriter, r *http.Request) {
+	// 处理表单提交
+	if r.Method == "POST" {
+		action := r.FormValue("action")
+
+		switch action {
+		case "kill_session":
+			// 断开整个SSH连接
+			if connID, err := strconv.Atoi(r.FormValue("connection_id")); err == nil {
+				if err := api.CloseLiveSession(connID); err != nil {
+					log.Printf("Failed to close connection %d: %v", connID, err)
+				}
+			}
+
+		case "kill_channel":
+			// 只关闭一个通道
+			if channelID, err := strconv.Atoi(r.FormValue("channel_id")); err == nil {
+				if err := api.CloseLiveChannel(channelID); err != nil {
+					log.Printf("Failed to close channel %d: %v", channelID, err)
+				}
+			}
+		}
+
+		// 重定向以避免重复提交
+		http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+		return
+	}
+
+	data := struct {
+		Sessions []*api.LiveSession
+		Now      time.Time
+	}{
+		Sessions: api.GetLiveSessions(),
+		Now:      time.Now(),
+	}
+
+	funcMap := template.FuncMap{
+		"formatBytes": formatBytes,
+		"since": func(t time.Time) string {
+			return time.Since(t).Round(time.Second).String()
+		},
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>SSH隧道实时会话</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
+    <style>
+        body { padding: 20px 0; }
+        .table th, .table td { white-space: nowrap; vertical-align: middle; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="text-center mb-4">SSH隧道实时会话</h1>
+
+        <ul class="nav nav-tabs mb-4">
+            <li class="nav-item">
+                <a class="nav-link" href="/">用户管理</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/connections">连接记录</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/stats">统计数据</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
+        </ul>
+
+        <div class="d-flex justify-content-between align-items-center mb-3">
+            <span class="text-muted">共 {{len .Sessions}} 个活动连接，更新于 {{.Now.Format "2006-01-02 15:04:05"}}（速率每5秒采样一次）</span>
+            <a href="/sessions" class="btn btn-outline-primary btn-sm">刷新</a>
+        </div>
+
+        {{range .Sessions}}
+        <div class="card mb-4">
+            <div class="card-header d-flex justify-content-between align-items-center">
+                <div>
+                    <strong>#{{.ConnectionID}} {{.Username}}</strong>
+                    <span class="text-muted ms-2">{{.IP}}</span>
+                    <span class="badge bg-secondary ms-2">{{.AuthMethod}}</span>
+                </div>
+                <form method="POST" onsubmit="return confirm('确定断开该SSH连接吗？');">
+                    <input type="hidden" name="connection_id" value="{{.ConnectionID}}">
+                    <button type="submit" name="action" value="kill_session" class="btn btn-sm btn-danger">断开连接</button>
+                </form>
+            </div>
+            <div class="card-body">
+                <div class="row mb-3">
+                    <div class="col-md-4"><small class="text-muted">客户端版本</small><br>{{.ClientVersion}}</div>
+                    <div class="col-md-4"><small class="text-muted">连接时间</small><br>{{.ConnectedAt.Format "2006-01-02 15:04:05"}}（{{since .ConnectedAt}}）</div>
+                    <div class="col-md-4"><small class="text-muted">最后活动</small><br>{{since .LastActivity}}前</div>
+                </div>
+                <div class="row mb-3">
+                    <div class="col-md-4"><small class="text-muted">当前速率</small><br>↑ {{formatBytes .RateUp}}/s ↓ {{formatBytes .RateDown}}/s</div>
+                    <div class="col-md-4"><small class="text-muted">打开通道的流量</small><br>↑ {{formatBytes .BytesUp}} ↓ {{formatBytes .BytesDown}}</div>
+                    <div class="col-md-4"><small class="text-muted">远程转发监听</small><br>{{range .RemoteForwards}}<span class="badge bg-info me-1">{{.}}</span>{{else}}无{{end}}</div>
+                </div>
+
+                <div class="table-responsive">
+                    <table class="table table-sm table-striped mb-0">
+                        <thead>
+                            <tr>
+                                <th>通道ID</th>
+                                <th>目标地址</th>
+                                <th>打开时间</th>
+                                <th>上行流量</th>
+                                <th>下行流量</th>
+                                <th>当前速率</th>
+                                <th>操作</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {{range .Channels}}
+                            <tr>
+                                <td>{{.ID}}</td>
+                                <td>{{.Target}}{{if eq .ChannelType "forwarded-tcpip"}} <span class="badge bg-info">远程转发</span>{{end}}</td>
+                                <td>{{.ConnectedAt.Format "15:04:05"}}（{{since .ConnectedAt}}）</td>
+                                <td>{{formatBytes .BytesUp}}</td>
+                                <td>{{formatBytes .BytesDown}}</td>
+                                <td>↑ {{formatBytes .RateUp}}/s ↓ {{formatBytes .RateDown}}/s</td>
+                                <td>
+                                    <form method="POST" style="display: inline;">
+                                        <input type="hidden" name="channel_id" value="{{.ID}}">
+                                        <button type="submit" name="action" value="kill_channel" class="btn btn-sm btn-outline-danger">关闭</button>
+                                    </form>
+                                </td>
+                            </tr>
+                            {{else}}
+                            <tr>
+                                <td colspan="7" class="text-center text-muted">没有打开的转发通道</td>
+                            </tr>
+                            {{end}}
+                        </tbody>
+                    </table>
+                </div>
+            </div>
+        </div>
+        {{else}}
+        <div class="alert alert-info text-center">当前没有活动的SSH连接</div>
+        {{end}}
+
+        <div class="form-text">
+            JSON接口：<code>GET /sessions/live</code>获取活动连接；<code>POST /sessions/kill</code>，请求体<code>{"connection_id": N}</code>断开SSH连接，或<code>{"channel_id": N}</code>只关闭一个通道
+        </div>
+    </div>
+
+    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
+</body>
+</html>
+`
+
+	t, err := template.New("sessions").Funcs(funcMap).Parse(tmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, data)
+}
+
+// serveDashboardEvents 以Server-Sent Events推送实时事件：每秒的吞吐量以及SSH连接和通道的打开、关闭
+func serveDashboardEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	events, unsubscribe := api.SubscribeLiveEvents()
+	defer unsubscribe()
+
+	// 先发送当前的活动连接，便于仪表盘初始化
+	if data, err := json.Marshal(api.GetLiveSessions()); err == nil {
+		fmt.Fprintf(w, "event: sessions\ndata: %s\n\n", data)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Failed to encode live event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func serveDashboardPage(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>SSH隧道实时仪表盘</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
+    <script src="https://cdn.jsdelivr.net/npm/chart.js"></script>
+    <style>
+        body { padding: 20px 0; }
+        .chart-container { position: relative; height: 300px; }
+        #eventLog { height: 300px; overflow-y: auto; font-size: 0.875rem; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="text-center mb-4">SSH隧道实时仪表盘</h1>
+
+        <ul class="nav nav-tabs mb-4">
+            <li class="nav-item">
+                <a class="nav-link" href="/">用户管理</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/connections">连接记录</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/stats">统计数据</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
+        </ul>
+
+        <div class="row mb-2">
+            <div class="col-md-3 mb-3">
+                <div class="card bg-primary text-white">
+                    <div class="card-body text-center">
+                        <h6 class="card-title">活动连接</h6>
+                        <h3 id="sessionCount">-</h3>
+                    </div>
+                </div>
+            </div>
+            <div class="col-md-3 mb-3">
+                <div class="card bg-success text-white">
+                    <div class="card-body text-center">
+                        <h6 class="card-title">打开的通道</h6>
+                        <h3 id="channelCount">-</h3>
+                    </div>
+                </div>
+            </div>
+            <div class="col-md-3 mb-3">
+                <div class="card bg-info text-white">
+                    <div class="card-body text-center">
+                        <h6 class="card-title">上行速率</h6>
+                        <h3 id="upRate">-</h3>
+                    </div>
+                </div>
+            </div>
+            <div class="col-md-3 mb-3">
+                <div class="card bg-warning text-dark">
+                    <div class="card-body text-center">
+                        <h6 class="card-title">下行速率</h6>
+                        <h3 id="downRate">-</h3>
+                    </div>
+                </div>
+            </div>
+        </div>
+
+        <div class="row">
+            <div class="col-md-6 mb-4">
+                <div class="card">
+                    <div class="card-header d-flex justify-content-between align-items-center">
+                        <h5 class="mb-0">总吞吐量（最近60秒）</h5>
+                        <span id="streamStatus" class="badge bg-secondary">连接中</span>
+                    </div>
+                    <div class="card-body">
+                        <div class="chart-container">
+                            <canvas id="throughputChart"></canvas>
+                        </div>
+                    </div>
+                </div>
+            </div>
+            <div class="col-md-6 mb-4">
+                <div class="card">
+                    <div class="card-header">
+                        <h5 class="mb-0">各用户吞吐量（上行+下行，最近60秒）</h5>
+                    </div>
+                    <div class="card-body">
+                        <div class="chart-container">
+                            <canvas id="userThroughputChart"></canvas>
+                        </div>
+                    </div>
+                </div>
+            </div>
+        </div>
+
+        <div class="card mb-4">
+            <div class="card-header">
+                <h5 class="mb-0">连接事件</h5>
+            </div>
+            <div class="card-body">
+                <ul id="eventLog" class="list-unstyled mb-0"></ul>
+            </div>
+        </div>
+    </div>
+
+    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
+    <script>
+        const maxPoints = 60;
+        const maxEvents = 100;
+        const colors = ['54, 162, 235', '255, 99, 132', '75, 192, 192', '255, 159, 64', '153, 102, 255', '255, 206, 86', '83, 102, 255', '99, 255, 132'];
+
+        function formatRate(bytes) {
+            if (bytes >= 1024 * 1024) return (bytes / 1024 / 1024).toFixed(2) + ' MB/s';
+            if (bytes >= 1024) return (bytes / 1024).toFixed(2) + ' KB/s';
+            return bytes + ' B/s';
+        }
+
+        function lineOptions() {
+            return {
+                responsive: true,
+                maintainAspectRatio: false,
+                animation: false,
+                scales: {
+                    y: {
+                        beginAtZero: true,
+                        ticks: { callback: formatRate }
+                    }
+                }
+            };
+        }
+
+        const throughputChart = new Chart(document.getElementById('throughputChart').getContext('2d'), {
+            type: 'line',
+            data: {
+                labels: [],
+                datasets: [{
+                    label: '上行',
+                    data: [],
+                    borderColor: 'rgb(54, 162, 235)',
+                    backgroundColor: 'rgba(54, 162, 235, 0.2)',
+                    tension: 0.1
+                }, {
+                    label: '下行',
+                    data: [],
+                    borderColor: 'rgb(255, 159, 64)',
+                    backgroundColor: 'rgba(255, 159, 64, 0.2)',
+                    tension: 0.1
+                }]
+            },
+            options: lineOptions()
+        });
+
+        const userThroughputChart = new Chart(document.getElementById('userThroughputChart').getContext('2d'), {
+            type: 'line',
+            data: { labels: [], datasets: [] },
+            options: lineOptions()
+        });
+
+        // 追加一个数据点，超过maxPoints时移除最早的数据点
+        function pushPoint(chart, label, values) {
+            chart.data.labels.push(label);
+            chart.data.datasets.forEach(function(dataset, i) {
+                dataset.data.push(values[i]);
+            });
+            if (chart.data.labels.length > maxPoints) {
+                chart.data.labels.shift();
+                chart.data.datasets.forEach(function(dataset) { dataset.data.shift(); });
+            }
+            chart.update();
+        }
+
+        // 获取用户的数据集，不存在时创建并用0补齐之前的数据点
+        function userDataset(username) {
+            let dataset = userThroughputChart.data.datasets.find(function(d) { return d.label === username; });
+            if (!dataset) {
+                const color = colors[userThroughputChart.data.datasets.length % colors.length];
+                dataset = {
+                    label: username,
+                    data: userThroughputChart.data.labels.map(function() { return 0; }),
+                    borderColor: 'rgb(' + color + ')',
+                    backgroundColor: 'rgba(' + color + ', 0.2)',
+                    tension: 0.1
+                };
+                userThroughputChart.data.datasets.push(dataset);
+            }
+            return dataset;
+        }
+
+        function logEvent(time, text, cls) {
+            const item = document.createElement('li');
+            item.className = cls || '';
+            item.textContent = new Date(time).toLocaleTimeString() + '  ' + text;
+            const log = document.getElementById('eventLog');
+            log.insertBefore(item, log.firstChild);
+            while (log.children.length > maxEvents) {
+                log.removeChild(log.lastChild);
+            }
+        }
+
+        const reasons = { client: '客户端断开', idle_timeout: '空闲超时', max_duration: '超过最长时长', admin: '管理员断开' };
+        const source = new EventSource('/dashboard/events');
+        const status = document.getElementById('streamStatus');
+
+        source.onopen = function() {
+            status.className = 'badge bg-success';
+            status.textContent = '已连接';
+        };
+        source.onerror = function() {
+            status.className = 'badge bg-danger';
+            status.textContent = '已断开，正在重连';
+        };
+
+        source.addEventListener('sessions', function(e) {
+            const sessions = JSON.parse(e.data);
+            document.getElementById('sessionCount').textContent = sessions.length;
+            document.getElementById('channelCount').textContent = sessions.reduce(function(n, s) { return n + s.channels.length; }, 0);
+        });
+
+        source.addEventListener('throughput', function(e) {
+            const event = JSON.parse(e.data);
+            const sample = event.data;
+            const label = new Date(event.time).toLocaleTimeString();
+
+            document.getElementById('sessionCount').textContent = sample.sessions;
+            document.getElementById('channelCount').textContent = sample.channels;
+            document.getElementById('upRate').textContent = formatRate(sample.up);
+            document.getElementById('downRate').textContent = formatRate(sample.down);
+
+            pushPoint(throughputChart, label, [sample.up, sample.down]);
+
+            Object.keys(sample.users).forEach(userDataset);
+            pushPoint(userThroughputChart, label, userThroughputChart.data.datasets.map(function(d) {
+                const user = sample.users[d.label];
+                return user ? user.up + user.down : 0;
+            }));
+        });
+
+        source.addEventListener('session_open', function(e) {
+            const d = JSON.parse(e.data).data;
+            logEvent(JSON.parse(e.data).time, '用户 ' + d.username + ' 从 ' + d.ip + ' 建立SSH连接 #' + d.connection_id, 'text-success');
+        });
+        source.addEventListener('session_close', function(e) {
+            const d = JSON.parse(e.data).data;
+            logEvent(JSON.parse(e.data).time, '用户 ' + d.username + ' 的SSH连接 #' + d.connection_id + ' 已断开（' + (reasons[d.reason] || d.reason) + '）', 'text-danger');
+        });
+        source.addEventListener('channel_open', function(e) {
+            const d = JSON.parse(e.data).data;
+            logEvent(JSON.parse(e.data).time, '用户 ' + d.username + ' 打开' + (d.channel_type === 'forwarded-tcpip' ? '远程转发' : '') + '通道 #' + d.id + ' → ' + d.target, 'text-primary');
+        });
+        source.addEventListener('channel_close', function(e) {
+            const d = JSON.parse(e.data).data;
+            logEvent(JSON.parse(e.data).time, '用户 ' + d.username + ' 关闭通道 #' + d.id + ' → ' + d.target + '（↑' + d.bytes_up + ' B ↓' + d.bytes_down + ' B）', 'text-muted');
+        });
+    </script>
+</body>
+</html>
+`
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(tmpl))
+}
+
+// serveTrafficSeriesAPI 以JSON格式返回指定时间范围的流量时间序列（utils.TrafficSeries）
+// 查询参数：from、to（RFC3339或2006-01-02T15:04）、granularity（minute/hour/day，为空时自动选择）、
+// user_id、target、group_by（user/target）、limit
+func serveTrafficSeriesAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := &utils.TrafficSeriesQuery{
+		Granularity: r.FormValue("granularity"),
+		Target:      r.FormValue("target"),
+		GroupBy:     r.FormValue("group_by"),
+	}
+	var err error
+	if query.From, err = parseQueryTime(r.FormValue("from")); err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.To, err = parseQueryTime(r.FormValue("to")); err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.UserID, _ = strconv.Atoi(r.FormValue("user_id"))
+	query.Limit, _ = strconv.Atoi(r.FormValue("limit"))
+
+	series, err := utils.QueryTrafficSeries(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(series)
+}
+
+// parseQueryTime 解析查询参数中的时间，支持RFC3339和本地时间2006-01-02T15:04，为空时返回零值
+func parseQueryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+}
+
+// serveMetrics 以Prometheus文本格式输出服务器指标
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	utils.WriteMetrics(w)
+}
+
+// topTalkersQueryFromRequest 从查询参数中读取流量排行的查询条件
+// 参数from、to支持RFC3339和2006-01-02T15:04，user_id、limit为整数
+func topTalkersQueryFromRequest(r *http.Request) (*utils.TopTalkersQuery, error) {
+	query := &utils.TopTalkersQuery{}
+	var err error
+	if query.From, err = parseQueryTime(r.FormValue("from")); err != nil {
+		return nil, fmt.Errorf("invalid from: %v", err)
+	}
+	if query.To, err = parseQueryTime(r.FormValue("to")); err != nil {
+		return nil, fmt.Errorf("invalid to: %v", err)
+	}
+	query.UserID, _ = strconv.Atoi(r.FormValue("user_id"))
+	query.Limit, _ = strconv.Atoi(r.FormValue("limit"))
+	return query, nil
+}
+
+// serveTopTalkersAPI 以JSON格式返回指定时间段的流量排行（utils.TopTalkersReport）
+func serveTopTalkersAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := topTalkersQueryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := utils.GetTopTalkers(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// topTalkersPeriod 流量排行页面的快捷时间段
+type topTalkersPeriod struct {
+	Label string
+	From  time.Time
+	To    time.Time
+}
+
+// serveTopTalkersPage 流量排行页面：指定时间段内流量最多的用户、目标主机、目标端口和各用户的目标地址明细
+func serveTopTalkersPage(w http.ResponseWriter, r *http.Request) {
+	var report *utils.TopTalkersReport
+	query, err := topTalkersQueryFromRequest(r)
+	if err == nil {
+		report, err = utils.GetTopTalkers(query)
+	}
+	errorMessage := ""
+	if err != nil {
+		log.Printf("Failed to get top talkers: %v", err)
+		errorMessage = err.Error()
+		report = &utils.TopTalkersReport{Total: &utils.TalkerEntry{}}
+	}
+
+	now := time.Now()
+	monthStart := utils.MonthStart(now)
+	data := struct {
+		Report  *utils.TopTalkersReport
+		Query   *utils.TopTalkersQuery
+		Users   []*models.User
+		Periods []topTalkersPeriod
+		Error   string
+	}{
+		Report: report,
+		Query:  query,
+		Users:  services.GetAllUsers(),
+		Periods: []topTalkersPeriod{
+			{Label: "最近24小时", From: now.Add(-24 * time.Hour), To: now},
+			{Label: "最近7天", From: now.AddDate(0, 0, -7), To: now},
+			{Label: "最近30天", From: now.AddDate(0, 0, -30), To: now},
+			{Label: "本月", From: monthStart, To: now},
+			{Label: "上月", From: monthStart.AddDate(0, -1, 0), To: monthStart},
+		},
+		Error: errorMessage,
+	}
+	if data.Query == nil {
+		data.Query = &utils.TopTalkersQuery{}
+	}
+
+	funcMap := template.FuncMap{
+		"formatBytes": formatBytes,
+		"inputTime": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Local().Format("2006-01-02T15:04")
+		},
+		"percent": func(part, total int64) string {
+			if total == 0 {
+				return "0"
+			}
+			return fmt.Sprintf("%.1f", float64(part)*100/float64(total))
+		},
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>SSH隧道流量排行</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
+    <style>
+        body { padding: 20px 0; }
+        .table th, .table td { white-space: nowrap; vertical-align: middle; }
+        .share { min-width: 120px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="text-center mb-4">SSH隧道流量排行</h1>
+
+        <ul class="nav nav-tabs mb-4">
+            <li class="nav-item">
+                <a class="nav-link" href="/">用户管理</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/connections">连接记录</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/stats">统计数据</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
+        </ul>
+
+        <div class="card mb-4">
+            <div class="card-body">
+                <form method="GET" class="row g-2 align-items-end">
+                    <div class="col-md-3">
+                        <label class="form-label">开始时间</label>
+                        <input type="datetime-local" class="form-control" name="from" value="{{inputTime .Report.From}}">
+                    </div>
+                    <div class="col-md-3">
+                        <label class="form-label">结束时间</label>
+                        <input type="datetime-local" class="form-control" name="to" value="{{inputTime .Report.To}}">
+                    </div>
+                    <div class="col-md-3">
+                        <label class="form-label">用户</label>
+                        <select class="form-select" name="user_id">
+                            <option value="">所有用户</option>
+                            {{range .Users}}<option value="{{.ID}}" {{if eq .ID $.Query.UserID}}selected{{end}}>{{.Username}}</option>{{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-1">
+                        <label class="form-label">条目数</label>
+                        <input type="number" class="form-control" name="limit" min="1" value="{{if .Query.Limit}}{{.Query.Limit}}{{else}}10{{end}}">
+                    </div>
+                    <div class="col-md-2">
+                        <button type="submit" class="btn btn-primary w-100">查询</button>
+                    </div>
+                </form>
+                <div class="mt-2">
+                    {{range .Periods}}
+                    <a class="btn btn-sm btn-outline-secondary me-1" href="/top-talkers?from={{inputTime .From}}&to={{inputTime .To}}{{if $.Query.UserID}}&user_id={{$.Query.UserID}}{{end}}">{{.Label}}</a>
+                    {{end}}
+                </div>
+                {{if .Error}}<div class="alert alert-danger mt-3 mb-0">{{.Error}}</div>{{end}}
+            </div>
+        </div>
+
+        <div class="row mb-4">
+            <div class="col-md-3"><div class="card bg-primary text-white"><div class="card-body text-center"><h6 class="card-title">总流量</h6><h4>{{formatBytes .Report.Total.Bytes}}</h4></div></div></div>
+            <div class="col-md-3"><div class="card bg-info text-white"><div class="card-body text-center"><h6 class="card-title">上行 / 下行</h6><h4>{{formatBytes .Report.Total.BytesUp}} / {{formatBytes .Report.Total.BytesDown}}</h4></div></div></div>
+            <div class="col-md-3"><div class="card bg-success text-white"><div class="card-body text-center"><h6 class="card-title">目标连接数</h6><h4>{{.Report.Total.Connections}}</h4></div></div></div>
+            <div class="col-md-3"><div class="card bg-warning text-white"><div class="card-body text-center"><h6 class="card-title">用户数</h6><h4>{{.Report.Total.Users}}</h4></div></div></div>
+        </div>
+
+        <div class="row">
+            <div class="col-md-4 mb-4">
+                <div class="card h-100">
+                    <div class="card-header"><h5 class="mb-0">用户排行</h5></div>
+                    <div class="card-body p-0">
+                        <table class="table table-sm table-striped mb-0">
+                            <thead><tr><th>用户</th><th>流量</th><th class="share">占比</th><th>连接</th></tr></thead>
+                            <tbody>
+                                {{range .Report.Users}}
+                                <tr>
+                                    <td><a href="#user-{{.UserID}}">{{.Key}}</a></td>
+                                    <td title="上行 {{formatBytes .BytesUp}} / 下行 {{formatBytes .BytesDown}}">{{formatBytes .Bytes}}</td>
+                                    <td class="share"><div class="progress" title="{{percent .Bytes $.Report.Total.Bytes}}%"><div class="progress-bar" style="width: {{percent .Bytes $.Report.Total.Bytes}}%"></div></div></td>
+                                    <td>{{.Connections}}</td>
+                                </tr>
+                                {{else}}
+                                <tr><td colspan="4" class="text-center text-muted">没有数据</td></tr>
+                                {{end}}
+                            </tbody>
+                        </table>
+                    </div>
+                </div>
+            </div>
+            <div class="col-md-4 mb-4">
+                <div class="card h-100">
+                    <div class="card-header"><h5 class="mb-0">目标主机排行</h5></div>
+                    <div class="card-body p-0">
+                        <table class="table table-sm table-striped mb-0">
+                            <thead><tr><th>主机</th><th>流量</th><th class="share">占比</th><th>连接</th><th>用户</th></tr></thead>
+                            <tbody>
+                                {{range .Report.Hosts}}
+                                <tr>
+                                    <td>{{.Key}}</td>
+                                    <td title="上行 {{formatBytes .BytesUp}} / 下行 {{formatBytes .BytesDown}}">{{formatBytes .Bytes}}</td>
+                                    <td class="share"><div class="progress" title="{{percent .Bytes $.Report.Total.Bytes}}%"><div class="progress-bar bg-success" style="width: {{percent .Bytes $.Report.Total.Bytes}}%"></div></div></td>
+                                    <td>{{.Connections}}</td>
+                                    <td>{{.Users}}</td>
+                                </tr>
+                                {{else}}
+                                <tr><td colspan="5" class="text-center text-muted">没有数据</td></tr>
+                                {{end}}
+                            </tbody>
+                        </table>
+                    </div>
+                </div>
+            </div>
+            <div class="col-md-4 mb-4">
+                <div class="card h-100">
+                    <div class="card-header"><h5 class="mb-0">目标端口排行</h5></div>
+                    <div class="card-body p-0">
+                        <table class="table table-sm table-striped mb-0">
+                            <thead><tr><th>端口</th><th>流量</th><th class="share">占比</th><th>连接</th><th>用户</th></tr></thead>
+                            <tbody>
+                                {{range .Report.Ports}}
+                                <tr>
+                                    <td>{{.Key}}</td>
+                                    <td title="上行 {{formatBytes .BytesUp}} / 下行 {{formatBytes .BytesDown}}">{{formatBytes .Bytes}}</td>
+                                    <td class="share"><div class="progress" title="{{percent .Bytes $.Report.Total.Bytes}}%"><div class="progress-bar bg-warning" style="width: {{percent .Bytes $.Report.Total.Bytes}}%"></div></div></td>
+                                    <td>{{.Connections}}</td>
+                                    <td>{{.Users}}</td>
+                                </tr>
+                                {{else}}
+                                <tr><td colspan="5" class="text-center text-muted">没有数据</td></tr>
+                                {{end}}
+                            </tbody>
+                        </table>
+                    </div>
+                </div>
+            </div>
+        </div>
+
+        <h4 class="mb-3">各用户目标地址明细</h4>
+        {{range .Report.UserDestinations}}
+        <div class="card mb-3" id="user-{{.User.UserID}}">
+            <div class="card-header">
+                <strong>{{.User.Key}}</strong>
+                <span class="text-muted ms-2">共 {{formatBytes .User.Bytes}}（上行 {{formatBytes .User.BytesUp}} / 下行 {{formatBytes .User.BytesDown}}），{{.User.Connections}} 个目标连接</span>
+            </div>
+            <div class="card-body p-0">
+                <table class="table table-sm table-striped mb-0">
+                    <thead><tr><th>目标地址</th><th>上行流量</th><th>下行流量</th><th class="share">占该用户流量</th><th>连接</th></tr></thead>
+                    <tbody>
+                        {{$user := .User}}
+                        {{range .Destinations}}
+                        <tr>
+                            <td>{{.Key}}</td>
+                            <td>{{formatBytes .BytesUp}}</td>
+                            <td>{{formatBytes .BytesDown}}</td>
+                            <td class="share"><div class="progress" title="{{percent .Bytes $user.Bytes}}%"><div class="progress-bar bg-info" style="width: {{percent .Bytes $user.Bytes}}%"></div></div></td>
+                            <td>{{.Connections}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+            </div>
+        </div>
+        {{else}}
+        <div class="alert alert-info text-center">所选时间段内没有目标连接</div>
+        {{end}}
+
+        <div class="form-text mb-4">
+            按目标连接的建立时间统计，活动连接的流量每30秒写入一次数据库。
+            JSON接口：<code>GET /top-talkers/data?from=&amp;to=&amp;user_id=&amp;limit=</code>，时间为RFC3339或<code>2006-01-02T15:04</code>格式，默认为最近7天。
+        </div>
+    </div>
+
+    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
+</body>
+</html>
+`
+
+	t, err := template.New("top-talkers").Funcs(funcMap).Parse(tmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, data)
+}
+
+// reportPeriodFromRequest 从参数中读取使用报表的时间段
+// 参数from、to为2006-01-02格式的本地日期，to为包含的最后一天
+func reportPeriodFromRequest(r *http.Request) (time.Time, time.Time, error) {
+	from, err := time.ParseInLocation("2006-01-02", r.FormValue("from"), time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %v", err)
+	}
+	to, err := time.ParseInLocation("2006-01-02", r.FormValue("to"), time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %v", err)
+	}
+	to = to.AddDate(0, 0, 1)
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
+
+// serveUsageReportAPI 直接返回指定时间段的使用报表，不写入报表目录
+// 参数format为json（默认）或csv
+func serveUsageReportAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := reportPeriodFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = utils.ReportFormatJSON
+	}
+	if format != utils.ReportFormatJSON && format != utils.ReportFormatCSV {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	report, err := utils.GenerateUsageReport(from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == utils.ReportFormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, utils.UsageReportFileName(from, to, format)))
+		utils.WriteUsageReportCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	utils.WriteUsageReportJSON(w, report)
+}
+
+// serveUsageReportDownload 下载报表目录中已生成的报表文件
+func serveUsageReportDownload(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("file")
+	if !utils.IsUsageReportFileName(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	http.ServeFile(w, r, filepath.Join(config.Load().ReportDir, name))
+}
+
+// usageReportPeriod 使用报表页面的快捷时间段，To为包含的最后一天
+type usageReportPeriod struct {
+	Label string
+	From  time.Time
+	To    time.Time
+}
+
+// serveUsageReportsPage 使用报表页面：按时间段生成报表，查看和下载报表目录中已生成的报表
+func serveUsageReportsPage(w http.ResponseWriter, r *http.Request) {
+	// 处理表单提交
+	if r.Method == "POST" {
+		if r.FormValue("action") == "generate" {
+			from, to, err := reportPeriodFromRequest(r)
+			if err == nil {
+				_, err = services.GenerateUsageReportFiles(from, to, nil)
+			}
+			if err != nil {
+				log.Printf("Failed to generate usage report: %v", err)
+			}
+		}
+
+		http.Redirect(w, r, "/reports", http.StatusSeeOther)
+		return
+	}
+
+	cfg := config.Load()
+	now := time.Now()
+	today := utils.DayStart(now)
+	var periods []usageReportPeriod
+	for _, p := range []struct{ label, schedule string }{
+		{"昨天", utils.ReportScheduleDaily},
+		{"上周", utils.ReportScheduleWeekly},
+		{"上月", utils.ReportScheduleMonthly},
+	} {
+		from, to, _ := utils.UsageReportPeriod(p.schedule, now)
+		periods = append(periods, usageReportPeriod{Label: p.label, From: from, To: to.AddDate(0, 0, -1)})
+	}
+	periods = append(periods, usageReportPeriod{Label: "本月至今", From: utils.MonthStart(now), To: today})
+
+	data := struct {
+		Files    []*utils.UsageReportFile
+		Periods  []usageReportPeriod
+		Schedule string
+		Formats  string
+		Dir      string
+	}{
+		Files:    services.GetUsageReportFiles(),
+		Periods:  periods,
+		Schedule: cfg.ReportSchedule,
+		Formats:  cfg.ReportFormats,
+		Dir:      cfg.ReportDir,
+	}
+
+	funcMap := template.FuncMap{
+		"formatBytes": formatBytes,
+		"date": func(t time.Time) string {
+			return t.Format("2006-01-02")
+		},
+		"formatTime": func(t time.Time) string {
+			return t.Format("2006-01-02 15:04:05")
+		},
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>SSH隧道使用报表</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
+    <style>
+        body { padding: 20px 0; }
+        .table th, .table td { white-space: nowrap; vertical-align: middle; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="text-center mb-4">SSH隧道使用报表</h1>
+
+        <ul class="nav nav-tabs mb-4">
+            <li class="nav-item">
+                <a class="nav-link" href="/">用户管理</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/connections">连接记录</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/stats">统计数据</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
+        </ul>
+
+        <div class="card mb-4">
+            <div class="card-header">
+                <h5 class="mb-0">生成报表</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-2 align-items-end">
+                    <input type="hidden" name="action" value="generate">
+                    <div class="col-md-4">
+                        <label class="form-label">开始日期</label>
+                        <input type="date" class="form-control" name="from" required>
+                    </div>
+                    <div class="col-md-4">
+                        <label class="form-label">结束日期（含）</label>
+                        <input type="date" class="form-control" name="to" required>
+                    </div>
+                    <div class="col-md-4">
+                        <button type="submit" class="btn btn-primary w-100">生成并保存</button>
+                    </div>
+                </form>
+
+                <table class="table table-sm mt-3 mb-0">
+                    <thead><tr><th>快捷时间段</th><th>日期</th><th>操作</th></tr></thead>
+                    <tbody>
+                        {{range .Periods}}
+                        <tr>
+                            <td>{{.Label}}</td>
+                            <td>{{date .From}} ~ {{date .To}}</td>
+                            <td>
+                                <form method="POST" class="d-inline">
+                                    <input type="hidden" name="action" value="generate">
+                                    <input type="hidden" name="from" value="{{date .From}}">
+                                    <input type="hidden" name="to" value="{{date .To}}">
+                                    <button type="submit" class="btn btn-sm btn-primary">生成并保存</button>
+                                </form>
+                                <a class="btn btn-sm btn-outline-secondary" href="/reports/usage?from={{date .From}}&to={{date .To}}&format=json" target="_blank">预览JSON</a>
+                                <a class="btn btn-sm btn-outline-secondary" href="/reports/usage?from={{date .From}}&to={{date .To}}&format=csv">下载CSV</a>
+                            </td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+
+                <div class="form-text">
+                    文件格式：{{.Formats}}；
+                    自动生成：{{if .Schedule}}{{.Schedule}}{{else}}未启用（设置REPORT_SCHEDULE为daily、weekly或monthly）{{end}}。
+                    连接时长只计算与时间段重叠的部分，流量和目标地址按目标连接的建立时间统计。
+                </div>
+            </div>
+        </div>
+
+        <div class="card">
+            <div class="card-header">
+                <h5 class="mb-0">已生成的报表</h5>
+            </div>
+            <div class="card-body p-0">
+                <table class="table table-striped mb-0">
+                    <thead>
+                        <tr>
+                            <th>文件名</th>
+                            <th>大小</th>
+                            <th>生成时间</th>
+                            <th>操作</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Files}}
+                        <tr>
+                            <td>{{.Name}}</td>
+                            <td>{{formatBytes .Size}}</td>
+                            <td>{{formatTime .ModTime}}</td>
+                            <td><a class="btn btn-sm btn-outline-primary" href="/reports/download?file={{.Name}}">下载</a></td>
+                        </tr>
+                        {{else}}
+                        <tr><td colspan="4" class="text-center text-muted">报表目录 {{.Dir}} 中还没有报表</td></tr>
+                        {{end}}
+                    </tbody>
+                </table>
+            </div>
+        </div>
+    </div>
+
+    <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js"></script>
+</body>
+</html>
+`
+
+	t, err := template.New("reports").Funcs(funcMap).Parse(tmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, data)
+}
+
+// apiTokenExpiryOptions API令牌页面可选的有效期（天），0表示永不过期
+var apiTokenExpiryOptions = []int{0, 7, 30, 90, 365}
+
+// serveAPITokensPage API令牌页面：创建和撤销REST API令牌
+// 新令牌的明文只在创建后的页面中显示一次，因此创建时直接渲染页面而不重定向
+func serveAPITokensPage(w http.ResponseWriter, r *http.Request) {
+	var newToken string
+	var newTokenInfo *models.APIToken
+	errorMessage := ""
+
+	// 处理表单提交
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "create_token":
+			// 创建令牌
+			var expiresAt *time.Time
+			if days, err := strconv.Atoi(r.FormValue("expires_in_days")); err == nil && days > 0 {
+				t := time.Now().AddDate(0, 0, days)
+				expiresAt = &t
+			}
+
+			plaintext, token, err := services.CreateAPIToken(r.FormValue("name"), r.FormValue("scope"), expiresAt)
+			if err != nil {
+				log.Printf("Failed to create API token: %v", err)
+				errorMessage = err.Error()
+			}
+			newToken, newTokenInfo = plaintext, token
+
+		case "revoke_token":
+			// 撤销令牌
+			if tokenID, err := strconv.Atoi(r.FormValue("token_id")); err == nil {
+				if err := services.RevokeAPIToken(tokenID); err != nil {
+					log.Printf("Failed to revoke API token %d: %v", tokenID, err)
+				}
+			}
+
+			// 重定向以避免重复提交
+			http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+			return
+		}
+	}
+
+	data := struct {
+		Tokens        []*models.APIToken
+		NewToken      string
+		NewTokenInfo  *models.APIToken
+		ExpiryOptions []int
+		Error         string
+		Now           time.Time
+	}{
+		Tokens:        services.GetAPITokens(),
+		NewToken:      newToken,
+		NewTokenInfo:  newTokenInfo,
+		ExpiryOptions: apiTokenExpiryOptions,
+		Error:         errorMessage,
+		Now:           time.Now(),
+	}
+
+	funcMap := template.FuncMap{
+		"formatTime": func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.Format("2006-01-02 15:04:05")
+		},
+		"expired": func(t *time.Time, now time.Time) bool {
+			return t != nil && !now.Before(*t)
+		},
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>SSH隧道API令牌</title>
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
+    <style>
+        body { padding: 20px 0; }
+        .table th, .table td { white-space: nowrap; vertical-align: middle; }
+        .new-token { word-break: break-all; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="text-center mb-4">SSH隧道API令牌</h1>
+
+        <ul class="nav nav-tabs mb-4">
+            <li class="nav-item">
+                <a class="nav-link" href="/">用户管理</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/connections">连接记录</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/stats">统计数据</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
+        </ul>
+
+        {{if .NewToken}}
+        <div class="alert alert-success">
+            <h5>已创建令牌"{{.NewTokenInfo.Name}}"</h5>
+            <p class="mb-2">请立即复制保存，令牌只显示这一次：</p>
+            <code class="new-token fs-6">{{.NewToken}}</code>
+            <p class="mt-2 mb-0">使用方式：<code>curl -H "Authorization: Bearer {{.NewToken}}" http://服务器:端口/api/users</code></p>
+        </div>
+        {{end}}
+        {{if .Error}}<div class="alert alert-danger">{{.Error}}</div>{{end}}
+
+        <div class="card mb-4">
+            <div class="card-header">
+                <h5 class="mb-0">创建令牌</h5>
+            </div>
+            <div class="card-body">
+                <form method="POST" class="row g-2 align-items-end">
+                    <input type="hidden" name="action" value="create_token">
+                    <div class="col-md-4">
+                        <label class="form-label">名称</label>
+                        <input type="text" class="form-control" name="name" placeholder="如：备份脚本" required>
+                    </div>
+                    <div class="col-md-3">
+                        <label class="form-label">权限</label>
+                        <select class="form-select" name="scope">
+                            <option value="read">只读（仅GET接口）</option>
+                            <option value="admin">管理（所有接口）</option>
+                        </select>
+                    </div>
+                    <div class="col-md-3">
+                        <label class="form-label">有效期</label>
+                        <select class="form-select" name="expires_in_days">
+                            {{range .ExpiryOptions}}<option value="{{.}}" {{if eq . 90}}selected{{end}}>{{if eq . 0}}永不过期{{else}}{{.}}天{{end}}</option>{{end}}
+                        </select>
+                    </div>
+                    <div class="col-md-2">
+                        <button type="submit" class="btn btn-primary w-100">创建</button>
+                    </div>
+                </form>
+                <div class="form-text">令牌只在创建时显示一次，数据库中只保存其哈希值。REST API接受<code>Authorization: Bearer 令牌</code>认证。</div>
+            </div>
+        </div>
+
+        <div class="card">
+            <div class="card-header">
+                <h5 class="mb-0">令牌列表</h5>
+            </div>
+            <div class="card-body p-0">
+                <table class="table table-striped mb-0">
+                    <thead>
+                        <tr>
+                            <th>名称</th>
+                            <th>令牌</th>
+                            <th>权限</th>
+                            <th>创建时间</th>
+                            <th>过期时间</th>
+                            <th>最后使用</th>
+                            <th>操作</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Tokens}}
+                        <tr>
+                            <td>{{.Name}}</td>
+                            <td><code>{{.Prefix}}…</code></td>
+                            <td>{{if eq .Scope "admin"}}<span class="badge bg-danger">管理</span>{{else}}<span class="badge bg-secondary">只读</span>{{end}}</td>
+                            <td>{{.Created.Format "2006-01-02 15:04:05"}}</td>
+                            <td>{{if .ExpiresAt}}{{formatTime .ExpiresAt}}{{if expired .ExpiresAt $.Now}} <span class="badge bg-warning text-dark">已过期</span>{{end}}{{else}}永不过期{{end}}</td>
+                            <td>{{if .LastUsedAt}}{{formatTime .LastUsedAt}}（{{.LastUsedIP}}）{{else}}<span class="text-muted">从未使用</span>{{end}}</td>
+                            <td>
+                                <form method="POST" class="d-inline" onsubmit="return confirm('确定撤销令牌&quot;{{.Name}}&quot;吗？使用该令牌的脚本将立即无法访问。');">
+                                    <input type="hidden" name="action" value="revoke_token">
+                                    <input type="hidden" name="token_id" value="{{.ID}}">
+                                    <button type="submit" class="btn btn-sm btn-outline-danger">撤销</button>
+                                </form>
+                            </td>
+                        </tr>
                         {{else}}
-                        <li class="page-item disabled">
-                            <span class="page-link">&raquo;</span>
-                        </li>
+                        <tr><td colspan="7" class="text-center text-muted">还没有API令牌</td></tr>
                         {{end}}
-                    </ul>
-                </nav>
-                <div class="text-center text-muted">
-                    共 {{.TotalConnections}} 条记录，第 {{.CurrentPage}} 页，共 {{.TotalPages}} 页
-                </div>
-                {{end}}
+                    </tbody>
+                </table>
             </div>
         </div>
     </div>
@@ -545,75 +3188,76 @@ func serveConnectionsPage(w http.ResponseWriter, r *http.Request) {
 </html>
 `
 	
-	// 定义模板函数
-	funcMap := template.FuncMap{
-		"formatBytes": formatBytes,
-		"until": func(n int) []int {
-			result := make([]int, n)
-			for i := range result {
-				result[i] = i
-			}
-			return result
-		},
-		"add": func(a, b int) int {
-			return a + b
-		},
-		"sub": func(a, b int) int {
-			return a - b
-		},
+	t, err := template.New("tokens").Funcs(funcMap).Parse(tmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	
-	t, _ := template.New("connections").Funcs(funcMap).Parse(tmpl)
 	t.Execute(w, data)
 }
 
-func serveFirewallPage(w http.ResponseWriter, r *http.Request) {
+// webAdminRoleOptions 管理员页面可选的角色及其说明
+var webAdminRoleOptions = []struct {
+	Role        string
+	Description string
+}{
+	{models.WebAdminRoleViewer, "只读：查看所有页面"},
+	{models.WebAdminRoleOperator, "操作员：另外可以断开会话、激活/停用用户、生成报表"},
+	{models.WebAdminRoleAdmin, "管理员：所有操作"},
+}
+
+// serveWebAdminsPage 管理员账号页面：添加、删除管理员，修改角色和密码
+// 操作成功后重定向，失败时直接渲染页面显示错误
+// 参数: current - 当前登录的管理员账号，不能删除自己
+func serveWebAdminsPage(w http.ResponseWriter, r *http.Request, current *models.WebAdmin) {
+	errorMessage := ""
+
 	// 处理表单提交
 	if r.Method == "POST" {
-		action := r.FormValue("action")
+		var err error
+		adminID, _ := strconv.Atoi(r.FormValue("admin_id"))
 		
-		switch action {
-		case "add_rule":
-			// 添加防火墙规则
-			ruleType := r.FormValue("rule_type")
-			pattern := r.FormValue("pattern")
+		switch r.FormValue("action") {
+		case "add_admin":
+			// 添加管理员
+			err = services.AddWebAdmin(r.FormValue("username"), r.FormValue("password"), r.FormValue("role"))
 			
-			if (ruleType == "whitelist" || ruleType == "blacklist") && pattern != "" {
-				err := utils.AddFirewallRule(ruleType, pattern)
-				if err != nil {
-					log.Printf("Failed to add firewall rule: %v", err)
-				}
-			}
+		case "update_role":
+			// 修改角色
+			err = services.UpdateWebAdminRole(adminID, r.FormValue("role"))
 			
-		case "delete_rule":
-			// 删除防火墙规则
-			ruleIDStr := r.FormValue("rule_id")
-			if ruleIDStr != "" {
-				if ruleID, err := strconv.Atoi(ruleIDStr); err == nil {
-					err := utils.DeleteFirewallRule(ruleID)
-					if err != nil {
-						log.Printf("Failed to delete firewall rule: %v", err)
-					}
-				}
+		case "reset_password":
+			// 修改密码
+			err = services.ResetWebAdminPassword(adminID, r.FormValue("password"))
+
+		case "delete_admin":
+			// 删除管理员，不能删除当前登录的账号
+			if adminID == current.ID {
+				err = fmt.Errorf("cannot delete the account you are logged in with")
+			} else {
+				err = services.DeleteWebAdmin(adminID)
 			}
 		}
 		
-		// 重定向以避免重复提交
-		http.Redirect(w, r, "/firewall", http.StatusSeeOther)
-		return
-	}
-	
-	// 获取所有防火墙规则
-	rules, err := utils.GetFirewallRules()
-	if err != nil {
-		log.Printf("Failed to get firewall rules: %v", err)
-		rules = []*utils.FirewallRule{} // 空列表
+		if err == nil {
+			// 重定向以避免重复提交
+			http.Redirect(w, r, "/admins", http.StatusSeeOther)
+			return
+		}
+		log.Printf("Failed to %s web admin: %v", r.FormValue("action"), err)
+		errorMessage = err.Error()
 	}
 	
 	data := struct {
-		Rules []*utils.FirewallRule
+		Admins      []*models.WebAdmin
+		Current     *models.WebAdmin
+		RoleOptions interface{}
+		Error       string
 	}{
-		Rules: rules,
+		Admins:      services.GetWebAdmins(),
+		Current:     current,
+		RoleOptions: webAdminRoleOptions,
+		Error:       errorMessage,
 	}
 	
 	tmpl := `
@@ -622,15 +3266,16 @@ func serveFirewallPage(w http.ResponseWriter, r *http.Request) {
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>SSH隧道防火墙规则管理</title>
+    <title>SSH隧道管理员</title>
     <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css" rel="stylesheet">
     <style>
         body { padding: 20px 0; }
+        .table th, .table td { white-space: nowrap; vertical-align: middle; }
     </style>
 </head>
 <body>
     <div class="container">
-        <h1 class="text-center mb-4">SSH隧道防火墙规则管理</h1>
+        <h1 class="text-center mb-4">SSH隧道管理员</h1>
         
         <ul class="nav nav-tabs mb-4">
             <li class="nav-item">
@@ -643,90 +3288,110 @@ func serveFirewallPage(w http.ResponseWriter, r *http.Request) {
                 <a class="nav-link" href="/stats">统计数据</a>
             </li>
             <li class="nav-item">
-                <a class="nav-link active" href="/firewall">防火墙规则</a>
+                <a class="nav-link" href="/firewall">防火墙规则</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link active" href="/admins">管理员</a>
             </li>
         </ul>
         
-        <!-- 添加规则表单 -->
+        {{if .Error}}<div class="alert alert-danger">{{.Error}}</div>{{end}}
+
         <div class="card mb-4">
             <div class="card-header">
-                <h5 class="mb-0">添加防火墙规则</h5>
+                <h5 class="mb-0">添加管理员</h5>
             </div>
             <div class="card-body">
-                <form method="POST" class="row g-3">
+                <form method="POST" class="row g-2 align-items-end">
+                    <input type="hidden" name="action" value="add_admin">
+                    <div class="col-md-3">
+                        <label class="form-label">用户名</label>
+                        <input type="text" class="form-control" name="username" required>
+                    </div>
+                    <div class="col-md-3">
+                        <label class="form-label">密码</label>
+                        <input type="password" class="form-control" name="password" required>
+                    </div>
                     <div class="col-md-4">
-                        <label for="rule_type" class="form-label">规则类型</label>
-                        <select class="form-select" id="rule_type" name="rule_type" required>
-                            <option value="">请选择规则类型</option>
-                            <option value="whitelist">白名单</option>
-                            <option value="blacklist">黑名单</option>
+                        <label class="form-label">角色</label>
+                        <select class="form-select" name="role">
+                            {{range .RoleOptions}}<option value="{{.Role}}">{{.Description}}</option>{{end}}
                         </select>
                     </div>
-                    <div class="col-md-6">
-                        <label for="pattern" class="form-label">目标地址模式（正则表达式）</label>
-                        <input type="text" class="form-control" id="pattern" name="pattern" placeholder="例如: .*\.google\.com:443" required>
-                    </div>
                     <div class="col-md-2">
-                        <label class="form-label">&nbsp;</label>
-                        <button type="submit" class="btn btn-primary form-control" name="action" value="add_rule">添加规则</button>
+                        <button type="submit" class="btn btn-primary w-100">添加</button>
                     </div>
                 </form>
-                <div class="form-text">
-                    <p class="mb-1"><strong>使用说明：</strong></p>
-                    <ul>
-                        <li>如果未设置任何规则，则允许所有流量代理</li>
-                        <li>如果设置了白名单（正则），则仅允许匹配白名单的目标地址转发</li>
-                        <li>如果设置了黑名单（正则），则不允许匹配黑名单的目标地址转发</li>
-                        <li>白名单优先级高于黑名单</li>
-                    </ul>
-                </div>
+                <div class="form-text">管理员账号用于登录本管理界面，也可以用于REST API的基础认证。至少需要保留一个管理员角色的账号。</div>
             </div>
         </div>
         
-        <!-- 规则列表 -->
         <div class="card">
             <div class="card-header">
-                <h5 class="mb-0">防火墙规则列表</h5>
+                <h5 class="mb-0">管理员列表</h5>
             </div>
-            <div class="card-body">
-                <div class="table-responsive">
-                    <table class="table table-striped table-hover">
-                        <thead class="table-dark">
-                            <tr>
-                                <th>ID</th>
-                                <th>类型</th>
-                                <th>模式</th>
-                                <th>操作</th>
-                            </tr>
-                        </thead>
-                        <tbody>
-                            {{range .Rules}}
-                            <tr>
-                                <td>{{.ID}}</td>
-                                <td>
-                                    {{if eq .Type "whitelist"}}
-                                        <span class="badge bg-success">白名单</span>
-                                    {{else}}
-                                        <span class="badge bg-danger">黑名单</span>
-                                    {{end}}
-                                </td>
-                                <td>{{.Pattern}}</td>
-                                <td>
-                                    <form method="POST" style="display: inline;">
-                                        <input type="hidden" name="rule_id" value="{{.ID}}">
-                                        <button type="submit" name="action" value="delete_rule" class="btn btn-sm btn-danger" 
-                                            onclick="return confirm('确定要删除这条规则吗？')">删除</button>
-                                    </form>
-                                </td>
-                            </tr>
-                            {{else}}
-                            <tr>
-                                <td colspan="4" class="text-center">暂无防火墙规则</td>
-                            </tr>
-                            {{end}}
-                        </tbody>
-                    </table>
-                </div>
+            <div class="card-body p-0">
+                <table class="table table-striped mb-0">
+                    <thead>
+                        <tr>
+                            <th>用户名</th>
+                            <th>角色</th>
+                            <th>创建时间</th>
+                            <th>修改密码</th>
+                            <th>操作</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Admins}}
+                        <tr>
+                            <td>{{.Username}}{{if eq .ID $.Current.ID}} <span class="badge bg-info">当前登录</span>{{end}}</td>
+                            <td>
+                                <form method="POST" class="d-flex gap-1">
+                                    <input type="hidden" name="action" value="update_role">
+                                    <input type="hidden" name="admin_id" value="{{.ID}}">
+                                    <select class="form-select form-select-sm" name="role">
+                                        {{$role := .Role}}{{range $.RoleOptions}}<option value="{{.Role}}" {{if eq .Role $role}}selected{{end}}>{{.Role}}</option>{{end}}
+                                    </select>
+                                    <button type="submit" class="btn btn-sm btn-outline-primary">修改</button>
+                                </form>
+                            </td>
+                            <td>{{.Created.Format "2006-01-02 15:04:05"}}</td>
+                            <td>
+                                <form method="POST" class="d-flex gap-1">
+                                    <input type="hidden" name="action" value="reset_password">
+                                    <input type="hidden" name="admin_id" value="{{.ID}}">
+                                    <input type="password" class="form-control form-control-sm" name="password" placeholder="新密码" required>
+                                    <button type="submit" class="btn btn-sm btn-outline-secondary">保存</button>
+                                </form>
+                            </td>
+                            <td>
+                                {{if ne .ID $.Current.ID}}
+                                <form method="POST" class="d-inline" onsubmit="return confirm('确定删除管理员&quot;{{.Username}}&quot;吗？');">
+                                    <input type="hidden" name="action" value="delete_admin">
+                                    <input type="hidden" name="admin_id" value="{{.ID}}">
+                                    <button type="submit" class="btn btn-sm btn-outline-danger">删除</button>
+                                </form>
+                                {{end}}
+                            </td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
             </div>
         </div>
     </div>
@@ -736,18 +3401,22 @@ func serveFirewallPage(w http.ResponseWriter, r *http.Request) {
 </html>
 `
 	
-	t, _ := template.New("firewall").Parse(tmpl)
+	t, err := template.New("admins").Parse(tmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	t.Execute(w, data)
 }
 
 func serveStatsPage(w http.ResponseWriter, r *http.Request) {
 	stats := services.GetStatistics()
-	connections := services.GetAllConnections()
 	users := services.GetAllUsers()
 	
-	// 准备图表数据
-	chartData := prepareChartData(connections)
-	userChartData := prepareUserChartData(connections, users)
+	// 准备图表数据：最近7天每天每个用户的连接数，在数据库中聚合
+	counts := services.GetConnectionCountsByDay(utils.DayStart(time.Now().AddDate(0, 0, -6)))
+	chartData := prepareChartData(counts)
+	userChartData := prepareUserChartData(counts, users)
 	
 	tmpl := `
 <!DOCTYPE html>
@@ -780,6 +3449,24 @@ func serveStatsPage(w http.ResponseWriter, r *http.Request) {
             <li class="nav-item">
                 <a class="nav-link" href="/firewall">防火墙规则</a>
             </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/sessions">实时会话</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/dashboard">实时仪表盘</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/top-talkers">流量排行</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/reports">使用报表</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/tokens">API令牌</a>
+            </li>
+            <li class="nav-item">
+                <a class="nav-link" href="/admins">管理员</a>
+            </li>
         </ul>
         
         <div class="row">
@@ -852,6 +3539,72 @@ func serveStatsPage(w http.ResponseWriter, r *http.Request) {
                     </div>
                 </div>
             </div>
+
+            <div class="col-md-12 mb-4">
+                <div class="card">
+                    <div class="card-header">
+                        <h5 class="mb-0">流量趋势图</h5>
+                    </div>
+                    <div class="card-body">
+                        <form id="trafficForm" class="row g-2 align-items-end mb-3">
+                            <div class="col-md-2">
+                                <label class="form-label">时间范围</label>
+                                <select class="form-select" id="trafficRange">
+                                    <option value="1">最近1小时</option>
+                                    <option value="6">最近6小时</option>
+                                    <option value="24" selected>最近24小时</option>
+                                    <option value="168">最近7天</option>
+                                    <option value="720">最近30天</option>
+                                    <option value="custom">自定义</option>
+                                </select>
+                            </div>
+                            <div class="col-md-2">
+                                <label class="form-label">开始时间</label>
+                                <input type="datetime-local" class="form-control" id="trafficFrom" disabled>
+                            </div>
+                            <div class="col-md-2">
+                                <label class="form-label">结束时间</label>
+                                <input type="datetime-local" class="form-control" id="trafficTo" disabled>
+                            </div>
+                            <div class="col-md-1">
+                                <label class="form-label">粒度</label>
+                                <select class="form-select" id="trafficGranularity">
+                                    <option value="">自动</option>
+                                    <option value="minute">分钟</option>
+                                    <option value="hour">小时</option>
+                                    <option value="day">天</option>
+                                </select>
+                            </div>
+                            <div class="col-md-2">
+                                <label class="form-label">用户</label>
+                                <select class="form-select" id="trafficUser">
+                                    <option value="">所有用户</option>
+                                    {{range .}}<option value="{{.ID}}">{{.Username}}</option>{{end}}
+                                </select>
+                            </div>
+                            <div class="col-md-2">
+                                <label class="form-label">分组</label>
+                                <select class="form-select" id="trafficGroupBy">
+                                    <option value="">不分组（上行/下行）</option>
+                                    <option value="user">按用户</option>
+                                    <option value="target">按目标地址</option>
+                                </select>
+                            </div>
+                            <div class="col-md-1">
+                                <button type="submit" class="btn btn-primary w-100">查询</button>
+                            </div>
+                        </form>
+                        <div class="text-danger small mb-2" id="trafficError"></div>
+                        <div class="chart-container">
+                            <canvas id="trafficChart"></canvas>
+                        </div>
+                        <div class="form-text">
+                            数据来自按分钟/小时/天汇总的流量表，活动连接的流量每30秒写入一次。
+                            按分钟的数据保留7天、按小时的数据保留90天（可通过环境变量调整），按天的数据永久保留。
+                        </div>
+                    </div>
+                </div>
+            </div>
         </div>
     </div>
     
@@ -891,6 +3644,107 @@ func serveStatsPage(w http.ResponseWriter, r *http.Request) {
             }
         });
         
+        // 流量趋势图，从/stats/traffic按所选时间范围加载
+        const trafficColors = ['54, 162, 235', '255, 99, 132', '75, 192, 192', '255, 159, 64', '153, 102, 255',
+            '255, 206, 86', '83, 102, 255', '255, 99, 255', '99, 255, 132', '199, 199, 199', '120, 120, 120'];
+        const trafficChart = new Chart(document.getElementById('trafficChart').getContext('2d'), {
+            type: 'line',
+            data: { labels: [], datasets: [] },
+            options: {
+                responsive: true,
+                maintainAspectRatio: false,
+                interaction: { mode: 'index', intersect: false },
+                scales: {
+                    y: { beginAtZero: true, ticks: { callback: function(value) { return formatTrafficBytes(value); } } }
+                },
+                plugins: {
+                    tooltip: { callbacks: { label: function(item) { return item.dataset.label + ': ' + formatTrafficBytes(item.parsed.y); } } }
+                }
+            }
+        });
+
+        function formatTrafficBytes(bytes) {
+            const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+            let i = 0;
+            while (bytes >= 1024 && i < units.length - 1) {
+                bytes /= 1024;
+                i++;
+            }
+            return (i === 0 ? bytes : bytes.toFixed(2)) + ' ' + units[i];
+        }
+
+        function formatBucket(time, granularity) {
+            const d = new Date(time);
+            const pad = function(n) { return String(n).padStart(2, '0'); };
+            const date = pad(d.getMonth() + 1) + '-' + pad(d.getDate());
+            return granularity === 'day' ? date : date + ' ' + pad(d.getHours()) + ':' + pad(d.getMinutes());
+        }
+
+        function trafficDataset(label, data, color) {
+            return {
+                label: label,
+                data: data,
+                borderColor: 'rgb(' + color + ')',
+                backgroundColor: 'rgba(' + color + ', 0.2)',
+                pointRadius: 0,
+                tension: 0.1
+            };
+        }
+
+        function loadTraffic() {
+            const params = new URLSearchParams();
+            const range = document.getElementById('trafficRange').value;
+            if (range === 'custom') {
+                const from = document.getElementById('trafficFrom').value;
+                const to = document.getElementById('trafficTo').value;
+                if (from) params.set('from', new Date(from).toISOString());
+                if (to) params.set('to', new Date(to).toISOString());
+            } else {
+                params.set('from', new Date(Date.now() - Number(range) * 3600 * 1000).toISOString());
+            }
+            const groupBy = document.getElementById('trafficGroupBy').value;
+            params.set('granularity', document.getElementById('trafficGranularity').value);
+            params.set('user_id', document.getElementById('trafficUser').value);
+            params.set('group_by', groupBy);
+
+            fetch('/stats/traffic?' + params.toString()).then(function(resp) {
+                if (!resp.ok) {
+                    return resp.text().then(function(text) { throw new Error(text); });
+                }
+                return resp.json();
+            }).then(function(series) {
+                document.getElementById('trafficError').textContent = '';
+                trafficChart.data.labels = series.buckets.map(function(time) { return formatBucket(time, series.granularity); });
+                if (groupBy === '') {
+                    const line = series.series[0];
+                    const empty = series.buckets.map(function() { return 0; });
+                    trafficChart.data.datasets = [
+                        trafficDataset('上行', line ? line.bytes_up : empty, trafficColors[0]),
+                        trafficDataset('下行', line ? line.bytes_down : empty, trafficColors[1])
+                    ];
+                } else {
+                    trafficChart.data.datasets = series.series.map(function(line, i) {
+                        const total = line.bytes_up.map(function(up, j) { return up + line.bytes_down[j]; });
+                        return trafficDataset(line.other ? '其他' : line.key, total, trafficColors[i % trafficColors.length]);
+                    });
+                }
+                trafficChart.update();
+            }).catch(function(err) {
+                document.getElementById('trafficError').textContent = '加载流量数据失败: ' + err.message;
+            });
+        }
+
+        document.getElementById('trafficRange').addEventListener('change', function() {
+            const custom = this.value === 'custom';
+            document.getElementById('trafficFrom').disabled = !custom;
+            document.getElementById('trafficTo').disabled = !custom;
+        });
+        document.getElementById('trafficForm').addEventListener('submit', function(e) {
+            e.preventDefault();
+            loadTraffic();
+        });
+        loadTraffic();
+
         // 创建各用户连接趋势图
         const userCtx = document.getElementById('userConnectionChart').getContext('2d');
         const userConnectionChart = new Chart(userCtx, {
@@ -918,7 +3772,13 @@ func serveStatsPage(w http.ResponseWriter, r *http.Request) {
 `
 	
 	t, _ := template.New("stats").Parse(tmpl)
-	t.Execute(w, nil)
+	t.Execute(w, users)
+}
+
+// quotaUsage 用户本日和本月已使用的流量
+type quotaUsage struct {
+	Daily   int64
+	Monthly int64
 }
 
 func formatBytes(bytes int64) string {
@@ -950,22 +3810,18 @@ type UserChartData struct {
 	Datasets []map[string]interface{} `json:"datasets"`
 }
 
-func prepareChartData(connections []*models.Connection) string {
+func prepareChartData(counts map[string]map[int]int) string {
 	// 按日期统计连接数
 	connectionCountByDate := make(map[string]int)
 	
 	// 统计最近7天的连接数
 	now := time.Now()
 	for i := 6; i >= 0; i-- {
-		date := now.AddDate(0, 0, -i).Format("01-02") // 只显示月日
+		day := now.AddDate(0, 0, -i)
+		date := day.Format("01-02") // 只显示月日
 		connectionCountByDate[date] = 0
-	}
-	
-	// 遍历连接记录，统计每天的连接数
-	for _, conn := range connections {
-		date := conn.ConnectedAt.Format("01-02")
-		if _, exists := connectionCountByDate[date]; exists {
-			connectionCountByDate[date]++
+		for _, count := range counts[day.Format("2006-01-02")] {
+			connectionCountByDate[date] += count
 		}
 	}
 	
@@ -991,7 +3847,7 @@ func prepareChartData(connections []*models.Connection) string {
 	return string(jsonData)
 }
 
-func prepareUserChartData(connections []*models.Connection, users []*models.User) string {
+func prepareUserChartData(counts map[string]map[int]int, users []*models.User) string {
 	// 定义一组颜色，确保不同用户有不同的颜色
 	colors := []string{
 		"255, 99, 132",   // 红色
@@ -1019,19 +3875,12 @@ func prepareUserChartData(connections []*models.Connection, users []*models.User
 	now := time.Now()
 	dates := make([]string, 0)
 	for i := 6; i >= 0; i-- {
-		date := now.AddDate(0, 0, -i).Format("01-02") // 只显示月日
+		day := now.AddDate(0, 0, -i)
+		date := day.Format("01-02") // 只显示月日
 		dates = append(dates, date)
 		userConnectionCountByDate[date] = make(map[int]int)
 		for userID := range userMap {
-			userConnectionCountByDate[date][userID] = 0
-		}
-	}
-	
-	// 遍历连接记录，统计每天每个用户的连接数
-	for _, conn := range connections {
-		date := conn.ConnectedAt.Format("01-02")
-		if _, exists := userConnectionCountByDate[date]; exists {
-			userConnectionCountByDate[date][conn.UserID]++
+			userConnectionCountByDate[date][userID] = counts[day.Format("2006-01-02")][userID]
 		}
 	}
 	